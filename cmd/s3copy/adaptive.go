@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// adaptiveDecreaseCooldown is the minimum time between two decreases, so a
+// burst of throttled responses from requests that were already in flight
+// halves the concurrency once instead of collapsing it to 1
+const adaptiveDecreaseCooldown = time.Second
+
+// throttlingErrorCodes are the error codes S3 and S3-compatible services use
+// when a client exceeds the request rate
+var throttlingErrorCodes = map[string]bool{
+	"SlowDown":                  true,
+	"Throttling":                true,
+	"ThrottlingException":       true,
+	"TooManyRequests":           true,
+	"RequestLimitExceeded":      true,
+	"RequestThrottled":          true,
+	"ServiceUnavailable":        true,
+	"RequestThrottledException": true,
+}
+
+// adaptiveLimiter caps the number of concurrent transfers with an AIMD
+// controller: the cap grows by one after every window of successful requests
+// as large as the cap, and is halved when S3 throttles a request. It never
+// exceeds --max-workers.
+type adaptiveLimiter struct {
+	mutex        sync.Mutex
+	limit        int
+	maxLimit     int
+	inFlight     int
+	successes    int
+	lastDecrease time.Time
+	wake         chan struct{}
+	now          func() time.Time
+}
+
+// newAdaptiveLimiter starts at a quarter of maxLimit
+func newAdaptiveLimiter(maxLimit int) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		limit:    max(1, maxLimit/4),
+		maxLimit: max(1, maxLimit),
+		wake:     make(chan struct{}),
+		now:      time.Now,
+	}
+}
+
+// acquire blocks until the number of running transfers is below the current
+// cap and returns the function that releases the slot. A nil limiter never
+// blocks.
+func (l *adaptiveLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	for {
+		l.mutex.Lock()
+		if l.inFlight < l.limit {
+			l.inFlight++
+			l.mutex.Unlock()
+			return l.release, nil
+		}
+		wake := l.wake
+		l.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+func (l *adaptiveLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+	l.wakeWaiters()
+}
+
+// wakeWaiters lets blocked acquire calls check the cap again. The caller
+// holds the mutex.
+func (l *adaptiveLimiter) wakeWaiters() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// onSuccess records a request that was not throttled
+func (l *adaptiveLimiter) onSuccess() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.successes++
+	if l.successes < l.limit || l.limit >= l.maxLimit {
+		return
+	}
+	l.successes = 0
+	l.limit++
+	l.wakeWaiters()
+	logVerbose("Adaptive concurrency: increased to %d workers (%s)\n", l.limit, report.throughput())
+}
+
+// onThrottle records a throttled request and halves the cap
+func (l *adaptiveLimiter) onThrottle(code string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.successes = 0
+	now := l.now()
+	if now.Sub(l.lastDecrease) < adaptiveDecreaseCooldown {
+		return
+	}
+	l.lastDecrease = now
+	l.limit = max(1, l.limit/2)
+	logVerbose("Adaptive concurrency: throttled by S3 (%s), reduced to %d workers (%s)\n", code, l.limit, report.throughput())
+}
+
+// current returns the current cap
+func (l *adaptiveLimiter) current() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+// throttlingCode returns the error code or HTTP status of a throttled
+// request, or "" when err is not a throttling error
+func throttlingCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && throttlingErrorCodes[apiErr.ErrorCode()] {
+		return apiErr.ErrorCode()
+	}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		switch status := responseErr.HTTPStatusCode(); status {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return http.StatusText(status)
+		}
+	}
+	return ""
+}
+
+// addThrottleObserver reports the outcome of every request attempt to the
+// adaptive limiter. It runs inside the retry loop, so throttled attempts
+// that the SDK retries successfully still lower the concurrency.
+func addThrottleObserver(limiter *adaptiveLimiter) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("S3CopyAdaptiveConcurrency", func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			if code := throttlingCode(err); code != "" {
+				limiter.onThrottle(code)
+			} else if err == nil {
+				limiter.onSuccess()
+			}
+			return out, metadata, err
+		}), "Retry", middleware.After)
+	}
+}
+
+// acquireTransferSlot acquires the --concurrency-per-endpoint slots of the
+// buckets a worker transfers from or to and the --adaptive-concurrency slot
+// it holds while it transfers a file. Every worker takes them in this order,
+// so none can wait for a slot another worker holds while that one waits for
+// one of its slots.
+func acquireTransferSlot(ctx context.Context, buckets ...string) (func(), error) {
+	releaseEndpoints := func() {}
+	if endpointSlots != nil {
+		var err error
+		releaseEndpoints, err = endpointSlots.acquire(ctx, transferEndpoints(buckets)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	releaseAdaptive, err := adaptiveSlots.acquire(ctx)
+	if err != nil {
+		releaseEndpoints()
+		return nil, err
+	}
+	return func() {
+		releaseAdaptive()
+		releaseEndpoints()
+	}, nil
+}
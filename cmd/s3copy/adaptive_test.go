@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	limiter := newAdaptiveLimiter(8)
+	clock := time.Unix(0, 0)
+	limiter.now = func() time.Time { return clock }
+	assert.Equal(t, 2, limiter.current())
+
+	t.Run("additive increase after a window of successes", func(t *testing.T) {
+		limiter.onSuccess()
+		assert.Equal(t, 2, limiter.current())
+		limiter.onSuccess()
+		assert.Equal(t, 3, limiter.current())
+		for range 3 {
+			limiter.onSuccess()
+		}
+		assert.Equal(t, 4, limiter.current())
+	})
+
+	t.Run("multiplicative decrease on throttling", func(t *testing.T) {
+		clock = clock.Add(time.Minute)
+		limiter.onThrottle("SlowDown")
+		assert.Equal(t, 2, limiter.current())
+
+		limiter.onThrottle("SlowDown")
+		assert.Equal(t, 2, limiter.current(), "throttles within the cooldown decrease once")
+
+		clock = clock.Add(adaptiveDecreaseCooldown)
+		limiter.onThrottle("SlowDown")
+		assert.Equal(t, 1, limiter.current())
+
+		clock = clock.Add(adaptiveDecreaseCooldown)
+		limiter.onThrottle("SlowDown")
+		assert.Equal(t, 1, limiter.current(), "never below one worker")
+	})
+
+	t.Run("capped at max workers", func(t *testing.T) {
+		for range 100 {
+			limiter.onSuccess()
+		}
+		assert.Equal(t, 8, limiter.current())
+	})
+}
+
+func TestAdaptiveLimiterAcquire(t *testing.T) {
+	limiter := newAdaptiveLimiter(4)
+	ctx := context.Background()
+
+	release, err := limiter.acquire(ctx)
+	require.NoError(t, err)
+
+	acquired := make(chan func(), 1)
+	go func() {
+		next, err := limiter.acquire(ctx)
+		if err == nil {
+			acquired <- next
+		}
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second slot acquired above the cap")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	limiter.onSuccess()
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(5 * time.Second):
+		t.Fatal("increase did not wake the waiting worker")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	limiter.onThrottle("SlowDown")
+	_, err = limiter.acquire(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	release()
+
+	var nilLimiter *adaptiveLimiter
+	release, err = nilLimiter.acquire(ctx)
+	require.NoError(t, err)
+	release()
+}
+
+func TestThrottlingCode(t *testing.T) {
+	assert.Equal(t, "SlowDown", throttlingCode(&smithy.GenericAPIError{Code: "SlowDown"}))
+	assert.Equal(t, "TooManyRequests", throttlingCode(fmt.Errorf("wrapped: %w", &smithy.GenericAPIError{Code: "TooManyRequests"})))
+	assert.Empty(t, throttlingCode(&smithy.GenericAPIError{Code: "AccessDenied"}))
+	assert.Empty(t, throttlingCode(nil))
+}
+
+func TestUploadAdaptiveConcurrencyBacksOff(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	localDir := t.TempDir()
+	for i := range 4 {
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, fmt.Sprintf("f%d.txt", i)), []byte("data"), 0644))
+	}
+
+	// every other PUT is rejected with 503 SlowDown
+	fake := newFakeS3(nil)
+	var mutex sync.Mutex
+	var puts, throttled int
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mutex.Lock()
+			puts++
+			throttle := puts%2 == 1
+			if throttle {
+				throttled++
+			}
+			mutex.Unlock()
+			if throttle {
+				_, _ = io.Copy(io.Discard, r.Body)
+				writeFakeError(w, http.StatusServiceUnavailable, "SlowDown")
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	setTestConfig(localDir, "s3://adaptive-bucket/data/", "", false, true, false, true)
+	maxWorkers = 8
+	retries = 5
+	adaptiveSlots = newAdaptiveLimiter(maxWorkers)
+
+	var err error
+	output := captureStdout(func() {
+		err = uploadToS3(context.Background())
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, fake.contents(), 4, "throttled uploads are retried")
+	assert.Positive(t, throttled)
+	assert.Contains(t, output, "Adaptive concurrency: throttled by S3 (SlowDown), reduced to 1 workers")
+}
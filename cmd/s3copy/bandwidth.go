@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// bandwidthRecheckInterval is how often the limiter looks at the wall clock
+// to pick up the rate of the --bwlimit-schedule window it is in
+const bandwidthRecheckInterval = time.Minute
+
+// throttledReadSize caps a single read of a throttled body, so a large read
+// does not send a burst followed by a long pause
+const throttledReadSize = 32 * 1024
+
+const minutesPerDay = 24 * 60
+
+// bandwidthWindow is one entry of --bwlimit-schedule. start and end are
+// minutes since midnight; a window whose end is not after its start wraps
+// around midnight. A rate of 0 means unlimited.
+type bandwidthWindow struct {
+	start int
+	end   int
+	rate  int64
+}
+
+// contains reports whether the minute of the day falls into the window
+func (w bandwidthWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// bandwidthSchedule maps the time of day to a rate in bytes per second.
+// The first window containing the time wins; times outside every window use
+// the --bwlimit rate.
+type bandwidthSchedule struct {
+	windows  []bandwidthWindow
+	fallback int64
+}
+
+// rateAt returns the rate for the local time of t, 0 being unlimited
+func (s bandwidthSchedule) rateAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range s.windows {
+		if window.contains(minute) {
+			return window.rate
+		}
+	}
+	return s.fallback
+}
+
+// parseBandwidthRate parses a rate per second such as 5MB, or unlimited
+func parseBandwidthRate(value string) (int64, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "unlimited", "off":
+		return 0, nil
+	}
+	return parseByteSize(value)
+}
+
+// parseClockTime parses an HH:MM time of day into minutes since midnight
+func parseClockTime(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(value), ":")
+	h, hourErr := strconv.Atoi(hours)
+	m, minuteErr := strconv.Atoi(minutes)
+	if !ok || len(minutes) != 2 || hourErr != nil || minuteErr != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// parseBandwidthSchedule parses a comma-separated list of
+// <HH:MM>-<HH:MM>:<rate> entries
+func parseBandwidthSchedule(value string) ([]bandwidthWindow, error) {
+	var windows []bandwidthWindow
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		startPart, rest, ok := strings.Cut(entry, "-")
+		i := strings.LastIndex(rest, ":")
+		if !ok || i < 0 {
+			return nil, fmt.Errorf("invalid entry %q, use <HH:MM>-<HH:MM>:<rate>", entry)
+		}
+		start, err := parseClockTime(startPart)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClockTime(rest[:i])
+		if err != nil {
+			return nil, err
+		}
+		start, end = start%minutesPerDay, end%minutesPerDay
+		if start == end {
+			return nil, fmt.Errorf("empty time window in %q", entry)
+		}
+		rate, err := parseBandwidthRate(rest[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in %q: %w", entry, err)
+		}
+		windows = append(windows, bandwidthWindow{start: start, end: end, rate: rate})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return windows, nil
+}
+
+// formatBandwidth describes a rate for log messages
+func formatBandwidth(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return formatBytes(rate) + "/s"
+}
+
+// bandwidthLimiter is a token bucket shared by all transfers. It holds at
+// most one second worth of tokens, and re-reads the rate from the schedule
+// every bandwidthRecheckInterval, so a run that spans a window boundary
+// speeds up or slows down without a restart.
+type bandwidthLimiter struct {
+	mutex    sync.Mutex
+	schedule bandwidthSchedule
+	rate     int64
+	tokens   float64
+	last     time.Time
+	checked  time.Time
+	now      func() time.Time
+}
+
+func newBandwidthLimiter(schedule bandwidthSchedule) *bandwidthLimiter {
+	return &bandwidthLimiter{schedule: schedule, now: time.Now}
+}
+
+// refresh picks up the rate of the current window. Called with the mutex
+// held.
+func (l *bandwidthLimiter) refresh(now time.Time) {
+	if !l.checked.IsZero() && now.Sub(l.checked) < bandwidthRecheckInterval {
+		return
+	}
+	l.checked = now
+
+	rate := l.schedule.rateAt(now)
+	if rate == l.rate && !l.last.IsZero() {
+		return
+	}
+	if !l.last.IsZero() {
+		logVerbose("Bandwidth limit changed from %s to %s\n", formatBandwidth(l.rate), formatBandwidth(rate))
+	}
+	// settle the bucket at the old rate, so neither debt nor savings carry
+	// over into the new window
+	if l.rate > 0 {
+		l.tokens = min(float64(l.rate), l.tokens+max(0, now.Sub(l.last).Seconds())*float64(l.rate))
+	} else {
+		l.tokens = 0
+	}
+	l.rate = rate
+	l.tokens = min(l.tokens, float64(rate))
+	l.last = now
+}
+
+// reserve takes n bytes from the bucket and returns how long the caller has
+// to wait before the bytes fit into the current rate
+func (l *bandwidthLimiter) reserve(n int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.refresh(now)
+	if l.rate == 0 {
+		return 0
+	}
+
+	elapsed := max(0, now.Sub(l.last).Seconds())
+	l.last = now
+	l.tokens = min(float64(l.rate), l.tokens+elapsed*float64(l.rate))
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// wait blocks until n bytes may be transferred. A nil limiter never blocks.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader passes the bytes of a request or response body through the
+// limiter
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttledReadSize {
+		p = p[:throttledReadSize]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReader) Close() error {
+	return r.reader.Close()
+}
+
+// throttledHTTPClient limits the bandwidth of the S3 client. Upload bodies
+// and download bodies go through the same limiter, so the rate applies to
+// the sum of all transfers in both directions.
+type throttledHTTPClient struct {
+	client  aws.HTTPClient
+	limiter *bandwidthLimiter
+}
+
+func (c *throttledHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &throttledReader{ctx: req.Context(), reader: req.Body, limiter: c.limiter}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Body != nil {
+		resp.Body = &throttledReader{ctx: req.Context(), reader: resp.Body, limiter: c.limiter}
+	}
+	return resp, nil
+}
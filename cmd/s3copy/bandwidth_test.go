@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	windows, err := parseBandwidthSchedule("08:00-18:00:5MB, 18:00-08:00:unlimited")
+	require.NoError(t, err)
+	assert.Equal(t, []bandwidthWindow{
+		{start: 8 * 60, end: 18 * 60, rate: 5 * 1024 * 1024},
+		{start: 18 * 60, end: 8 * 60, rate: 0},
+	}, windows)
+
+	windows, err = parseBandwidthSchedule("22:30-24:00:512KB")
+	require.NoError(t, err)
+	assert.Equal(t, []bandwidthWindow{{start: 22*60 + 30, end: 0, rate: 512 * 1024}}, windows)
+
+	for _, invalid := range []string{
+		"",
+		"08:00-18:00",
+		"08:00:5MB",
+		"8-18:5MB",
+		"08:00-25:00:5MB",
+		"08:60-18:00:5MB",
+		"08:00-08:00:5MB",
+		"00:00-24:00:5MB",
+		"08:00-18:00:fast",
+	} {
+		_, err := parseBandwidthSchedule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestBandwidthScheduleRateAt(t *testing.T) {
+	windows, err := parseBandwidthSchedule("08:00-18:00:5MB,22:00-06:00:1MB")
+	require.NoError(t, err)
+	schedule := bandwidthSchedule{windows: windows, fallback: 10 * 1024 * 1024}
+
+	at := func(hour, minute int) int64 {
+		return schedule.rateAt(time.Date(2026, 3, 2, hour, minute, 0, 0, time.Local))
+	}
+	assert.Equal(t, int64(5*1024*1024), at(8, 0))
+	assert.Equal(t, int64(5*1024*1024), at(17, 59))
+	assert.Equal(t, int64(10*1024*1024), at(18, 0), "times outside every window use --bwlimit")
+	assert.Equal(t, int64(1024*1024), at(23, 15), "windows wrap around midnight")
+	assert.Equal(t, int64(1024*1024), at(5, 59))
+	assert.Equal(t, int64(10*1024*1024), at(6, 0))
+}
+
+func TestBandwidthLimiterTransitions(t *testing.T) {
+	windows, err := parseBandwidthSchedule("08:00-18:00:1KB,18:00-08:00:unlimited")
+	require.NoError(t, err)
+
+	clock := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	limiter := newBandwidthLimiter(bandwidthSchedule{windows: windows})
+	limiter.now = func() time.Time { return clock }
+
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(512), "the bucket starts empty")
+	assert.Equal(t, time.Second, limiter.reserve(512))
+
+	clock = clock.Add(time.Second)
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(512), "one second pays back 1KB of the debt")
+
+	clock = clock.Add(10 * time.Second)
+	assert.Zero(t, limiter.reserve(1024))
+	assert.Equal(t, time.Second, limiter.reserve(1024), "the bucket holds at most one second of tokens")
+
+	clock = time.Date(2026, 3, 2, 18, 0, 30, 0, time.Local)
+	assert.Zero(t, limiter.reserve(10<<20), "after 18:00 the limit is lifted")
+	assert.Equal(t, int64(0), limiter.rate)
+
+	clock = time.Date(2026, 3, 3, 8, 0, 10, 0, time.Local)
+	assert.Equal(t, time.Second, limiter.reserve(1024), "the limit returns in the morning without the debt of the day before")
+	assert.Equal(t, int64(1024), limiter.rate)
+}
+
+func TestBandwidthLimiterRecheckInterval(t *testing.T) {
+	windows, err := parseBandwidthSchedule("08:00-18:00:1KB")
+	require.NoError(t, err)
+
+	clock := time.Date(2026, 3, 2, 17, 59, 30, 0, time.Local)
+	limiter := newBandwidthLimiter(bandwidthSchedule{windows: windows})
+	limiter.now = func() time.Time { return clock }
+
+	limiter.reserve(0)
+	assert.Equal(t, int64(1024), limiter.rate)
+
+	clock = clock.Add(45 * time.Second)
+	limiter.reserve(0)
+	assert.Equal(t, int64(1024), limiter.rate, "the schedule is only re-read every bandwidthRecheckInterval")
+
+	clock = clock.Add(bandwidthRecheckInterval)
+	limiter.reserve(0)
+	assert.Equal(t, int64(0), limiter.rate)
+}
+
+func TestThrottledHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(strings.Repeat("x", 1000+len(body))))
+	}))
+	defer server.Close()
+
+	clock := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	limiter := newBandwidthLimiter(bandwidthSchedule{fallback: 1 << 30})
+	limiter.now = func() time.Time { return clock }
+	client := &throttledHTTPClient{client: awshttp.NewBuildableClient(), limiter: limiter}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, server.URL, strings.NewReader(strings.Repeat("y", 500)))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Len(t, body, 1500)
+	assert.Equal(t, -2000.0, limiter.tokens, "uploaded and downloaded bytes share the limiter")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// caseInsensitiveDir reports whether the file system of dir treats names
+// that differ only in case as the same file, as the defaults of macOS and
+// Windows do. It creates and removes a probe file; a directory that cannot
+// be probed is taken as case-sensitive.
+func caseInsensitiveDir(dir string) bool {
+	probe, err := os.CreateTemp(dir, ".s3copy-case-*")
+	if err != nil {
+		logVerbose("Warning: could not check the case sensitivity of %s: %v\n", dir, err)
+		return false
+	}
+	probePath := probe.Name()
+	closeWithLog(probe, probePath)
+	defer removeTempFile(probePath)
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(probePath), strings.ToUpper(filepath.Base(probePath))))
+	return err == nil
+}
+
+// caseCollisions tracks the local paths of a download on a case-insensitive
+// file system, where keys such as A.txt and a.txt would silently overwrite
+// each other. A nil tracker, used on case-sensitive file systems, accepts
+// every path.
+type caseCollisions struct {
+	seen map[string]string
+}
+
+func newCaseCollisions(enabled bool) *caseCollisions {
+	if !enabled {
+		return nil
+	}
+	return &caseCollisions{seen: make(map[string]string)}
+}
+
+// resolve returns the relative local path for a key. A path that collides
+// with an earlier one gets a " (n)" suffix before its extension and a
+// warning, or an error with --strict.
+func (c *caseCollisions) resolve(key, relPath string) (string, error) {
+	if c == nil {
+		return relPath, nil
+	}
+
+	resolved := relPath
+	if earlier, exists := c.seen[strings.ToLower(relPath)]; exists {
+		if strict {
+			return "", fmt.Errorf("keys %s and %s map to the same file on a case-insensitive file system", earlier, key)
+		}
+		ext := path.Ext(relPath)
+		for n := 2; ; n++ {
+			resolved = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(relPath, ext), n, ext)
+			if _, taken := c.seen[strings.ToLower(resolved)]; !taken {
+				break
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Warning: keys %s and %s differ only in case, downloading %s as %s\n", earlier, key, key, resolved)
+	}
+	c.seen[strings.ToLower(resolved)] = key
+	return resolved, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaseCollisions(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, false, false, false)
+
+	collisions := newCaseCollisions(true)
+	var resolved []string
+	warnings := captureStderr(func() {
+		for _, key := range []string{"data/A.txt", "data/a.txt", "data/A.TXT", "data/b.txt", "data/Dir/c", "data/dir/c"} {
+			relPath, err := collisions.resolve(key, key[len("data/"):])
+			require.NoError(t, err)
+			resolved = append(resolved, relPath)
+		}
+	})
+	assert.Equal(t, []string{"A.txt", "a (2).txt", "A (3).TXT", "b.txt", "Dir/c", "dir/c (2)"}, resolved)
+	assert.Contains(t, warnings, "keys data/A.txt and data/a.txt differ only in case, downloading data/a.txt as a (2).txt")
+
+	strict = true
+	collisions = newCaseCollisions(true)
+	_, err := collisions.resolve("A.txt", "A.txt")
+	require.NoError(t, err)
+	_, err = collisions.resolve("a.txt", "a.txt")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "keys A.txt and a.txt map to the same file on a case-insensitive file system")
+
+	disabled := newCaseCollisions(false)
+	for _, key := range []string{"A.txt", "a.txt"} {
+		relPath, err := disabled.resolve(key, key)
+		require.NoError(t, err)
+		assert.Equal(t, key, relPath, "case-sensitive file systems keep every key")
+	}
+}
+
+func TestCaseInsensitiveDirLeavesNoProbe(t *testing.T) {
+	dir := t.TempDir()
+	caseInsensitiveDir(dir)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDownloadCaseCollision(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	useFakeS3(t, newFakeS3(map[string]string{
+		"linux-origin/docs/A.txt": "upper",
+		"linux-origin/docs/a.txt": "lower",
+	}))
+
+	destDir := t.TempDir()
+	setTestConfig("s3://linux-origin/docs/", destDir, "", false, true, true, false)
+
+	var err error
+	warnings := captureStderr(func() {
+		err = downloadFromS3(context.Background())
+	})
+	require.NoError(t, err)
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(destDir, name))
+		require.NoError(t, err)
+		return string(content)
+	}
+	if caseInsensitiveDir(destDir) {
+		assert.Contains(t, warnings, "differ only in case")
+		assert.ElementsMatch(t, []string{"upper", "lower"}, []string{read("A.txt"), read("a (2).txt")})
+	} else {
+		assert.NotContains(t, warnings, "differ only in case")
+		assert.Equal(t, "upper", read("A.txt"))
+		assert.Equal(t, "lower", read("a.txt"))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// parseByteRange converts a --range value into an HTTP Range header. It
+// accepts "start-end" (both inclusive), "start-" for everything from start
+// and "-n" for the last n bytes.
+func parseByteRange(spec string) (string, error) {
+	startText, endText, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found || (startText == "" && endText == "") {
+		return "", fmt.Errorf("invalid range %q, use start-end, start- or -length", spec)
+	}
+
+	parseOffset := func(text string) (int64, error) {
+		offset, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("invalid range %q: %q is not a byte offset", spec, text)
+		}
+		return offset, nil
+	}
+
+	if startText == "" {
+		length, err := parseOffset(endText)
+		if err != nil {
+			return "", err
+		}
+		if length == 0 {
+			return "", fmt.Errorf("invalid range %q: the suffix length must be positive", spec)
+		}
+		return fmt.Sprintf("bytes=-%d", length), nil
+	}
+
+	start, err := parseOffset(startText)
+	if err != nil {
+		return "", err
+	}
+	if endText == "" {
+		return fmt.Sprintf("bytes=%d-", start), nil
+	}
+	end, err := parseOffset(endText)
+	if err != nil {
+		return "", err
+	}
+	if end < start {
+		return "", fmt.Errorf("invalid range %q: end is before start", spec)
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end), nil
+}
+
+// catS3Object writes the body of the source object to w, or only the bytes
+// selected by --range. Encrypted objects are decrypted as they stream, which
+// needs the whole object, so --range cannot be combined with --encrypt.
+func catS3Object(ctx context.Context, w io.Writer) error {
+	bucketName, key, err := splitS3URI(source)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+	if catRange != "" {
+		rangeHeader, err := parseByteRange(catRange)
+		if err != nil {
+			return err
+		}
+		input.Range = aws.String(rangeHeader)
+	}
+
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	result, err := s3Client.GetObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to get s3://%s/%s: %w", bucketName, key, err)
+	}
+	defer closeWithLog(result.Body, "object body")
+
+	if shouldEncryptFile(key) {
+		secret, err := objectPassword(key, result.Metadata)
+		if err != nil {
+			return err
+		}
+		if err := decryptStreamWithSize(w, result.Body, expectedPlaintextSize(result.Metadata), secret); err != nil {
+			return fmt.Errorf("failed to decrypt s3://%s/%s: %w", bucketName, key, err)
+		}
+		return nil
+	}
+
+	if _, err := io.Copy(w, result.Body); err != nil {
+		return fmt.Errorf("failed to read s3://%s/%s: %w", bucketName, key, err)
+	}
+	return nil
+}
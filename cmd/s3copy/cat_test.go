@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "10-19", want: "bytes=10-19"},
+		{spec: "0-0", want: "bytes=0-0"},
+		{spec: "100-", want: "bytes=100-"},
+		{spec: "-25", want: "bytes=-25"},
+		{spec: "20-10", wantErr: true},
+		{spec: "-", wantErr: true},
+		{spec: "-0", wantErr: true},
+		{spec: "10", wantErr: true},
+		{spec: "a-b", wantErr: true},
+		{spec: "-5-10", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseByteRange(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCatS3ObjectRange(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	fake := newFakeS3(map[string]string{"cat-bucket/notes.txt": string(content)})
+	var rangeHeader string
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		fake.ServeHTTP(w, r)
+	}))
+
+	setTestConfig("s3://cat-bucket/notes.txt", "", "", false, false, false, false)
+
+	t.Run("middle range", func(t *testing.T) {
+		catRange = "10-15"
+		var out bytes.Buffer
+		require.NoError(t, catS3Object(context.Background(), &out))
+		assert.Equal(t, "bytes=10-15", rangeHeader)
+		assert.Equal(t, []byte("abcdef"), out.Bytes())
+	})
+
+	t.Run("suffix range", func(t *testing.T) {
+		catRange = "-3"
+		var out bytes.Buffer
+		require.NoError(t, catS3Object(context.Background(), &out))
+		assert.Equal(t, "xyz", out.String())
+	})
+
+	t.Run("whole object", func(t *testing.T) {
+		catRange = ""
+		var out bytes.Buffer
+		require.NoError(t, catS3Object(context.Background(), &out))
+		assert.Empty(t, rangeHeader)
+		assert.Equal(t, content, out.Bytes())
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		source = "s3://cat-bucket/missing.txt"
+		var out bytes.Buffer
+		err := catS3Object(context.Background(), &out)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "s3://cat-bucket/missing.txt")
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// checksumsAlgorithms maps the --checksums-algorithm values to their hash
+var checksumsAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksumsEntry is one file of the run and the object it was uploaded to
+type checksumsEntry struct {
+	target   uploadTarget
+	filePath string
+}
+
+// checksumsRecorder collects the files of a directory upload or sync for
+// --checksums-file. Files skipped because their object is already up to date
+// are recorded too, so the checksums file covers the whole directory. The
+// MD5s computed during the run are kept by file path.
+type checksumsRecorder struct {
+	mutex   sync.Mutex
+	entries []checksumsEntry
+	md5s    map[string]string
+}
+
+var uploadedChecksums = &checksumsRecorder{}
+
+// record adds a local file and its targets when --checksums-file is set. A
+// known MD5 is reused when the checksums file uses md5.
+func (r *checksumsRecorder) record(filePath, localMD5 string, targets []uploadTarget) {
+	if checksumsFile == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, target := range targets {
+		r.entries = append(r.entries, checksumsEntry{target: target, filePath: filePath})
+	}
+	r.recordMD5Locked(filePath, localMD5)
+}
+
+// recordMD5 keeps the MD5 an upload computed for a local file, so the file
+// is not read again for a checksums file that uses md5
+func (r *checksumsRecorder) recordMD5(filePath, localMD5 string) {
+	if checksumsFile == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.recordMD5Locked(filePath, localMD5)
+}
+
+func (r *checksumsRecorder) recordMD5Locked(filePath, localMD5 string) {
+	if localMD5 == "" {
+		return
+	}
+	if r.md5s == nil {
+		r.md5s = map[string]string{}
+	}
+	r.md5s[filePath] = localMD5
+}
+
+// render returns the checksums file for the object at target in the format
+// of sha256sum and its siblings. Names are relative to the directory of the
+// checksums file, so `sha256sum -c` works in a downloaded copy of the
+// prefix. Objects outside that directory are left out.
+func (r *checksumsRecorder) render(target uploadTarget) ([]byte, error) {
+	r.mutex.Lock()
+	entries := slices.Clone(r.entries)
+	md5s := maps.Clone(r.md5s)
+	r.mutex.Unlock()
+
+	dir := path.Dir(target.key)
+	if dir == "." {
+		dir = ""
+	} else {
+		dir += "/"
+	}
+
+	var selected []checksumsEntry
+	for _, entry := range entries {
+		if entry.target.bucket != target.bucket || entry.target.key == target.key {
+			continue
+		}
+		if !strings.HasPrefix(entry.target.key, dir) {
+			logVerbose("Warning: %s is outside of %s, leaving it out of the checksums file\n", entry.target, target)
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	slices.SortFunc(selected, func(a, b checksumsEntry) int {
+		return cmp.Compare(a.target.key, b.target.key)
+	})
+
+	var buf bytes.Buffer
+	sums := map[string]string{}
+	for _, entry := range selected {
+		sum, ok := sums[entry.filePath]
+		if !ok {
+			var err error
+			sum, err = fileChecksum(entry.filePath, md5s[entry.filePath])
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", entry.filePath, err)
+			}
+			sums[entry.filePath] = sum
+		}
+		writeChecksumsLine(&buf, sum, strings.TrimPrefix(entry.target.key, dir))
+	}
+	return buf.Bytes(), nil
+}
+
+// fileChecksum returns the hex checksum of a recorded file with the
+// --checksums-algorithm. The MD5 computed during the run, if any, is reused.
+func fileChecksum(filePath, localMD5 string) (string, error) {
+	if checksumsAlgorithm == "md5" && localMD5 != "" {
+		return localMD5, nil
+	}
+	sum, err := calculateFileChecksum(filePath, checksumsAlgorithms[checksumsAlgorithm])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// isChecksumsFileTarget reports whether target is one of the --checksums-file
+// objects. Sync leaves them in place, they are rewritten after the run.
+func isChecksumsFileTarget(target uploadTarget) bool {
+	return checksumsFile != "" && slices.Contains(destinationPrefixTargets(checksumsFile), target)
+}
+
+// writeChecksumsLine writes "<hash>  <name>". Like GNU coreutils, a name with
+// a backslash or a line break is escaped and the line starts with a
+// backslash.
+func writeChecksumsLine(buf *bytes.Buffer, sum, name string) {
+	if strings.ContainsAny(name, "\\\n\r") {
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+		buf.WriteString("\\")
+	}
+	buf.WriteString(sum)
+	buf.WriteString("  ")
+	buf.WriteString(name)
+	buf.WriteString("\n")
+}
+
+// writeChecksumsFile uploads the --checksums-file objects after a run in which
+// every file was transferred. Like the completion marker, it is not written
+// when the run failed, so it never lists objects that are missing.
+func writeChecksumsFile(ctx context.Context, runErr error) error {
+	if (runErr != nil && !errors.Is(runErr, errNothingToDo)) || len(failures.list()) > 0 {
+		logInfo("Not writing checksums file, the run did not complete\n")
+		return nil
+	}
+
+	for _, target := range destinationPrefixTargets(checksumsFile) {
+		if dryRun {
+			logInfo("Would write checksums file %s\n", target)
+			continue
+		}
+
+		data, err := uploadedChecksums.render(target)
+		if err != nil {
+			return err
+		}
+		s3Client, err := getS3Client(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get S3 client: %w", err)
+		}
+		_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(target.bucket),
+			Key:         aws.String(target.key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("text/plain; charset=utf-8"),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+		logInfo("Wrote checksums file %s\n", target)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumsFile(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"a.txt":            "alpha",
+		"nested/b.txt":     "bravo",
+		"nested/deep/c.md": "charlie",
+	}
+	for relPath, content := range files {
+		localPath := filepath.Join(srcDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	upload := func(t *testing.T, algorithm string) string {
+		setTestConfig(srcDir, "s3://bucket/data/", "", false, true, true, false)
+		checksumsFile = "SUMS"
+		checksumsAlgorithm = algorithm
+
+		require.NoError(t, uploadToS3(context.Background()))
+		require.NoError(t, writeChecksumsFile(context.Background(), nil))
+		object, ok := fake.object("bucket/data/SUMS")
+		require.True(t, ok, "checksums file was not uploaded")
+		return object.content
+	}
+
+	t.Run("sha256 lines verify against the files", func(t *testing.T) {
+		sums := upload(t, "sha256")
+		lines := strings.Split(strings.TrimSuffix(sums, "\n"), "\n")
+		require.Len(t, lines, len(files))
+		for _, line := range lines {
+			sum, name, found := strings.Cut(line, "  ")
+			require.True(t, found, "malformed line %q", line)
+			content, ok := files[name]
+			require.True(t, ok, "unexpected name %q", name)
+			expected := sha256.Sum256([]byte(content))
+			assert.Equal(t, hex.EncodeToString(expected[:]), sum)
+		}
+		assert.Equal(t, []string{"a.txt", "nested/b.txt", "nested/deep/c.md"}, namesOf(lines), "lines are sorted by key")
+	})
+
+	t.Run("sha256sum -c accepts the file", func(t *testing.T) {
+		tool, err := exec.LookPath("sha256sum")
+		if err != nil {
+			t.Skip("sha256sum is not installed")
+		}
+		sums := upload(t, "sha256")
+		sumsPath := filepath.Join(t.TempDir(), "SHA256SUMS")
+		require.NoError(t, os.WriteFile(sumsPath, []byte(sums), 0644))
+
+		cmd := exec.Command(tool, "-c", "--strict", sumsPath)
+		cmd.Dir = srcDir
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	})
+
+	t.Run("md5 is readable as a manifest", func(t *testing.T) {
+		entries, err := parseManifest(strings.NewReader(upload(t, "md5")))
+		require.NoError(t, err)
+		assert.Len(t, entries, len(files))
+		assert.Equal(t, "fd9ab41e47a9ef4f6477a8a000bf404f", entries["nested/b.txt"])
+		assert.Equal(t, md5Hex("bravo"), uploadedChecksums.md5s[filepath.Join(srcDir, "nested", "b.txt")],
+			"the MD5 of the upload is reused")
+	})
+
+	t.Run("sync keeps the checksums file", func(t *testing.T) {
+		upload(t, "sha256")
+		setTestConfig(srcDir, "s3://bucket/data/", "", false, true, true, false)
+		checksumsFile = "SUMS"
+
+		s3Client, err := getS3Client(context.Background())
+		require.NoError(t, err)
+		result, err := syncLocalToS3(context.Background(), s3Client)
+		require.NoError(t, err)
+		assert.Empty(t, result.Deleted)
+		assert.Contains(t, fake.contents(), "bucket/data/SUMS")
+	})
+
+	t.Run("failed run writes nothing", func(t *testing.T) {
+		fake.remove("bucket/data/SUMS")
+		checksumsFile = "SUMS"
+		require.NoError(t, writeChecksumsFile(context.Background(), errors.New("upload failed")))
+		assert.NotContains(t, fake.contents(), "bucket/data/SUMS")
+	})
+}
+
+func TestChecksumsFileRender(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "kept.txt"), []byte("kept"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "SUMS"), []byte("stale"), 0644))
+
+	setTestConfig(srcDir, "s3://bucket/mirror", "", false, true, true, false)
+	checksumsFile = "SUMS"
+	checksumsAlgorithm = "sha256"
+	for _, file := range []FileInfo{
+		{Path: filepath.Join(srcDir, "kept.txt"), RelPath: "kept.txt"},
+		{Path: filepath.Join(srcDir, "SUMS"), RelPath: "SUMS"},
+	} {
+		uploadedChecksums.record(file.Path, file.MD5Hash, []uploadTarget{{bucket: "bucket", key: "mirror/" + file.RelPath}})
+	}
+	uploadedChecksums.record(filepath.Join(srcDir, "kept.txt"), "", []uploadTarget{{bucket: "other", key: "mirror/kept.txt"}})
+
+	data, err := uploadedChecksums.render(uploadTarget{bucket: "bucket", key: "mirror/SUMS"})
+	require.NoError(t, err)
+	expected := sha256.Sum256([]byte("kept"))
+	assert.Equal(t, hex.EncodeToString(expected[:])+"  kept.txt\n", string(data), "the checksums file and other buckets are left out")
+}
+
+func TestWriteChecksumsLine(t *testing.T) {
+	var buf bytes.Buffer
+	writeChecksumsLine(&buf, "abc", "plain name.txt")
+	writeChecksumsLine(&buf, "def", "back\\slash\nbreak")
+	assert.Equal(t, "abc  plain name.txt\n\\def  back\\\\slash\\nbreak\n", buf.String())
+}
+
+func namesOf(lines []string) []string {
+	var names []string
+	for _, line := range lines {
+		_, name, _ := strings.Cut(line, "  ")
+		names = append(names, name)
+	}
+	return names
+}
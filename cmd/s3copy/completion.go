@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// completionMarkerDocument is the content of the --completion-marker object
+type completionMarkerDocument struct {
+	CompletedAt string `json:"completed_at"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Files       int64  `json:"files"`
+	RunID       string `json:"run_id,omitempty"`
+}
+
+// completionMarkerTargets returns the objects --completion-marker writes. A
+// key is placed under the prefix of every destination, an s3:// URI is used
+// as it is, also with --bucket.
+func completionMarkerTargets() ([]uploadTarget, error) {
+	if strings.HasPrefix(completionMarker, "s3://") {
+		markerBucket, markerKey, _ := strings.Cut(strings.TrimPrefix(completionMarker, "s3://"), "/")
+		if markerBucket == "" || markerKey == "" {
+			return nil, fmt.Errorf("invalid completion marker %q, use s3://bucket/key", completionMarker)
+		}
+		return []uploadTarget{{bucket: markerBucket, key: markerKey}}, nil
+	}
+
+	return destinationPrefixTargets(completionMarker), nil
+}
+
+// destinationPrefixTargets returns the object name under the prefix of every
+// destination
+func destinationPrefixTargets(name string) []uploadTarget {
+	var targets []uploadTarget
+	for _, dest := range uploadDestinations() {
+		s3Path := strings.TrimPrefix(dest, "s3://")
+		destBucket, prefix, _ := strings.Cut(s3Path, "/")
+		if bucket != "" && destBucket != bucket {
+			destBucket, prefix = bucket, s3Path
+		}
+		targets = append(targets, uploadTarget{bucket: destBucket, key: strings.TrimPrefix(path.Join(prefix, name), "/")})
+	}
+	return targets
+}
+
+// writeCompletionMarker writes the --completion-marker objects after a run
+// in which every file was transferred, so consumers that trigger on S3
+// events never act on a partially uploaded prefix. Runs with an error or a
+// failed file write no marker.
+func writeCompletionMarker(ctx context.Context, runErr error) error {
+	if (runErr != nil && !errors.Is(runErr, errNothingToDo)) || len(failures.list()) > 0 {
+		logInfo("Not writing completion marker, the run did not complete\n")
+		return nil
+	}
+
+	targets, err := completionMarkerTargets()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(completionMarkerDocument{
+		CompletedAt: time.Now().UTC().Format(time.RFC3339),
+		Source:      source,
+		Destination: strings.Join(uploadDestinations(), ","),
+		Files:       report.transferredFiles(),
+		RunID:       runID,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode completion marker: %w", err)
+	}
+
+	if dryRun {
+		for _, target := range targets {
+			logInfo("Would write completion marker %s\n", target)
+		}
+		return nil
+	}
+
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+	for _, target := range targets {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(target.bucket),
+			Key:         aws.String(target.key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+		logInfo("Wrote completion marker %s\n", target)
+	}
+	return nil
+}
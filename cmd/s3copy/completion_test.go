@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletionMarkerTargets(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	setTestConfig("./data", "s3://batch/incoming/2026-03-02/", "", false, true, true, false)
+	completionMarker = "_SUCCESS"
+	targets, err := completionMarkerTargets()
+	require.NoError(t, err)
+	assert.Equal(t, []uploadTarget{{bucket: "batch", key: "incoming/2026-03-02/_SUCCESS"}}, targets)
+
+	destinations = []string{"s3://batch/incoming/", "s3://replica"}
+	targets, err = completionMarkerTargets()
+	require.NoError(t, err)
+	assert.Equal(t, []uploadTarget{{bucket: "batch", key: "incoming/_SUCCESS"}, {bucket: "replica", key: "_SUCCESS"}}, targets)
+
+	destinations = nil
+	setTestConfig("./data", "s3://incoming/", "batch", false, true, true, false)
+	completionMarker = "_SUCCESS"
+	targets, err = completionMarkerTargets()
+	require.NoError(t, err)
+	assert.Equal(t, []uploadTarget{{bucket: "batch", key: "incoming/_SUCCESS"}}, targets)
+
+	completionMarker = "s3://signals/batches/done.json"
+	targets, err = completionMarkerTargets()
+	require.NoError(t, err)
+	assert.Equal(t, []uploadTarget{{bucket: "signals", key: "batches/done.json"}}, targets)
+
+	completionMarker = "s3://signals"
+	_, err = completionMarkerTargets()
+	assert.Error(t, err)
+}
+
+func TestCompletionMarker(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	httpServer := useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "denied") {
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeFakeError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	t.Setenv("S3COPY_ENDPOINT", httpServer.URL)
+	t.Setenv("S3COPY_ACCESS_KEY", "access")
+	t.Setenv("S3COPY_SECRET_KEY", "secret")
+	t.Setenv("S3COPY_REGION", "us-east-1")
+	t.Setenv("S3COPY_USE_PATH_STYLE", strconv.FormatBool(true))
+
+	run := func(localDir, dest string) error {
+		setTestConfig(localDir, dest, "", false, true, true, false)
+		envFile = filepath.Join(t.TempDir(), "missing.env")
+		completionMarker = "_SUCCESS"
+		var runErr error
+		captureStdout(func() {
+			runErr = runCopy()
+		})
+		return runErr
+	}
+
+	t.Run("written after success", func(t *testing.T) {
+		localDir := t.TempDir()
+		for _, name := range []string{"a.csv", "b.csv"} {
+			require.NoError(t, os.WriteFile(filepath.Join(localDir, name), []byte(name), 0644))
+		}
+
+		require.NoError(t, run(localDir, "s3://batch/ok/"))
+		assert.Contains(t, fake.contents(), "batch/ok/a.csv")
+		require.Contains(t, fake.contents(), "batch/ok/_SUCCESS")
+
+		var document completionMarkerDocument
+		require.NoError(t, json.Unmarshal([]byte(fake.content("batch/ok/_SUCCESS")), &document))
+		assert.Equal(t, localDir, document.Source)
+		assert.Equal(t, "s3://batch/ok/", document.Destination)
+		assert.Equal(t, int64(2), document.Files)
+		assert.NotEmpty(t, document.CompletedAt)
+	})
+
+	t.Run("absent after a failed file", func(t *testing.T) {
+		localDir := t.TempDir()
+		for _, name := range []string{"a.csv", "denied.csv"} {
+			require.NoError(t, os.WriteFile(filepath.Join(localDir, name), []byte(name), 0644))
+		}
+
+		require.Error(t, run(localDir, "s3://batch/partial/"))
+		assert.Contains(t, fake.contents(), "batch/partial/a.csv")
+		assert.NotContains(t, fake.contents(), "batch/partial/_SUCCESS")
+	})
+}
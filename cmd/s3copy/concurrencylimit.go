@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// concurrencyLimiter caps the number of transfers running against each
+// endpoint. Every worker of the pool acquires a slot for the endpoints it
+// talks to, so a slow destination cannot hold more than its share of the
+// --max-workers workers.
+type concurrencyLimiter struct {
+	mutex        sync.Mutex
+	defaultLimit int
+	limits       map[string]int
+	slots        map[string]chan struct{}
+}
+
+// parseConcurrencyLimits parses a comma-separated list of caps. An entry is
+// either a number, which applies to every endpoint, or name=number for a
+// single one; normalize turns the name into the key acquire is called with.
+// A cap of 0 means unlimited.
+func parseConcurrencyLimits(value string, normalize func(string) string) (*concurrencyLimiter, error) {
+	limiter := &concurrencyLimiter{
+		limits: make(map[string]int),
+		slots:  make(map[string]chan struct{}),
+	}
+
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, limitValue, hasName := strings.Cut(entry, "=")
+		if hasName {
+			name, limitValue = normalize(name), strings.TrimSpace(limitValue)
+			if name == "" {
+				return nil, fmt.Errorf("invalid entry %q, use <number> or <endpoint>=<number>", entry)
+			}
+		} else {
+			limitValue = entry
+		}
+
+		limit, err := strconv.Atoi(limitValue)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit %q in %q, must be a non-negative number", limitValue, entry)
+		}
+
+		if hasName {
+			limiter.limits[name] = limit
+		} else {
+			limiter.defaultLimit = limit
+		}
+	}
+
+	return limiter, nil
+}
+
+// limitFor returns the cap for an endpoint, 0 when it is unlimited
+func (l *concurrencyLimiter) limitFor(name string) int {
+	if limit, ok := l.limits[name]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// slotsFor returns the semaphore of an endpoint, nil when it is unlimited
+func (l *concurrencyLimiter) slotsFor(name string) chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	slots, ok := l.slots[name]
+	if !ok {
+		if limit := l.limitFor(name); limit > 0 {
+			slots = make(chan struct{}, limit)
+		}
+		l.slots[name] = slots
+	}
+	return slots
+}
+
+// acquire blocks until a slot for every name is free and returns the
+// function that releases them. A file uploaded to several destinations
+// holds a slot of each of their endpoints. Slots are taken in sorted order, so
+// two workers waiting for the same names cannot deadlock. A nil limiter
+// never blocks.
+func (l *concurrencyLimiter) acquire(ctx context.Context, names ...string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+	var held []chan struct{}
+	release := func() {
+		for _, slots := range held {
+			<-slots
+		}
+	}
+
+	for _, name := range names {
+		slots := l.slotsFor(name)
+		if slots == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case slots <- struct{}{}:
+			held = append(held, slots)
+		}
+	}
+	return release, nil
+}
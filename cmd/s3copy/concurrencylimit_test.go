@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	var nilLimiter *concurrencyLimiter
+	release, err := nilLimiter.acquire(context.Background(), "s3.example.com")
+	require.NoError(t, err)
+	release()
+
+	limiter, err := parseEndpointConcurrency("1")
+	require.NoError(t, err)
+
+	release, err = limiter.acquire(context.Background(), "s3.example.com")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx, "s3.example.com")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release, err = limiter.acquire(context.Background(), "s3.example.com")
+	require.NoError(t, err)
+	release()
+}
+
+func TestConcurrencyLimiterAcquireSeveralEndpoints(t *testing.T) {
+	limiter, err := parseEndpointConcurrency("1")
+	require.NoError(t, err)
+
+	// a file uploaded to two endpoints holds a slot of each
+	release, err := limiter.acquire(context.Background(), "second", "first", "second")
+	require.NoError(t, err)
+
+	for _, endpoint := range []string{"first", "second"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = limiter.acquire(ctx, endpoint)
+		cancel()
+		assert.ErrorIs(t, err, context.DeadlineExceeded, endpoint)
+	}
+
+	// a failed acquire returns the slots it already held
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx, "third", "second")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	releaseThird, err := limiter.acquire(context.Background(), "third")
+	require.NoError(t, err)
+	releaseThird()
+
+	release()
+	release, err = limiter.acquire(context.Background(), "first", "second")
+	require.NoError(t, err)
+	release()
+}
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/joho/godotenv"
+	"github.com/urfave/cli/v3"
 	"golang.org/x/term"
 )
 
@@ -23,12 +38,48 @@ type Config struct {
 	UsePathStyle bool
 }
 
+// JSONConfig holds the connection settings of the configuration document
+// accepted by --config-stdin. Every other field of the document names a flag,
+// with underscores where the flag uses dashes, and overrides it when present.
+type JSONConfig struct {
+	Endpoint     string `json:"endpoint"`
+	AccessKey    string `json:"access_key"`
+	SecretKey    string `json:"secret_key"`
+	Region       string `json:"region"`
+	UsePathStyle bool   `json:"use_path_style"`
+}
+
 var (
 	config           Config
 	s3ClientInstance *s3.Client
 	s3ClientMutex    sync.Mutex
+
+	// transfer managers for s3ClientInstance, shared by all phases of a run
+	uploaderInstance   *uploadManager
+	downloaderInstance *manager.Client
 )
 
+// loadEnvConfig loads the .env file and reads the connection config from the
+// environment. Variables already set in the environment take precedence over
+// the file; the source of each value is recorded for --show-config.
+func loadEnvConfig(envFilePath string) {
+	setBeforeEnvFile := presentEnvKeys()
+	if err := godotenv.Load(envFilePath); err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: Could not load %s file: %v\n", envFilePath, err)
+		}
+	}
+	recordEnvSources(setBeforeEnvFile, envFilePath)
+
+	config = Config{
+		Endpoint:     getEnvOrDefault("S3COPY_ENDPOINT", ""),
+		AccessKey:    getEnvOrDefault("S3COPY_ACCESS_KEY", ""),
+		SecretKey:    getEnvOrDefault("S3COPY_SECRET_KEY", ""),
+		Region:       getEnvOrDefault("S3COPY_REGION", "us-east-1"),
+		UsePathStyle: getEnvOrDefault("S3COPY_USE_PATH_STYLE", "false") == "true",
+	}
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -36,6 +87,193 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// findEnvFile searches for a .env file starting in the current directory and
+// walking up through its parents. It returns ".env" if none is found.
+func findEnvFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ".env"
+	}
+
+	for {
+		candidate := filepath.Join(dir, ".env")
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ".env"
+		}
+		dir = parent
+	}
+}
+
+// loadJSONConfig reads a JSONConfig document from reader and applies it to the
+// connection config and the flags of cmd
+func loadJSONConfig(reader io.Reader, cmd *cli.Command) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read JSON config: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to parse JSON config: %w", err)
+	}
+	var jsonConfig JSONConfig
+	if err := json.Unmarshal(data, &jsonConfig); err != nil {
+		return fmt.Errorf("failed to parse JSON config: %w", err)
+	}
+	recordJSONSources(data, cmd)
+
+	if jsonConfig.AccessKey == "" || jsonConfig.SecretKey == "" {
+		return fmt.Errorf("JSON config is missing required fields (access_key, secret_key)")
+	}
+
+	region := jsonConfig.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	config = Config{
+		Endpoint:     jsonConfig.Endpoint,
+		AccessKey:    jsonConfig.AccessKey,
+		SecretKey:    jsonConfig.SecretKey,
+		Region:       region,
+		UsePathStyle: jsonConfig.UsePathStyle,
+	}
+
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		if isConnectionSetting(field) {
+			continue
+		}
+		if err := applyJSONField(cmd, field, fields[field]); err != nil {
+			return err
+		}
+	}
+	selectDestination()
+
+	if encrypt && password == "" && passwordMap == "" && recipientFile == "" && identityFile == "" {
+		return fmt.Errorf("JSON config enables encryption but does not provide a password")
+	}
+
+	return nil
+}
+
+// isConnectionSetting reports whether a JSON config field is one of the
+// connection settings rather than a flag
+func isConnectionSetting(field string) bool {
+	name := strings.ReplaceAll(field, "_", "-")
+	return slices.ContainsFunc(connectionSettings, func(setting connectionSetting) bool {
+		return setting.name == name
+	})
+}
+
+// jsonFieldFlag returns the flag of cmd a JSON config field names, nil when
+// there is none. A field may use the long name of the flag or any of its
+// aliases.
+func jsonFieldFlag(cmd *cli.Command, field string) cli.Flag {
+	name := strings.ReplaceAll(field, "_", "-")
+	index := slices.IndexFunc(cmd.Flags, func(flag cli.Flag) bool {
+		return slices.Contains(flag.Names(), name)
+	})
+	if index < 0 {
+		return nil
+	}
+	return cmd.Flags[index]
+}
+
+// applyJSONField sets the flag named by a JSON config field as if its value
+// was given on the command line. A flag that can be repeated takes a string
+// or a list of strings, which replaces the values of the command line.
+func applyJSONField(cmd *cli.Command, field string, raw json.RawMessage) error {
+	flag := jsonFieldFlag(cmd, field)
+	if flag == nil {
+		return fmt.Errorf("failed to parse JSON config: unknown field %q", field)
+	}
+	name := flag.Names()[0]
+	if string(raw) == "null" {
+		return nil
+	}
+
+	values, err := jsonFlagValues(raw)
+	if err != nil {
+		return fmt.Errorf("invalid value for %q in JSON config: %w", field, err)
+	}
+	if slice, ok := flag.(*cli.StringSliceFlag); ok {
+		*slice.Destination = nil
+	} else if len(values) != 1 {
+		return fmt.Errorf("invalid value for %q in JSON config: expected a single value", field)
+	}
+	for _, value := range values {
+		if err := cmd.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %q in JSON config: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// jsonFlagValues converts a JSON value to flag values. Numbers keep the
+// digits of the document, so large sizes are not rounded.
+func jsonFlagValues(raw json.RawMessage) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	items, isList := value.([]any)
+	if !isList {
+		items = []any{value}
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		switch typed := item.(type) {
+		case string:
+			values = append(values, typed)
+		case json.Number:
+			values = append(values, typed.String())
+		case bool:
+			values = append(values, strconv.FormatBool(typed))
+		default:
+			return nil, fmt.Errorf("expected a string, number or boolean")
+		}
+	}
+	return values, nil
+}
+
+// recordJSONSources marks the values present in the JSON config document.
+// Field names use underscores where the flags use dashes, and an alias is
+// recorded under the long name of its flag.
+func recordJSONSources(data []byte, cmd *cli.Command) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return
+	}
+	for field, value := range fields {
+		if string(value) == "null" || (field == "region" && string(value) == `""`) {
+			continue
+		}
+		name := strings.ReplaceAll(field, "_", "-")
+		if flag := jsonFieldFlag(cmd, field); flag != nil {
+			name = flag.Names()[0]
+		}
+		configSources[name] = sourceStdin
+	}
+}
+
+// needsPasswordPrompt reports whether the encryption password has to be read
+// from the terminal. With --password-map the password only covers keys no
+// rule matches, so it is asked for when --password is given without a value.
+func needsPasswordPrompt() bool {
+	if (!encrypt && !verifyEncryption) || usesRecipientKeys() {
+		return false
+	}
+	return (password == "" && len(passwordRules) == 0) || password == "PROMPT"
+}
+
 func getPasswordFromUser() (string, error) {
 	fmt.Print("Enter encryption password: ")
 	password, err := term.ReadPassword(int(syscall.Stdin))
@@ -51,7 +289,7 @@ func createS3Config(ctx context.Context) (aws.Config, error) {
 		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.AccessKey, config.SecretKey, "")),
 		awsconfig.WithRegion(config.Region),
 		awsconfig.WithRetryer(func() aws.Retryer {
-			return retry.AddWithMaxAttempts(retry.NewStandard(), retries)
+			return withRetryDeadline(retry.AddWithMaxAttempts(retry.NewStandard(), retries))
 		}),
 	}
 
@@ -59,9 +297,53 @@ func createS3Config(ctx context.Context) (aws.Config, error) {
 		configOptions = append(configOptions, awsconfig.WithBaseEndpoint(config.Endpoint))
 	}
 
+	if bandwidth != nil || preferIPv4 {
+		var httpClient aws.HTTPClient = newS3HTTPClient()
+		if bandwidth != nil {
+			httpClient = &throttledHTTPClient{client: httpClient, limiter: bandwidth}
+		}
+		configOptions = append(configOptions, awsconfig.WithHTTPClient(httpClient))
+	}
+
+	if adaptiveSlots != nil {
+		configOptions = append(configOptions, awsconfig.WithAPIOptions([]func(*middleware.Stack) error{addThrottleObserver(adaptiveSlots)}))
+	}
+
+	if retryDeadlineDuration > 0 {
+		configOptions = append(configOptions, awsconfig.WithAPIOptions([]func(*middleware.Stack) error{addRetryStart}))
+	}
+
 	cfg, err := awsconfig.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		return cfg, err
+	}
+	applyDebugHTTP(&cfg)
+
+	return cfg, nil
+}
 
-	return cfg, err
+// newS3HTTPClient returns the SDK's default HTTP client, restricted to IPv4
+// connections with --prefer-ipv4
+func newS3HTTPClient() *awshttp.BuildableClient {
+	client := awshttp.NewBuildableClient()
+	if !preferIPv4 {
+		return client
+	}
+	dialer := client.GetDialer()
+	return client.WithTransportOptions(func(transport *http.Transport) {
+		transport.DialContext = ipv4DialContext(dialer)
+	})
+}
+
+// ipv4DialContext dials TCP connections over IPv4 only. Endpoints that
+// resolve to both families then never wait for a broken IPv6 route.
+func ipv4DialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network == "tcp" {
+			network = "tcp4"
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
 }
 
 func getS3Client(ctx context.Context) (*s3.Client, error) {
@@ -94,4 +376,38 @@ func resetS3Client() {
 	s3ClientMutex.Lock()
 	defer s3ClientMutex.Unlock()
 	s3ClientInstance = nil
+	uploaderInstance = nil
+	downloaderInstance = nil
+}
+
+// getUploader returns the upload transfer manager for s3Client. The manager
+// for the shared client is created once and reused by every phase and
+// worker; manager.Client is safe for concurrent use. Other clients get a
+// new manager.
+func getUploader(s3Client *s3.Client) *uploadManager {
+	s3ClientMutex.Lock()
+	defer s3ClientMutex.Unlock()
+
+	if s3Client != s3ClientInstance {
+		return newUploader(s3Client, reportTransferProgress)
+	}
+	if uploaderInstance == nil {
+		uploaderInstance = newUploader(s3Client, reportTransferProgress)
+	}
+	return uploaderInstance
+}
+
+// getDownloader returns the download transfer manager for s3Client, shared
+// like getUploader
+func getDownloader(s3Client *s3.Client) *manager.Client {
+	s3ClientMutex.Lock()
+	defer s3ClientMutex.Unlock()
+
+	if s3Client != s3ClientInstance {
+		return manager.New(s3Client, reportTransferProgress)
+	}
+	if downloaderInstance == nil {
+		downloaderInstance = manager.New(s3Client, reportTransferProgress)
+	}
+	return downloaderInstance
 }
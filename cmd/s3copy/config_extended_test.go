@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -129,3 +136,62 @@ func TestResetS3Client(t *testing.T) {
 		assert.Nil(t, s3ClientInstance)
 	})
 }
+
+func TestSharedTransferManagers(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	ctx := context.Background()
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	s3Client, err := getS3Client(ctx)
+	require.NoError(t, err)
+
+	uploader := getUploader(s3Client)
+	downloader := getDownloader(s3Client)
+	assert.Same(t, uploader, getUploader(s3Client))
+	assert.Same(t, downloader, getDownloader(s3Client))
+	assert.NotSame(t, uploader, downloader)
+
+	t.Run("parallel workers share one manager", func(t *testing.T) {
+		localDir := t.TempDir()
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for i := range 16 {
+			filePath := filepath.Join(localDir, fmt.Sprintf("f%02d.txt", i))
+			require.NoError(t, os.WriteFile(filePath, []byte(strings.Repeat("x", i+1)), 0644))
+			wg.Go(func() {
+				shared := getUploader(s3Client)
+				if shared != uploader {
+					errs <- fmt.Errorf("worker %d got another uploader", i)
+					return
+				}
+				errs <- uploadSingleFile(ctx, shared, "shared", fmt.Sprintf("f%02d.txt", i), filePath)
+			})
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Len(t, fake.contents(), 16)
+		for i := range 16 {
+			assert.Equal(t, strings.Repeat("x", i+1), fake.content(fmt.Sprintf("shared/f%02d.txt", i)))
+		}
+	})
+
+	t.Run("reset creates new managers", func(t *testing.T) {
+		resetS3Client()
+		newClient, err := getS3Client(ctx)
+		require.NoError(t, err)
+		assert.NotSame(t, uploader, getUploader(newClient))
+		assert.NotSame(t, downloader, getDownloader(newClient))
+	})
+
+	t.Run("other clients are not cached", func(t *testing.T) {
+		other := s3.New(s3.Options{Region: "us-east-1"})
+		assert.NotSame(t, getUploader(other), getUploader(other))
+	})
+}
@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go/logging"
+	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfig(t *testing.T) {
@@ -41,3 +50,293 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, "http://localhost:9000", cfg.Endpoint)
 	})
 }
+
+func TestLoadJSONConfig(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("full config", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+
+		input := `{
+			"endpoint": "http://localhost:9000",
+			"access_key": "json-key",
+			"secret_key": "json-secret",
+			"region": "eu-central-1",
+			"use_path_style": true,
+			"source": "./data",
+			"destination": "s3://json-bucket/backup/",
+			"recursive": true,
+			"encrypt": true,
+			"password": "json-password",
+			"max_workers": 8,
+			"ignore": "*.tmp",
+			"sync_compare": "size-time"
+		}`
+
+		err := loadJSONConfig(strings.NewReader(input), newApp())
+		require.NoError(t, err)
+
+		assert.Equal(t, "http://localhost:9000", config.Endpoint)
+		assert.Equal(t, "json-key", config.AccessKey)
+		assert.Equal(t, "json-secret", config.SecretKey)
+		assert.Equal(t, "eu-central-1", config.Region)
+		assert.True(t, config.UsePathStyle)
+		assert.Equal(t, "./data", source)
+		assert.Equal(t, "s3://json-bucket/backup/", destination)
+		assert.True(t, recursive)
+		assert.True(t, encrypt)
+		assert.Equal(t, "json-password", password)
+		assert.Equal(t, 8, maxWorkers)
+		assert.Equal(t, "*.tmp", ignorePatterns)
+		assert.Equal(t, "size-time", syncCompare)
+	})
+
+	t.Run("omitted fields keep flag values", func(t *testing.T) {
+		setTestConfig("flag-source", "flag-dest", "flag-bucket", false, true, false, false)
+
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s"}`), newApp())
+		require.NoError(t, err)
+
+		assert.Equal(t, "us-east-1", config.Region)
+		assert.Equal(t, "flag-source", source)
+		assert.Equal(t, "flag-dest", destination)
+		assert.Equal(t, "flag-bucket", bucket)
+		assert.True(t, recursive)
+		assert.Equal(t, 5, maxWorkers)
+	})
+
+	t.Run("every flag can be set", func(t *testing.T) {
+		setTestConfig("", "flag-dest", "", false, false, false, false)
+
+		input := `{
+			"access_key": "k",
+			"secret_key": "s",
+			"destination": ["s3://first/data/", "s3://second/data/"],
+			"checksums_file": "SHA256SUMS",
+			"if_metadata": "team=ops",
+			"pack_threshold": "1KB",
+			"run_id": null
+		}`
+		err := loadJSONConfig(strings.NewReader(input), newApp())
+		require.NoError(t, err)
+
+		assert.Equal(t, "s3://first/data/", destination)
+		assert.Equal(t, []string{"s3://first/data/", "s3://second/data/"}, destinations)
+		assert.Equal(t, "SHA256SUMS", checksumsFile)
+		assert.Equal(t, []string{"team=ops"}, ifMetadata)
+		assert.Equal(t, "1KB", packThreshold)
+	})
+
+	t.Run("destination replaces the flag values", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+		app := newApp()
+		require.NoError(t, app.Set("destination", "s3://flag/one/"))
+		require.NoError(t, app.Set("destination", "s3://flag/two/"))
+
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s", "destination": "s3://json/"}`), app)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s3://json/"}, destinations)
+		assert.Equal(t, "s3://json/", destination)
+		assert.True(t, app.IsSet("destination"))
+	})
+
+	t.Run("flag aliases", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s", "s": "./data", "r": true}`), newApp())
+		require.NoError(t, err)
+		assert.Equal(t, "./data", source)
+		assert.True(t, recursive)
+		assert.Equal(t, sourceStdin, configSources["source"])
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s", "max_workers": "many"}`), newApp())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `"max_workers"`)
+
+		err = loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s", "source": ["a", "b"]}`), newApp())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected a single value")
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k"}`), newApp())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing required fields")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s", "sorce": "x"}`), newApp())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "sorce")
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		err := loadJSONConfig(strings.NewReader(`{not json`), newApp())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to parse JSON config")
+	})
+
+	t.Run("encryption without password", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+		password = ""
+
+		err := loadJSONConfig(strings.NewReader(`{"access_key": "k", "secret_key": "s", "encrypt": true}`), newApp())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not provide a password")
+	})
+
+	t.Run("encryption with password map", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+		password = ""
+
+		input := `{"access_key": "k", "secret_key": "s", "encrypt": true, "password_map": "passwords.txt"}`
+		err := loadJSONConfig(strings.NewReader(input), newApp())
+		require.NoError(t, err)
+		assert.True(t, encrypt)
+		assert.Equal(t, "passwords.txt", passwordMap)
+	})
+}
+
+func TestNeedsPasswordPrompt(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	setTestConfig("", "", "", true, false, false, false)
+	password = ""
+	assert.True(t, needsPasswordPrompt())
+
+	password = "PROMPT"
+	passwordRules = []passwordRule{{}}
+	assert.True(t, needsPasswordPrompt(), "an empty --password is asked for even with a password map")
+
+	password = ""
+	assert.False(t, needsPasswordPrompt(), "the password map covers the keys")
+
+	password = "secret"
+	passwordRules = nil
+	assert.False(t, needsPasswordPrompt())
+
+	password = ""
+	encrypt = false
+	assert.False(t, needsPasswordPrompt())
+	verifyEncryption = true
+	assert.True(t, needsPasswordPrompt())
+}
+
+func TestFindEnvFile(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "project", "sub", "dir")
+	require.NoError(t, os.MkdirAll(nested, 0755))
+
+	t.Run("discovers .env in a parent directory", func(t *testing.T) {
+		envPath := filepath.Join(root, "project", ".env")
+		require.NoError(t, os.WriteFile(envPath, []byte("S3COPY_ACCESS_KEY=parent\n"), 0644))
+		defer func() { _ = os.Remove(envPath) }()
+
+		t.Chdir(nested)
+		found := findEnvFile()
+		assert.Equal(t, "parent", readEnvAccessKey(t, found))
+	})
+
+	t.Run("nearest .env wins", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(root, "project", ".env"), []byte("S3COPY_ACCESS_KEY=parent\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "project", "sub", ".env"), []byte("S3COPY_ACCESS_KEY=nearest\n"), 0644))
+
+		t.Chdir(nested)
+		assert.Equal(t, "nearest", readEnvAccessKey(t, findEnvFile()))
+	})
+
+	t.Run("directory named .env is skipped", func(t *testing.T) {
+		emptyRoot := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(emptyRoot, ".env"), 0755))
+
+		t.Chdir(emptyRoot)
+		found := findEnvFile()
+		assert.NotEqual(t, filepath.Join(emptyRoot, ".env"), found)
+	})
+}
+
+func readEnvAccessKey(t *testing.T, path string) string {
+	t.Helper()
+	values, err := godotenv.Read(path)
+	require.NoError(t, err)
+	return values["S3COPY_ACCESS_KEY"]
+}
+
+func TestPreferIPv4(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	// the SDK cannot add a CA bundle to the throttled client
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer closeWithLog(listener, "listener")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			closeWithLog(conn, "connection")
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	dial := ipv4DialContext(&net.Dialer{Timeout: time.Second})
+	conn, err := dial(context.Background(), "tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	closeWithLog(conn, "connection")
+
+	// an IPv6 address cannot be dialed over tcp4, whatever the network supports
+	_, err = dial(context.Background(), "tcp", fmt.Sprintf("[::1]:%d", port))
+	require.Error(t, err)
+
+	setTestConfig("", "", "", false, false, true, false)
+	config = Config{AccessKey: "access", SecretKey: "secret", Region: "us-east-1"}
+	preferIPv4 = true
+	cfg, err := createS3Config(context.Background())
+	require.NoError(t, err)
+	client, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	require.True(t, ok, "expected the SDK client, got %T", cfg.HTTPClient)
+	require.NotNil(t, client.GetTransport().DialContext)
+	_, err = client.GetTransport().DialContext(context.Background(), "tcp", fmt.Sprintf("[::1]:%d", port))
+	require.Error(t, err)
+
+	bandwidth = newBandwidthLimiter(bandwidthSchedule{})
+	cfg, err = createS3Config(context.Background())
+	require.NoError(t, err)
+	throttled, ok := cfg.HTTPClient.(*throttledHTTPClient)
+	require.True(t, ok, "expected the throttled client, got %T", cfg.HTTPClient)
+	assert.NotNil(t, throttled.client.(*awshttp.BuildableClient).GetTransport().DialContext)
+}
+
+func TestDebugHTTP(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	setTestConfig("", "", "", false, false, true, false)
+	config = Config{AccessKey: "access", SecretKey: "secret", Region: "us-east-1"}
+	cfg, err := createS3Config(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, cfg.ClientLogMode)
+
+	debugHTTP = true
+	cfg, err = createS3Config(context.Background())
+	require.NoError(t, err)
+	assert.True(t, cfg.ClientLogMode.IsRequest())
+	assert.True(t, cfg.ClientLogMode.IsResponse())
+	assert.False(t, cfg.ClientLogMode.IsRequestWithBody())
+
+	var trace strings.Builder
+	newHTTPTraceLogger(&trace).Logf(logging.Debug, "Request\n%s", "PUT /bucket/key HTTP/1.1\r\nHost: localhost\r\n"+
+		"Authorization: AWS4-HMAC-SHA256 Credential=access/20260101/us-east-1/s3/aws4_request, Signature=abc\r\n"+
+		"x-amz-security-token: token\r\nX-Amz-Date: 20260101T000000Z\r\n")
+	assert.Contains(t, trace.String(), "Authorization: "+redactedValue+"\r\n")
+	assert.Contains(t, trace.String(), "x-amz-security-token: "+redactedValue+"\r\n")
+	assert.Contains(t, trace.String(), "X-Amz-Date: 20260101T000000Z")
+	assert.NotContains(t, trace.String(), "Signature=abc")
+}
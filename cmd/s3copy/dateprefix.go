@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultDatePrefixTemplate produces Hive-style partitions that Athena and
+// Spark discover without further configuration
+const defaultDatePrefixTemplate = "year={year}/month={month}/day={day}"
+
+// datePlaceholderPattern matches the {name} placeholders of a template
+var datePlaceholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// datePlaceholders maps the template placeholders to time layouts
+var datePlaceholders = map[string]string{
+	"{year}":   "2006",
+	"{month}":  "01",
+	"{day}":    "02",
+	"{hour}":   "15",
+	"{minute}": "04",
+}
+
+// validateDatePrefixTemplate rejects templates with unknown placeholders or
+// without any date placeholder
+func validateDatePrefixTemplate(template string) error {
+	placeholders := datePlaceholderPattern.FindAllString(template, -1)
+	if len(placeholders) == 0 {
+		return fmt.Errorf("template %q has no date placeholder, use {year}, {month}, {day}, {hour} or {minute}", template)
+	}
+	for _, placeholder := range placeholders {
+		if _, ok := datePlaceholders[placeholder]; !ok {
+			return fmt.Errorf("unknown placeholder %s in template %q", placeholder, template)
+		}
+	}
+	return nil
+}
+
+// renderDatePrefix fills the template with the UTC date of a modification
+// time, so partitions do not depend on the time zone of the uploading host
+func renderDatePrefix(template string, modTime time.Time) string {
+	utc := modTime.UTC()
+	rendered := datePlaceholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return utc.Format(datePlaceholders[placeholder])
+	})
+	return strings.Trim(rendered, "/")
+}
+
+// addDatePrefix inserts the --date-prefix partition of a file between the
+// destination prefix and relPath, the part of each key derived from the
+// local path. An empty relPath means the last segment of each key, for single
+// files uploaded to a full key. Without --date-prefix the targets are
+// returned unchanged.
+func addDatePrefix(targets []uploadTarget, relPath string, modTime time.Time) []uploadTarget {
+	if !datePrefix {
+		return targets
+	}
+
+	partition := renderDatePrefix(datePrefixTemplate, modTime)
+	prefixed := make([]uploadTarget, len(targets))
+	for i, target := range targets {
+		relKey := strings.ReplaceAll(relPath, "\\", "/")
+		if relKey == "" {
+			relKey = path.Base(target.key)
+		}
+		prefix := strings.TrimSuffix(strings.TrimSuffix(target.key, relKey), "/")
+		prefixed[i] = uploadTarget{
+			bucket: target.bucket,
+			key:    strings.TrimPrefix(path.Join(prefix, partition, relKey), "/"),
+		}
+	}
+	return prefixed
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderDatePrefix(t *testing.T) {
+	modTime := time.Date(2024, 6, 1, 23, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t, "year=2024/month=06/day=01", renderDatePrefix(defaultDatePrefixTemplate, modTime))
+	assert.Equal(t, "2024/06/01/21", renderDatePrefix("/{year}/{month}/{day}/{hour}/", modTime))
+	assert.Equal(t, "dt=2024-06-01", renderDatePrefix("dt={year}-{month}-{day}", modTime))
+}
+
+func TestValidateDatePrefixTemplate(t *testing.T) {
+	assert.NoError(t, validateDatePrefixTemplate(defaultDatePrefixTemplate))
+	assert.NoError(t, validateDatePrefixTemplate("{year}/{month}/{day}/{hour}/{minute}"))
+	assert.ErrorContains(t, validateDatePrefixTemplate("logs"), "no date placeholder")
+	assert.ErrorContains(t, validateDatePrefixTemplate("year={yyyy}"), "unknown placeholder {yyyy}")
+}
+
+func TestAddDatePrefix(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	modTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	targets := []uploadTarget{
+		{bucket: "logs", key: "raw/app/a.log"},
+		{bucket: "mirror", key: "app/a.log"},
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Equal(t, targets, addDatePrefix(targets, filepath.Join("app", "a.log"), modTime))
+	})
+
+	datePrefix = true
+	datePrefixTemplate = defaultDatePrefixTemplate
+
+	t.Run("between prefix and relative path", func(t *testing.T) {
+		assert.Equal(t, []uploadTarget{
+			{bucket: "logs", key: "raw/year=2024/month=06/day=01/app/a.log"},
+			{bucket: "mirror", key: "year=2024/month=06/day=01/app/a.log"},
+		}, addDatePrefix(targets, filepath.Join("app", "a.log"), modTime))
+	})
+
+	t.Run("single file key", func(t *testing.T) {
+		assert.Equal(t, []uploadTarget{{bucket: "logs", key: "raw/year=2024/month=06/day=01/renamed.log"}},
+			addDatePrefix([]uploadTarget{{bucket: "logs", key: "raw/renamed.log"}}, "", modTime))
+	})
+}
+
+func TestUploadDatePrefix(t *testing.T) {
+	ctx := context.Background()
+
+	restore := preserveGlobalVars()
+	defer restore()
+	resetS3Client()
+	defer resetS3Client()
+
+	config = Config{
+		AccessKey: "dummy",
+		SecretKey: "dummy",
+		Region:    "us-east-1",
+	}
+
+	dir := t.TempDir()
+	files := map[string]time.Time{
+		"june.log":                       time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC),
+		"july.log":                       time.Date(2024, 7, 15, 8, 0, 0, 0, time.UTC),
+		filepath.Join("web", "late.log"): time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC),
+	}
+	for name, modTime := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("log"), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	keyPattern := regexp.MustCompile(`s3://bucket/(\S+)`)
+	uploadKeys := func(t *testing.T, src, dst, template string) []string {
+		setTestConfig(src, dst, "", false, true, false, false)
+		dryRun = true
+		datePrefix = true
+		datePrefixTemplate = template
+
+		var err error
+		output := captureStdout(func() {
+			err = uploadToS3(ctx)
+		})
+		require.NoError(t, err)
+
+		var keys []string
+		for _, match := range keyPattern.FindAllStringSubmatch(output, -1) {
+			keys = append(keys, match[1])
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"raw/year=2024/month=06/day=01/june.log",
+			"raw/year=2024/month=06/day=01/web/late.log",
+			"raw/year=2024/month=07/day=15/july.log",
+		}, uploadKeys(t, dir, "s3://bucket/raw/", defaultDatePrefixTemplate))
+	})
+
+	t.Run("glob", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"raw/year=2024/month=06/day=01/june.log",
+			"raw/year=2024/month=07/day=15/july.log",
+		}, uploadKeys(t, filepath.Join(dir, "*.log"), "s3://bucket/raw/", defaultDatePrefixTemplate))
+	})
+
+	t.Run("single file with custom template", func(t *testing.T) {
+		assert.Equal(t, []string{"raw/dt=2024-07-15/july.log"}, uploadKeys(t, filepath.Join(dir, "july.log"), "s3://bucket/raw/", "dt={year}-{month}-{day}"))
+	})
+}
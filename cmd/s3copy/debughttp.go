@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/logging"
+)
+
+// debugHTTPLogMode is the SDK log mode of --debug-http. Bodies are left out,
+// they are file contents.
+const debugHTTPLogMode = aws.LogRequest | aws.LogResponse | aws.LogRetries
+
+// sensitiveHeaderPattern matches the header lines of a dumped request that
+// carry the signature or a session token
+var sensitiveHeaderPattern = regexp.MustCompile(`(?im)^((?:Authorization|X-Amz-Security-Token):)[^\r\n]*`)
+
+// redactHTTPTrace replaces the values of the sensitive headers in a dumped
+// request or response
+func redactHTTPTrace(message string) string {
+	return sensitiveHeaderPattern.ReplaceAllString(message, "${1} "+redactedValue)
+}
+
+// newHTTPTraceLogger returns an SDK logger that writes the request and
+// response dumps of --debug-http to w with the credentials redacted
+func newHTTPTraceLogger(w io.Writer) logging.Logger {
+	return logging.LoggerFunc(func(classification logging.Classification, format string, v ...any) {
+		fmt.Fprintf(w, "%s %s\n", classification, redactHTTPTrace(fmt.Sprintf(format, v...)))
+	})
+}
+
+// applyDebugHTTP enables the HTTP trace of --debug-http on cfg
+func applyDebugHTTP(cfg *aws.Config) {
+	if !debugHTTP {
+		return
+	}
+	cfg.ClientLogMode = debugHTTPLogMode
+	cfg.Logger = newHTTPTraceLogger(os.Stderr)
+}
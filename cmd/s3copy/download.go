@@ -2,10 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
@@ -18,7 +28,7 @@ func downloadFromS3(ctx context.Context) error {
 		return fmt.Errorf("failed to get S3 client: %w", err)
 	}
 
-	downloader := manager.New(s3Client)
+	downloader := getDownloader(s3Client)
 
 	s3Path := strings.TrimPrefix(source, "s3://")
 	var s3Key string
@@ -34,27 +44,51 @@ func downloadFromS3(ctx context.Context) error {
 		s3Key = strings.TrimPrefix(s3Path, bucket+"/")
 	}
 
-	_, err = s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(s3Key),
 	})
 
 	if err == nil {
-		finalDestination := destination
+		if !metadataMatches(head.Metadata) {
+			logInfo("Skipping %s (metadata does not match --if-metadata)\n", s3Key)
+			return nil
+		}
 
-		if strings.HasSuffix(destination, "/") || destination == "." || destination == "./" {
-			filename := filepath.Base(s3Key)
-			finalDestination = filepath.Join(destination, filename)
-		} else {
+		finalDestination := destination
+		intoDir := strings.HasSuffix(destination, "/") || destination == "." || destination == "./"
+		if !intoDir {
 			if info, err := os.Stat(destination); err == nil && info.IsDir() {
-				filename := filepath.Base(s3Key)
-				finalDestination = filepath.Join(destination, filename)
+				intoDir = true
+			}
+		}
+
+		if intoDir {
+			finalDestination = filepath.Join(destination, filepath.Base(s3Key))
+			if nameFromMetadata != "" {
+				finalDestination = applyMetadataFileName(head.Metadata, s3Key, finalDestination)
 			}
 		}
 
+		if checkFreeSpace {
+			if err := ensureFreeSpace(filepath.Dir(finalDestination), aws.ToInt64(head.ContentLength)); err != nil {
+				return err
+			}
+		}
+
+		defer report.track(phaseTransfer)()
+		report.queueFile()
+		defer report.completeFile()
 		return downloadFile(ctx, downloader, s3Key, finalDestination)
 	}
 
+	if exactKey {
+		if isNotFound(err) {
+			return fmt.Errorf("object not found: s3://%s/%s", bucket, s3Key)
+		}
+		return fmt.Errorf("failed to check s3://%s/%s: %w", bucket, s3Key, err)
+	}
+
 	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
 		Prefix: aws.String(s3Key),
@@ -63,14 +97,59 @@ func downloadFromS3(ctx context.Context) error {
 	type downloadTask struct {
 		s3Key     string
 		localPath string
+		group     *logGroup
+	}
+
+	var groups *logGroups
+	if groupOutput {
+		groups = newLogGroups()
+	}
+
+	if checkFreeSpace {
+		required, err := listedDownloadSize(ctx, s3Client, bucket, s3Key)
+		if err != nil {
+			return err
+		}
+		if err := ensureFreeSpace(destination, required); err != nil {
+			return err
+		}
 	}
 
-	if err := os.MkdirAll(destination, 0755); err != nil {
+	if err := makeDownloadDir(destination); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
+	collisions := newCaseCollisions(caseInsensitiveDir(destination))
 
-	return runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task downloadTask) error {
-		if err := os.MkdirAll(filepath.Dir(task.localPath), 0755); err != nil {
+	downloadObject := func(workerCtx context.Context, task downloadTask) error {
+		release, err := acquireTransferSlot(workerCtx, bucket)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		matches, err := objectMetadataMatches(workerCtx, s3Client, bucket, task.s3Key)
+		if err != nil {
+			return fmt.Errorf("failed to check metadata of %s: %w", task.s3Key, err)
+		}
+		if !matches {
+			logVerboseContext(workerCtx, "Skipping %s (metadata does not match --if-metadata)\n", task.s3Key)
+			return nil
+		}
+
+		if nameFromMetadata != "" {
+			task.localPath, err = metadataLocalPath(workerCtx, s3Client, bucket, task.s3Key, task.localPath)
+			if err != nil {
+				return fmt.Errorf("failed to check metadata of %s: %w", task.s3Key, err)
+			}
+		}
+		if unflatten {
+			task.localPath, err = unflattenedLocalPath(workerCtx, s3Client, bucket, task.s3Key, task.localPath)
+			if err != nil {
+				return fmt.Errorf("failed to check metadata of %s: %w", task.s3Key, err)
+			}
+		}
+
+		if err := makeDownloadDir(filepath.Dir(task.localPath)); err != nil {
 			return fmt.Errorf("failed to create directory: %w", err)
 		}
 
@@ -78,6 +157,31 @@ func downloadFromS3(ctx context.Context) error {
 			return fmt.Errorf("failed to download %s: %w", task.s3Key, err)
 		}
 		return nil
+	}
+
+	// with --continue-on-error a failed object, and always an object that
+	// exceeded --per-file-timeout, is collected here instead of stopping the
+	// other workers
+	var objectErrors []string
+	var objectErrorsMutex sync.Mutex
+	// packs are collected by the listing and unpacked after the other objects
+	var packs []packObject
+
+	stopTransfer := report.track(phaseTransfer)
+	err = runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task downloadTask) error {
+		defer report.completeFile()
+		defer groups.done(task.group)
+		workerCtx = withLogGroup(workerCtx, task.group)
+
+		err := downloadObject(workerCtx, task)
+		if err == nil || (!continueOnError && !isFileTimeout(err)) || workerCtx.Err() != nil {
+			return err
+		}
+		logInfoContext(workerCtx, "Error: %v\n", err)
+		objectErrorsMutex.Lock()
+		objectErrors = append(objectErrors, err.Error())
+		objectErrorsMutex.Unlock()
+		return nil
 	}, func(producerCtx context.Context, taskChan chan<- downloadTask) error {
 		foundObjects := false
 
@@ -95,56 +199,133 @@ func downloadFromS3(ctx context.Context) error {
 				if relPath == "" {
 					relPath = filepath.Base(*obj.Key)
 				}
+				if isPackKey(*obj.Key) {
+					// packs unpack into the directory that holds their pack directory
+					packs = append(packs, packObject{
+						key:      *obj.Key,
+						destDir:  filepath.Join(destination, path.Dir(path.Dir(relPath))),
+						modified: aws.ToTime(obj.LastModified),
+					})
+					continue
+				}
+				relPath, err := collisions.resolve(*obj.Key, relPath)
+				if err != nil {
+					return err
+				}
 
 				task := downloadTask{
 					s3Key:     *obj.Key,
 					localPath: filepath.Join(destination, relPath),
+					group:     groups.add(relPath),
 				}
 
 				select {
 				case <-producerCtx.Done():
 					return producerCtx.Err()
 				case taskChan <- task:
+					report.queueFile()
 				}
 			}
 		}
 
+		groups.finish()
 		if !foundObjects {
 			return fmt.Errorf("no objects found with prefix: %s", s3Key)
 		}
 
 		return nil
 	})
+	groups.flushAll()
+	if err == nil {
+		err = unpackAll(ctx, s3Client, bucket, packs, func(err error) bool {
+			if !continueOnError {
+				return false
+			}
+			logInfo("Error: %v\n", err)
+			objectErrors = append(objectErrors, err.Error())
+			return true
+		})
+	}
+	stopTransfer()
+	if err != nil {
+		return err
+	}
+	if len(objectErrors) > 0 {
+		printObjectErrors("Download", "Downloaded", report.transferredFiles(), objectErrors)
+		return &partialFailureError{fmt.Errorf("download completed with %d error(s)", len(objectErrors))}
+	}
+
+	if verifyManifestPath != "" && !dryRun {
+		return verifyManifest(ctx, verifyManifestPath, destination)
+	}
+
+	return nil
+}
+
+// printObjectErrors prints the objects a --continue-on-error download or move
+// could not process, in the format of the sync summary
+func printObjectErrors(operation, doneLabel string, done int64, objectErrors []string) {
+	if !currentOutputMode().summary() {
+		return
+	}
+	slices.Sort(objectErrors)
+	fmt.Printf("\n=== %s Summary ===\n", operation)
+	fmt.Printf("%s: %d files\n", doneLabel, done)
+	fmt.Printf("Errors: %d\n", len(objectErrors))
+	for _, err := range objectErrors {
+		fmt.Printf("  error %s\n", err)
+	}
 }
 
 func downloadFile(ctx context.Context, downloader *manager.Client, s3Key, localPath string) error {
-	return downloadFileWithParams(ctx, downloader, bucket, s3Key, localPath, true)
+	err := downloadFileWithParams(ctx, downloader, bucket, s3Key, localPath, true)
+	recordFailure(operationDownload, localPath, fmt.Sprintf("s3://%s/%s", bucket, s3Key), err)
+	return err
 }
 
 func downloadFileWithParams(ctx context.Context, downloader *manager.Client, bucketName, s3Key, localPath string, checkSkipExisting bool) error {
+	release, err := openFileSlots.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runWithFileTimeout(ctx, s3Key, func(fileCtx context.Context) error {
+		return performS3Download(fileCtx, downloader, bucketName, s3Key, localPath, checkSkipExisting)
+	})
+}
+
+func performS3Download(ctx context.Context, downloader *manager.Client, bucketName, s3Key, localPath string, checkSkipExisting bool) error {
 	if checkSkipExisting {
-		logInfo("Downloading s3://%s/%s to %s\n", bucketName, s3Key, localPath)
+		logInfoContext(ctx, "Downloading s3://%s/%s to %s\n", bucketName, s3Key, localPath)
 	}
 
 	if dryRun {
 		return nil
 	}
 
-	if checkSkipExisting && !forceOverwrite && !encrypt {
+	decryptFile := shouldEncryptFile(s3Key)
+
+	if checkSkipExisting && skipSameSize && !decryptFile && sameSizeLocally(ctx, bucketName, s3Key, localPath) {
+		logInfoContext(ctx, "Skipping %s (local file already exists with the same size)\n", localPath)
+		return nil
+	}
+
+	if checkSkipExisting && !forceOverwrite && !decryptFile {
 		if _, err := os.Stat(localPath); err == nil {
 			localMD5, err := calculateFileMD5(localPath)
 			if err != nil {
-				logVerbose("Warning: Could not calculate MD5 for local file %s: %v\n", localPath, err)
+				logVerboseContext(ctx, "Warning: Could not calculate MD5 for local file %s: %v\n", localPath, err)
 			} else {
 				s3Client, err := getS3Client(ctx)
 				if err != nil {
-					logVerbose("Warning: Could not get S3 client for checksum check: %v\n", err)
+					logVerboseContext(ctx, "Warning: Could not get S3 client for checksum check: %v\n", err)
 				} else {
-					skip, err := compareFileChecksums(ctx, s3Client, bucketName, s3Key, localMD5)
+					skip, err := compareFileChecksums(ctx, s3Client, bucketName, s3Key, localPath, localMD5)
 					if err != nil {
-						logVerbose("Warning: %v\n", err)
+						logVerboseContext(ctx, "Warning: %v\n", err)
 					} else if skip {
-						logInfo("Skipping %s (local file already exists with same checksum)\n", localPath)
+						logInfoContext(ctx, "Skipping %s (local file already exists with same checksum)\n", localPath)
 						return nil
 					}
 				}
@@ -152,30 +333,21 @@ func downloadFileWithParams(ctx context.Context, downloader *manager.Client, buc
 		}
 	}
 
-	if encrypt {
+	if decryptFile {
 		tempFile, err := os.CreateTemp(filepath.Dir(localPath), ".s3copy-tmp-*")
 		if err != nil {
 			return fmt.Errorf("failed to create temp file: %w", err)
 		}
 		tempPath := tempFile.Name()
-		defer func() {
-			if err := os.Remove(tempPath); err != nil {
-				fmt.Printf("Warning: failed to remove temp file %s: %v\n", tempPath, err)
-			}
-		}()
-
-		_, err = downloader.DownloadObject(ctx, &manager.DownloadObjectInput{
-			Bucket:   aws.String(bucketName),
-			Key:      aws.String(s3Key),
-			WriterAt: tempFile,
-		})
-
-		closeWithLog(tempFile, tempPath)
+		defer removeTempFile(tempPath)
 
+		output, err := downloadVerified(ctx, downloader, bucketName, s3Key, tempFile)
 		if err != nil {
 			return err
 		}
 
+		warnEncryptionMismatch(tempPath, fmt.Sprintf("s3://%s/%s", bucketName, s3Key), true)
+
 		tempFileRead, err := os.Open(tempPath)
 		if err != nil {
 			return fmt.Errorf("failed to open temp file for decryption: %w", err)
@@ -187,19 +359,30 @@ func downloadFileWithParams(ctx context.Context, downloader *manager.Client, buc
 			return fmt.Errorf("failed to create temp decrypted file for %s: %w", localPath, err)
 		}
 		decryptedTempPath := decryptedTempFile.Name()
-		defer func() {
-			if err := os.Remove(decryptedTempPath); err != nil && !os.IsNotExist(err) {
-				fmt.Printf("Warning: failed to remove temp file %s: %v\n", decryptedTempPath, err)
-			}
-		}()
+		defer removeTempFile(decryptedTempPath)
 
-		if err := decryptStreamFromReader(decryptedTempFile, tempFileRead); err != nil {
+		secret, err := objectPassword(s3Key, output.Metadata)
+		if err != nil {
+			closeWithLog(decryptedTempFile, decryptedTempPath)
+			return err
+		}
+		if err := decryptWithRetry(decryptedTempFile, tempFileRead, plaintextSize(ctx, output.Metadata), secret); err != nil {
 			closeWithLog(decryptedTempFile, decryptedTempPath)
 			return fmt.Errorf("decryption failed: %w", err)
 		}
 
 		closeWithLog(decryptedTempFile, decryptedTempPath)
 
+		if filterCmd != "" {
+			if err := filterFileInPlace(ctx, decryptedTempPath); err != nil {
+				return err
+			}
+		}
+
+		if err := applyDownloadFileMode(decryptedTempPath); err != nil {
+			return err
+		}
+
 		if err := os.Rename(decryptedTempPath, localPath); err != nil {
 			if removeErr := os.Remove(localPath); removeErr != nil && !os.IsNotExist(removeErr) {
 				return fmt.Errorf("failed to replace existing file %s: %w", localPath, removeErr)
@@ -208,25 +391,28 @@ func downloadFileWithParams(ctx context.Context, downloader *manager.Client, buc
 				return fmt.Errorf("failed to move decrypted file into place: %w", renameErr)
 			}
 		}
+		report.addFile(localPath)
 	} else {
 		tempFile, err := os.CreateTemp(filepath.Dir(localPath), ".s3copy-dl-*")
 		if err != nil {
 			return fmt.Errorf("failed to create temp file for %s: %w", localPath, err)
 		}
 		tempPath := tempFile.Name()
-		defer func() {
-			if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
-				fmt.Printf("Warning: failed to remove temp file %s: %v\n", tempPath, err)
+		defer removeTempFile(tempPath)
+
+		if _, err := downloadVerified(ctx, downloader, bucketName, s3Key, tempFile); err != nil {
+			return err
+		}
+
+		warnEncryptionMismatch(tempPath, fmt.Sprintf("s3://%s/%s", bucketName, s3Key), false)
+
+		if filterCmd != "" {
+			if err := filterFileInPlace(ctx, tempPath); err != nil {
+				return err
 			}
-		}()
+		}
 
-		_, err = downloader.DownloadObject(ctx, &manager.DownloadObjectInput{
-			Bucket:   aws.String(bucketName),
-			Key:      aws.String(s3Key),
-			WriterAt: tempFile,
-		})
-		closeWithLog(tempFile, tempPath)
-		if err != nil {
+		if err := applyDownloadFileMode(tempPath); err != nil {
 			return err
 		}
 
@@ -238,7 +424,183 @@ func downloadFileWithParams(ctx context.Context, downloader *manager.Client, buc
 				return fmt.Errorf("failed to move downloaded file into place: %w", renameErr)
 			}
 		}
+		report.addFile(localPath)
+	}
+
+	return nil
+}
+
+// downloadVerified downloads an object into tempFile, which it closes, and
+// verifies it with --verify and --spot-check. With
+// --retry-on-checksum-mismatch a file that fails verification is deleted and
+// the object downloaded again into a new file at the same path, up to the
+// configured number of retries.
+func downloadVerified(ctx context.Context, downloader *manager.Client, bucketName, s3Key string, tempFile *os.File) (*manager.DownloadObjectOutput, error) {
+	tempPath := tempFile.Name()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			removeTempFile(tempPath)
+			var err error
+			tempFile, err = os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create temp file: %w", err)
+			}
+		}
+
+		output, err := downloader.DownloadObject(ctx, &manager.DownloadObjectInput{
+			Bucket:   aws.String(bucketName),
+			Key:      aws.String(s3Key),
+			WriterAt: tempFile,
+		})
+		closeWithLog(tempFile, tempPath)
+		if err != nil || (!verifyDownloads && spotCheck == 0) {
+			return output, err
+		}
+
+		if verifyDownloads {
+			err = verifyDownloadChecksum(tempPath, s3Key, output)
+		}
+		if err == nil && spotCheck > 0 {
+			err = spotCheckObject(ctx, bucketName, s3Key, tempPath)
+		}
+		if err == nil || !retryOnChecksumMismatch || !errors.Is(err, errChecksumMismatch) || attempt >= retries {
+			return output, err
+		}
+		logInfoContext(ctx, "Retrying download of s3://%s/%s (attempt %d/%d): %v\n", bucketName, s3Key, attempt+1, retries, err)
 	}
+}
+
+// readErrorRecorder remembers the last non-EOF error returned by the wrapped reader
+type readErrorRecorder struct {
+	reader io.Reader
+	err    error
+}
 
+func (r *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// decryptWithRetry decrypts the already downloaded src into dst. When reading
+// src fails, both files are rewound and decryption is retried up to the
+// configured number of retries, so a transient local read error does not
+// require downloading the object again. Authentication failures are not
+// retried. A plainSize that is not negative must match the decrypted size.
+func decryptWithRetry(dst *os.File, src io.ReadSeeker, plainSize int64, secret string) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			logVerbose("Retrying decryption (attempt %d/%d) after read error: %v\n", attempt, retries, err)
+			if _, seekErr := src.Seek(0, io.SeekStart); seekErr != nil {
+				return fmt.Errorf("failed to rewind encrypted file: %w", seekErr)
+			}
+			if _, seekErr := dst.Seek(0, io.SeekStart); seekErr != nil {
+				return fmt.Errorf("failed to rewind decrypted file: %w", seekErr)
+			}
+			if truncErr := dst.Truncate(0); truncErr != nil {
+				return fmt.Errorf("failed to truncate decrypted file: %w", truncErr)
+			}
+		}
+
+		recorder := &readErrorRecorder{reader: src}
+		err = decryptStreamWithSize(dst, recorder, plainSize, secret)
+		if err == nil || recorder.err == nil {
+			return err
+		}
+	}
+	return err
+}
+
+// downloadChecksum picks the additional checksum S3 returned for an object:
+// its name, the expected base64 value and the matching hash function. The
+// transfer manager drops composite checksums of multipart objects, which
+// cannot be recomputed from the whole file.
+func downloadChecksum(output *manager.DownloadObjectOutput) (string, string, func() hash.Hash) {
+	switch {
+	case aws.ToString(output.ChecksumSHA256) != "":
+		return "SHA256", *output.ChecksumSHA256, sha256.New
+	case aws.ToString(output.ChecksumCRC32C) != "":
+		return "CRC32C", *output.ChecksumCRC32C, func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }
+	case aws.ToString(output.ChecksumCRC32) != "":
+		return "CRC32", *output.ChecksumCRC32, func() hash.Hash { return crc32.NewIEEE() }
+	case aws.ToString(output.ChecksumSHA1) != "":
+		return "SHA1", *output.ChecksumSHA1, sha1.New
+	}
+	return "", "", nil
+}
+
+// errChecksumMismatch marks a downloaded file whose content does not match
+// the checksum stored with its object
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyDownloadChecksum recomputes the additional checksum stored with an
+// object on the downloaded file. Unlike the ETag, the checksum covers the
+// whole object also for multipart uploads.
+func verifyDownloadChecksum(filePath, s3Key string, output *manager.DownloadObjectOutput) error {
+	if integrityPolicy == integritySizeOnly {
+		return verifyDownloadSize(filePath, s3Key, output)
+	}
+
+	name, expected, newHash := downloadChecksum(output)
+	if newHash == nil {
+		return verifyDownloadMD5(filePath, s3Key, output)
+	}
+
+	sum, err := calculateFileChecksum(filePath, newHash)
+	if err != nil {
+		return fmt.Errorf("failed to calculate %s checksum of %s: %w", name, s3Key, err)
+	}
+
+	if actual := base64.StdEncoding.EncodeToString(sum); actual != expected {
+		return fmt.Errorf("%s %w for %s: expected %s, got %s", name, errChecksumMismatch, s3Key, expected, actual)
+	}
+
+	logVerbose("Verified %s checksum of %s\n", name, s3Key)
+	return nil
+}
+
+// verifyDownloadMD5 verifies a download of an object without an additional
+// checksum. etag-first skips the verification, the other policies recompute
+// the MD5 and compare it with the local-md5 metadata or a single-part ETag.
+// recompute-always fails when the object has neither.
+func verifyDownloadMD5(filePath, s3Key string, output *manager.DownloadObjectOutput) error {
+	object := objectIntegrity{
+		size:      -1,
+		etag:      normalizeETag(aws.ToString(output.ETag)),
+		storedMD5: output.Metadata["local-md5"],
+	}
+	if integrityPolicy == integrityETagFirst || (object.storedMD5 == "" && !isSinglePartETag(object.etag)) {
+		if integrityPolicy == integrityRecomputeAlways {
+			return fmt.Errorf("cannot verify %s: the object has no checksum, local-md5 metadata or single-part ETag", s3Key)
+		}
+		logVerbose("No additional checksum stored for %s, skipping verification\n", s3Key)
+		return nil
+	}
+
+	fileMD5, err := calculateFileMD5(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate MD5 of %s: %w", s3Key, err)
+	}
+	if same, evidence := integrityMatch(integrityMetadataFirst, -1, fileMD5, object); !same {
+		return fmt.Errorf("MD5 %w for %s: got %s, which does not match the %s", errChecksumMismatch, s3Key, fileMD5, strings.TrimPrefix(evidence, "checksum via "))
+	}
+	logVerbose("Verified MD5 of %s\n", s3Key)
+	return nil
+}
+
+// verifyDownloadSize checks only the length of a download, for
+// --integrity-policy size-only
+func verifyDownloadSize(filePath, s3Key string, output *manager.DownloadObjectOutput) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	if output.ContentLength != nil && info.Size() != *output.ContentLength {
+		return fmt.Errorf("size %w for %s: expected %d bytes, got %d", errChecksumMismatch, s3Key, *output.ContentLength, info.Size())
+	}
+	logVerbose("Verified size of %s\n", s3Key)
 	return nil
 }
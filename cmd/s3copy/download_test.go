@@ -3,12 +3,25 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -52,3 +65,394 @@ func TestDownloadFromS3Directory(t *testing.T) {
 		}
 	})
 }
+
+// flakyReadSeeker returns an error for the next `failures` reads past the header, then reads normally
+type flakyReadSeeker struct {
+	*bytes.Reader
+	failures int
+}
+
+func (f *flakyReadSeeker) Read(p []byte) (int, error) {
+	if f.failures > 0 && f.Size()-int64(f.Len()) >= 44 {
+		f.failures--
+		return 0, errors.New("transient read error")
+	}
+	return f.Reader.Read(p)
+}
+
+func TestDecryptWithRetry(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	password = "retry-password"
+	plaintext := bytes.Repeat([]byte("retry me "), 1000)
+
+	var encrypted bytes.Buffer
+	require.NoError(t, encryptStream(&encrypted, bytes.NewReader(plaintext)))
+
+	t.Run("succeeds after transient read error", func(t *testing.T) {
+		retries = 3
+		dst, err := os.CreateTemp(t.TempDir(), "decrypted-*")
+		require.NoError(t, err)
+		defer closeWithLog(dst, dst.Name())
+
+		src := &flakyReadSeeker{Reader: bytes.NewReader(encrypted.Bytes()), failures: 2}
+		err = decryptWithRetry(dst, src, int64(len(plaintext)), password)
+		require.NoError(t, err)
+
+		_, err = dst.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		content, err := io.ReadAll(dst)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, content)
+	})
+
+	t.Run("gives up after retries are exhausted", func(t *testing.T) {
+		retries = 1
+		dst, err := os.CreateTemp(t.TempDir(), "decrypted-*")
+		require.NoError(t, err)
+		defer closeWithLog(dst, dst.Name())
+
+		src := &flakyReadSeeker{Reader: bytes.NewReader(encrypted.Bytes()), failures: 5}
+		err = decryptWithRetry(dst, src, -1, password)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "transient read error")
+	})
+
+	t.Run("wrong password is not retried", func(t *testing.T) {
+		retries = 3
+		password = "wrong-password"
+		defer func() { password = "retry-password" }()
+
+		dst, err := os.CreateTemp(t.TempDir(), "decrypted-*")
+		require.NoError(t, err)
+		defer closeWithLog(dst, dst.Name())
+
+		src := &flakyReadSeeker{Reader: bytes.NewReader(encrypted.Bytes())}
+		err = decryptWithRetry(dst, src, -1, password)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "wrong password")
+	})
+}
+
+func TestVerifyDownloadChecksum(t *testing.T) {
+	content := []byte("checksum verified content")
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+
+	sum := sha256.Sum256(content)
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+
+	t.Run("matching SHA256", func(t *testing.T) {
+		err := verifyDownloadChecksum(filePath, "file.txt", &manager.DownloadObjectOutput{ChecksumSHA256: aws.String(checksum)})
+		assert.NoError(t, err)
+	})
+
+	t.Run("mismatching SHA256", func(t *testing.T) {
+		other := sha256.Sum256([]byte("other content"))
+		err := verifyDownloadChecksum(filePath, "file.txt", &manager.DownloadObjectOutput{
+			ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(other[:])),
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SHA256 checksum mismatch")
+	})
+
+	t.Run("matching CRC32C", func(t *testing.T) {
+		crc := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+		err := verifyDownloadChecksum(filePath, "file.txt", &manager.DownloadObjectOutput{
+			ChecksumCRC32C: aws.String(base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc))),
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("no checksum", func(t *testing.T) {
+		assert.NoError(t, verifyDownloadChecksum(filePath, "file.txt", &manager.DownloadObjectOutput{}))
+	})
+}
+
+func TestRetryOnChecksumMismatch(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	content := "content that arrives damaged first"
+	sum := sha256.Sum256([]byte(content))
+	var gets, corrupted atomic.Int32
+	corrupted.Store(1)
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := content
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+			if corrupted.Add(-1) >= 0 {
+				body = strings.Replace(content, "damaged", "DAMAGED", 1)
+			}
+		}
+		w.Header().Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sum[:]))
+		http.ServeContent(w, r, "object", time.Unix(0, 0), strings.NewReader(body))
+	}))
+
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	setTestConfig("s3://flaky/file.txt", localPath, "", false, false, true, false)
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+	verifyDownloads = true
+	retries = 2
+
+	// the SDK checks the checksum while it reads the body and retries on its
+	// own; without that check the damage reaches the file like corruption
+	// that happens after the transfer
+	unchecked := s3.New(s3Client.Options(), func(o *s3.Options) {
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+	downloader := manager.New(unchecked, func(o *manager.Options) {
+		o.DisableChecksumValidation = true
+	})
+	download := func() error {
+		var err error
+		captureStdout(func() {
+			err = performS3Download(context.Background(), downloader, "flaky", "file.txt", localPath, false)
+		})
+		return err
+	}
+
+	t.Run("without retry the mismatch fails", func(t *testing.T) {
+		gets.Store(0)
+		corrupted.Store(1)
+		err := download()
+		require.Error(t, err)
+		assert.ErrorIs(t, err, errChecksumMismatch)
+		assert.NoFileExists(t, localPath)
+	})
+
+	t.Run("retry downloads a correct file", func(t *testing.T) {
+		retryOnChecksumMismatch = true
+		gets.Store(0)
+		corrupted.Store(1)
+		require.NoError(t, download())
+		assert.Equal(t, int32(2), gets.Load())
+
+		downloaded, err := os.ReadFile(localPath)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(downloaded))
+
+		entries, err := os.ReadDir(filepath.Dir(localPath))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "no temp files are left behind")
+	})
+
+	t.Run("retries are limited", func(t *testing.T) {
+		require.NoError(t, os.Remove(localPath))
+		gets.Store(0)
+		corrupted.Store(10)
+		err := download()
+		assert.ErrorIs(t, err, errChecksumMismatch)
+		assert.Equal(t, int32(3), gets.Load())
+	})
+}
+
+func TestDownloadVerifiesSHA256Checksum(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-download-verify-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	content := []byte("content uploaded with a SHA256 checksum")
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(bucketName),
+		Key:               aws.String("verified.txt"),
+		Body:              bytes.NewReader(content),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	require.NoError(t, err)
+
+	localPath := filepath.Join(t.TempDir(), "verified.txt")
+	setTestConfig(fmt.Sprintf("s3://%s/verified.txt", bucketName), localPath, "", false, false, false, true)
+	verifyDownloads = true
+
+	output := captureStdout(func() {
+		err = performS3Download(ctx, manager.New(s3Client), bucketName, "verified.txt", localPath, false)
+	})
+	require.NoError(t, err)
+	assert.Contains(t, output, "Verified SHA256 checksum of verified.txt")
+
+	downloaded, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+}
+
+func TestParseMetadataConditions(t *testing.T) {
+	conditions, err := parseMetadataConditions([]string{"Stage=published", "owner=team=a"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"stage": "published", "owner": "team=a"}, conditions)
+
+	_, err = parseMetadataConditions([]string{"stage"})
+	assert.ErrorContains(t, err, "key=value")
+
+	_, err = parseMetadataConditions([]string{"=published"})
+	assert.Error(t, err)
+}
+
+func TestDownloadIfMetadata(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-download-if-metadata-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	objects := map[string]map[string]string{
+		"reports/a.txt":        {"stage": "published"},
+		"reports/b.txt":        {"stage": "draft"},
+		"reports/c.txt":        nil,
+		"reports/nested/d.txt": {"stage": "published", "owner": "finance"},
+	}
+	for key, metadata := range objects {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:   aws.String(bucketName),
+			Key:      aws.String(key),
+			Body:     bytes.NewReader([]byte("content of " + key)),
+			Metadata: metadata,
+		})
+		require.NoError(t, err)
+	}
+
+	download := func(t *testing.T, src string, conditions ...string) string {
+		destDir := t.TempDir()
+		setTestConfig(src, destDir, bucketName, false, false, true, false)
+		parsed, err := parseMetadataConditions(conditions)
+		require.NoError(t, err)
+		metadataConditions = parsed
+		require.NoError(t, downloadFromS3(ctx))
+		return destDir
+	}
+
+	t.Run("prefix download keeps matching objects", func(t *testing.T) {
+		destDir := download(t, fmt.Sprintf("s3://%s/reports/", bucketName), "Stage=published")
+
+		assert.FileExists(t, filepath.Join(destDir, "a.txt"))
+		assert.FileExists(t, filepath.Join(destDir, "nested", "d.txt"))
+		assert.NoFileExists(t, filepath.Join(destDir, "b.txt"))
+		assert.NoFileExists(t, filepath.Join(destDir, "c.txt"))
+	})
+
+	t.Run("all conditions must match", func(t *testing.T) {
+		destDir := download(t, fmt.Sprintf("s3://%s/reports/", bucketName), "stage=published", "owner=finance")
+
+		assert.FileExists(t, filepath.Join(destDir, "nested", "d.txt"))
+		assert.NoFileExists(t, filepath.Join(destDir, "a.txt"))
+	})
+
+	t.Run("single object", func(t *testing.T) {
+		destDir := download(t, fmt.Sprintf("s3://%s/reports/b.txt", bucketName), "stage=published")
+		assert.NoFileExists(t, filepath.Join(destDir, "b.txt"))
+
+		destDir = download(t, fmt.Sprintf("s3://%s/reports/b.txt", bucketName), "stage=draft")
+		assert.FileExists(t, filepath.Join(destDir, "b.txt"))
+	})
+}
+
+func TestExactKey(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	useFakeS3(t, newFakeS3(map[string]string{
+		"exact-bucket/report.csv":          "the intended object",
+		"exact-bucket/reports/2025/q1.csv": "q1",
+		"exact-bucket/reports/2025/q2.csv": "q2",
+	}))
+
+	download := func(t *testing.T, src string, exact bool) (string, error) {
+		destDir := t.TempDir()
+		setTestConfig(src, destDir+"/", "", false, false, true, false)
+		exactKey = exact
+		return destDir, downloadFromS3(context.Background())
+	}
+
+	t.Run("a typo errors instead of downloading the prefix", func(t *testing.T) {
+		destDir, err := download(t, "s3://exact-bucket/report", true)
+		require.Error(t, err)
+		assert.Equal(t, "object not found: s3://exact-bucket/report", err.Error())
+
+		entries, err := os.ReadDir(destDir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("an existing key downloads", func(t *testing.T) {
+		destDir, err := download(t, "s3://exact-bucket/report.csv", true)
+		require.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(destDir, "report.csv"))
+		require.NoError(t, err)
+		assert.Equal(t, "the intended object", string(content))
+	})
+
+	t.Run("without exact the prefix is downloaded", func(t *testing.T) {
+		destDir, err := download(t, "s3://exact-bucket/report", false)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(destDir, "s", "2025", "q1.csv"))
+	})
+}
+
+func TestContinueOnError(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(map[string]string{
+		"partial/data/a.txt":     "a",
+		"partial/data/b.txt":     "b",
+		"partial/data/gone.txt":  "listed, but deleted before it is downloaded",
+		"partial/data/sub/c.txt": "c",
+	})
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial/data/gone.txt" {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	download := func(t *testing.T, continueDownload bool) (string, error) {
+		destDir := t.TempDir()
+		setTestConfig("s3://partial/data/", destDir, "", false, true, true, false)
+		maxWorkers = 1
+		continueOnError = continueDownload
+		failures.reset()
+		var err error
+		captureStdout(func() {
+			err = downloadFromS3(context.Background())
+		})
+		return destDir, err
+	}
+	defer failures.reset()
+
+	t.Run("the other objects are still downloaded", func(t *testing.T) {
+		destDir, err := download(t, true)
+		require.Error(t, err)
+		assert.Equal(t, "download completed with 1 error(s)", err.Error())
+		var partial *partialFailureError
+		assert.ErrorAs(t, err, &partial)
+
+		for relPath, content := range map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"} {
+			downloaded, err := os.ReadFile(filepath.Join(destDir, filepath.FromSlash(relPath)))
+			require.NoError(t, err, relPath)
+			assert.Equal(t, content, string(downloaded))
+		}
+		assert.NoFileExists(t, filepath.Join(destDir, "gone.txt"))
+
+		recorded := failures.list()
+		require.Len(t, recorded, 1)
+		assert.Equal(t, "s3://partial/data/gone.txt", recorded[0].Key)
+	})
+
+	t.Run("without the flag the first failure stops the download", func(t *testing.T) {
+		destDir, err := download(t, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "gone.txt")
+		assert.NoFileExists(t, filepath.Join(destDir, "sub", "c.txt"))
+	})
+}
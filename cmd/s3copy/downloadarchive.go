@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// archiveFormatFor returns the format of a --download-archive file from its
+// extension: "tar", "tar.gz" or "zip"
+func archiveFormatFor(archivePath string) (string, error) {
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar", nil
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	}
+	return "", fmt.Errorf("unsupported archive %s, use a .tar, .tar.gz, .tgz or .zip file", archivePath)
+}
+
+// archiveWriter adds downloaded objects to a local archive. The workers
+// download in parallel, the entries are written one at a time.
+type archiveWriter struct {
+	mutex   sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	tar     *tar.Writer
+	zip     *zip.Writer
+	entries int
+}
+
+func newArchiveWriter(file *os.File, format string) *archiveWriter {
+	archive := &archiveWriter{file: file}
+	switch format {
+	case "zip":
+		archive.zip = zip.NewWriter(file)
+	case "tar.gz":
+		archive.gzip = gzip.NewWriter(file)
+		archive.tar = tar.NewWriter(archive.gzip)
+	default:
+		archive.tar = tar.NewWriter(file)
+	}
+	return archive
+}
+
+// add copies size bytes from reader into the archive as name
+func (a *archiveWriter) add(name string, modTime time.Time, size int64, reader io.Reader) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var writer io.Writer
+	if a.zip != nil {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+		header.SetMode(0644)
+		entry, err := a.zip.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to archive: %w", name, err)
+		}
+		writer = entry
+	} else {
+		if err := a.tar.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: modTime}); err != nil {
+			return fmt.Errorf("failed to add %s to archive: %w", name, err)
+		}
+		writer = a.tar
+	}
+	if _, err := io.CopyN(writer, reader, size); err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	a.entries++
+	return nil
+}
+
+// close finishes the archive and closes the file
+func (a *archiveWriter) close() error {
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+	} else {
+		err = a.tar.Close()
+		if a.gzip != nil && err == nil {
+			err = a.gzip.Close()
+		}
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// downloadToArchive downloads every object below the source prefix into the
+// single local archive named by --download-archive, with the object keys as
+// entry names. The archive is written to a temporary file next to it and only
+// moved into place once every object was added.
+func downloadToArchive(ctx context.Context) error {
+	format, err := archiveFormatFor(downloadArchive)
+	if err != nil {
+		return err
+	}
+
+	sourcePath := source
+	if bucket == "" && !strings.Contains(strings.TrimPrefix(sourcePath, "s3://"), "/") {
+		sourcePath += "/"
+	}
+	bucketName, prefix, err := splitS3URI(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+	downloader := getDownloader(s3Client)
+
+	var archive *archiveWriter
+	var tempPath string
+	if !dryRun {
+		archiveDir := filepath.Dir(downloadArchive)
+		if err := makeDownloadDir(archiveDir); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		tempFile, err := os.CreateTemp(archiveDir, ".s3copy-archive-*")
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %w", err)
+		}
+		tempPath = tempFile.Name()
+		defer removeTempFile(tempPath)
+		archive = newArchiveWriter(tempFile, format)
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+
+	stopTransfer := report.track(phaseTransfer)
+	err = runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, key string) error {
+		defer report.completeFile()
+
+		release, err := acquireTransferSlot(workerCtx, bucketName)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		logInfoContext(workerCtx, "Adding s3://%s/%s to %s\n", bucketName, key, downloadArchive)
+		if dryRun {
+			return nil
+		}
+		err = archiveObject(workerCtx, downloader, archive, bucketName, key)
+		recordFailure(operationDownload, downloadArchive, fmt.Sprintf("s3://%s/%s", bucketName, key), err)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", key, err)
+		}
+		return nil
+	}, func(producerCtx context.Context, taskChan chan<- string) error {
+		foundObjects := false
+		for paginator.HasMorePages() {
+			result, pageErr := paginator.NextPage(producerCtx)
+			if pageErr != nil {
+				return fmt.Errorf("failed to list objects: %w", pageErr)
+			}
+			for _, obj := range result.Contents {
+				if strings.HasSuffix(*obj.Key, "/") {
+					continue
+				}
+				foundObjects = true
+				select {
+				case <-producerCtx.Done():
+					return producerCtx.Err()
+				case taskChan <- *obj.Key:
+					report.queueFile()
+				}
+			}
+		}
+		if !foundObjects {
+			return fmt.Errorf("no objects found with prefix: %s", prefix)
+		}
+		return nil
+	})
+	stopTransfer()
+
+	if archive == nil {
+		return err
+	}
+	if closeErr := archive.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, downloadArchive); err != nil {
+		return fmt.Errorf("failed to move archive into place: %w", err)
+	}
+	logInfo("Wrote %d objects to %s\n", archive.entries, downloadArchive)
+	return nil
+}
+
+// archiveObject downloads an object to a temporary file, decrypting it with
+// --encrypt, and adds it to the archive
+func archiveObject(ctx context.Context, downloader *manager.Client, archive *archiveWriter, bucketName, key string) error {
+	tempFile, err := os.CreateTemp("", ".s3copy-dl-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer removeTempFile(tempPath)
+
+	output, err := downloadVerified(ctx, downloader, bucketName, key, tempFile)
+	if err != nil {
+		return err
+	}
+
+	contentPath := tempPath
+	if shouldEncryptFile(key) {
+		secret, err := objectPassword(key, output.Metadata)
+		if err != nil {
+			return err
+		}
+		decryptedPath, err := decryptToTempFile(tempPath, expectedPlaintextSize(output.Metadata), secret)
+		if err != nil {
+			return err
+		}
+		defer removeTempFile(decryptedPath)
+		contentPath = decryptedPath
+	}
+
+	content, err := os.Open(contentPath)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file: %w", err)
+	}
+	defer closeWithLog(content, contentPath)
+	info, err := content.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded file: %w", err)
+	}
+
+	modTime := time.Now()
+	if output.LastModified != nil {
+		modTime = *output.LastModified
+	}
+	if err := archive.add(key, modTime, info.Size(), content); err != nil {
+		return err
+	}
+	report.addStream(info.Size())
+	return nil
+}
+
+// decryptToTempFile decrypts a downloaded file into a new temporary file and
+// returns its path
+func decryptToTempFile(encryptedPath string, plainSize int64, secret string) (string, error) {
+	encrypted, err := os.Open(encryptedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open temp file for decryption: %w", err)
+	}
+	defer closeWithLog(encrypted, encryptedPath)
+
+	decrypted, err := os.CreateTemp("", ".s3copy-dec-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	decryptedPath := decrypted.Name()
+	err = decryptWithRetry(decrypted, encrypted, plainSize, secret)
+	closeWithLog(decrypted, decryptedPath)
+	if err != nil {
+		removeTempFile(decryptedPath)
+		return "", fmt.Errorf("decryption failed: %w", err)
+	}
+	return decryptedPath, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFormatFor(t *testing.T) {
+	for archivePath, expected := range map[string]string{
+		"out.tar":     "tar",
+		"out.tar.gz":  "tar.gz",
+		"OUT.TGZ":     "tar.gz",
+		"dir/out.zip": "zip",
+	} {
+		format, err := archiveFormatFor(archivePath)
+		require.NoError(t, err, archivePath)
+		assert.Equal(t, expected, format, archivePath)
+	}
+
+	_, err := archiveFormatFor("out.rar")
+	require.Error(t, err)
+}
+
+func readTarEntries(t *testing.T, reader io.Reader) map[string]string {
+	entries := map[string]string{}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		entries[header.Name] = string(content)
+	}
+}
+
+func TestDownloadToArchive(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(map[string]string{"bundle/other/ignored.txt": "not below the prefix"})
+	expected := map[string]string{}
+	for i := range 12 {
+		key := fmt.Sprintf("reports/2025/%02d/summary.csv", i+1)
+		expected[key] = fmt.Sprintf("month,%d\n", i+1)
+		fake.put("bundle/"+key, expected[key])
+	}
+	useFakeS3(t, fake)
+
+	readEntries := map[string]func(t *testing.T, archivePath string) map[string]string{
+		"out.tar": func(t *testing.T, archivePath string) map[string]string {
+			file, err := os.Open(archivePath)
+			require.NoError(t, err)
+			defer closeWithLog(file, archivePath)
+			return readTarEntries(t, file)
+		},
+		"out.tar.gz": func(t *testing.T, archivePath string) map[string]string {
+			file, err := os.Open(archivePath)
+			require.NoError(t, err)
+			defer closeWithLog(file, archivePath)
+			gzipReader, err := gzip.NewReader(file)
+			require.NoError(t, err)
+			return readTarEntries(t, gzipReader)
+		},
+		"out.zip": func(t *testing.T, archivePath string) map[string]string {
+			zipReader, err := zip.OpenReader(archivePath)
+			require.NoError(t, err)
+			defer closeWithLog(zipReader, archivePath)
+			entries := map[string]string{}
+			for _, file := range zipReader.File {
+				reader, err := file.Open()
+				require.NoError(t, err)
+				content, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				closeWithLog(reader, file.Name)
+				entries[file.Name] = string(content)
+			}
+			return entries
+		},
+	}
+
+	for name, read := range readEntries {
+		t.Run(name, func(t *testing.T) {
+			setTestConfig("s3://bundle/reports/", "", "", false, true, true, false)
+			archivePath := filepath.Join(t.TempDir(), "nested", name)
+			downloadArchive = archivePath
+
+			require.NoError(t, downloadToArchive(context.Background()))
+			assert.Equal(t, expected, read(t, archivePath))
+
+			leftovers, err := filepath.Glob(filepath.Join(filepath.Dir(archivePath), ".s3copy-archive-*"))
+			require.NoError(t, err)
+			assert.Empty(t, leftovers)
+		})
+	}
+
+	t.Run("missing prefix leaves no archive", func(t *testing.T) {
+		setTestConfig("s3://bundle/missing/", "", "", false, true, true, false)
+		archivePath := filepath.Join(t.TempDir(), "out.tar")
+		downloadArchive = archivePath
+
+		require.Error(t, downloadToArchive(context.Background()))
+		entries, err := os.ReadDir(filepath.Dir(archivePath))
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// chunkBufferSize is large enough for a plaintext chunk and for the same
+// chunk sealed with its authentication tag
+const chunkBufferSize = DefaultEncryptionChunkSize + chacha20poly1305.Overhead
+
+// chunkBufferPool reuses the plaintext and ciphertext buffers of
+// encryptWithHeader across chunks and concurrent uploads, and optionally caps
+// the number of chunks that are in memory at the same time. A chunk holds one
+// plaintext and one ciphertext buffer from the time it is read until its
+// ciphertext is written, so the budget is taken once per chunk instead of
+// once per buffer and a stream never waits for a second buffer while it holds
+// the first.
+type chunkBufferPool struct {
+	buffers sync.Pool
+	budget  chan struct{}
+}
+
+// chunkBuffers is shared by all encrypted uploads. --encryption-memory
+// replaces it with a pool that has a budget.
+var chunkBuffers = newChunkBufferPool(0)
+
+// newChunkBufferPool creates a pool that allows at most maxChunks chunks in
+// flight, or any number when maxChunks is 0
+func newChunkBufferPool(maxChunks int) *chunkBufferPool {
+	pool := &chunkBufferPool{}
+	pool.buffers.New = func() any {
+		buf := make([]byte, chunkBufferSize)
+		return &buf
+	}
+	if maxChunks > 0 {
+		pool.budget = make(chan struct{}, maxChunks)
+	}
+	return pool
+}
+
+// chunkBudget converts an --encryption-memory value into the number of chunks
+// that fit into it, at least one
+func chunkBudget(memory int64) int {
+	if memory <= 0 {
+		return 0
+	}
+	return int(max(1, memory/(2*chunkBufferSize)))
+}
+
+// acquire waits until a chunk fits into the budget
+func (p *chunkBufferPool) acquire() {
+	if p.budget != nil {
+		p.budget <- struct{}{}
+	}
+}
+
+// release returns the budget of a chunk that was written or dropped
+func (p *chunkBufferPool) release() {
+	if p.budget != nil {
+		<-p.budget
+	}
+}
+
+// get returns a buffer of chunkBufferSize bytes. Its content is left over
+// from a previous chunk, so callers only use the bytes they fill.
+func (p *chunkBufferPool) get() *[]byte {
+	buf := p.buffers.Get().(*[]byte)
+	*buf = (*buf)[:chunkBufferSize]
+	return buf
+}
+
+// put hands a buffer back for the next chunk
+func (p *chunkBufferPool) put(buf *[]byte) {
+	p.buffers.Put(buf)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// minHeaderDistinctBytes is the number of distinct byte values the random
+// salt and nonce at the start of a password encrypted object are expected to
+// contain at least. 44 random bytes hold about 40 distinct values, while text
+// and most binary formats start with far fewer.
+const minHeaderDistinctBytes = 30
+
+// looksEncrypted reports whether data has the layout of an s3copy encrypted
+// object. Objects encrypted for a recipient start with a magic marker.
+// Password encrypted objects start with a random salt and nonce, followed
+// by length-prefixed chunks that must end exactly at the end of the data.
+func looksEncrypted(data io.ReaderAt, size int64) bool {
+	magic := make([]byte, len(recipientHeaderMagic))
+	if _, err := data.ReadAt(magic, 0); err == nil && bytes.Equal(magic, recipientHeaderMagic) {
+		return true
+	}
+	streamMagic := make([]byte, len(streamV2Magic))
+	if _, err := data.ReadAt(streamMagic, 0); err == nil && bytes.Equal(streamMagic, streamV2Magic) {
+		return true
+	}
+
+	header := make([]byte, passwordSaltSize+chacha20poly1305.NonceSize)
+	if _, err := data.ReadAt(header, 0); err != nil {
+		return false
+	}
+	distinct := make(map[byte]struct{}, len(header))
+	for _, b := range header {
+		distinct[b] = struct{}{}
+	}
+	if len(distinct) < minHeaderDistinctBytes {
+		return false
+	}
+
+	offset := int64(len(header))
+	lengthBytes := make([]byte, 4)
+	for offset < size {
+		if _, err := data.ReadAt(lengthBytes, offset); err != nil {
+			return false
+		}
+		chunkLen := int64(binary.BigEndian.Uint32(lengthBytes))
+		if chunkLen <= chacha20poly1305.Overhead || chunkLen > DefaultEncryptionChunkSize+chacha20poly1305.Overhead {
+			return false
+		}
+		offset += int64(len(lengthBytes)) + chunkLen
+	}
+	return offset == size
+}
+
+// warnEncryptionMismatch inspects a downloaded file and warns when it looks
+// encrypted but is saved without decryption, or is about to be decrypted but
+// does not look encrypted. Both usually mean --encrypt was set differently
+// than during the upload.
+func warnEncryptionMismatch(path, objectURI string, decrypting bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		logVerbose("Warning: could not inspect %s: %v\n", path, err)
+		return
+	}
+	defer closeWithLog(file, path)
+
+	info, err := file.Stat()
+	if err != nil {
+		logVerbose("Warning: could not inspect %s: %v\n", path, err)
+		return
+	}
+
+	encrypted := looksEncrypted(file, info.Size())
+	switch {
+	case encrypted && !decrypting:
+		fmt.Fprintf(os.Stderr, "Warning: %s looks like an s3copy encrypted object but is downloaded without --encrypt, the file will contain ciphertext\n", objectURI)
+	case !encrypted && decrypting:
+		fmt.Fprintf(os.Stderr, "Warning: %s does not look like an s3copy encrypted object, it was probably uploaded without --encrypt\n", objectURI)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptForTest encrypts data with the current password
+func encryptForTest(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var encrypted bytes.Buffer
+	require.NoError(t, encryptStreamWithWorkers(&encrypted, bytes.NewReader(data), 2))
+	return encrypted.Bytes()
+}
+
+func TestLooksEncrypted(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	password = "detect-secret"
+
+	random := make([]byte, 3*DefaultEncryptionChunkSize)
+	rng := rand.NewChaCha8([32]byte{1})
+	_, _ = rng.Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{name: "encrypted multi chunk", data: encryptForTest(t, random[:DefaultEncryptionChunkSize*5/2]), want: true},
+		{name: "encrypted small", data: encryptForTest(t, []byte("hello")), want: true},
+		{name: "encrypted empty", data: encryptForTest(t, nil), want: true},
+		{name: "recipient header", data: append(bytes.Clone(recipientHeaderMagic), make([]byte, 64)...), want: true},
+		{name: "text", data: []byte(strings.Repeat("plain text line\n", 100))},
+		{name: "random", data: random},
+		{name: "short", data: []byte("abc")},
+		{name: "empty", data: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, looksEncrypted(bytes.NewReader(tt.data), int64(len(tt.data))))
+		})
+	}
+}
+
+func TestDownloadEncryptionMismatch(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, false, false, false)
+	password = "detect-secret"
+
+	plaintext := []byte("quarterly numbers, not encrypted")
+	encrypted := encryptForTest(t, plaintext)
+	useFakeS3(t, newFakeS3(map[string]string{
+		"detect-bucket/secret.bin": string(encrypted),
+		"detect-bucket/plain.txt":  string(plaintext),
+	}))
+
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+	downloader := manager.New(s3Client)
+	dir := t.TempDir()
+
+	t.Run("encrypted object downloaded without --encrypt", func(t *testing.T) {
+		encrypt = false
+		localPath := filepath.Join(dir, "secret.bin")
+		var downloadErr error
+		warning := captureStderr(func() {
+			downloadErr = performS3Download(context.Background(), downloader, "detect-bucket", "secret.bin", localPath, false)
+		})
+		require.NoError(t, downloadErr)
+		assert.Contains(t, warning, "s3://detect-bucket/secret.bin looks like an s3copy encrypted object")
+
+		saved, err := os.ReadFile(localPath)
+		require.NoError(t, err)
+		assert.Equal(t, encrypted, saved)
+	})
+
+	t.Run("plain object downloaded with --encrypt", func(t *testing.T) {
+		encrypt = true
+		localPath := filepath.Join(dir, "plain.txt")
+		var downloadErr error
+		warning := captureStderr(func() {
+			downloadErr = performS3Download(context.Background(), downloader, "detect-bucket", "plain.txt", localPath, false)
+		})
+		require.Error(t, downloadErr)
+		assert.Contains(t, warning, "s3://detect-bucket/plain.txt does not look like an s3copy encrypted object")
+	})
+
+	t.Run("matching flags do not warn", func(t *testing.T) {
+		encrypt = true
+		var downloadErr error
+		warning := captureStderr(func() {
+			downloadErr = performS3Download(context.Background(), downloader, "detect-bucket", "secret.bin", filepath.Join(dir, "decrypted.txt"), false)
+		})
+		require.NoError(t, downloadErr)
+		assert.Empty(t, warning)
+
+		encrypt = false
+		warning = captureStderr(func() {
+			downloadErr = performS3Download(context.Background(), downloader, "detect-bucket", "plain.txt", filepath.Join(dir, "copy.txt"), false)
+		})
+		require.NoError(t, downloadErr)
+		assert.Empty(t, warning)
+	})
+}
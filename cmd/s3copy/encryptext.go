@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// encryptedMetadataKey marks objects whose body is encrypted, so a prefix can
+// hold encrypted and plaintext objects side by side
+const encryptedMetadataKey = "encrypted"
+
+// parseEncryptExtensions parses the comma-separated list of --encrypt-ext.
+// Extensions are matched case-insensitively, with or without a leading dot.
+func parseEncryptExtensions(value string) ([]string, error) {
+	var extensions []string
+	for ext := range strings.SplitSeq(value, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions = append(extensions, ext)
+	}
+	if len(extensions) == 0 {
+		return nil, fmt.Errorf("%q contains no extension", value)
+	}
+	return extensions, nil
+}
+
+// shouldEncryptFile reports whether a file or object is encrypted. With
+// --encrypt-ext only names with one of the listed extensions are.
+func shouldEncryptFile(name string) bool {
+	if !encrypt {
+		return false
+	}
+	if len(encryptExtensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(name, "\\", "/")))
+	for _, candidate := range encryptExtensions {
+		if ext == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// plaintextSizeMetadataKey records the size of the plaintext of an encrypted
+// upload. Each chunk is authenticated on its own, so an object that ends
+// after a complete chunk decrypts without an error; comparing the decrypted
+// size with this entry catches it without changing the format.
+const plaintextSizeMetadataKey = "plaintext-size"
+
+// isEncryptedObject reports whether object metadata carries the encrypted marker
+func isEncryptedObject(metadata map[string]string) bool {
+	return metadata[encryptedMetadataKey] == "true"
+}
+
+// markEncrypted adds the encrypted marker and the plaintext size to the
+// upload metadata. A negative size, of a stream with unknown length, is not
+// recorded.
+func markEncrypted(metadata map[string]string, plainSize int64) map[string]string {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[encryptedMetadataKey] = "true"
+	if plainSize >= 0 {
+		metadata[plaintextSizeMetadataKey] = strconv.FormatInt(plainSize, 10)
+	}
+	return metadata
+}
+
+// expectedPlaintextSize returns the plaintext size recorded with an encrypted
+// object, or -1 for objects uploaded before it was recorded
+func expectedPlaintextSize(metadata map[string]string) int64 {
+	size, err := strconv.ParseInt(metadata[plaintextSizeMetadataKey], 10, 64)
+	if err != nil || size < 0 {
+		return -1
+	}
+	return size
+}
+
+// encryptedObjectSize returns the size of the object an upload of plainSize
+// bytes produces with the current keys: the header, then every chunk with
+// its length prefix and authentication tag, plus the magic and trailer of
+// format version 2 with --trailing-checksum
+func encryptedObjectSize(plainSize int64) int64 {
+	keyMaterialSize := int64(passwordSaltSize)
+	if usesRecipientKeys() {
+		keyMaterialSize = int64(recipientKeyMaterialSize)
+	}
+	chunks := (plainSize + DefaultEncryptionChunkSize - 1) / DefaultEncryptionChunkSize
+	size := keyMaterialSize + chacha20poly1305.NonceSize + plainSize + chunks*(4+chacha20poly1305.Overhead)
+	if trailingChecksum {
+		size += int64(len(streamV2Magic)) + trailerRecordSize
+	}
+	return size
+}
+
+// encryptedFileIsSame compares a local file that is uploaded encrypted with
+// its object. The ciphertext differs on every upload, so instead of a
+// checksum the object must carry the encrypted marker, have the size the
+// plaintext encrypts to and store the modification time of the local file.
+func encryptedFileIsSame(ctx context.Context, s3Client *s3.Client, localFile, s3File FileInfo, bucket string) bool {
+	if s3File.Size != encryptedObjectSize(localFile.Size) {
+		return false
+	}
+
+	headResult, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(s3File.Path),
+	})
+	if err != nil || !isEncryptedObject(headResult.Metadata) {
+		return false
+	}
+
+	mtimeUnix, err := strconv.ParseInt(headResult.Metadata["local-mtime"], 10, 64)
+	return err == nil && mtimeUnix == localFile.ModTime
+}
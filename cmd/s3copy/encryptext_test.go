@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEncryptExtensions(t *testing.T) {
+	extensions, err := parseEncryptExtensions(".pem, KEY,,.Env")
+	require.NoError(t, err)
+	assert.Equal(t, []string{".pem", ".key", ".env"}, extensions)
+
+	_, err = parseEncryptExtensions(" , ")
+	assert.ErrorContains(t, err, "contains no extension")
+}
+
+func TestShouldEncryptFile(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	encrypt = false
+	assert.False(t, shouldEncryptFile("server.pem"))
+
+	encrypt = true
+	assert.True(t, shouldEncryptFile("notes.txt"))
+
+	encryptExtensions = []string{".pem", ".key"}
+	assert.True(t, shouldEncryptFile("certs/server.PEM"))
+	assert.True(t, shouldEncryptFile(`certs\server.key`))
+	assert.False(t, shouldEncryptFile("notes.txt"))
+	assert.False(t, shouldEncryptFile("pem"))
+}
+
+func TestEncryptedObjectSize(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	password = "size-password"
+	sizes := []int{0, 1, DefaultEncryptionChunkSize, DefaultEncryptionChunkSize + 1, 2*DefaultEncryptionChunkSize + 17}
+
+	check := func(t *testing.T) {
+		for _, size := range sizes {
+			plaintext := make([]byte, size)
+			_, err := rand.Read(plaintext)
+			require.NoError(t, err)
+
+			var encrypted bytes.Buffer
+			require.NoError(t, encryptStream(&encrypted, bytes.NewReader(plaintext)))
+			assert.Equal(t, int64(encrypted.Len()), encryptedObjectSize(int64(size)), "plaintext size %d", size)
+		}
+	}
+
+	t.Run("password", check)
+
+	t.Run("recipient key", func(t *testing.T) {
+		_, recipientPath, _ := writeX25519KeyFiles(t)
+		key, err := loadRecipientKey(recipientPath)
+		require.NoError(t, err)
+		recipientKey = key
+		check(t)
+	})
+}
+
+func TestMarkEncrypted(t *testing.T) {
+	assert.True(t, isEncryptedObject(markEncrypted(nil, 0)))
+	metadata := markEncrypted(map[string]string{"local-mtime": "1"}, 4096)
+	assert.Equal(t, map[string]string{"local-mtime": "1", encryptedMetadataKey: "true", plaintextSizeMetadataKey: "4096"}, metadata)
+	assert.False(t, isEncryptedObject(map[string]string{"local-mtime": "1"}))
+
+	assert.Equal(t, int64(4096), expectedPlaintextSize(metadata))
+	assert.Equal(t, int64(-1), expectedPlaintextSize(map[string]string{encryptedMetadataKey: "true"}))
+	assert.Equal(t, int64(-1), expectedPlaintextSize(map[string]string{plaintextSizeMetadataKey: "many"}))
+}
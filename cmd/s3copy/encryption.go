@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"runtime"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -56,109 +60,498 @@ func (nm *NonceManager) Reset() {
 }
 
 func encryptStream(writer io.Writer, reader io.Reader) error {
-	salt := make([]byte, 32)
-	if _, err := rand.Read(salt); err != nil {
-		return fmt.Errorf("failed to generate salt: %v", err)
+	return encryptStreamWithWorkers(writer, reader, cryptoWorkers())
+}
+
+// passwordSaltSize is the size of the random salt that starts the header of
+// password-encrypted objects
+const passwordSaltSize = 32
+
+// Argon2id parameters used to derive the encryption key from the password
+const (
+	argon2Time      = 3
+	argon2Memory    = 64 * 1024
+	argon2Threads   = 4
+	argon2KeyLength = 32
+)
+
+// deriveKey derives the encryption key from a password and a salt
+func deriveKey(secret string, salt []byte) []byte {
+	return argon2.IDKey([]byte(secret), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
+}
+
+// Format version 2, written with --trailing-checksum, starts with
+// streamV2Magic, seals every chunk with streamV2ChunkData as additional data
+// and ends with a trailer record holding the number of chunks and plaintext
+// bytes. The trailer turns a stream that was cut short after a whole chunk
+// into an error instead of a shorter plaintext. The additional data keeps a
+// version 2 stream whose magic was stripped from decrypting as version 1.
+var (
+	streamV2Magic       = []byte("s3copy-stream-v2")
+	streamV2ChunkData   = []byte("s3copy-stream-v2 chunk")
+	streamV2TrailerData = []byte("s3copy-stream-v2 trailer")
+)
+
+const (
+	// trailerFlag marks the length prefix of the trailer record, which data
+	// chunks never reach
+	trailerFlag = 1 << 31
+	// trailerPlaintextSize holds the chunk count and the plaintext length
+	trailerPlaintextSize = 16
+	// trailerRecordSize is the size of the trailer with its length prefix
+	trailerRecordSize = 4 + trailerPlaintextSize + chacha20poly1305.Overhead
+)
+
+// cryptoWorkers returns the number of goroutines used to seal or open the
+// chunks of a single file
+func cryptoWorkers() int {
+	return max(1, min(runtime.GOMAXPROCS(0), DefaultEncryptionWorkers))
+}
+
+// sealJob is a plaintext chunk, the pooled buffer holding it and the channel
+// its ciphertext is delivered on
+type sealJob struct {
+	plaintext []byte
+	buffer    *[]byte
+	nonce     []byte
+	sealed    chan *[]byte
+}
+
+// encryptionHeader holds the key material and base nonce written at the
+// start of an encrypted object and the key the chunks are sealed with. With a
+// password the key material is the random salt the key is derived from; with
+// a recipient key it is the wrapped random data key.
+type encryptionHeader struct {
+	version     int
+	keyMaterial []byte
+	baseNonce   []byte
+	key         []byte
+}
+
+// chunkAdditionalData returns the additional data the chunks of the
+// header's format version are sealed with
+func (h *encryptionHeader) chunkAdditionalData() []byte {
+	if h.version >= 2 {
+		return streamV2ChunkData
+	}
+	return nil
+}
+
+// write writes the header at the start of an encrypted object
+func (h *encryptionHeader) write(writer io.Writer) error {
+	if h.version >= 2 {
+		if _, err := writer.Write(streamV2Magic); err != nil {
+			return fmt.Errorf("failed to write format marker: %v", err)
+		}
+	}
+	if _, err := writer.Write(h.keyMaterial); err != nil {
+		return fmt.Errorf("failed to write key material: %v", err)
+	}
+	if _, err := writer.Write(h.baseNonce); err != nil {
+		return fmt.Errorf("failed to write base nonce: %v", err)
+	}
+	return nil
+}
+
+// newEncryptionHeader generates fresh key material and a base nonce. Without
+// recipient keys the key is derived from secret, the password of the object.
+func newEncryptionHeader(secret string) (*encryptionHeader, error) {
+	var keyMaterial, key []byte
+	if usesRecipientKeys() {
+		var err error
+		keyMaterial, key, err = newRecipientKeyMaterial()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		keyMaterial = make([]byte, passwordSaltSize)
+		if _, err := rand.Read(keyMaterial); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %v", err)
+		}
+		key = deriveKey(secret, keyMaterial)
 	}
 
 	nonceManager, err := NewNonceManager()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if _, err := writer.Write(salt); err != nil {
-		return fmt.Errorf("failed to write salt: %v", err)
+	version := 1
+	if trailingChecksum {
+		version = 2
 	}
-	if _, err := writer.Write(nonceManager.GetBaseNonce()); err != nil {
-		return fmt.Errorf("failed to write base nonce: %v", err)
+
+	return &encryptionHeader{
+		version:     version,
+		keyMaterial: keyMaterial,
+		baseNonce:   nonceManager.GetBaseNonce(),
+		key:         key,
+	}, nil
+}
+
+// readEncryptionHeader reads the header of an encrypted object and recovers
+// the key, from secret or from the identity with --identity-file. The
+// format version is detected from the magic; version 1 has none, so the bytes
+// read to look for it are the start of the key material, which is always
+// longer than the magic.
+func readEncryptionHeader(reader io.Reader, secret string) (*encryptionHeader, error) {
+	version := 1
+	prefix := make([]byte, len(streamV2Magic))
+	n, err := io.ReadFull(reader, prefix)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read encryption header: %v", err)
+	}
+	if err == nil && bytes.Equal(prefix, streamV2Magic) {
+		version = 2
+	} else {
+		reader = io.MultiReader(bytes.NewReader(prefix[:n]), reader)
 	}
 
-	key := argon2.IDKey([]byte(password), salt, 3, 64*1024, 4, 32)
+	var keyMaterial, key []byte
+	if usesRecipientKeys() {
+		var err error
+		keyMaterial, key, err = readRecipientKeyMaterial(reader)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		keyMaterial = make([]byte, passwordSaltSize)
+		if _, err := io.ReadFull(reader, keyMaterial); err != nil {
+			return nil, fmt.Errorf("failed to read encryption header: %v", err)
+		}
+		key = deriveKey(secret, keyMaterial)
+	}
 
-	aead, err := chacha20poly1305.New(key)
+	baseNonce := make([]byte, chacha20poly1305.NonceSize)
+	if _, err := io.ReadFull(reader, baseNonce); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %v", err)
+	}
+
+	return &encryptionHeader{version: version, keyMaterial: keyMaterial, baseNonce: baseNonce, key: key}, nil
+}
+
+// encryptStreamWithWorkers encrypts reader into writer with a fresh header
+// for --password
+func encryptStreamWithWorkers(writer io.Writer, reader io.Reader, workers int) error {
+	header, err := newEncryptionHeader(password)
 	if err != nil {
-		return fmt.Errorf("failed to create AEAD cipher: %v", err)
+		return err
 	}
+	return encryptWithHeader(writer, reader, header, workers)
+}
 
-	buf := make([]byte, DefaultEncryptionChunkSize)
-	chunkCount := uint64(0)
+// encryptWithHeader encrypts reader into writer. Chunks are read in order,
+// sealed by up to workers goroutines and written back in their original
+// order, so the output format is the same as a sequential encryption.
+// Encrypting the same input twice with the same header yields identical output.
+// Chunk buffers come from chunkBuffers and are returned once the ciphertext
+// is written. Version 2 headers add the trailer after the last chunk.
+func encryptWithHeader(writer io.Writer, reader io.Reader, header *encryptionHeader, workers int) error {
+	if err := header.write(writer); err != nil {
+		return err
+	}
 
-	for {
-		n, err := reader.Read(buf)
-		if n > 0 {
-			chunkNonce := nonceManager.NextNonce()
-			encryptedChunk := aead.Seal(nil, chunkNonce, buf[:n], nil)
-			chunkSizeBytes := make([]byte, 4)
-			binary.BigEndian.PutUint32(chunkSizeBytes, uint32(len(encryptedChunk)))
+	aead, err := chacha20poly1305.New(header.key)
+	if err != nil {
+		return fmt.Errorf("failed to create AEAD cipher: %v", err)
+	}
 
-			if _, writeErr := writer.Write(chunkSizeBytes); writeErr != nil {
-				return fmt.Errorf("failed to write chunk size: %v", writeErr)
+	nonceManager := &NonceManager{baseNonce: header.baseNonce}
+	additionalData := header.chunkAdditionalData()
+	buffers := chunkBuffers
+
+	jobs := make(chan sealJob, workers)
+	ordered := make(chan chan *[]byte, workers)
+	writeFailed := make(chan struct{})
+	writeErrChan := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Go(func() {
+			for job := range jobs {
+				out := buffers.get()
+				*out = aead.Seal((*out)[:0], job.nonce, job.plaintext, additionalData)
+				buffers.put(job.buffer)
+				job.sealed <- out
 			}
-			if _, writeErr := writer.Write(encryptedChunk); writeErr != nil {
-				return fmt.Errorf("failed to write encrypted chunk: %v", writeErr)
+		})
+	}
+
+	go func() {
+		var writeErr error
+		chunkSizeBytes := make([]byte, 4)
+		for sealed := range ordered {
+			encryptedChunk := <-sealed
+			if writeErr == nil {
+				binary.BigEndian.PutUint32(chunkSizeBytes, uint32(len(*encryptedChunk)))
+
+				if _, err := writer.Write(chunkSizeBytes); err != nil {
+					writeErr = fmt.Errorf("failed to write chunk size: %v", err)
+				} else if _, err := writer.Write(*encryptedChunk); err != nil {
+					writeErr = fmt.Errorf("failed to write encrypted chunk: %v", err)
+				}
+				if writeErr != nil {
+					close(writeFailed)
+				}
 			}
+			buffers.put(encryptedChunk)
+			buffers.release()
+		}
+		writeErrChan <- writeErr
+	}()
 
-			chunkCount++
+	var readErr error
+	var chunks, plaintextBytes uint64
+readLoop:
+	for {
+		select {
+		case <-writeFailed:
+			break readLoop
+		default:
+		}
+
+		buffers.acquire()
+		buf := buffers.get()
+		n, err := reader.Read((*buf)[:DefaultEncryptionChunkSize])
+		if n > 0 {
+			sealed := make(chan *[]byte, 1)
+			jobs <- sealJob{plaintext: (*buf)[:n], buffer: buf, nonce: nonceManager.NextNonce(), sealed: sealed}
+			ordered <- sealed
+			chunks++
+			plaintextBytes += uint64(n)
+		} else {
+			buffers.put(buf)
+			buffers.release()
 		}
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read from source: %v", err)
+			readErr = fmt.Errorf("failed to read from source: %v", err)
+			break
 		}
 	}
 
+	close(jobs)
+	close(ordered)
+	wg.Wait()
+
+	if writeErr := <-writeErrChan; writeErr != nil {
+		return writeErr
+	}
+	if readErr != nil || header.version < 2 {
+		return readErr
+	}
+	return writeTrailer(writer, aead, nonceManager.NextNonce(), chunks, plaintextBytes)
+}
+
+// writeTrailer seals the number of chunks and plaintext bytes of a version 2
+// stream with the nonce after the last chunk
+func writeTrailer(writer io.Writer, aead cipher.AEAD, nonce []byte, chunks, plaintextBytes uint64) error {
+	plaintext := make([]byte, trailerPlaintextSize)
+	binary.BigEndian.PutUint64(plaintext[:8], chunks)
+	binary.BigEndian.PutUint64(plaintext[8:], plaintextBytes)
+	sealed := aead.Seal(nil, nonce, plaintext, streamV2TrailerData)
+
+	record := binary.BigEndian.AppendUint32(nil, uint32(len(sealed))|trailerFlag)
+	if _, err := writer.Write(append(record, sealed...)); err != nil {
+		return fmt.Errorf("failed to write trailer: %v", err)
+	}
+	return nil
+}
+
+// verifyTrailer opens the trailer of a version 2 stream and compares it with
+// the chunks that were read
+func verifyTrailer(aead cipher.AEAD, nonce, sealed []byte, chunks, plaintextBytes uint64) error {
+	plaintext, err := aead.Open(nil, nonce, sealed, streamV2TrailerData)
+	if err != nil || len(plaintext) != trailerPlaintextSize {
+		return fmt.Errorf("decryption failed: the trailer is corrupted")
+	}
+	wantChunks := binary.BigEndian.Uint64(plaintext[:8])
+	wantBytes := binary.BigEndian.Uint64(plaintext[8:])
+	if wantChunks != chunks || wantBytes != plaintextBytes {
+		return fmt.Errorf("encrypted stream is incomplete: the trailer records %d chunks with %d bytes, found %d chunks with %d bytes",
+			wantChunks, wantBytes, chunks, plaintextBytes)
+	}
 	return nil
 }
 
 func decryptStreamFromReader(writer io.Writer, reader io.Reader) error {
-	header := make([]byte, 44) // 32 (salt) + 12 (base nonce)
-	if _, err := io.ReadFull(reader, header); err != nil {
-		return fmt.Errorf("failed to read encryption header: %v", err)
+	return decryptStreamWithWorkers(writer, reader, cryptoWorkers())
+}
+
+// decryptStreamWithSize decrypts an object encrypted with secret and, when
+// plainSize is not negative, fails if the plaintext is not exactly that long
+func decryptStreamWithSize(writer io.Writer, reader io.Reader, plainSize int64, secret string) error {
+	counter := &countingWriter{writer: writer}
+	if err := decryptStream(counter, reader, cryptoWorkers(), secret); err != nil {
+		return err
+	}
+	if plainSize >= 0 && counter.written != plainSize {
+		return fmt.Errorf("encrypted object is incomplete: decrypted %d bytes, the upload recorded %d", counter.written, plainSize)
 	}
+	return nil
+}
 
-	salt := header[:32]
-	baseNonce := header[32:44]
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
 
-	key := argon2.IDKey([]byte(password), salt, 3, 64*1024, 4, 32)
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
 
-	aead, err := chacha20poly1305.New(key)
+// openJob is an encrypted chunk and the channel its plaintext is delivered on
+type openJob struct {
+	ciphertext []byte
+	nonce      []byte
+	opened     chan openResult
+}
+
+type openResult struct {
+	plaintext []byte
+	err       error
+}
+
+// decryptStreamWithWorkers decrypts reader into writer. Chunks are read in
+// order, opened by up to workers goroutines and written back in their
+// original order. The first authentication failure stops reading further
+// chunks, and nothing after the failing chunk is written. A version 2 stream
+// must end with a trailer that matches the chunks read; the plaintext is
+// written before the trailer is checked, so callers only keep it when no
+// error is returned.
+func decryptStreamWithWorkers(writer io.Writer, reader io.Reader, workers int) error {
+	return decryptStream(writer, reader, workers, password)
+}
+
+// decryptStream decrypts like decryptStreamWithWorkers with the key derived
+// from secret
+func decryptStream(writer io.Writer, reader io.Reader, workers int, secret string) error {
+	header, err := readEncryptionHeader(reader, secret)
+	if err != nil {
+		return err
+	}
+
+	aead, err := chacha20poly1305.New(header.key)
 	if err != nil {
 		return fmt.Errorf("failed to create AEAD cipher: %v", err)
 	}
 
-	nonceManager := &NonceManager{
-		baseNonce: make([]byte, chacha20poly1305.NonceSize),
-		counter:   0,
+	nonceManager := &NonceManager{baseNonce: header.baseNonce}
+	additionalData := header.chunkAdditionalData()
+
+	jobs := make(chan openJob, workers)
+	ordered := make(chan chan openResult, workers)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	fail := func() {
+		failOnce.Do(func() { close(failed) })
+	}
+	writeErrChan := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Go(func() {
+			for job := range jobs {
+				plaintext, err := aead.Open(nil, job.nonce, job.ciphertext, additionalData)
+				if err != nil {
+					err = fmt.Errorf("decryption failed (wrong password or corrupted data?): %v", err)
+					fail()
+				}
+				job.opened <- openResult{plaintext: plaintext, err: err}
+			}
+		})
 	}
-	copy(nonceManager.baseNonce, baseNonce)
 
+	go func() {
+		var writeErr error
+		for opened := range ordered {
+			result := <-opened
+			if writeErr != nil {
+				continue
+			}
+
+			if result.err != nil {
+				writeErr = result.err
+			} else if _, err := writer.Write(result.plaintext); err != nil {
+				writeErr = fmt.Errorf("failed to write decrypted data: %v", err)
+			}
+			if writeErr != nil {
+				fail()
+			}
+		}
+		writeErrChan <- writeErr
+	}()
+
+	var readErr error
+	var chunks, plaintextBytes uint64
+readLoop:
 	for {
+		select {
+		case <-failed:
+			break readLoop
+		default:
+		}
+
 		chunkSizeBytes := make([]byte, 4)
 		if _, err := io.ReadFull(reader, chunkSizeBytes); err != nil {
-			if err == io.EOF {
-				break // Normal end of stream
+			if err != io.EOF {
+				readErr = fmt.Errorf("failed to read chunk size: %v", err)
+			} else if header.version >= 2 {
+				readErr = fmt.Errorf("encrypted stream is truncated: it ends after %d chunks without its trailer", chunks)
 			}
-			return fmt.Errorf("failed to read chunk size: %v", err)
+			break // io.EOF is the normal end of a version 1 stream
 		}
 
 		chunkSize := binary.BigEndian.Uint32(chunkSizeBytes)
 
+		if header.version >= 2 && chunkSize&trailerFlag != 0 {
+			readErr = readTrailer(reader, aead, nonceManager.NextNonce(), chunkSize&^trailerFlag, chunks, plaintextBytes)
+			break
+		}
+
 		encryptedChunk := make([]byte, chunkSize)
 		if _, err := io.ReadFull(reader, encryptedChunk); err != nil {
-			return fmt.Errorf("failed to read encrypted chunk: %v", err)
+			readErr = fmt.Errorf("failed to read encrypted chunk: %v", err)
+			break
 		}
 
-		chunkNonce := nonceManager.NextNonce()
-		plaintext, err := aead.Open(nil, chunkNonce, encryptedChunk, nil)
-		if err != nil {
-			return fmt.Errorf("decryption failed (wrong password or corrupted data?): %v", err)
-		}
+		opened := make(chan openResult, 1)
+		jobs <- openJob{ciphertext: encryptedChunk, nonce: nonceManager.NextNonce(), opened: opened}
+		ordered <- opened
+		chunks++
+		plaintextBytes += uint64(max(0, len(encryptedChunk)-chacha20poly1305.Overhead))
+	}
 
-		if _, err := writer.Write(plaintext); err != nil {
-			return fmt.Errorf("failed to write decrypted data: %v", err)
-		}
+	close(jobs)
+	close(ordered)
+	wg.Wait()
+
+	if writeErr := <-writeErrChan; writeErr != nil {
+		return writeErr
 	}
+	return readErr
+}
 
+// readTrailer reads and verifies the trailer of a version 2 stream, which
+// must be the last record
+func readTrailer(reader io.Reader, aead cipher.AEAD, nonce []byte, size uint32, chunks, plaintextBytes uint64) error {
+	if size != trailerPlaintextSize+chacha20poly1305.Overhead {
+		return fmt.Errorf("decryption failed: the trailer is corrupted")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(reader, sealed); err != nil {
+		return fmt.Errorf("failed to read trailer: %v", err)
+	}
+	if err := verifyTrailer(aead, nonce, sealed, chunks, plaintextBytes); err != nil {
+		return err
+	}
+	if n, _ := reader.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("encrypted stream has unexpected data after its trailer")
+	}
 	return nil
 }
@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 func TestNonceManager(t *testing.T) {
@@ -165,6 +170,347 @@ func TestEncryptDecryptStream(t *testing.T) {
 	})
 }
 
+func TestEncryptStreamWithWorkers(t *testing.T) {
+	password = "testpassword123"
+
+	originalData := make([]byte, 5*DefaultEncryptionChunkSize+123)
+	_, err := rand.Read(originalData)
+	require.NoError(t, err)
+
+	for _, workers := range []int{1, 2, 8} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			encrypted := &bytes.Buffer{}
+			require.NoError(t, encryptStreamWithWorkers(encrypted, bytes.NewReader(originalData), workers))
+
+			decrypted := &bytes.Buffer{}
+			require.NoError(t, decryptStreamFromReader(decrypted, bytes.NewReader(encrypted.Bytes())))
+			assert.Equal(t, originalData, decrypted.Bytes())
+		})
+	}
+
+	t.Run("short reads keep chunk order", func(t *testing.T) {
+		data := []byte("many tiny chunks sealed out of order must be written back in order")
+
+		encrypted := &bytes.Buffer{}
+		require.NoError(t, encryptStreamWithWorkers(encrypted, iotest.OneByteReader(bytes.NewReader(data)), 4))
+
+		decrypted := &bytes.Buffer{}
+		require.NoError(t, decryptStreamFromReader(decrypted, bytes.NewReader(encrypted.Bytes())))
+		assert.Equal(t, data, decrypted.Bytes())
+	})
+
+	t.Run("write error mid-stream", func(t *testing.T) {
+		writer := &limitedWriter{remaining: 5}
+		err := encryptStreamWithWorkers(writer, bytes.NewReader(originalData), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to write")
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		err := encryptStreamWithWorkers(&bytes.Buffer{}, iotest.ErrReader(errors.New("disk error")), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read from source")
+	})
+}
+
+func BenchmarkEncryptStream(b *testing.B) {
+	password = "benchmarkpassword"
+	data := make([]byte, 64*1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(b, err)
+
+	for _, workers := range slices.Compact([]int{1, cryptoWorkers()}) {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := encryptStreamWithWorkers(io.Discard, bytes.NewReader(data), workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkBufferBudget(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	password = "testpassword123"
+
+	assert.Zero(t, chunkBudget(0))
+	assert.Equal(t, 1, chunkBudget(1), "at least one chunk")
+	assert.Equal(t, 8, chunkBudget(8*2*chunkBufferSize))
+
+	chunkBuffers = newChunkBufferPool(1)
+
+	inputs := make([][]byte, 4)
+	for i := range inputs {
+		inputs[i] = make([]byte, 5*DefaultEncryptionChunkSize+123*i)
+		_, err := rand.Read(inputs[i])
+		require.NoError(t, err)
+	}
+
+	encrypted := make([]*bytes.Buffer, len(inputs))
+	errs := make(chan error, len(inputs))
+	for i, input := range inputs {
+		encrypted[i] = &bytes.Buffer{}
+		go func() {
+			errs <- encryptStreamWithWorkers(encrypted[i], bytes.NewReader(input), 4)
+		}()
+	}
+	for range inputs {
+		require.NoError(t, <-errs)
+	}
+
+	assert.Empty(t, chunkBuffers.budget, "every chunk returned its budget")
+	for i, input := range inputs {
+		decrypted := &bytes.Buffer{}
+		require.NoError(t, decryptStreamFromReader(decrypted, encrypted[i]))
+		assert.Equal(t, input, decrypted.Bytes(), "reused buffers never leak into another chunk")
+	}
+
+	t.Run("write failure returns the budget", func(t *testing.T) {
+		err := encryptStreamWithWorkers(&limitedWriter{remaining: 3}, bytes.NewReader(inputs[0]), 4)
+		require.Error(t, err)
+		assert.Empty(t, chunkBuffers.budget)
+	})
+}
+
+func TestDecryptStreamWithWorkers(t *testing.T) {
+	password = "testpassword123"
+
+	originalData := make([]byte, 6*DefaultEncryptionChunkSize+789)
+	_, err := rand.Read(originalData)
+	require.NoError(t, err)
+
+	encrypted := &bytes.Buffer{}
+	require.NoError(t, encryptStream(encrypted, bytes.NewReader(originalData)))
+
+	serial := &bytes.Buffer{}
+	require.NoError(t, decryptStreamWithWorkers(serial, bytes.NewReader(encrypted.Bytes()), 1))
+	require.Equal(t, originalData, serial.Bytes())
+
+	for _, workers := range []int{2, 4, 8} {
+		t.Run(fmt.Sprintf("%d workers match serial output", workers), func(t *testing.T) {
+			parallel := &bytes.Buffer{}
+			require.NoError(t, decryptStreamWithWorkers(parallel, bytes.NewReader(encrypted.Bytes()), workers))
+			assert.Equal(t, serial.Bytes(), parallel.Bytes())
+		})
+	}
+
+	t.Run("corrupted chunk stops output at that chunk", func(t *testing.T) {
+		corrupted := bytes.Clone(encrypted.Bytes())
+		// flip a byte inside the third chunk: header, then two full chunks of
+		// 4-byte length prefix, chunk data and 16-byte tag
+		chunkLen := 4 + DefaultEncryptionChunkSize + chacha20poly1305.Overhead
+		corrupted[44+2*chunkLen+100] ^= 0xff
+
+		decrypted := &bytes.Buffer{}
+		err := decryptStreamWithWorkers(decrypted, bytes.NewReader(corrupted), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "decryption failed")
+		assert.Equal(t, originalData[:2*DefaultEncryptionChunkSize], decrypted.Bytes())
+	})
+
+	t.Run("truncated stream", func(t *testing.T) {
+		truncated := encrypted.Bytes()[:encrypted.Len()-10]
+
+		err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(truncated), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read encrypted chunk")
+	})
+}
+
+func TestDecryptStreamWithSize(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	password = "testpassword123"
+
+	originalData := make([]byte, 3*DefaultEncryptionChunkSize+55)
+	_, err := rand.Read(originalData)
+	require.NoError(t, err)
+
+	encrypted := &bytes.Buffer{}
+	require.NoError(t, encryptStream(encrypted, bytes.NewReader(originalData)))
+	plainSize := int64(len(originalData))
+
+	decrypted := &bytes.Buffer{}
+	require.NoError(t, decryptStreamWithSize(decrypted, bytes.NewReader(encrypted.Bytes()), plainSize, password))
+	assert.Equal(t, originalData, decrypted.Bytes())
+
+	// header, then one chunk with its length prefix and tag
+	truncated := encrypted.Bytes()[:44+4+DefaultEncryptionChunkSize+chacha20poly1305.Overhead]
+
+	require.NoError(t, decryptStreamFromReader(io.Discard, bytes.NewReader(truncated)), "without a size the cut is not noticed")
+
+	err = decryptStreamWithSize(io.Discard, bytes.NewReader(truncated), plainSize, password)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "encrypted object is incomplete")
+
+	require.NoError(t, decryptStreamWithSize(io.Discard, bytes.NewReader(truncated), -1, password), "objects without a recorded size decrypt as before")
+}
+
+func TestTrailingChecksum(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	password = "testpassword123"
+
+	originalData := make([]byte, 3*DefaultEncryptionChunkSize+321)
+	_, err := rand.Read(originalData)
+	require.NoError(t, err)
+
+	encryptWith := func(trailer bool, data []byte) []byte {
+		trailingChecksum = trailer
+		encrypted := &bytes.Buffer{}
+		require.NoError(t, encryptStream(encrypted, bytes.NewReader(data)))
+		return encrypted.Bytes()
+	}
+	v1 := encryptWith(false, originalData)
+	v2 := encryptWith(true, originalData)
+	trailingChecksum = false
+
+	assert.Equal(t, int64(len(v2)), func() int64 {
+		trailingChecksum = true
+		defer func() { trailingChecksum = false }()
+		return encryptedObjectSize(int64(len(originalData)))
+	}())
+	assert.True(t, bytes.HasPrefix(v2, streamV2Magic))
+	assert.True(t, looksEncrypted(bytes.NewReader(v2), int64(len(v2))))
+
+	for name, encrypted := range map[string][]byte{"version 1": v1, "version 2": v2} {
+		t.Run(name+" decrypts", func(t *testing.T) {
+			decrypted := &bytes.Buffer{}
+			require.NoError(t, decryptStreamWithWorkers(decrypted, bytes.NewReader(encrypted), 4))
+			assert.Equal(t, originalData, decrypted.Bytes())
+		})
+	}
+
+	t.Run("empty input has a trailer", func(t *testing.T) {
+		encrypted := encryptWith(true, nil)
+		trailingChecksum = false
+		decrypted := &bytes.Buffer{}
+		require.NoError(t, decryptStreamWithWorkers(decrypted, bytes.NewReader(encrypted), 1))
+		assert.Empty(t, decrypted.Bytes())
+
+		headerOnly := encrypted[:len(encrypted)-trailerRecordSize]
+		err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(headerOnly), 1)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "truncated")
+	})
+
+	// header: magic, salt and base nonce; then full chunks with their
+	// 4-byte length prefix and 16-byte tag
+	headerLen := len(streamV2Magic) + 44
+	chunkLen := 4 + DefaultEncryptionChunkSize + chacha20poly1305.Overhead
+
+	t.Run("truncation at a chunk boundary", func(t *testing.T) {
+		for chunks := range 4 {
+			truncated := v2[:headerLen+chunks*chunkLen]
+			err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(truncated), 4)
+			require.Error(t, err, "stream cut after %d chunks", chunks)
+			assert.Contains(t, err.Error(), "truncated")
+		}
+
+		// the same cut goes unnoticed in version 1
+		truncated := v1[:44+2*chunkLen]
+		decrypted := &bytes.Buffer{}
+		require.NoError(t, decryptStreamWithWorkers(decrypted, bytes.NewReader(truncated), 4))
+		assert.Equal(t, originalData[:2*DefaultEncryptionChunkSize], decrypted.Bytes())
+	})
+
+	t.Run("truncation mid-way", func(t *testing.T) {
+		truncated := v2[:len(v2)/2]
+		err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(truncated), 4)
+		require.Error(t, err)
+	})
+
+	t.Run("chunk dropped before the trailer", func(t *testing.T) {
+		lastChunk := headerLen + 3*chunkLen
+		spliced := slices.Concat(v2[:headerLen+2*chunkLen], v2[lastChunk:])
+		err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(spliced), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "decryption failed")
+	})
+
+	t.Run("data after the trailer", func(t *testing.T) {
+		extended := slices.Concat(v2, []byte{0, 0, 0, 0})
+		err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(extended), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "after its trailer")
+	})
+
+	t.Run("stripped format marker", func(t *testing.T) {
+		stripped := v2[len(streamV2Magic):]
+		err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(stripped), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "decryption failed")
+	})
+}
+
+// BenchmarkEncryptConcurrentUploads encrypts one stream per upload worker
+// at the same time with a precomputed header, so the allocations are the
+// chunk buffers and not the key derivation
+func BenchmarkEncryptConcurrentUploads(b *testing.B) {
+	password = "benchmarkpassword"
+	data := make([]byte, 16*1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(b, err)
+
+	header, err := newEncryptionHeader(password)
+	require.NoError(b, err)
+
+	const uploads = 5
+	b.SetBytes(int64(uploads * len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		errs := make(chan error, uploads)
+		for range uploads {
+			go func() {
+				errs <- encryptWithHeader(io.Discard, bytes.NewReader(data), header, cryptoWorkers())
+			}()
+		}
+		for range uploads {
+			if err := <-errs; err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecryptStream(b *testing.B) {
+	password = "benchmarkpassword"
+	data := make([]byte, 64*1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(b, err)
+
+	encrypted := &bytes.Buffer{}
+	require.NoError(b, encryptStream(encrypted, bytes.NewReader(data)))
+
+	for _, workers := range slices.Compact([]int{1, cryptoWorkers()}) {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if err := decryptStreamWithWorkers(io.Discard, bytes.NewReader(encrypted.Bytes()), workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// limitedWriter accepts a fixed number of writes and fails afterwards
+type limitedWriter struct {
+	remaining int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.remaining == 0 {
+		return 0, fmt.Errorf("write failed")
+	}
+	l.remaining--
+	return len(p), nil
+}
+
 // failingWriter is a writer that always fails
 type failingWriter struct{}
 
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseEndpointConcurrency parses the --concurrency-per-endpoint caps. An
+// endpoint is given as a host, optionally with a scheme and port, e.g.
+// replica.s3.example.com or https://minio.internal:9000.
+func parseEndpointConcurrency(value string) (*concurrencyLimiter, error) {
+	return parseConcurrencyLimits(value, endpointHost)
+}
+
+// endpointHost returns the lower-case host of an endpoint URL or host name
+func endpointHost(endpoint string) string {
+	endpoint = strings.TrimSpace(endpoint)
+	if strings.Contains(endpoint, "://") {
+		if parsed, err := url.Parse(endpoint); err == nil {
+			endpoint = parsed.Host
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(endpoint, "/"))
+}
+
+// transferEndpoint returns the host the requests for a bucket go to. With
+// path-style addressing every bucket shares the configured endpoint, with
+// virtual-hosted addressing each bucket is its own host below it.
+func transferEndpoint(bucketName string) string {
+	host := endpointHost(config.Endpoint)
+	if host == "" {
+		host = fmt.Sprintf("s3.%s.amazonaws.com", config.Region)
+	}
+	if config.UsePathStyle {
+		return host
+	}
+	return strings.ToLower(bucketName) + "." + host
+}
+
+// transferEndpoints returns the endpoints of the buckets of a transfer
+func transferEndpoints(buckets []string) []string {
+	endpoints := make([]string, 0, len(buckets))
+	for _, bucketName := range buckets {
+		endpoints = append(endpoints, transferEndpoint(bucketName))
+	}
+	return endpoints
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferEndpoint(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	previous := config
+	defer func() { config = previous }()
+
+	config = Config{Endpoint: "https://S3.Example.com/", Region: "us-east-1"}
+	assert.Equal(t, "replica.s3.example.com", transferEndpoint("replica"), "virtual-hosted buckets are their own host")
+
+	config.UsePathStyle = true
+	assert.Equal(t, "s3.example.com", transferEndpoint("replica"))
+
+	config = Config{Region: "eu-west-1"}
+	assert.Equal(t, "replica.s3.eu-west-1.amazonaws.com", transferEndpoint("replica"))
+}
+
+func TestParseEndpointConcurrency(t *testing.T) {
+	limiter, err := parseEndpointConcurrency("4, https://Replica.s3.example.com=2,minio.internal:9000=0")
+	require.NoError(t, err)
+	assert.Equal(t, 4, limiter.limitFor("other.s3.example.com"))
+	assert.Equal(t, 2, limiter.limitFor("replica.s3.example.com"))
+	assert.Equal(t, 0, limiter.limitFor("minio.internal:9000"))
+
+	for _, value := range []string{"abc", "-1", "=3", "replica.s3.example.com=x"} {
+		_, err := parseEndpointConcurrency(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestEndpointLimiterCapsEachEndpoint(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	previous := config
+	defer func() { config = previous }()
+
+	config = Config{Endpoint: "https://s3.example.com", Region: "us-east-1"}
+	limiter, err := parseEndpointConcurrency("slow.s3.example.com=1,fast.s3.example.com=3")
+	require.NoError(t, err)
+	endpointSlots = limiter
+
+	var mutex sync.Mutex
+	inFlight := map[string]int{}
+	peak := map[string]int{}
+
+	tasks := make([]string, 0, 24)
+	for range 12 {
+		tasks = append(tasks, "slow", "fast")
+	}
+
+	err = runWorkerPool(context.Background(), tasks, 8, func(workerCtx context.Context, bucketName string) error {
+		release, err := acquireTransferSlot(workerCtx, bucketName)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		mutex.Lock()
+		inFlight[bucketName]++
+		peak[bucketName] = max(peak[bucketName], inFlight[bucketName])
+		mutex.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mutex.Lock()
+		inFlight[bucketName]--
+		mutex.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, peak["slow"])
+	assert.LessOrEqual(t, peak["fast"], 3)
+	assert.Greater(t, peak["fast"], 1)
+}
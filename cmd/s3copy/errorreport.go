@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// Operations recorded in the --error-report file
+const (
+	operationUpload      = "upload"
+	operationDownload    = "download"
+	operationMove        = "move"
+	operationDelete      = "delete"
+	operationDeleteLocal = "delete-local"
+	operationCreateDir   = "mkdir"
+	operationMetadata    = "metadata"
+	operationScrub       = "scrub"
+)
+
+// fileFailure is one failed file operation in the --error-report file
+type fileFailure struct {
+	Path      string `json:"path,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Operation string `json:"operation"`
+	Error     string `json:"error"`
+	Retried   bool   `json:"retried"`
+}
+
+// errorReportDocument is the JSON document written by --error-report
+type errorReportDocument struct {
+	RunID    string        `json:"run_id,omitempty"`
+	Failed   int           `json:"failed"`
+	Error    string        `json:"error,omitempty"`
+	Failures []fileFailure `json:"failures"`
+}
+
+// failureLog collects the per-file failures of a run
+type failureLog struct {
+	mutex    sync.Mutex
+	failures []fileFailure
+}
+
+var failures = &failureLog{}
+
+// reset discards the failures of a previous run
+func (l *failureLog) reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.failures = nil
+}
+
+// list returns a copy of the recorded failures
+func (l *failureLog) list() []fileFailure {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]fileFailure(nil), l.failures...)
+}
+
+// recordFailure adds a failed file operation to the error report. key is an
+// s3:// URI. Cancellations caused by another failure are not recorded.
+func recordFailure(operation, path, key string, err error) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	var maxAttemptsErr *retry.MaxAttemptsError
+	failures.mutex.Lock()
+	defer failures.mutex.Unlock()
+	failures.failures = append(failures.failures, fileFailure{
+		Path:      path,
+		Key:       key,
+		Operation: operation,
+		Error:     err.Error(),
+		Retried:   errors.As(err, &maxAttemptsErr),
+	})
+}
+
+// writeErrorReport writes the recorded failures and the overall error of the
+// run, if any, to path. The file is replaced atomically.
+func writeErrorReport(path string, runErr error) error {
+	document := errorReportDocument{RunID: runID, Failures: failures.list()}
+	document.Failed = len(document.Failures)
+	if document.Failures == nil {
+		document.Failures = []fileFailure{}
+	}
+	if runErr != nil && !errors.Is(runErr, errNothingToDo) {
+		document.Error = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode error report: %w", err)
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write error report: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to write error report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingS3Server wraps fake and rejects uploads of keys containing "denied"
+// with 403 and of keys containing "flaky" with 500
+func failingS3Server(fake *fakeS3) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "denied"):
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeFakeError(w, http.StatusForbidden, "AccessDenied")
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "flaky"):
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeFakeError(w, http.StatusInternalServerError, "InternalError")
+		default:
+			fake.ServeHTTP(w, r)
+		}
+	}
+}
+
+func TestErrorReportSyncPartialFailure(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	httpServer := useFakeS3(t, failingS3Server(newFakeS3(nil)))
+
+	t.Setenv("S3COPY_ENDPOINT", httpServer.URL)
+	t.Setenv("S3COPY_ACCESS_KEY", "access")
+	t.Setenv("S3COPY_SECRET_KEY", "secret")
+	t.Setenv("S3COPY_REGION", "us-east-1")
+	t.Setenv("S3COPY_USE_PATH_STYLE", strconv.FormatBool(true))
+
+	localDir := t.TempDir()
+	for _, name := range []string{"ok.txt", "denied.txt", "flaky.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, name), []byte(name), 0644))
+	}
+
+	setTestConfig(localDir, "s3://reports/data/", "", false, true, true, false)
+	envFile = filepath.Join(t.TempDir(), "missing.env")
+	syncMode = true
+	retries = 2
+	errorReport = filepath.Join(t.TempDir(), "errors.json")
+
+	var runErr error
+	captureStdout(func() {
+		runErr = runCopy()
+	})
+	var partial *partialFailureError
+	require.True(t, errors.As(runErr, &partial), "got %v", runErr)
+
+	data, err := os.ReadFile(errorReport)
+	require.NoError(t, err)
+	var document errorReportDocument
+	require.NoError(t, json.Unmarshal(data, &document))
+
+	assert.Equal(t, 2, document.Failed)
+	assert.Contains(t, document.Error, "sync completed with 2 error(s)")
+	require.Len(t, document.Failures, 2)
+
+	byKey := make(map[string]fileFailure)
+	for _, failure := range document.Failures {
+		byKey[failure.Key] = failure
+	}
+
+	denied := byKey["s3://reports/data/denied.txt"]
+	assert.Equal(t, filepath.Join(localDir, "denied.txt"), denied.Path)
+	assert.Equal(t, operationUpload, denied.Operation)
+	assert.Contains(t, denied.Error, "AccessDenied")
+	assert.False(t, denied.Retried, "403 is not retried")
+
+	flaky := byKey["s3://reports/data/flaky.txt"]
+	assert.Equal(t, operationUpload, flaky.Operation)
+	assert.Contains(t, flaky.Error, "InternalError")
+	assert.True(t, flaky.Retried, "500 is retried until the attempts run out")
+}
+
+func TestErrorReportWithoutFailures(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	failures.reset()
+	recordFailure(operationUpload, "/tmp/a.txt", "s3://b/a.txt", nil)
+	path := filepath.Join(t.TempDir(), "errors.json")
+	require.NoError(t, writeErrorReport(path, nil))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"failed": 0, "failures": []}`, string(data))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// etagState is the content of the --since-etag state file: the ETag of every
+// object a sync found in step with its local file, together with the size and
+// modification time of that file
+type etagState struct {
+	Bucket  string                    `json:"bucket"`
+	Prefix  string                    `json:"prefix"`
+	Entries map[string]etagStateEntry `json:"entries"`
+}
+
+type etagStateEntry struct {
+	ETag    string `json:"etag"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+func newETagState(bucket, prefix string) *etagState {
+	return &etagState{Bucket: bucket, Prefix: prefix, Entries: make(map[string]etagStateEntry)}
+}
+
+// loadETagState reads the state of the previous sync. A missing file, or a
+// state recorded for another bucket or prefix, yields an empty state.
+func loadETagState(path, bucket, prefix string) (*etagState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newETagState(bucket, prefix), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ETag state: %w", err)
+	}
+
+	var state etagState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid ETag state %s: %w", path, err)
+	}
+	if state.Bucket != bucket || state.Prefix != prefix {
+		logVerbose("Ignoring ETag state %s recorded for s3://%s/%s\n", path, state.Bucket, state.Prefix)
+		return newETagState(bucket, prefix), nil
+	}
+	if state.Entries == nil {
+		state.Entries = make(map[string]etagStateEntry)
+	}
+	return &state, nil
+}
+
+// save writes the state atomically, so an interrupted sync keeps the
+// previous state
+func (s *etagState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ETag state: %w", err)
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write ETag state: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to write ETag state: %w", err)
+	}
+	return nil
+}
+
+// unchanged reports whether neither the object nor the local file changed
+// since the previous sync found them in step
+func (s *etagState) unchanged(localFile, s3File FileInfo) bool {
+	if s == nil {
+		return false
+	}
+	entry, ok := s.Entries[localFile.RelPath]
+	return ok && entry.ETag == s3File.MD5Hash && entry.Size == localFile.Size && entry.ModTime == localFile.ModTime
+}
+
+// record remembers a pair that is in step
+func (s *etagState) record(localFile, s3File FileInfo) {
+	if s == nil {
+		return
+	}
+	s.Entries[localFile.RelPath] = etagStateEntry{ETag: s3File.MD5Hash, Size: localFile.Size, ModTime: localFile.ModTime}
+}
+
+// syncComparer compares the local files and objects present on both sides
+// of a sync. With --since-etag, pairs whose ETag, size and modification time
+// match the previous sync are taken as unchanged without hashing the local
+// file or sending a HEAD request; only the others are examined.
+type syncComparer struct {
+	s3Client *s3.Client
+	bucket   string
+	previous *etagState
+	next     *etagState
+	examined []string
+	skipped  int
+}
+
+// newSyncComparer loads the --since-etag state when the flag is set
+func newSyncComparer(s3Client *s3.Client, bucket, prefix string) (*syncComparer, error) {
+	comparer := &syncComparer{s3Client: s3Client, bucket: bucket}
+	if sinceETag == "" {
+		return comparer, nil
+	}
+
+	previous, err := loadETagState(sinceETag, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	comparer.previous = previous
+	comparer.next = newETagState(bucket, prefix)
+	return comparer, nil
+}
+
+// localChecksums reports whether local files must be hashed while they are
+// listed. With --since-etag only the examined files are hashed.
+func localChecksums() bool {
+	return shouldUseChecksumCompare() && sinceETag == ""
+}
+
+// same reports whether a local file and its object are in step
+func (c *syncComparer) same(ctx context.Context, localFile, s3File FileInfo) bool {
+	if c.previous.unchanged(localFile, s3File) {
+		c.next.record(localFile, s3File)
+		c.skipped++
+		return true
+	}
+
+	c.examined = append(c.examined, localFile.RelPath)
+	if shouldUseChecksumCompare() && localFile.MD5Hash == "" {
+		md5Hash, err := calculateFileMD5(localFile.Path)
+		if err != nil {
+			logVerbose("Warning: could not calculate MD5 for %s: %v\n", localFile.Path, err)
+			return false
+		}
+		localFile.MD5Hash = md5Hash
+	}
+
+	if !filesAreSameByMode(ctx, c.s3Client, localFile, s3File, c.bucket) {
+		return false
+	}
+	c.next.record(localFile, s3File)
+	return true
+}
+
+// saveState writes the state for the next sync. Pairs transferred by this
+// sync are not recorded, the next sync examines them once more.
+func (c *syncComparer) saveState() error {
+	if c.next == nil || dryRun {
+		return nil
+	}
+	logVerbose("Since the last sync: %d files unchanged, %d examined\n", c.skipped, len(c.examined))
+	return c.next.save(sinceETag)
+}
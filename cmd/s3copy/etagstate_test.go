@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinceETagExaminesOnlyChangedKeys(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, true, false, false)
+
+	dir := t.TempDir()
+	sinceETag = filepath.Join(t.TempDir(), "etags.json")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+
+	// remote holds the objects of a bucket that is in step with dir
+	remote := make(map[string]FileInfo)
+	upload := func(t *testing.T, name string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		md5Hash, err := calculateFileMD5(path)
+		require.NoError(t, err)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		remote[name] = FileInfo{Path: "data/" + name, RelPath: name, Size: info.Size(), MD5Hash: md5Hash}
+	}
+
+	compare := func(t *testing.T) (examined, outdated []string) {
+		t.Helper()
+		assert.False(t, localChecksums(), "local files are not hashed while listing")
+		localFiles, err := listLocalFilesWithOptions(dir, localChecksums())
+		require.NoError(t, err)
+
+		comparer, err := newSyncComparer(nil, "bucket", "data/")
+		require.NoError(t, err)
+		for _, localFile := range localFiles {
+			s3File, ok := remote[localFile.RelPath]
+			if ok && !comparer.same(context.Background(), localFile, s3File) {
+				outdated = append(outdated, localFile.RelPath)
+			}
+		}
+		require.NoError(t, comparer.saveState())
+		slices.Sort(comparer.examined)
+		return comparer.examined, outdated
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		upload(t, name)
+	}
+	examined, outdated := compare(t)
+	assert.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, examined, "the first sync examines everything")
+	assert.Empty(t, outdated)
+
+	examined, outdated = compare(t)
+	assert.Empty(t, examined, "nothing changed")
+	assert.Empty(t, outdated)
+
+	// b.txt is replaced on S3, c.txt is edited locally and d.txt is new on both sides
+	remote["b.txt"] = FileInfo{Path: "data/b.txt", RelPath: "b.txt", Size: 99, MD5Hash: "0123456789abcdef0123456789abcdef"}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("edited content"), 0644))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "c.txt"), later, later))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "d.txt"), []byte("new"), 0644))
+	upload(t, "d.txt")
+
+	examined, outdated = compare(t)
+	assert.Equal(t, []string{"b.txt", "c.txt", "d.txt"}, examined)
+	assert.Equal(t, []string{"b.txt", "c.txt"}, outdated)
+
+	t.Run("state of another prefix is ignored", func(t *testing.T) {
+		state, err := loadETagState(sinceETag, "bucket", "other/")
+		require.NoError(t, err)
+		assert.Empty(t, state.Entries)
+
+		state, err = loadETagState(sinceETag, "bucket", "data/")
+		require.NoError(t, err)
+		assert.Contains(t, state.Entries, "a.txt")
+		assert.NotContains(t, state.Entries, "b.txt", "outdated pairs are not recorded")
+	})
+}
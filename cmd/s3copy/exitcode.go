@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Exit codes of the process. Codes 2 and 3 are only used with
+// --detailed-exit-codes, otherwise every failure exits with 1.
+const (
+	exitSuccess     = 0
+	exitFatal       = 1
+	exitPartial     = 2
+	exitNothingToDo = 3
+)
+
+// errNothingToDo is returned with --detailed-exit-codes when an operation
+// completed without transferring or deleting anything
+var errNothingToDo = errors.New("nothing to do")
+
+// runTimeoutError replaces the error of a run cancelled by --timeout. The
+// file counts are taken when the deadline passed, before the cancelled
+// transfers returned.
+type runTimeoutError struct {
+	timeout   time.Duration
+	queued    int64
+	completed int64
+	err       error
+}
+
+func (e *runTimeoutError) Error() string {
+	return fmt.Sprintf("operation timed out after %s: %d of %d queued files finished, %d cancelled or not started: %v",
+		e.timeout, e.completed, e.queued, e.queued-e.completed, e.err)
+}
+
+func (e *runTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// partialFailureError marks an operation that ran to completion but failed
+// for some of the files
+type partialFailureError struct {
+	err error
+}
+
+func (e *partialFailureError) Error() string {
+	return e.err.Error()
+}
+
+func (e *partialFailureError) Unwrap() error {
+	return e.err
+}
+
+// exitCode maps the error returned by the command to the process exit code
+func exitCode(err error) int {
+	if err == nil {
+		return exitSuccess
+	}
+	if !detailedExitCodes {
+		return exitFatal
+	}
+
+	var partial *partialFailureError
+	switch {
+	case errors.Is(err, errNothingToDo):
+		return exitNothingToDo
+	case errors.As(err, &partial):
+		return exitPartial
+	default:
+		return exitFatal
+	}
+}
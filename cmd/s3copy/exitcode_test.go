@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	partial := fmt.Errorf("error syncing directories: %w", &partialFailureError{errors.New("sync completed with 1 error(s)")})
+
+	t.Run("default scheme", func(t *testing.T) {
+		detailedExitCodes = false
+		assert.Equal(t, exitSuccess, exitCode(nil))
+		assert.Equal(t, exitFatal, exitCode(errors.New("boom")))
+		assert.Equal(t, exitFatal, exitCode(partial))
+	})
+
+	t.Run("detailed scheme", func(t *testing.T) {
+		detailedExitCodes = true
+		assert.Equal(t, exitSuccess, exitCode(nil))
+		assert.Equal(t, exitFatal, exitCode(errors.New("missing required environment variables")))
+		assert.Equal(t, exitPartial, exitCode(partial))
+		assert.Equal(t, exitNothingToDo, exitCode(errNothingToDo))
+		assert.Equal(t, "sync completed with 1 error(s)", errors.Unwrap(partial).Error())
+	})
+}
+
+func TestDetailedExitCodes(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-exit-codes-bucket"
+
+	_, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Setenv("S3COPY_ENDPOINT", config.Endpoint)
+	t.Setenv("S3COPY_ACCESS_KEY", config.AccessKey)
+	t.Setenv("S3COPY_SECRET_KEY", config.SecretKey)
+	t.Setenv("S3COPY_REGION", config.Region)
+	t.Setenv("S3COPY_USE_PATH_STYLE", strconv.FormatBool(config.UsePathStyle))
+
+	localDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("b"), 0644))
+
+	run := func(src, dst string, sync bool) int {
+		setTestConfig(src, dst, "", false, true, true, false)
+		envFile = filepath.Join(t.TempDir(), "missing.env")
+		syncMode = sync
+		detailedExitCodes = true
+		return exitCode(runCopy())
+	}
+
+	t.Run("upload transfers files", func(t *testing.T) {
+		assert.Equal(t, exitSuccess, run(localDir, fmt.Sprintf("s3://%s/copy/", bucketName), false))
+	})
+
+	t.Run("upload with every file skipped", func(t *testing.T) {
+		assert.Equal(t, exitNothingToDo, run(localDir, fmt.Sprintf("s3://%s/copy/", bucketName), false))
+	})
+
+	t.Run("sync already in sync", func(t *testing.T) {
+		assert.Equal(t, exitSuccess, run(localDir, fmt.Sprintf("s3://%s/sync/", bucketName), true))
+		assert.Equal(t, exitNothingToDo, run(localDir, fmt.Sprintf("s3://%s/sync/", bucketName), true))
+	})
+
+	t.Run("sync with file-level errors", func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Join(localDir, "nested"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, "nested", "c.txt"), []byte("c"), 0644))
+		assert.Equal(t, exitSuccess, run(localDir, fmt.Sprintf("s3://%s/partial/", bucketName), true))
+
+		// A local file named like the S3 "directory" makes that download fail
+		downloadDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(downloadDir, "nested"), []byte("not a directory"), 0644))
+
+		assert.Equal(t, exitPartial, run(fmt.Sprintf("s3://%s/partial/", bucketName), downloadDir, true))
+		assert.FileExists(t, filepath.Join(downloadDir, "a.txt"))
+	})
+
+	t.Run("fatal error", func(t *testing.T) {
+		assert.Equal(t, exitFatal, run(localDir, filepath.Join(t.TempDir(), "local"), false))
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// expandEnvValue replaces $VAR and ${VAR} in value with the environment.
+// Unset variables are collected in missing instead of expanding to an empty
+// string, which would silently write to another prefix.
+func expandEnvValue(value string, missing *[]string) string {
+	return os.Expand(value, func(name string) string {
+		expanded, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(*missing, name) {
+			*missing = append(*missing, name)
+		}
+		return expanded
+	})
+}
+
+// expandPathArguments expands environment variables in the source,
+// destinations and bucket when --expand-env is set
+func expandPathArguments() error {
+	if !expandEnv {
+		return nil
+	}
+	var missing []string
+	source = expandEnvValue(source, &missing)
+	destination = expandEnvValue(destination, &missing)
+	for i, dest := range destinations {
+		destinations[i] = expandEnvValue(dest, &missing)
+	}
+	bucket = expandEnvValue(bucket, &missing)
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("expand-env: environment variable %s is not set", missing[0])
+	default:
+		return fmt.Errorf("expand-env: environment variables %s are not set", strings.Join(missing, ", "))
+	}
+}
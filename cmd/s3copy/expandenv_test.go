@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPathArguments(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	t.Setenv("S3COPY_TEST_HOST", "web-01")
+	t.Setenv("S3COPY_TEST_BUCKET", "backups")
+	t.Setenv("S3COPY_TEST_EMPTY", "")
+
+	setPaths := func() {
+		setTestConfig("./data/$S3COPY_TEST_HOST", "s3://${S3COPY_TEST_BUCKET}/hosts/$S3COPY_TEST_HOST/", "$S3COPY_TEST_BUCKET", false, true, true, false)
+		destinations = []string{destination, "s3://mirror/$S3COPY_TEST_HOST/"}
+	}
+
+	t.Run("left alone without the flag", func(t *testing.T) {
+		setPaths()
+		require.NoError(t, expandPathArguments())
+		assert.Equal(t, "./data/$S3COPY_TEST_HOST", source)
+		assert.Equal(t, "s3://${S3COPY_TEST_BUCKET}/hosts/$S3COPY_TEST_HOST/", destination)
+		assert.Equal(t, "$S3COPY_TEST_BUCKET", bucket)
+	})
+
+	t.Run("expanded with the flag", func(t *testing.T) {
+		setPaths()
+		expandEnv = true
+		require.NoError(t, expandPathArguments())
+		assert.Equal(t, "./data/web-01", source)
+		assert.Equal(t, "s3://backups/hosts/web-01/", destination)
+		assert.Equal(t, []string{"s3://backups/hosts/web-01/", "s3://mirror/web-01/"}, destinations)
+		assert.Equal(t, "backups", bucket)
+	})
+
+	t.Run("set but empty variables expand to nothing", func(t *testing.T) {
+		setTestConfig("./data", "s3://backups/$S3COPY_TEST_EMPTY", "", false, true, true, false)
+		expandEnv = true
+		require.NoError(t, expandPathArguments())
+		assert.Equal(t, "s3://backups/", destination)
+	})
+
+	t.Run("unset variables fail", func(t *testing.T) {
+		setTestConfig("./$S3COPY_TEST_UNSET_A/x", "s3://backups/${S3COPY_TEST_UNSET_B}/$S3COPY_TEST_UNSET_A", "", false, true, true, false)
+		expandEnv = true
+		err := expandPathArguments()
+		require.Error(t, err)
+		assert.Equal(t, "expand-env: environment variables S3COPY_TEST_UNSET_A, S3COPY_TEST_UNSET_B are not set", err.Error())
+	})
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObject is an object stored by fakeS3. The header holds what S3 returns
+// with the content: the user metadata, Content-Type, Cache-Control and the
+// like. Objects completed by a multipart upload remember their part sizes.
+type fakeObject struct {
+	content  string
+	header   http.Header
+	parts    []int
+	etag     string
+	modified time.Time
+}
+
+// metadata returns the user metadata entry name of the object
+func (o fakeObject) metadata(name string) string {
+	return o.header.Get("X-Amz-Meta-" + name)
+}
+
+// fakeUpload is a multipart upload of fakeS3 that was not completed yet
+type fakeUpload struct {
+	path   string
+	header http.Header
+	parts  map[int]string
+}
+
+// fakeRequest is a request fakeS3 received: the S3 operation and the
+// bucket/key path it addressed
+type fakeRequest struct {
+	operation string
+	path      string
+}
+
+// fakeS3 is an in-memory, path-style S3 endpoint for tests. Objects are
+// addressed as bucket/key. It lists objects with ListObjectsV2, serves them
+// by range or part number, and handles PutObject, CopyObject, DeleteObject,
+// DeleteObjects and multipart uploads. Every request is recorded, operations
+// in denied are answered with 403 AccessDenied. Tests that need other answers
+// wrap it in a handler that intercepts some requests.
+type fakeS3 struct {
+	mutex    sync.Mutex
+	objects  map[string]*fakeObject
+	uploads  map[string]*fakeUpload
+	nextID   int
+	requests []fakeRequest
+	denied   map[string]bool
+}
+
+// newFakeS3 returns a fake that stores the given bucket/key contents
+func newFakeS3(objects map[string]string) *fakeS3 {
+	s := &fakeS3{objects: map[string]*fakeObject{}, uploads: map[string]*fakeUpload{}}
+	for path, content := range objects {
+		s.put(path, content)
+	}
+	return s
+}
+
+// useFakeS3 starts handler as the S3 endpoint of the test and points the
+// client configuration at it. The server is closed and the configuration
+// and client are restored when the test ends.
+func useFakeS3(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	previous := config
+	t.Cleanup(func() {
+		server.Close()
+		config = previous
+		resetS3Client()
+	})
+
+	config = Config{
+		Endpoint:     server.URL,
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		Region:       "us-east-1",
+		UsePathStyle: true,
+	}
+	resetS3Client()
+	return server
+}
+
+// put stores content at path
+func (s *fakeS3) put(path, content string) {
+	s.store(path, &fakeObject{content: content})
+}
+
+// store adds object at path, filling in its header and modification time
+func (s *fakeS3) store(path string, object *fakeObject) {
+	if object.header == nil {
+		object.header = http.Header{}
+	}
+	if object.modified.IsZero() {
+		object.modified = time.Now().UTC().Truncate(time.Second)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects[path] = object
+}
+
+// remove deletes the object at path
+func (s *fakeS3) remove(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.objects, path)
+}
+
+// object returns a copy of the object at path
+func (s *fakeS3) object(path string) (fakeObject, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	object, ok := s.objects[path]
+	if !ok {
+		return fakeObject{}, false
+	}
+	return *object, true
+}
+
+// content returns the content of the object at path, or "" when it does not exist
+func (s *fakeS3) content(path string) string {
+	object, _ := s.object(path)
+	return object.content
+}
+
+// contents returns the content of every stored object by path
+func (s *fakeS3) contents() map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	contents := make(map[string]string, len(s.objects))
+	for path, object := range s.objects {
+		contents[path] = object.content
+	}
+	return contents
+}
+
+// paths returns the paths the operation was requested for, in order
+func (s *fakeS3) paths(operation string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var paths []string
+	for _, request := range s.requests {
+		if request.operation == operation {
+			paths = append(paths, request.path)
+		}
+	}
+	return paths
+}
+
+// count returns how often the operation was requested
+func (s *fakeS3) count(operation string) int {
+	return len(s.paths(operation))
+}
+
+// operations returns the operations of every request, in order
+func (s *fakeS3) operations() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	operations := make([]string, len(s.requests))
+	for i, request := range s.requests {
+		operations[i] = request.operation
+	}
+	return operations
+}
+
+// resetRequests forgets the recorded requests
+func (s *fakeS3) resetRequests() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = nil
+}
+
+// fakeOperation names the S3 operation of a request
+func fakeOperation(r *http.Request, key string) string {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case key == "" && query.Has("uploads"):
+			return "ListMultipartUploads"
+		case key == "":
+			return "ListObjectsV2"
+		case query.Has("uploadId"):
+			return "ListParts"
+		}
+		return "GetObject"
+	case http.MethodHead:
+		return "HeadObject"
+	case http.MethodPut:
+		switch {
+		case key == "":
+			return "CreateBucket"
+		case query.Has("uploadId"):
+			return "UploadPart"
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			return "CopyObject"
+		}
+		return "PutObject"
+	case http.MethodPost:
+		switch {
+		case key == "" && query.Has("delete"):
+			return "DeleteObjects"
+		case query.Has("uploads"):
+			return "CreateMultipartUpload"
+		case query.Has("uploadId"):
+			return "CompleteMultipartUpload"
+		}
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			return "AbortMultipartUpload"
+		}
+		return "DeleteObject"
+	}
+	return r.Method
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	path := bucket + "/" + key
+	operation := fakeOperation(r, key)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, fakeRequest{operation: operation, path: path})
+	if s.denied[operation] {
+		writeFakeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	query := r.URL.Query()
+	switch operation {
+	case "ListObjectsV2":
+		s.listObjects(w, bucket, query.Get("prefix"), query.Get("delimiter"), query.Get("start-after"))
+	case "ListMultipartUploads":
+		s.listUploads(w, bucket, query.Get("prefix"))
+	case "GetObject", "HeadObject":
+		s.serveObject(w, r, path)
+	case "PutObject":
+		if r.Header.Get("If-None-Match") == "*" && s.objects[path] != nil {
+			writeFakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		object := &fakeObject{content: string(body), header: objectHeader(r.Header), modified: time.Now().UTC().Truncate(time.Second)}
+		s.objects[path] = object
+		w.Header().Set("ETag", `"`+object.entityTag()+`"`)
+	case "CopyObject":
+		copySource, _ := strings.CutPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")
+		if unescaped, err := url.PathUnescape(copySource); err == nil {
+			copySource = unescaped
+		}
+		source, ok := s.objects[copySource]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		object := &fakeObject{content: source.content, header: source.header.Clone(), parts: source.parts, modified: time.Now().UTC().Truncate(time.Second)}
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			object.header = objectHeader(r.Header)
+		}
+		s.objects[path] = object
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: `"` + object.entityTag() + `"`})
+	case "DeleteObject":
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	case "DeleteObjects":
+		var request struct {
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+		if err := xml.Unmarshal(body, &request); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		type deleted struct {
+			Key string
+		}
+		var result struct {
+			XMLName xml.Name  `xml:"DeleteResult"`
+			Deleted []deleted `xml:"Deleted"`
+		}
+		for _, object := range request.Objects {
+			delete(s.objects, bucket+"/"+object.Key)
+			result.Deleted = append(result.Deleted, deleted{Key: object.Key})
+		}
+		writeFakeXML(w, result)
+	case "CreateMultipartUpload":
+		s.nextID++
+		uploadID := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[uploadID] = &fakeUpload{path: path, header: objectHeader(r.Header), parts: map[int]string{}}
+		writeFakeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+	case "UploadPart":
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[partNumber] = string(body)
+		w.Header().Set("ETag", `"`+md5Hex(string(body))+`"`)
+	case "ListParts":
+		s.listParts(w, bucket, key, query.Get("uploadId"))
+	case "CompleteMultipartUpload":
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		delete(s.uploads, query.Get("uploadId"))
+		partNumbers := slices.Sorted(maps.Keys(upload.parts))
+		object := &fakeObject{header: upload.header, modified: time.Now().UTC().Truncate(time.Second)}
+		var content strings.Builder
+		for _, partNumber := range partNumbers {
+			content.WriteString(upload.parts[partNumber])
+			object.parts = append(object.parts, len(upload.parts[partNumber]))
+		}
+		object.content = content.String()
+		s.objects[upload.path] = object
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: `"` + object.entityTag() + `"`})
+	case "AbortMultipartUpload":
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case "CreateBucket":
+	default:
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// entityTag returns the ETag S3 computes for the object: the MD5 of the
+// content, or for a multipart upload the MD5 of the part MD5s and the part count
+func (o fakeObject) entityTag() string {
+	if o.etag != "" {
+		return o.etag
+	}
+	if len(o.parts) == 0 {
+		return md5Hex(o.content)
+	}
+	partHashes := md5.New()
+	offset := 0
+	for _, size := range o.parts {
+		sum := md5.Sum([]byte(o.content[offset : offset+size]))
+		partHashes.Write(sum[:])
+		offset += size
+	}
+	return hex.EncodeToString(partHashes.Sum(nil)) + "-" + strconv.Itoa(len(o.parts))
+}
+
+func (s *fakeS3) serveObject(w http.ResponseWriter, r *http.Request, path string) {
+	object, ok := s.objects[path]
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	for name, values := range object.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("ETag", `"`+object.entityTag()+`"`)
+
+	partNumber, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if partNumber > 0 && len(object.parts) > 0 {
+		if partNumber > len(object.parts) {
+			writeFakeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber")
+			return
+		}
+		start := 0
+		for _, size := range object.parts[:partNumber-1] {
+			start += size
+		}
+		end := start + object.parts[partNumber-1]
+		w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+		w.Header().Set("X-Amz-Mp-Parts-Count", strconv.Itoa(len(object.parts)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(object.content)))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodGet {
+			_, _ = io.WriteString(w, object.content[start:end])
+		}
+		return
+	}
+	http.ServeContent(w, r, "", object.modified, strings.NewReader(object.content))
+}
+
+func (s *fakeS3) listObjects(w http.ResponseWriter, bucket, prefix, delimiter, startAfter string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		Delimiter      string `xml:",omitempty"`
+		KeyCount       int
+		MaxKeys        int
+		IsTruncated    bool
+		Contents       []content      `xml:"Contents"`
+		CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+	}{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+
+	var keys []string
+	for path := range s.objects {
+		objectBucket, key, _ := strings.Cut(path, "/")
+		if objectBucket == bucket && strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	seenPrefixes := map[string]bool{}
+	for _, key := range keys {
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[common] {
+					seenPrefixes[common] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: common})
+				}
+				continue
+			}
+		}
+		object := s.objects[bucket+"/"+key]
+		storageClass := object.header.Get("X-Amz-Storage-Class")
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: object.modified.Format(time.RFC3339),
+			ETag:         `"` + object.entityTag() + `"`,
+			Size:         len(object.content),
+			StorageClass: storageClass,
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	writeFakeXML(w, result)
+}
+
+func (s *fakeS3) listUploads(w http.ResponseWriter, bucket, prefix string) {
+	type upload struct {
+		Key       string
+		UploadId  string
+		Initiated string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket  string
+		Uploads []upload `xml:"Upload"`
+	}{Bucket: bucket}
+	for _, uploadID := range slices.Sorted(maps.Keys(s.uploads)) {
+		uploadBucket, key, _ := strings.Cut(s.uploads[uploadID].path, "/")
+		if uploadBucket == bucket && strings.HasPrefix(key, prefix) {
+			result.Uploads = append(result.Uploads, upload{Key: key, UploadId: uploadID, Initiated: time.Now().UTC().Format(time.RFC3339)})
+		}
+	}
+	writeFakeXML(w, result)
+}
+
+func (s *fakeS3) listParts(w http.ResponseWriter, bucket, key, uploadID string) {
+	upload, ok := s.uploads[uploadID]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	type part struct {
+		PartNumber int
+		ETag       string
+		Size       int
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListPartsResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+		Parts    []part `xml:"Part"`
+	}{Bucket: bucket, Key: key, UploadId: uploadID}
+	for _, partNumber := range slices.Sorted(maps.Keys(upload.parts)) {
+		content := upload.parts[partNumber]
+		result.Parts = append(result.Parts, part{PartNumber: partNumber, ETag: `"` + md5Hex(content) + `"`, Size: len(content)})
+	}
+	writeFakeXML(w, result)
+}
+
+// objectHeader keeps the request headers S3 stores with an object
+func objectHeader(request http.Header) http.Header {
+	header := http.Header{}
+	for name, values := range request {
+		switch {
+		case strings.HasPrefix(name, "X-Amz-Meta-"),
+			name == "Content-Type", name == "Cache-Control", name == "Content-Encoding",
+			name == "Content-Disposition", name == "Content-Language", name == "Expires",
+			name == "X-Amz-Storage-Class":
+			header[name] = values
+		}
+	}
+	return header
+}
+
+func writeFakeXML(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(value)
+}
+
+// writeFakeError answers with an S3 error document
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultDownloadDirMode is the mode of directories created by downloads
+// without --dir-mode, reduced by the umask
+const defaultDownloadDirMode os.FileMode = 0755
+
+// parseOctalMode parses a permission mode like 0700, 700 or 0o700
+func parseOctalMode(value string) (os.FileMode, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(value, "0o"), "0O")
+	mode, err := strconv.ParseUint(digits, 8, 32)
+	if err != nil || digits == "" || mode > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q, use octal permissions between 000 and 777 like 0700", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// makeDownloadDir creates dir and its missing parents. With --dir-mode every
+// directory it creates gets exactly that mode, regardless of the umask;
+// directories that already exist are left alone.
+func makeDownloadDir(dir string) error {
+	if dirMode == "" {
+		return os.MkdirAll(dir, defaultDownloadDirMode)
+	}
+
+	var missing []string
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil {
+			break
+		}
+		missing = append(missing, current)
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, downloadDirMode); err != nil {
+		return err
+	}
+	for _, created := range missing {
+		if err := os.Chmod(created, downloadDirMode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", created, err)
+		}
+	}
+	return nil
+}
+
+// applyDownloadFileMode sets the --file-mode of a downloaded file before it
+// is moved into place. Without the flag the file keeps the mode it was
+// created with.
+func applyDownloadFileMode(path string) error {
+	if fileMode == "" {
+		return nil
+	}
+	if err := os.Chmod(path, downloadFileMode); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", path, err)
+	}
+	return nil
+}
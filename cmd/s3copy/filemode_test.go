@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOctalMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{value: "0700", want: 0o700},
+		{value: "700", want: 0o700},
+		{value: "0o750", want: 0o750},
+		{value: "0", want: 0},
+		{value: "0777", want: 0o777},
+		{value: "1777", wantErr: true},
+		{value: "0800", wantErr: true},
+		{value: "rwx", wantErr: true},
+		{value: "0o", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseOctalMode(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDownloadDirAndFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX permissions are not supported on Windows")
+	}
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, false, false, false)
+
+	useFakeS3(t, newFakeS3(map[string]string{"bucket/secret.txt": "private"}))
+
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+
+	base := t.TempDir()
+	require.NoError(t, os.Chmod(base, 0o755))
+
+	dirMode = "0770"
+	downloadDirMode = 0o770
+	fileMode = "0640"
+	downloadFileMode = 0o640
+
+	localDir := filepath.Join(base, "restore", "private")
+	require.NoError(t, makeDownloadDir(localDir))
+	localPath := filepath.Join(localDir, "secret.txt")
+	require.NoError(t, performS3Download(context.Background(), manager.New(s3Client), "bucket", "secret.txt", localPath, false))
+
+	for _, dir := range []string{filepath.Join(base, "restore"), localDir} {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o770), info.Mode().Perm(), "%s ignores the umask", dir)
+	}
+
+	info, err := os.Stat(base)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm(), "existing directories are left alone")
+
+	info, err = os.Stat(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// filterCommand builds the shell command used to run --filter-cmd
+func filterCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// filterFileInPlace pipes the content of filePath through the filter command
+// and replaces the file with the command's output
+func filterFileInPlace(ctx context.Context, filePath string) error {
+	input, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for filtering: %w", filePath, err)
+	}
+
+	output, err := os.CreateTemp(filepath.Dir(filePath), ".s3copy-filter-*")
+	if err != nil {
+		closeWithLog(input, filePath)
+		return fmt.Errorf("failed to create temp file for filter output: %w", err)
+	}
+	outputPath := output.Name()
+	defer removeTempFile(outputPath)
+
+	var stderr bytes.Buffer
+	cmd := filterCommand(ctx, filterCmd)
+	cmd.Stdin = input
+	cmd.Stdout = output
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	closeWithLog(output, outputPath)
+	closeWithLog(input, filePath)
+
+	if runErr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("filter command failed: %w: %s", runErr, msg)
+		}
+		return fmt.Errorf("filter command failed: %w", runErr)
+	}
+
+	if err := os.Rename(outputPath, filePath); err != nil {
+		return fmt.Errorf("failed to move filtered file into place: %w", err)
+	}
+
+	return nil
+}
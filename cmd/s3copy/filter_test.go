@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterFileInPlace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("filter command tests use POSIX shell utilities")
+	}
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	ctx := context.Background()
+
+	t.Run("transforms file content", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "data.txt")
+		err := os.WriteFile(filePath, []byte("hello filter"), 0644)
+		require.NoError(t, err)
+
+		filterCmd = "tr 'a-z' 'A-Z'"
+		err = filterFileInPlace(ctx, filePath)
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(filePath)
+		require.NoError(t, err)
+		assert.Equal(t, "HELLO FILTER", string(content))
+	})
+
+	t.Run("cat leaves content unchanged", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "data.txt")
+		err := os.WriteFile(filePath, []byte("unchanged"), 0644)
+		require.NoError(t, err)
+
+		filterCmd = "cat"
+		err = filterFileInPlace(ctx, filePath)
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(filePath)
+		require.NoError(t, err)
+		assert.Equal(t, "unchanged", string(content))
+	})
+
+	t.Run("failing command keeps original and cleans up", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := filepath.Join(dir, "data.txt")
+		err := os.WriteFile(filePath, []byte("original"), 0644)
+		require.NoError(t, err)
+
+		filterCmd = "echo boom >&2; exit 3"
+		err = filterFileInPlace(ctx, filePath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "filter command failed")
+		assert.Contains(t, err.Error(), "boom")
+
+		content, err := os.ReadFile(filePath)
+		require.NoError(t, err)
+		assert.Equal(t, "original", string(content))
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// originalPathMetadataKey is the user metadata entry a --flatten upload
+// stores the relative path of a file in, and --unflatten restores it from
+const originalPathMetadataKey = "original-path"
+
+// flattenedFiles remembers the files of a --flatten upload: the relative path
+// of every local file for its metadata, and which file took which key, so two
+// files with the same name do not silently replace each other
+type flattenedFiles struct {
+	mutex sync.Mutex
+	paths map[string]string
+	keys  map[string]string
+}
+
+var flattened = newFlattenedFiles()
+
+func newFlattenedFiles() *flattenedFiles {
+	return &flattenedFiles{paths: map[string]string{}, keys: map[string]string{}}
+}
+
+// flattenRelPath returns the part of a relative path that is appended to the
+// destination prefix: the file name with --flatten, the whole path otherwise
+func flattenRelPath(relPath string) string {
+	if !flatten {
+		return relPath
+	}
+	return filepath.Base(relPath)
+}
+
+// record registers the final targets of a flattened file. It fails when
+// another file of the run was already flattened to one of the keys.
+func (f *flattenedFiles) record(filePath, relPath string, targets []uploadTarget) error {
+	if !flatten {
+		return nil
+	}
+	relKey := filepath.ToSlash(relPath)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, target := range targets {
+		if other, taken := f.keys[target.String()]; taken && other != relKey {
+			return fmt.Errorf("flatten maps %s and %s to the same key %s", other, relKey, target)
+		}
+	}
+	for _, target := range targets {
+		f.keys[target.String()] = relKey
+	}
+	f.paths[filePath] = relKey
+	return nil
+}
+
+// withOriginalPath adds the relative path of a flattened file to the upload
+// metadata
+func withOriginalPath(metadata map[string]string, filePath string) map[string]string {
+	flattened.mutex.Lock()
+	relPath, ok := flattened.paths[filePath]
+	flattened.mutex.Unlock()
+	if !ok {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[originalPathMetadataKey] = relPath
+	return metadata
+}
+
+// applyOriginalPath returns the local path below the destination that the
+// original-path metadata of an object names. Objects without the entry, or
+// with a path that would leave the destination, keep their key-derived path.
+func applyOriginalPath(metadata map[string]string, key, localPath string) string {
+	original, ok := metadata[originalPathMetadataKey]
+	if !ok || original == "" {
+		return localPath
+	}
+	relPath := filepath.FromSlash(path.Clean(original))
+	if !filepath.IsLocal(relPath) {
+		fmt.Fprintf(os.Stderr, "Warning: %s: unsafe path %q in metadata %s, using the key name\n", key, original, originalPathMetadataKey)
+		return localPath
+	}
+	return filepath.Join(destination, relPath)
+}
+
+// unflattenedLocalPath reads the metadata of an object and returns the local
+// path --unflatten restores it to
+func unflattenedLocalPath(ctx context.Context, s3Client *s3.Client, bucketName, key, localPath string) (string, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get object metadata: %w", err)
+	}
+	return applyOriginalPath(head.Metadata, key, localPath), nil
+}
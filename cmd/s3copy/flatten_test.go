@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlattenUnflatten(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	configure := func(src, dst string) {
+		setTestConfig(src, dst, "", false, true, true, false)
+	}
+
+	files := map[string]string{
+		"a/x.txt":         "x",
+		"a/b/y.txt":       "y",
+		"c/d/e/z.txt":     "z",
+		"top.txt":         "top",
+		"c/d/e/notes.csv": "1,2,3",
+	}
+	sourceDir := t.TempDir()
+	for relPath, content := range files {
+		localPath := filepath.Join(sourceDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+
+	configure(sourceDir, "s3://flat/out/")
+	flatten = true
+	require.NoError(t, uploadToS3(context.Background()))
+
+	assert.Len(t, fake.contents(), len(files))
+	for relPath, content := range files {
+		object, ok := fake.object("flat/out/" + filepath.Base(relPath))
+		require.True(t, ok, relPath)
+		assert.Equal(t, content, object.content, relPath)
+		assert.Equal(t, relPath, object.metadata("Original-Path"), relPath)
+	}
+
+	restoreDir := t.TempDir()
+	configure("s3://flat/out/", restoreDir)
+	unflatten = true
+	require.NoError(t, downloadFromS3(context.Background()))
+
+	restored := map[string]string{}
+	require.NoError(t, filepath.Walk(restoreDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(restoreDir, path)
+		require.NoError(t, err)
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		restored[filepath.ToSlash(relPath)] = string(content)
+		return nil
+	}))
+	assert.Equal(t, files, restored)
+
+	t.Run("fails when two files share a name", func(t *testing.T) {
+		collidingDir := t.TempDir()
+		for _, relPath := range []string{"one/same.txt", "two/same.txt"} {
+			localPath := filepath.Join(collidingDir, filepath.FromSlash(relPath))
+			require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+			require.NoError(t, os.WriteFile(localPath, []byte(relPath), 0644))
+		}
+
+		configure(collidingDir, "s3://flat/collide/")
+		flatten = true
+		err := uploadToS3(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "same key")
+	})
+}
+
+func TestApplyOriginalPath(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	destination = "restore"
+
+	fallback := filepath.Join("restore", "x.txt")
+	assert.Equal(t, filepath.Join("restore", "a", "b", "x.txt"), applyOriginalPath(map[string]string{originalPathMetadataKey: "a/b/x.txt"}, "out/x.txt", fallback))
+	assert.Equal(t, fallback, applyOriginalPath(nil, "out/x.txt", fallback))
+	assert.Equal(t, fallback, applyOriginalPath(map[string]string{originalPathMetadataKey: "../escape.txt"}, "out/x.txt", fallback))
+	assert.Equal(t, fallback, applyOriginalPath(map[string]string{originalPathMetadataKey: "/etc/passwd"}, "out/x.txt", fallback))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// freeDiskSpace returns the bytes available to unprivileged users on the
+// volume that holds dir. Tests replace it.
+var freeDiskSpace = availableDiskSpace
+
+// existingDir returns dir or its nearest ancestor that exists, so the free
+// space of a destination can be checked before it is created
+func existingDir(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// ensureFreeSpace fails when the volume of dir has less room than required
+// plus the --min-free margin
+func ensureFreeSpace(dir string, required int64) error {
+	volume := existingDir(dir)
+	available, err := freeDiskSpace(volume)
+	if err != nil {
+		return fmt.Errorf("failed to check free space in %s: %w", volume, err)
+	}
+	needed := uint64(required) + uint64(minFreeBytes)
+	if available < needed {
+		return fmt.Errorf("not enough free space in %s: %s needed (%s of objects plus %s --min-free), %s available",
+			volume, formatBytes(int64(needed)), formatBytes(required), formatBytes(minFreeBytes), formatBytes(int64(available)))
+	}
+	logVerbose("Free space in %s: %s available, %s needed\n", volume, formatBytes(int64(available)), formatBytes(int64(needed)))
+	return nil
+}
+
+// listedDownloadSize sums the sizes of the objects below prefix, the bytes a
+// directory download writes at most
+func listedDownloadSize(ctx context.Context, s3Client *s3.Client, bucketName, prefix string) (int64, error) {
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	var total int64
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			total += aws.ToInt64(obj.Size)
+		}
+	}
+	return total, nil
+}
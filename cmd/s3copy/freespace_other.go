@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+func availableDiskSpace(string) (uint64, error) {
+	return 0, errors.New("free space cannot be checked on this platform")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExistingDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, dir, existingDir(dir))
+	assert.Equal(t, dir, existingDir(filepath.Join(dir, "missing", "deeper")))
+}
+
+func TestAvailableDiskSpace(t *testing.T) {
+	available, err := availableDiskSpace(t.TempDir())
+	require.NoError(t, err)
+	assert.Positive(t, available)
+}
+
+func TestCheckFreeSpaceDownload(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	previous := freeDiskSpace
+	defer func() { freeDiskSpace = previous }()
+
+	useFakeS3(t, newFakeS3(map[string]string{
+		"space-bucket/data/a.txt":     strings.Repeat("a", 600),
+		"space-bucket/data/sub/b.txt": strings.Repeat("b", 400),
+	}))
+
+	var checkedDir string
+	download := func(t *testing.T, available uint64, margin string) (string, error) {
+		freeDiskSpace = func(dir string) (uint64, error) {
+			checkedDir = dir
+			return available, nil
+		}
+		dest := filepath.Join(t.TempDir(), "restore")
+		setTestConfig("s3://space-bucket/data/", dest, "", false, true, true, false)
+		checkFreeSpace = true
+		if margin != "" {
+			var err error
+			minFreeBytes, err = parseByteSize(margin)
+			require.NoError(t, err)
+		}
+		return dest, downloadFromS3(context.Background())
+	}
+
+	t.Run("enough space", func(t *testing.T) {
+		dest, err := download(t, 1000, "")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Dir(dest), checkedDir)
+		assert.FileExists(t, filepath.Join(dest, "sub", "b.txt"))
+	})
+
+	t.Run("not enough space", func(t *testing.T) {
+		dest, err := download(t, 999, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not enough free space")
+		assert.Contains(t, err.Error(), "1000 B of objects")
+		assert.NoDirExists(t, dest)
+	})
+
+	t.Run("margin", func(t *testing.T) {
+		_, err := download(t, 1500, "1k")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1.0 KB --min-free")
+
+		_, err = download(t, 2024, "1k")
+		require.NoError(t, err)
+	})
+
+	t.Run("single object", func(t *testing.T) {
+		freeDiskSpace = func(string) (uint64, error) { return 500, nil }
+		setTestConfig("s3://space-bucket/data/a.txt", t.TempDir()+string(os.PathSeparator), "", false, false, true, false)
+		checkFreeSpace = true
+		err := downloadFromS3(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "600 B of objects")
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxHTTPSourceRedirects limits the redirects followed for an HTTP source
+const maxHTTPSourceRedirects = 10
+
+// httpSourceClient fetches HTTP sources. It follows redirects, but never from
+// https to plain http.
+var httpSourceClient = &http.Client{
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= maxHTTPSourceRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxHTTPSourceRedirects)
+		}
+		if via[0].URL.Scheme == "https" && request.URL.Scheme != "https" {
+			return fmt.Errorf("refusing redirect from https to %s", request.URL.Redacted())
+		}
+		logVerbose("Following redirect to %s\n", request.URL.Redacted())
+		return nil
+	},
+}
+
+// isHTTPSource reports whether a source is an http:// or https:// URL
+func isHTTPSource(value string) bool {
+	lower := strings.ToLower(value)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// httpSourceName returns the name an HTTP source is uploaded as when the
+// destination is a bucket or a prefix: the last element of the URL path
+func httpSourceName(sourceURL *url.URL) (string, error) {
+	name := path.Base(sourceURL.Path)
+	if name == "." || name == "/" {
+		return "", fmt.Errorf("source URL %s has no file name, use a destination with a full key", sourceURL.Redacted())
+	}
+	return name, nil
+}
+
+// uploadFromURL fetches an HTTP(S) source and streams the response body into
+// the upload, through the encryption pipe with --encrypt, without staging it
+// on disk. Any final status other than 200 is an error. The output and the
+// error report only show the URL without its password.
+func uploadFromURL(ctx context.Context, uploader *uploadManager) error {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		// the *url.Error quotes the URL, password included
+		return fmt.Errorf("invalid source URL: %w", errors.Unwrap(err))
+	}
+	name, err := httpSourceName(sourceURL)
+	if err != nil {
+		return err
+	}
+	targets, err := resolveUploadTargets(false, name)
+	if err != nil {
+		return err
+	}
+	target := targets[0]
+
+	logInfo("Uploading %s to %s\n", sourceURL.Redacted(), target)
+	if dryRun {
+		return nil
+	}
+
+	defer report.track(phaseTransfer)()
+	report.queueFile()
+	defer report.completeFile()
+
+	err = uploadHTTPBody(ctx, uploader, sourceURL, target, name)
+	recordFailure(operationUpload, sourceURL.Redacted(), target.String(), err)
+	return err
+}
+
+func uploadHTTPBody(ctx context.Context, uploader *uploadManager, sourceURL *url.URL, target uploadTarget, name string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("invalid source URL: %w", errors.Unwrap(err))
+	}
+	response, err := httpSourceClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to fetch source: %w", err)
+	}
+	defer closeWithLog(response.Body, "response body")
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: %s", response.Request.URL.Redacted(), response.Status)
+	}
+
+	return uploadStream(ctx, uploader, target, name, response.Body, response.ContentLength, response.Header.Get("Content-Type"))
+}
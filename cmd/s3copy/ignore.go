@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"iter"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,14 +25,26 @@ func initializeIgnoreMatcher() error {
 		}
 	}
 
-	if ignoreFile != "" {
-		filePatterns, err := readIgnoreFile(ignoreFile)
+	// Files are read in the given order so a later file can re-include
+	// paths excluded by an earlier one with "!" patterns
+	for path := range strings.SplitSeq(ignoreFile, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		filePatterns, err := readIgnoreFile(path)
 		if err != nil {
-			return fmt.Errorf("failed to read ignore file %s: %v", ignoreFile, err)
+			return fmt.Errorf("failed to read ignore file %s: %v", path, err)
 		}
 		patterns = append(patterns, filePatterns...)
 	}
 
+	if ignoreCase {
+		for i, pattern := range patterns {
+			patterns[i] = strings.ToLower(pattern)
+		}
+	}
+
 	if len(patterns) > 0 {
 		ignoreMatcher = ignore.CompileIgnoreLines(patterns...)
 	}
@@ -46,32 +59,83 @@ func readIgnoreFile(filePath string) ([]string, error) {
 	}
 
 	var patterns []string
-	for line := range strings.SplitSeq(string(content), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
-			patterns = append(patterns, trimmed)
-		}
+	for _, line := range contentLines(string(content)) {
+		patterns = append(patterns, line)
 	}
 
 	return patterns, nil
 }
 
+// contentLines yields the trimmed lines of an ignore, map or manifest file
+// with their line numbers. Empty lines and lines starting with # are skipped.
+func contentLines(content string) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		lineNum := 0
+		for line := range strings.SplitSeq(content, "\n") {
+			lineNum++
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			if !yield(lineNum, trimmed) {
+				return
+			}
+		}
+	}
+}
+
+// shouldIgnoreFile reports whether a path produced by walking or globbing the
+// source is excluded. Paths inside the source are matched relative to it, for
+// relative and absolute sources alike, so anchored and "!" patterns behave the
+// same way regardless of how the source was given.
 func shouldIgnoreFile(filePath string) bool {
-	if ignoreMatcher == nil {
+	if ignoreMatcher == nil && !noHidden {
 		return false
 	}
 
-	var relativePath string
-	if filepath.IsAbs(filePath) {
-		if rel, err := filepath.Rel(source, filePath); err == nil && !strings.HasPrefix(rel, "..") {
-			relativePath = rel
-		} else {
-			relativePath = filepath.Base(filePath)
-		}
-	} else {
-		relativePath = filePath
+	rel, err := filepath.Rel(source, filePath)
+	switch {
+	case err == nil && rel != "." && !strings.HasPrefix(rel, ".."):
+		return shouldIgnoreRelPath(rel)
+	case rel == ".":
+		// the source itself is never hidden, even when it is a dot directory
+		return matchesIgnorePatterns(filepath.Base(filePath))
+	case filepath.IsAbs(filePath):
+		return shouldIgnoreRelPath(filepath.Base(filePath))
+	default:
+		return shouldIgnoreRelPath(filePath)
 	}
+}
+
+// shouldIgnoreRelPath matches a path that is already relative to the root
+// being copied, such as the relative paths computed by sync
+func shouldIgnoreRelPath(relPath string) bool {
+	if noHidden && isHiddenPath(relPath) {
+		return true
+	}
+	return matchesIgnorePatterns(relPath)
+}
 
-	normalizedPath := strings.ReplaceAll(relativePath, "\\", "/")
+// matchesIgnorePatterns matches a path against --ignore and --ignore-file
+func matchesIgnorePatterns(relPath string) bool {
+	if ignoreMatcher == nil {
+		return false
+	}
+
+	normalizedPath := strings.ReplaceAll(relPath, "\\", "/")
+	if ignoreCase {
+		normalizedPath = strings.ToLower(normalizedPath)
+	}
 	return ignoreMatcher.MatchesPath(normalizedPath)
 }
+
+// isHiddenPath reports whether a file or directory of a relative path starts
+// with a dot, for --no-hidden. "." and ".." are not hidden.
+func isHiddenPath(relPath string) bool {
+	for segment := range strings.SplitSeq(strings.ReplaceAll(relPath, "\\", "/"), "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." && segment != ".." {
+			return true
+		}
+	}
+	return false
+}
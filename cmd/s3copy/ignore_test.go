@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
 
 	ignore "github.com/sabhiram/go-gitignore"
@@ -53,6 +56,47 @@ func TestInitializeIgnoreMatcher(t *testing.T) {
 		assert.False(t, ignoreMatcher.MatchesPath("file.txt"))
 		assert.False(t, ignoreMatcher.MatchesPath("comment"))
 	})
+
+	t.Run("with multiple ignore files", func(t *testing.T) {
+		dir := t.TempDir()
+		globalFile := filepath.Join(dir, "global.ignore")
+		projectFile := filepath.Join(dir, "project.ignore")
+		require.NoError(t, os.WriteFile(globalFile, []byte("*.log\n*.tmp\n"), 0644))
+		require.NoError(t, os.WriteFile(projectFile, []byte("build/\n"), 0644))
+
+		ignorePatterns = ""
+		ignoreFile = globalFile + ", " + projectFile
+		require.NoError(t, initializeIgnoreMatcher())
+		require.NotNil(t, ignoreMatcher)
+
+		assert.True(t, ignoreMatcher.MatchesPath("server.log"))
+		assert.True(t, ignoreMatcher.MatchesPath("cache.tmp"))
+		assert.True(t, ignoreMatcher.MatchesPath("build/output.bin"))
+		assert.False(t, ignoreMatcher.MatchesPath("main.go"))
+	})
+
+	t.Run("later ignore file negates earlier one", func(t *testing.T) {
+		dir := t.TempDir()
+		globalFile := filepath.Join(dir, "global.ignore")
+		projectFile := filepath.Join(dir, "project.ignore")
+		require.NoError(t, os.WriteFile(globalFile, []byte("*.log\n"), 0644))
+		require.NoError(t, os.WriteFile(projectFile, []byte("!audit.log\n"), 0644))
+
+		ignorePatterns = ""
+		ignoreFile = globalFile + "," + projectFile
+		require.NoError(t, initializeIgnoreMatcher())
+
+		assert.True(t, ignoreMatcher.MatchesPath("server.log"))
+		assert.False(t, ignoreMatcher.MatchesPath("audit.log"))
+	})
+
+	t.Run("missing file in list", func(t *testing.T) {
+		ignorePatterns = ""
+		ignoreFile = filepath.Join(t.TempDir(), "missing.ignore")
+		err := initializeIgnoreMatcher()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing.ignore")
+	})
 }
 
 func TestReadIgnoreFile(t *testing.T) {
@@ -115,3 +159,172 @@ func TestShouldIgnoreFile(t *testing.T) {
 		assert.False(t, shouldIgnoreFile("/other/file.txt"))
 	})
 }
+
+func TestShouldIgnoreFileIgnoreCase(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	source = "/tmp"
+	ignoreFile = ""
+	ignorePatterns = "*.JPG,Build/"
+
+	t.Run("case-sensitive by default", func(t *testing.T) {
+		ignoreCase = false
+		require.NoError(t, initializeIgnoreMatcher())
+
+		assert.True(t, shouldIgnoreFile("photo.JPG"))
+		assert.False(t, shouldIgnoreFile("photo.jpg"))
+		assert.False(t, shouldIgnoreFile("Photo.Jpg"))
+		assert.True(t, shouldIgnoreFile("Build/output.bin"))
+		assert.False(t, shouldIgnoreFile("build/output.bin"))
+	})
+
+	t.Run("case-insensitive with flag", func(t *testing.T) {
+		ignoreCase = true
+		require.NoError(t, initializeIgnoreMatcher())
+
+		assert.True(t, shouldIgnoreFile("photo.JPG"))
+		assert.True(t, shouldIgnoreFile("photo.jpg"))
+		assert.True(t, shouldIgnoreFile("/tmp/Photo.Jpg"))
+		assert.True(t, shouldIgnoreFile("build/output.bin"))
+		assert.True(t, shouldIgnoreFile("BUILD/output.bin"))
+		assert.False(t, shouldIgnoreFile("photo.png"))
+	})
+
+	t.Run("lowercase patterns match uppercase files", func(t *testing.T) {
+		ignoreCase = true
+		ignorePatterns = "*.log"
+		require.NoError(t, initializeIgnoreMatcher())
+
+		assert.True(t, shouldIgnoreFile("SERVER.LOG"))
+		assert.False(t, shouldIgnoreFile("SERVER.TXT"))
+	})
+}
+
+func TestShouldIgnoreFileNegation(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	ignoreFile = ""
+	ignoreCase = false
+
+	t.Run("absolute source", func(t *testing.T) {
+		ignorePatterns = "*.log,!important.log"
+		require.NoError(t, initializeIgnoreMatcher())
+		source = "/data/project"
+
+		assert.True(t, shouldIgnoreFile("/data/project/debug.log"))
+		assert.True(t, shouldIgnoreFile("/data/project/sub/debug.log"))
+		assert.False(t, shouldIgnoreFile("/data/project/important.log"))
+		assert.False(t, shouldIgnoreFile("/data/project/sub/important.log"))
+		assert.False(t, shouldIgnoreFile("/data/project/readme.txt"))
+	})
+
+	t.Run("relative source", func(t *testing.T) {
+		ignorePatterns = "*.log,!important.log"
+		require.NoError(t, initializeIgnoreMatcher())
+		source = "project"
+
+		assert.True(t, shouldIgnoreFile(filepath.Join("project", "debug.log")))
+		assert.False(t, shouldIgnoreFile(filepath.Join("project", "important.log")))
+	})
+
+	t.Run("anchored patterns with relative and absolute source", func(t *testing.T) {
+		ignorePatterns = "/logs/*,!/logs/keep.txt"
+		require.NoError(t, initializeIgnoreMatcher())
+
+		for _, root := range []string{"project", "/data/project"} {
+			source = root
+			assert.True(t, shouldIgnoreFile(filepath.Join(root, "logs", "a.txt")), root)
+			assert.False(t, shouldIgnoreFile(filepath.Join(root, "logs", "keep.txt")), root)
+			assert.False(t, shouldIgnoreFile(filepath.Join(root, "sub", "logs", "a.txt")), root)
+		}
+	})
+
+	t.Run("single file source", func(t *testing.T) {
+		ignorePatterns = "*.log,!important.log"
+		require.NoError(t, initializeIgnoreMatcher())
+
+		source = "/data/debug.log"
+		assert.True(t, shouldIgnoreFile("/data/debug.log"))
+
+		source = "/data/important.log"
+		assert.False(t, shouldIgnoreFile("/data/important.log"))
+	})
+
+	t.Run("relative paths from sync", func(t *testing.T) {
+		ignorePatterns = "*.log,!important.log"
+		require.NoError(t, initializeIgnoreMatcher())
+		source = "project"
+
+		assert.True(t, shouldIgnoreRelPath("project/debug.log"))
+		assert.False(t, shouldIgnoreRelPath("project/important.log"))
+	})
+}
+
+func TestNoHidden(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("hidden paths", func(t *testing.T) {
+		assert.True(t, isHiddenPath(".hidden"))
+		assert.True(t, isHiddenPath(".config/app.yml"))
+		assert.True(t, isHiddenPath("docs/.draft.md"))
+		assert.True(t, isHiddenPath(`docs\.git\HEAD`))
+		assert.False(t, isHiddenPath("docs/readme.md"))
+		assert.False(t, isHiddenPath("../data/file.txt"))
+		assert.False(t, isHiddenPath("./file.txt"))
+	})
+
+	root := filepath.Join(t.TempDir(), ".dotroot")
+	for _, file := range []string{"a.txt", ".hidden", filepath.Join(".config", "app.yml"), filepath.Join("docs", "readme.md"), filepath.Join("docs", ".draft.md")} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, file), []byte("data"), 0644))
+	}
+
+	t.Run("sync listing", func(t *testing.T) {
+		setTestConfig(root, "", "", false, true, false, false)
+		noHidden = true
+		require.NoError(t, initializeIgnoreMatcher())
+
+		files, err := listLocalFilesWithOptions(root, false)
+		require.NoError(t, err)
+		var relPaths []string
+		for _, file := range files {
+			relPaths = append(relPaths, file.RelPath)
+		}
+		assert.ElementsMatch(t, []string{"a.txt", "docs/readme.md"}, relPaths)
+	})
+
+	t.Run("upload of a dot directory source", func(t *testing.T) {
+		resetS3Client()
+		defer resetS3Client()
+		setTestConfig(root, "s3://bucket/backup/", "", false, true, false, false)
+		config = Config{AccessKey: "dummy", SecretKey: "dummy", Region: "us-east-1"}
+		dryRun = true
+		noHidden = true
+		require.NoError(t, initializeIgnoreMatcher())
+
+		var err error
+		output := captureStdout(func() {
+			err = uploadToS3(context.Background())
+		})
+		require.NoError(t, err)
+
+		var keys []string
+		for _, match := range regexp.MustCompile(`s3://bucket/(\S+)`).FindAllStringSubmatch(output, -1) {
+			keys = append(keys, match[1])
+		}
+		assert.ElementsMatch(t, []string{"backup/a.txt", "backup/docs/readme.md"}, keys)
+		assert.Contains(t, output, "Ignoring directory: "+filepath.Join(root, ".config"))
+	})
+
+	t.Run("without the flag hidden files are kept", func(t *testing.T) {
+		setTestConfig(root, "", "", false, true, false, false)
+		require.NoError(t, initializeIgnoreMatcher())
+
+		files, err := listLocalFilesWithOptions(root, false)
+		require.NoError(t, err)
+		assert.Len(t, files, 5)
+	})
+}
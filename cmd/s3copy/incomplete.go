@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// listIncompleteUploads lists the multipart uploads of the bucket that were
+// started but never completed or aborted, optionally filtered by --filter and
+// --incomplete-older-than
+func listIncompleteUploads(ctx context.Context, s3Client *s3.Client, now time.Time) ([]types.MultipartUpload, error) {
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	}
+	if filter != "" {
+		input.Prefix = aws.String(filter)
+	}
+
+	var uploads []types.MultipartUpload
+	paginator := s3.NewListMultipartUploadsPaginator(s3Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if incompleteMinAge > 0 && now.Sub(aws.ToTime(upload.Initiated)) < incompleteMinAge {
+				continue
+			}
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, nil
+}
+
+// handleIncompleteUploads prints the incomplete multipart uploads and, with
+// --abort-incomplete, aborts them so their parts stop using storage
+func handleIncompleteUploads(ctx context.Context) error {
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	uploads, err := listIncompleteUploads(ctx, s3Client, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Incomplete multipart uploads in bucket '%s'", bucket)
+	if filter != "" {
+		fmt.Printf(" with prefix '%s'", filter)
+	}
+	fmt.Println(":")
+	fmt.Println()
+
+	fmt.Printf("%-50s %-40s %-20s\n", "Key", "Upload ID", "Initiated")
+	fmt.Printf("%-50s %-40s %-20s\n", strings.Repeat("-", 50), strings.Repeat("-", 40), strings.Repeat("-", 20))
+	for _, upload := range uploads {
+		fmt.Printf("%-50s %-40s %-20s\n",
+			truncateString(aws.ToString(upload.Key), 50),
+			truncateString(aws.ToString(upload.UploadId), 40),
+			aws.ToTime(upload.Initiated).Format("2006-01-02 15:04:05"))
+	}
+	fmt.Println()
+	fmt.Printf("Total: %d incomplete uploads\n", len(uploads))
+
+	if !abortIncomplete {
+		return nil
+	}
+
+	var failed int
+	for _, upload := range uploads {
+		if dryRun {
+			logInfo("Would abort %s (%s)\n", aws.ToString(upload.Key), aws.ToString(upload.UploadId))
+			continue
+		}
+
+		if _, err := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		}); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "Failed to abort %s (%s): %v\n", aws.ToString(upload.Key), aws.ToString(upload.UploadId), err)
+			continue
+		}
+		logInfo("Aborted %s (%s)\n", aws.ToString(upload.Key), aws.ToString(upload.UploadId))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to abort %d of %d incomplete uploads", failed, len(uploads))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncompleteUploads(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-incomplete-uploads-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("uploads/interrupted.bin"),
+	})
+	require.NoError(t, err)
+
+	setTestConfig("", "", bucketName, false, false, false, false)
+	listIncomplete = true
+
+	t.Run("lists incomplete upload", func(t *testing.T) {
+		var handleErr error
+		output := captureStdout(func() {
+			handleErr = handleIncompleteUploads(ctx)
+		})
+		require.NoError(t, handleErr)
+		assert.Contains(t, output, "uploads/interrupted.bin")
+		assert.Contains(t, output, aws.ToString(created.UploadId))
+		assert.Contains(t, output, "Total: 1 incomplete uploads")
+	})
+
+	t.Run("age filter hides recent uploads", func(t *testing.T) {
+		incompleteMinAge = 24 * time.Hour
+		defer func() { incompleteMinAge = 0 }()
+
+		uploads, err := listIncompleteUploads(ctx, s3Client, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, uploads)
+
+		uploads, err = listIncompleteUploads(ctx, s3Client, time.Now().Add(48*time.Hour))
+		require.NoError(t, err)
+		assert.Len(t, uploads, 1)
+	})
+
+	t.Run("prefix filter", func(t *testing.T) {
+		filter = "other/"
+		defer func() { filter = "" }()
+
+		uploads, err := listIncompleteUploads(ctx, s3Client, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, uploads)
+	})
+
+	t.Run("aborts incomplete upload", func(t *testing.T) {
+		abortIncomplete = true
+
+		var handleErr error
+		output := captureStdout(func() {
+			handleErr = handleIncompleteUploads(ctx)
+		})
+		require.NoError(t, handleErr)
+		assert.Contains(t, output, "Aborted uploads/interrupted.bin")
+
+		uploads, err := listIncompleteUploads(ctx, s3Client, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, uploads)
+	})
+}
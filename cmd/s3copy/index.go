@@ -0,0 +1,252 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// encryptionIndexVersion is the format version written to new index files
+const encryptionIndexVersion = 1
+
+// kdfParameters describes how the encryption key of an object was derived
+type kdfParameters struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+	KeyLength uint32 `json:"keyLength"`
+}
+
+// currentKDFParameters returns the key derivation parameters of this version.
+// Objects encrypted to a recipient key use a random data key instead.
+func currentKDFParameters() kdfParameters {
+	if usesRecipientKeys() {
+		return kdfParameters{Algorithm: "x25519", KeyLength: dataKeySize}
+	}
+	return kdfParameters{
+		Algorithm: "argon2id",
+		Time:      argon2Time,
+		Memory:    argon2Memory,
+		Threads:   argon2Threads,
+		KeyLength: argon2KeyLength,
+	}
+}
+
+// encryptionIndexEntry records one encrypted object and the parameters needed
+// to restore it
+type encryptionIndexEntry struct {
+	Bucket        string        `json:"bucket"`
+	Key           string        `json:"key"`
+	Path          string        `json:"path"`
+	PlaintextSize int64         `json:"plaintextSize"`
+	ChunkSize     int           `json:"chunkSize"`
+	KDF           kdfParameters `json:"kdf"`
+	UploadedAt    time.Time     `json:"uploadedAt"`
+}
+
+// encryptionIndex is the JSON document written by --local-encryption-index
+type encryptionIndex struct {
+	Version int                    `json:"version"`
+	Entries []encryptionIndexEntry `json:"entries"`
+}
+
+// encryptionIndexRecorder collects the entries of the encrypted uploads of a run
+type encryptionIndexRecorder struct {
+	mutex   sync.Mutex
+	entries []encryptionIndexEntry
+}
+
+var indexRecorder = &encryptionIndexRecorder{}
+
+// record adds an encrypted upload when --local-encryption-index is set. The
+// path is relative to the upload source, or the file name for single files.
+func (r *encryptionIndexRecorder) record(bucketName, s3Key, filePath string) {
+	if localEncryptionIndex == "" {
+		return
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		logVerbose("Warning: Could not stat %s for encryption index: %v\n", filePath, err)
+		return
+	}
+
+	relPath, err := filepath.Rel(source, filePath)
+	if err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		relPath = filepath.Base(filePath)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = append(r.entries, encryptionIndexEntry{
+		Bucket:        bucketName,
+		Key:           s3Key,
+		Path:          filepath.ToSlash(relPath),
+		PlaintextSize: info.Size(),
+		ChunkSize:     DefaultEncryptionChunkSize,
+		KDF:           currentKDFParameters(),
+		UploadedAt:    time.Now().UTC().Truncate(time.Second),
+	})
+}
+
+// save merges the recorded entries into the index file. Entries for the same
+// bucket and key replace the existing ones.
+func (r *encryptionIndexRecorder) save(indexPath string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.entries) == 0 {
+		return nil
+	}
+
+	index, err := loadEncryptionIndex(indexPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	index.Entries = slices.DeleteFunc(index.Entries, func(existing encryptionIndexEntry) bool {
+		return slices.ContainsFunc(r.entries, func(entry encryptionIndexEntry) bool {
+			return entry.Bucket == existing.Bucket && entry.Key == existing.Key
+		})
+	})
+	index.Entries = append(index.Entries, r.entries...)
+	slices.SortFunc(index.Entries, func(a, b encryptionIndexEntry) int {
+		return cmp.Or(strings.Compare(a.Bucket, b.Bucket), strings.Compare(a.Key, b.Key))
+	})
+	index.Version = encryptionIndexVersion
+
+	if err := writeEncryptionIndex(indexPath, index); err != nil {
+		return err
+	}
+
+	logVerbose("Wrote %d entries to encryption index %s\n", len(r.entries), indexPath)
+	r.entries = nil
+	return nil
+}
+
+// loadEncryptionIndex reads an index file. A missing file returns an empty
+// index together with an error wrapping os.ErrNotExist.
+func loadEncryptionIndex(indexPath string) (encryptionIndex, error) {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return encryptionIndex{Version: encryptionIndexVersion}, err
+	}
+
+	var index encryptionIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return encryptionIndex{}, fmt.Errorf("invalid encryption index %s: %w", indexPath, err)
+	}
+	if index.Version > encryptionIndexVersion {
+		return encryptionIndex{}, fmt.Errorf("encryption index %s has unsupported version %d", indexPath, index.Version)
+	}
+	return index, nil
+}
+
+// writeEncryptionIndex writes the index to a temp file next to the target
+// and renames it, so an interrupted run never leaves a truncated index
+func writeEncryptionIndex(indexPath string, index encryptionIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode encryption index: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(indexPath), ".s3copy-index-*")
+	if err != nil {
+		return fmt.Errorf("failed to create encryption index: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer removeTempFile(tempPath)
+
+	if _, err := tempFile.Write(append(data, '\n')); err != nil {
+		closeWithLog(tempFile, tempPath)
+		return fmt.Errorf("failed to write encryption index: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write encryption index: %w", err)
+	}
+
+	if err := os.Rename(tempPath, indexPath); err != nil {
+		return fmt.Errorf("failed to write encryption index: %w", err)
+	}
+	return nil
+}
+
+// indexedSizeKey holds the plaintext size the encryption index recorded for
+// the object a restore worker downloads
+type indexedSizeKey struct{}
+
+// withIndexedSize makes the download of an object decrypt against the size
+// recorded in the index instead of the size in the object metadata
+func withIndexedSize(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, indexedSizeKey{}, size)
+}
+
+// plaintextSize returns the size a decrypted object must have: the size from
+// the encryption index during a restore, otherwise the size in the object
+// metadata, or -1 when neither is known
+func plaintextSize(ctx context.Context, metadata map[string]string) int64 {
+	if size, ok := ctx.Value(indexedSizeKey{}).(int64); ok {
+		return size
+	}
+	return expectedPlaintextSize(metadata)
+}
+
+// restoreFromEncryptionIndex downloads and decrypts every object listed in
+// the index into the destination directory, without listing the bucket
+func restoreFromEncryptionIndex(ctx context.Context) error {
+	index, err := loadEncryptionIndex(localEncryptionIndex)
+	if err != nil {
+		return fmt.Errorf("failed to read encryption index: %w", err)
+	}
+	if len(index.Entries) == 0 {
+		return fmt.Errorf("encryption index %s has no entries", localEncryptionIndex)
+	}
+
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+	downloader := getDownloader(s3Client)
+
+	return runWorkerPool(ctx, index.Entries, maxWorkers, func(workerCtx context.Context, entry encryptionIndexEntry) error {
+		if entry.KDF != currentKDFParameters() || entry.ChunkSize != DefaultEncryptionChunkSize {
+			return fmt.Errorf("s3://%s/%s was encrypted with unsupported parameters", entry.Bucket, entry.Key)
+		}
+
+		relPath := filepath.Clean(filepath.FromSlash(entry.Path))
+		if relPath == "." || filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %q in encryption index", entry.Path)
+		}
+		localPath := filepath.Join(destination, relPath)
+
+		logInfo("Restoring s3://%s/%s to %s\n", entry.Bucket, entry.Key, localPath)
+		if dryRun {
+			return nil
+		}
+
+		if err := makeDownloadDir(filepath.Dir(localPath)); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := downloadFileWithParams(withIndexedSize(workerCtx, entry.PlaintextSize), downloader, entry.Bucket, entry.Key, localPath, false); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", entry.Key, err)
+		}
+
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat restored file %s: %w", localPath, err)
+		}
+		if filterCmd == "" && info.Size() != entry.PlaintextSize {
+			return fmt.Errorf("restored %s has size %d, index records %d", localPath, info.Size(), entry.PlaintextSize)
+		}
+		return nil
+	})
+}
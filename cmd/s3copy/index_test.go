@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptionIndexRoundTrip(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	sourceDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("hello"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "sub", "b.txt"), []byte("hello world"), 0644))
+
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	source = sourceDir
+	localEncryptionIndex = indexPath
+
+	t.Run("missing index", func(t *testing.T) {
+		_, err := loadEncryptionIndex(indexPath)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	})
+
+	t.Run("write and read", func(t *testing.T) {
+		recorder := &encryptionIndexRecorder{}
+		recorder.record("bucket", "backup/sub/b.txt", filepath.Join(sourceDir, "sub", "b.txt"))
+		recorder.record("bucket", "backup/a.txt", filepath.Join(sourceDir, "a.txt"))
+		require.NoError(t, recorder.save(indexPath))
+
+		index, err := loadEncryptionIndex(indexPath)
+		require.NoError(t, err)
+		assert.Equal(t, encryptionIndexVersion, index.Version)
+		require.Len(t, index.Entries, 2)
+
+		entry := index.Entries[1]
+		assert.Equal(t, "bucket", entry.Bucket)
+		assert.Equal(t, "backup/sub/b.txt", entry.Key)
+		assert.Equal(t, "sub/b.txt", entry.Path)
+		assert.Equal(t, int64(11), entry.PlaintextSize)
+		assert.Equal(t, DefaultEncryptionChunkSize, entry.ChunkSize)
+		assert.Equal(t, currentKDFParameters(), entry.KDF)
+		assert.False(t, entry.UploadedAt.IsZero())
+		assert.Equal(t, "a.txt", index.Entries[0].Path)
+	})
+
+	t.Run("merge replaces existing keys", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("hello again"), 0644))
+
+		recorder := &encryptionIndexRecorder{}
+		recorder.record("bucket", "backup/a.txt", filepath.Join(sourceDir, "a.txt"))
+		recorder.record("other", "c.txt", filepath.Join(sourceDir, "a.txt"))
+		require.NoError(t, recorder.save(indexPath))
+
+		index, err := loadEncryptionIndex(indexPath)
+		require.NoError(t, err)
+		require.Len(t, index.Entries, 3)
+		assert.Equal(t, "backup/a.txt", index.Entries[0].Key)
+		assert.Equal(t, int64(11), index.Entries[0].PlaintextSize)
+		assert.Equal(t, "other", index.Entries[2].Bucket)
+	})
+
+	t.Run("single file uses base name", func(t *testing.T) {
+		source = filepath.Join(sourceDir, "sub", "b.txt")
+		recorder := &encryptionIndexRecorder{}
+		recorder.record("bucket", "b.txt", source)
+		assert.Equal(t, "b.txt", recorder.entries[0].Path)
+	})
+
+	t.Run("nothing recorded without flag", func(t *testing.T) {
+		localEncryptionIndex = ""
+		recorder := &encryptionIndexRecorder{}
+		recorder.record("bucket", "a.txt", filepath.Join(sourceDir, "a.txt"))
+		assert.Empty(t, recorder.entries)
+	})
+
+	t.Run("invalid index", func(t *testing.T) {
+		invalidPath := filepath.Join(t.TempDir(), "invalid.json")
+		require.NoError(t, os.WriteFile(invalidPath, []byte("{"), 0644))
+		_, err := loadEncryptionIndex(invalidPath)
+		assert.ErrorContains(t, err, "invalid encryption index")
+
+		require.NoError(t, os.WriteFile(invalidPath, []byte(`{"version": 99}`), 0644))
+		_, err = loadEncryptionIndex(invalidPath)
+		assert.ErrorContains(t, err, "unsupported version")
+	})
+}
+
+func TestRestoreFromEncryptionIndex(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-restore-index-bucket"
+
+	_, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	sourceDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "docs"), 0755))
+	files := map[string]string{
+		"notes.txt":       "plaintext notes",
+		"docs/report.txt": "quarterly report",
+	}
+	for relPath, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, filepath.FromSlash(relPath)), []byte(content), 0644))
+	}
+
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+
+	setTestConfig(sourceDir, fmt.Sprintf("s3://%s/backup/", bucketName), "", true, true, true, false)
+	password = "index-password"
+	localEncryptionIndex = indexPath
+
+	require.NoError(t, uploadToS3(ctx))
+	require.NoError(t, indexRecorder.save(indexPath))
+
+	restoreDir := t.TempDir()
+	setTestConfig("", restoreDir, "", true, false, true, false)
+	password = "index-password"
+	localEncryptionIndex = indexPath
+	restoreFromIndex = true
+
+	require.NoError(t, restoreFromEncryptionIndex(ctx))
+
+	for relPath, content := range files {
+		restored, err := os.ReadFile(filepath.Join(restoreDir, filepath.FromSlash(relPath)))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(restored))
+	}
+}
+
+func TestRestoreFromIndexUsesIndexData(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	sourceDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "notes.txt"), []byte("hello"), 0644))
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	setTestConfig(sourceDir, "s3://vault/backup/", "", true, true, true, false)
+	password = "index-password"
+	localEncryptionIndex = indexPath
+	require.NoError(t, uploadToS3(context.Background()))
+	require.NoError(t, indexRecorder.save(indexPath))
+
+	restoreObjects := func() (string, error) {
+		restoreDir := t.TempDir()
+		setTestConfig("", restoreDir, "", true, false, true, false)
+		password = "index-password"
+		localEncryptionIndex = indexPath
+		restoreFromIndex = true
+		fake.resetRequests()
+		return restoreDir, restoreFromEncryptionIndex(context.Background())
+	}
+
+	restoreDir, err := restoreObjects()
+	require.NoError(t, err)
+	restored, err := os.ReadFile(filepath.Join(restoreDir, "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(restored))
+	assert.Equal(t, []string{"GetObject"}, fake.operations(), "a restore reads nothing but the objects")
+
+	// an object copied without its metadata and cut after its header still
+	// decrypts; only the index knows that it must not be moved into place
+	encrypted := fake.content("vault/backup/notes.txt")
+	fake.put("vault/backup/notes.txt", encrypted[:encryptedObjectSize(0)])
+	restoreDir, err = restoreObjects()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "decrypted 0 bytes, the upload recorded 5")
+	assert.NoFileExists(t, filepath.Join(restoreDir, "notes.txt"))
+}
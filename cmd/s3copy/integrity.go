@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// hmacMetadataKey is the metadata entry (x-amz-meta-hmac) holding the HMAC of
+// an encrypted object
+const hmacMetadataKey = "hmac"
+
+// hmacKey derives the HMAC key from the encryption key, so the AEAD key
+// itself is never used for a second purpose
+func hmacKey(encryptionKey []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, encryptionKey, nil, "s3copy ciphertext hmac", 32)
+}
+
+// prepareEncryption creates the encryption header for an upload. With --hmac
+// the file is encrypted once up front to compute the HMAC-SHA256 of the
+// ciphertext and rewound, so the upload encrypts it again with the same header
+// and produces exactly the bytes the HMAC covers.
+func prepareEncryption(file io.ReadSeeker, secret string) (*encryptionHeader, string, error) {
+	header, err := newEncryptionHeader(secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !storeHMAC {
+		return header, "", nil
+	}
+
+	key, err := hmacKey(header.key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to derive HMAC key: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	if err := encryptWithHeader(mac, file, header, cryptoWorkers()); err != nil {
+		return nil, "", fmt.Errorf("failed to compute HMAC: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("failed to rewind file after computing HMAC: %w", err)
+	}
+
+	return header, hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// checkCiphertextHMAC streams an encrypted object and compares the HMAC of its
+// bytes with the expected value. Only the key is recovered from the header;
+// the chunks are not decrypted.
+func checkCiphertextHMAC(reader io.Reader, expected, secret string) (bool, error) {
+	header, err := readEncryptionHeader(reader, secret)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := hmacKey(header.key)
+	if err != nil {
+		return false, fmt.Errorf("failed to derive HMAC key: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	if err := header.write(mac); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(mac, reader); err != nil {
+		return false, fmt.Errorf("failed to read object: %v", err)
+	}
+
+	expectedMAC, err := hex.DecodeString(expected)
+	if err != nil {
+		return false, fmt.Errorf("invalid HMAC metadata: %v", err)
+	}
+
+	return hmac.Equal(mac.Sum(nil), expectedMAC), nil
+}
+
+// verifyEncryptedObjects checks the stored HMAC of the source object, or of
+// every object under the source prefix, without decrypting them
+func verifyEncryptedObjects(ctx context.Context) error {
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	verifyBucket, prefix, err := splitS3URI(source)
+	if err != nil {
+		return err
+	}
+
+	var mutex sync.Mutex
+	var checked, failed int
+
+	err = runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, key string) error {
+		ok, err := verifyObjectHMAC(workerCtx, s3Client, verifyBucket, key)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		checked++
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", key, err)
+		case !ok:
+			failed++
+			fmt.Fprintf(os.Stderr, "FAILED %s: HMAC mismatch\n", key)
+		default:
+			logVerbose("OK %s\n", key)
+		}
+		return nil
+	}, func(producerCtx context.Context, keyChan chan<- string) error {
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(verifyBucket),
+			Prefix: aws.String(prefix),
+		})
+
+		for paginator.HasMorePages() {
+			page, pageErr := paginator.NextPage(producerCtx)
+			if pageErr != nil {
+				return fmt.Errorf("failed to list objects: %w", pageErr)
+			}
+
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				if !recursive && key != prefix {
+					continue
+				}
+
+				select {
+				case <-producerCtx.Done():
+					return producerCtx.Err()
+				case keyChan <- key:
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("no objects found at s3://%s/%s", verifyBucket, prefix)
+	}
+
+	logSummary("Verified %d objects, %d failed\n", checked, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d objects failed HMAC verification", failed, checked)
+	}
+	return nil
+}
+
+// verifyObjectHMAC streams a single object and checks it against its hmac metadata
+func verifyObjectHMAC(ctx context.Context, s3Client *s3.Client, bucketName, key string) (bool, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, err
+	}
+	defer closeWithLog(result.Body, key)
+
+	expected := strings.TrimSpace(result.Metadata[hmacMetadataKey])
+	if expected == "" {
+		return false, fmt.Errorf("object has no %s metadata", hmacMetadataKey)
+	}
+
+	secret, err := objectPassword(key, result.Metadata)
+	if err != nil {
+		return false, err
+	}
+	return checkCiphertextHMAC(result.Body, expected, secret)
+}
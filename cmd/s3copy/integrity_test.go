@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCiphertextHMAC(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	password = "hmacpassword"
+	storeHMAC = true
+
+	plaintext := make([]byte, 2*DefaultEncryptionChunkSize+321)
+	_, err := rand.Read(plaintext)
+	require.NoError(t, err)
+
+	file := bytes.NewReader(plaintext)
+	header, objectHMAC, err := prepareEncryption(file, password)
+	require.NoError(t, err)
+	require.NotEmpty(t, objectHMAC)
+
+	encrypted := &bytes.Buffer{}
+	require.NoError(t, encryptWithHeader(encrypted, file, header, cryptoWorkers()))
+
+	t.Run("matches the uploaded ciphertext", func(t *testing.T) {
+		ok, err := checkCiphertextHMAC(bytes.NewReader(encrypted.Bytes()), objectHMAC, password)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("detects a single-byte corruption", func(t *testing.T) {
+		for _, offset := range []int{0, 40, encrypted.Len() / 2, encrypted.Len() - 1} {
+			corrupted := bytes.Clone(encrypted.Bytes())
+			corrupted[offset] ^= 0x01
+
+			ok, err := checkCiphertextHMAC(bytes.NewReader(corrupted), objectHMAC, password)
+			require.NoError(t, err)
+			assert.False(t, ok, "corruption at offset %d not detected", offset)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		password = "otherpassword"
+		defer func() { password = "hmacpassword" }()
+
+		ok, err := checkCiphertextHMAC(bytes.NewReader(encrypted.Bytes()), objectHMAC, password)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		_, err := checkCiphertextHMAC(bytes.NewReader(encrypted.Bytes()), "not-hex", password)
+		assert.Error(t, err)
+	})
+
+	t.Run("no HMAC without flag", func(t *testing.T) {
+		storeHMAC = false
+		defer func() { storeHMAC = true }()
+
+		_, objectHMAC, err := prepareEncryption(bytes.NewReader(plaintext), password)
+		require.NoError(t, err)
+		assert.Empty(t, objectHMAC)
+	})
+}
+
+func TestVerifyEncryptedObjects(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-hmac-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "archive.bin")
+	require.NoError(t, os.WriteFile(testFile, []byte("archive content to protect"), 0644))
+
+	setTestConfig(testFile, fmt.Sprintf("s3://%s/archives/archive.bin", bucketName), bucketName, true, false, true, false)
+	password = "hmacpassword"
+	storeHMAC = true
+	require.NoError(t, uploadToS3(ctx))
+
+	setTestConfig(fmt.Sprintf("s3://%s/archives/", bucketName), "", bucketName, true, true, true, false)
+	password = "hmacpassword"
+	verifyEncryption = true
+	require.NoError(t, verifyEncryptedObjects(ctx))
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("archives/archive.bin"),
+	})
+	require.NoError(t, err)
+	content, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	closeWithLog(result.Body, "archive.bin")
+
+	content[len(content)-1] ^= 0x01
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String("archives/archive.bin"),
+		Body:     bytes.NewReader(content),
+		Metadata: result.Metadata,
+	})
+	require.NoError(t, err)
+
+	err = verifyEncryptedObjects(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 1 objects failed HMAC verification")
+}
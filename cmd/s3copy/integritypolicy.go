@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The --integrity-policy modes decide which evidence makes a local file and
+// an object count as identical
+const (
+	// integrityETagFirst trusts an ETag equal to the MD5, then the local-md5
+	// metadata
+	integrityETagFirst = "etag-first"
+	// integrityMetadataFirst trusts the local-md5 metadata, and the ETag only
+	// for objects without it
+	integrityMetadataFirst = "metadata-first"
+	// integrityRecomputeAlways trusts no stored value and hashes the content
+	// of the object
+	integrityRecomputeAlways = "recompute-always"
+	// integritySizeOnly takes objects of the same size as identical
+	integritySizeOnly = "size-only"
+)
+
+var integrityPolicies = []string{integrityETagFirst, integrityMetadataFirst, integrityRecomputeAlways, integritySizeOnly}
+
+// validateIntegrityPolicy checks a --integrity-policy value
+func validateIntegrityPolicy(policy string) error {
+	if !slices.Contains(integrityPolicies, policy) {
+		return fmt.Errorf("invalid integrity-policy %q, use %s", policy, strings.Join(integrityPolicies, ", "))
+	}
+	return nil
+}
+
+// objectIntegrity is what is known about the content of an object. A size
+// of -1 is unknown, empty strings are missing values.
+type objectIntegrity struct {
+	size       int64
+	etag       string
+	storedMD5  string
+	contentMD5 string
+}
+
+// headIntegrity collects the integrity values of a HEAD response
+func headIntegrity(head *s3.HeadObjectOutput) objectIntegrity {
+	object := objectIntegrity{
+		size:      -1,
+		etag:      normalizeETag(aws.ToString(head.ETag)),
+		storedMD5: head.Metadata["local-md5"],
+	}
+	if head.ContentLength != nil {
+		object.size = *head.ContentLength
+	}
+	return object
+}
+
+// isSinglePartETag reports whether an ETag looks like the MD5 of the object.
+// The ETag of a multipart upload has a "-<parts>" suffix.
+func isSinglePartETag(etag string) bool {
+	return etag != "" && !strings.Contains(etag, "-")
+}
+
+// integrityMatch decides under policy whether a local file with the given
+// size and MD5 has the same content as an object. A localSize of -1 skips
+// the size comparison. The second result names the evidence for logging.
+func integrityMatch(policy string, localSize int64, localMD5 string, object objectIntegrity) (bool, string) {
+	if localSize >= 0 && object.size >= 0 && localSize != object.size {
+		return false, "size"
+	}
+
+	switch policy {
+	case integritySizeOnly:
+		return localSize >= 0 && object.size >= 0, "size"
+	case integrityRecomputeAlways:
+		if object.contentMD5 == "" {
+			return false, "no recomputed checksum"
+		}
+		return object.contentMD5 == localMD5, "recomputed checksum"
+	case integrityMetadataFirst:
+		if object.storedMD5 != "" {
+			return object.storedMD5 == localMD5, "checksum via metadata"
+		}
+		if isSinglePartETag(object.etag) {
+			return object.etag == localMD5, "checksum via ETag"
+		}
+		return false, "no usable checksum"
+	default:
+		if object.etag == localMD5 {
+			return true, "checksum via ETag"
+		}
+		if object.storedMD5 != "" {
+			return object.storedMD5 == localMD5, "checksum via metadata"
+		}
+		return false, "no usable checksum"
+	}
+}
+
+// objectContentMD5 streams an object and returns the MD5 of its content, for
+// --integrity-policy recompute-always
+func objectContentMD5(ctx context.Context, s3Client *s3.Client, bucketName, key string) (string, error) {
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+	defer closeWithLog(output.Body, key)
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, output.Body); err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// resolveObjectIntegrity adds the recomputed MD5 of the content for
+// recompute-always. Objects of another size are not read.
+func resolveObjectIntegrity(ctx context.Context, s3Client *s3.Client, bucketName, key string, localSize int64, object objectIntegrity) (objectIntegrity, error) {
+	if integrityPolicy != integrityRecomputeAlways || (localSize >= 0 && object.size >= 0 && localSize != object.size) {
+		return object, nil
+	}
+	contentMD5, err := objectContentMD5(ctx, s3Client, bucketName, key)
+	if err != nil {
+		return object, err
+	}
+	object.contentMD5 = contentMD5
+	return object, nil
+}
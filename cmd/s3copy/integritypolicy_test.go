@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func md5Hex(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestValidateIntegrityPolicy(t *testing.T) {
+	for _, policy := range integrityPolicies {
+		require.NoError(t, validateIntegrityPolicy(policy))
+	}
+	require.Error(t, validateIntegrityPolicy("trust-me"))
+}
+
+func TestIntegrityMatch(t *testing.T) {
+	const content = "integrity"
+	localMD5 := md5Hex(content)
+	otherMD5 := md5Hex("something else")
+	size := int64(len(content))
+
+	singlePart := objectIntegrity{size: size, etag: localMD5}
+	multipart := objectIntegrity{size: size, etag: "0123456789abcdef0123456789abcdef-3"}
+	multipartWithMetadata := objectIntegrity{size: size, etag: multipart.etag, storedMD5: localMD5}
+	// a single-part object whose metadata went stale after a server-side edit
+	staleMetadata := objectIntegrity{size: size, etag: localMD5, storedMD5: otherMD5}
+	changed := objectIntegrity{size: size, etag: otherMD5}
+	resized := objectIntegrity{size: size + 1, etag: otherMD5}
+	recomputed := objectIntegrity{size: size, etag: multipart.etag, contentMD5: localMD5}
+
+	tests := []struct {
+		policy   string
+		object   objectIntegrity
+		expected bool
+	}{
+		{integrityETagFirst, singlePart, true},
+		{integrityETagFirst, multipart, false},
+		{integrityETagFirst, multipartWithMetadata, true},
+		{integrityETagFirst, staleMetadata, true},
+		{integrityETagFirst, changed, false},
+		{integrityETagFirst, recomputed, false},
+
+		{integrityMetadataFirst, singlePart, true},
+		{integrityMetadataFirst, multipart, false},
+		{integrityMetadataFirst, multipartWithMetadata, true},
+		{integrityMetadataFirst, staleMetadata, false},
+		{integrityMetadataFirst, changed, false},
+
+		{integrityRecomputeAlways, singlePart, false},
+		{integrityRecomputeAlways, multipartWithMetadata, false},
+		{integrityRecomputeAlways, recomputed, true},
+		{integrityRecomputeAlways, objectIntegrity{size: size, contentMD5: otherMD5}, false},
+
+		{integritySizeOnly, singlePart, true},
+		{integritySizeOnly, multipart, true},
+		{integritySizeOnly, changed, true},
+		{integritySizeOnly, resized, false},
+		{integritySizeOnly, objectIntegrity{size: -1}, false},
+	}
+	for _, test := range tests {
+		same, evidence := integrityMatch(test.policy, size, localMD5, test.object)
+		assert.Equal(t, test.expected, same, "%s against %+v (%s)", test.policy, test.object, evidence)
+	}
+
+	for _, policy := range integrityPolicies {
+		same, evidence := integrityMatch(policy, size, localMD5, objectIntegrity{size: size + 1, etag: localMD5, storedMD5: localMD5, contentMD5: localMD5})
+		assert.False(t, same, policy)
+		assert.Equal(t, "size", evidence, policy)
+	}
+}
+
+func TestCompareFileChecksumsPolicy(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	const content = "local content"
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	localMD5 := md5Hex(content)
+
+	// serve replaces the object with one of the given ETag and local-md5
+	// metadata and forgets the requests of the previous object
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+	serve := func(content, etag, storedMD5 string) {
+		object := &fakeObject{content: content, etag: etag, header: http.Header{}}
+		if storedMD5 != "" {
+			object.header.Set("X-Amz-Meta-Local-Md5", storedMD5)
+		}
+		fake.store("bucket/file.txt", object)
+		fake.resetRequests()
+	}
+
+	compare := func(t *testing.T, policy string) bool {
+		setTestConfig("", "", "", false, false, true, false)
+		integrityPolicy = policy
+		s3Client, err := getS3Client(context.Background())
+		require.NoError(t, err)
+		same, err := compareFileChecksums(context.Background(), s3Client, "bucket", "file.txt", localPath, localMD5)
+		require.NoError(t, err)
+		return same
+	}
+
+	t.Run("multipart object with the same content", func(t *testing.T) {
+		serve(content, "0123456789abcdef0123456789abcdef-2", "")
+		assert.False(t, compare(t, integrityETagFirst))
+		assert.False(t, compare(t, integrityMetadataFirst))
+		assert.True(t, compare(t, integritySizeOnly))
+		assert.Zero(t, fake.count("GetObject"))
+		assert.True(t, compare(t, integrityRecomputeAlways))
+		assert.Equal(t, 1, fake.count("GetObject"))
+	})
+
+	t.Run("single-part object with stale metadata", func(t *testing.T) {
+		serve(content, localMD5, md5Hex("old"))
+		assert.True(t, compare(t, integrityETagFirst))
+		assert.False(t, compare(t, integrityMetadataFirst))
+		assert.True(t, compare(t, integrityRecomputeAlways))
+	})
+
+	t.Run("changed object of the same size", func(t *testing.T) {
+		changed := strings.Repeat("x", len(content))
+		serve(changed, md5Hex(changed), localMD5)
+		assert.True(t, compare(t, integrityMetadataFirst))
+		assert.True(t, compare(t, integritySizeOnly))
+		assert.False(t, compare(t, integrityRecomputeAlways))
+	})
+
+	t.Run("object of another size is not read", func(t *testing.T) {
+		serve(content+"!", "0123456789abcdef0123456789abcdef-2", "")
+		assert.False(t, compare(t, integrityRecomputeAlways))
+		assert.Zero(t, fake.count("GetObject"))
+	})
+}
+
+func TestVerifyDownloadPolicy(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	const content = "downloaded"
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
+	multipartETag := aws.String(`"0123456789abcdef0123456789abcdef-2"`)
+
+	verify := func(policy string, output *manager.DownloadObjectOutput) error {
+		integrityPolicy = policy
+		return verifyDownloadChecksum(filePath, "file.txt", output)
+	}
+
+	stale := &manager.DownloadObjectOutput{ETag: multipartETag, Metadata: map[string]string{"local-md5": md5Hex("other")}}
+	assert.NoError(t, verify(integrityETagFirst, stale))
+	assert.ErrorIs(t, verify(integrityMetadataFirst, stale), errChecksumMismatch)
+	assert.ErrorIs(t, verify(integrityRecomputeAlways, stale), errChecksumMismatch)
+
+	matching := &manager.DownloadObjectOutput{ETag: multipartETag, Metadata: map[string]string{"local-md5": md5Hex(content)}}
+	assert.NoError(t, verify(integrityMetadataFirst, matching))
+	assert.NoError(t, verify(integrityRecomputeAlways, matching))
+
+	singlePart := &manager.DownloadObjectOutput{ETag: aws.String(`"` + md5Hex(content) + `"`)}
+	assert.NoError(t, verify(integrityMetadataFirst, singlePart))
+
+	unverifiable := &manager.DownloadObjectOutput{ETag: multipartETag}
+	assert.NoError(t, verify(integrityMetadataFirst, unverifiable))
+	err := verify(integrityRecomputeAlways, unverifiable)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errChecksumMismatch)
+
+	assert.NoError(t, verify(integritySizeOnly, &manager.DownloadObjectOutput{ContentLength: aws.Int64(int64(len(content)))}))
+	assert.ErrorIs(t, verify(integritySizeOnly, &manager.DownloadObjectOutput{ContentLength: aws.Int64(1)}), errChecksumMismatch)
+}
+
+func TestCompareFileChecksumsWeakETag(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	const content = "served by a gateway"
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `W/"`+md5Hex(content)+`"`)
+		http.ServeContent(w, r, "object", time.Unix(0, 0), strings.NewReader(content))
+	}))
+
+	setTestConfig("", "", "", false, false, true, false)
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+
+	same, err := compareFileChecksums(context.Background(), s3Client, "bucket", "file.txt", localPath, md5Hex(content))
+	require.NoError(t, err)
+	assert.True(t, same)
+
+	exists, etag, _, err := checkS3ObjectExists(context.Background(), s3Client, "bucket", "file.txt")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, md5Hex(content), etag)
+}
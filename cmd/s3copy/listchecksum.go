@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// listChecksumBatchSize is the number of listed objects whose checksums are
+// fetched in parallel before they are printed in listing order
+const listChecksumBatchSize = 100
+
+// warnListChecksumCost warns that --with-checksum needs a HEAD request for
+// every listed object
+func warnListChecksumCost() {
+	fmt.Fprintf(os.Stderr, "Warning: --with-checksum sends one HEAD request per object, which is slow and costly for large buckets\n")
+}
+
+// objectChecksum returns the content checksum of a HEAD response: the
+// additional checksum S3 stores for the object, or else the MD5 of the local
+// file s3copy stores in the local-md5 metadata. It returns "" when the
+// object has neither.
+func objectChecksum(head *s3.HeadObjectOutput) string {
+	for _, checksum := range []struct {
+		name  string
+		value *string
+	}{
+		{"SHA256", head.ChecksumSHA256},
+		{"SHA1", head.ChecksumSHA1},
+		{"CRC64NVME", head.ChecksumCRC64NVME},
+		{"CRC32C", head.ChecksumCRC32C},
+		{"CRC32", head.ChecksumCRC32},
+	} {
+		if value := aws.ToString(checksum.value); value != "" {
+			return checksum.name + ":" + value
+		}
+	}
+	if localMD5 := head.Metadata["local-md5"]; localMD5 != "" {
+		return "MD5:" + localMD5
+	}
+	return ""
+}
+
+// fetchListChecksums sends a HEAD request for every object, with up to
+// --max-workers requests at once, and returns the checksums in the order of
+// the objects. Objects without a checksum, or whose HEAD failed, get "-".
+func fetchListChecksums(ctx context.Context, s3Client *s3.Client, bucketName string, objects []types.Object) []string {
+	checksums := make([]string, len(objects))
+	indexes := make([]int, len(objects))
+	for i := range objects {
+		indexes[i] = i
+	}
+
+	_ = runWorkerPool(ctx, indexes, maxWorkers, func(workerCtx context.Context, i int) error {
+		checksums[i] = "-"
+		head, err := s3Client.HeadObject(workerCtx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          objects[i].Key,
+			ChecksumMode: types.ChecksumModeEnabled,
+		})
+		if err != nil {
+			logVerbose("Warning: could not fetch the checksum of %s: %v\n", aws.ToString(objects[i].Key), err)
+			return nil
+		}
+		if checksum := objectChecksum(head); checksum != "" {
+			checksums[i] = checksum
+		}
+		return nil
+	})
+	return checksums
+}
+
+// checksumBatch buffers listed objects for --with-checksum and prints each
+// batch once its checksums are fetched
+type checksumBatch struct {
+	s3Client   *s3.Client
+	bucketName string
+	objects    []types.Object
+	print      func(obj types.Object, checksum string)
+}
+
+func (b *checksumBatch) add(ctx context.Context, obj types.Object) {
+	b.objects = append(b.objects, obj)
+	if len(b.objects) == listChecksumBatchSize {
+		b.flush(ctx)
+	}
+}
+
+func (b *checksumBatch) flush(ctx context.Context) {
+	for i, checksum := range fetchListChecksums(ctx, b.s3Client, b.bucketName, b.objects) {
+		b.print(b.objects[i], checksum)
+	}
+	b.objects = b.objects[:0]
+}
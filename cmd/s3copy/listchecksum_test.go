@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectChecksum(t *testing.T) {
+	assert.Equal(t, "SHA256:abc=", objectChecksum(&s3.HeadObjectOutput{
+		ChecksumSHA256: aws.String("abc="),
+		Metadata:       map[string]string{"local-md5": "d41d8cd98f00b204e9800998ecf8427e"},
+	}), "additional checksums take precedence")
+	assert.Equal(t, "CRC32C:yZRlqg==", objectChecksum(&s3.HeadObjectOutput{ChecksumCRC32C: aws.String("yZRlqg==")}))
+	assert.Equal(t, "MD5:d41d8cd98f00b204e9800998ecf8427e", objectChecksum(&s3.HeadObjectOutput{
+		Metadata: map[string]string{"local-md5": "d41d8cd98f00b204e9800998ecf8427e"},
+	}))
+	assert.Empty(t, objectChecksum(&s3.HeadObjectOutput{}))
+}
+
+func TestListWithChecksum(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/sums":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>sums</Name><KeyCount>3</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>` +
+				`<Contents><Key>big.iso</Key><Size>104857600</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"9b2cf535f27731c974343645a3985328-7"</ETag></Contents>` +
+				`<Contents><Key>photo.jpg</Key><Size>2048</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"abc"</ETag></Contents>` +
+				`<Contents><Key>plain.txt</Key><Size>12</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"def"</ETag></Contents>` +
+				`</ListBucketResult>`))
+		case r.Method == http.MethodHead && r.URL.Path == "/sums/big.iso":
+			w.Header().Set("x-amz-meta-local-md5", "0cc175b9c0f1b6a831c399e269772661")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead && r.URL.Path == "/sums/photo.jpg":
+			if r.Header.Get("x-amz-checksum-mode") == "ENABLED" {
+				w.Header().Set("x-amz-checksum-sha256", "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg=")
+			}
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	setTestConfig("", "", "sums", false, false, false, false)
+	listObjects = true
+	listWithChecksum = true
+	maxWorkers = 3
+
+	var err error
+	output := captureStdout(func() {
+		err = listS3Objects()
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, output, "Checksum")
+	assert.Regexp(t, regexp.MustCompile(`(?m)^big\.iso .* MD5:0cc175b9c0f1b6a831c399e269772661$`), output)
+	assert.Regexp(t, regexp.MustCompile(`(?m)^photo\.jpg .* SHA256:n4bQgYhMfWWaL\+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg=$`), output)
+	assert.Regexp(t, regexp.MustCompile(`(?m)^plain\.txt .* -$`), output)
+	assert.Contains(t, output, "Total: 3 objects")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// listTemplateEscapes turns the escape sequences a shell leaves alone in
+// single quotes into the characters they stand for
+var listTemplateEscapes = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\\`, `\`)
+
+// listTemplateObject is the data a --list-template is executed with for
+// every listed object
+type listTemplateObject struct {
+	Key          string
+	Size         int64
+	SizeHuman    string
+	LastModified time.Time
+	StorageClass string
+	ETag         string
+	// Checksum is only filled with --with-checksum
+	Checksum string
+}
+
+func newListTemplateObject(obj types.Object, checksum string) listTemplateObject {
+	size := aws.ToInt64(obj.Size)
+	return listTemplateObject{
+		Key:          aws.ToString(obj.Key),
+		Size:         size,
+		SizeHuman:    formatBytes(size),
+		LastModified: aws.ToTime(obj.LastModified),
+		StorageClass: string(obj.StorageClass),
+		ETag:         normalizeETag(aws.ToString(obj.ETag)),
+		Checksum:     checksum,
+	}
+}
+
+// parseListTemplate parses a --list-template value
+func parseListTemplate(value string) (*template.Template, error) {
+	tmpl, err := template.New("list-template").Option("missingkey=error").Parse(listTemplateEscapes.Replace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid list-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// listS3ObjectsTemplate prints every listed object with --list-template,
+// one line per object and without the table header and totals. The limit
+// hint goes to stderr to keep stdout to the rendered lines.
+func listS3ObjectsTemplate(w io.Writer, tmpl *template.Template) error {
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %v", err)
+	}
+
+	var renderErr error
+	render := func(obj types.Object, checksum string) {
+		if renderErr != nil {
+			return
+		}
+		var line bytes.Buffer
+		if err := tmpl.Execute(&line, newListTemplateObject(obj, checksum)); err != nil {
+			renderErr = fmt.Errorf("failed to render %s: %w", aws.ToString(obj.Key), err)
+			return
+		}
+		// an object the template renders to nothing is left out
+		if line.Len() > 0 {
+			fmt.Fprintln(w, line.String())
+		}
+	}
+
+	var batch *checksumBatch
+	if listWithChecksum {
+		warnListChecksumCost()
+		batch = &checksumBatch{s3Client: s3Client, bucketName: bucket, print: render}
+	}
+
+	limitReached, lastKey, err := forEachListedObject(ctx, s3Client, newListingInput(), func(obj types.Object) error {
+		if batch != nil {
+			batch.add(ctx, obj)
+		} else {
+			render(obj, "")
+		}
+		return renderErr
+	})
+	if batch != nil {
+		batch.flush(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if renderErr != nil {
+		return renderErr
+	}
+	if limitReached {
+		fmt.Fprintf(os.Stderr, "Limit of %d objects reached, continue with --start-after %q\n", listLimit, lastKey)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTemplate(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	fake.store("media/photos/a.jpg", &fakeObject{
+		content:  strings.Repeat("a", 2097152),
+		header:   http.Header{"X-Amz-Storage-Class": {"STANDARD_IA"}},
+		etag:     "0123456789abcdef0123456789abcdef",
+		modified: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	fake.store("media/photos/b.jpg", &fakeObject{
+		content:  strings.Repeat("b", 512),
+		etag:     "fedcba9876543210fedcba9876543210-2",
+		modified: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+	})
+	useFakeS3(t, fake)
+
+	list := func(t *testing.T, value string) (string, error) {
+		setTestConfig("", "", "media", false, false, false, false)
+		listObjects = true
+		tmpl, err := parseListTemplate(value)
+		require.NoError(t, err)
+		var output strings.Builder
+		err = listS3ObjectsTemplate(&output, tmpl)
+		return output.String(), err
+	}
+
+	output, err := list(t, `{{.Key}}\t{{.Size}}\t{{.SizeHuman}}\t{{.LastModified.Format "2006-01-02"}}\t{{.StorageClass}}\t{{.ETag}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "photos/a.jpg\t2097152\t2.0 MB\t2026-01-02\tSTANDARD_IA\t0123456789abcdef0123456789abcdef\n"+
+		"photos/b.jpg\t512\t512 B\t2026-03-04\tSTANDARD\tfedcba9876543210fedcba9876543210-2\n", output)
+
+	output, err = list(t, `{{if gt .Size 1024}}s3://media/{{.Key}}{{end}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "s3://media/photos/a.jpg\n", output)
+
+	_, err = list(t, `{{.Owner}}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "photos/a.jpg")
+
+	_, err = parseListTemplate(`{{.Key`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// logGroupKey is the context key of the logGroup a worker logs into
+type logGroupKey struct{}
+
+// logGroup buffers the log lines of the files below one top-level prefix of
+// a recursive download until all of them are done
+type logGroup struct {
+	name    string
+	mutex   sync.Mutex
+	buffer  bytes.Buffer
+	files   int
+	pending int
+	listed  bool
+	flushed bool
+}
+
+func (g *logGroup) printf(format string, args ...any) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	fmt.Fprintf(&g.buffer, format, args...)
+}
+
+// withLogGroup returns a context whose log lines go to group. A nil group
+// leaves the output ungrouped.
+func withLogGroup(ctx context.Context, group *logGroup) context.Context {
+	if group == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, logGroupKey{}, group)
+}
+
+// logGroupFrom returns the group of ctx, or nil when output is not grouped
+func logGroupFrom(ctx context.Context) *logGroup {
+	group, _ := ctx.Value(logGroupKey{}).(*logGroup)
+	return group
+}
+
+// logInfoContext is logInfo that writes into the group of ctx
+func logInfoContext(ctx context.Context, format string, args ...any) {
+	if group := logGroupFrom(ctx); group != nil {
+		if currentOutputMode().perFile() {
+			group.printf(format, args...)
+		}
+		return
+	}
+	logInfo(format, args...)
+}
+
+// logVerboseContext is logVerbose that writes into the group of ctx
+func logVerboseContext(ctx context.Context, format string, args ...any) {
+	if group := logGroupFrom(ctx); group != nil {
+		if currentOutputMode().details() {
+			group.printf(format, args...)
+		}
+		return
+	}
+	logVerbose(format, args...)
+}
+
+// logGroups assigns the objects of a prefix download to groups by their
+// first path segment below the prefix. Listing returns keys in order, so a
+// group is complete once the listing moved on to the next group; its lines
+// are printed as one block when its last file is done. Files at the top level
+// sort between the groups, so the top-level group is only complete when the
+// listing ends. A nil logGroups does not group output.
+type logGroups struct {
+	mutex   sync.Mutex
+	groups  map[string]*logGroup
+	current *logGroup
+}
+
+func newLogGroups() *logGroups {
+	return &logGroups{groups: make(map[string]*logGroup)}
+}
+
+// groupName returns the group of a path relative to the download prefix
+func groupName(relPath string) string {
+	if segment, _, nested := strings.Cut(relPath, "/"); nested {
+		return segment + "/"
+	}
+	return ""
+}
+
+// add registers a listed file and returns the group it logs into
+func (l *logGroups) add(relPath string) *logGroup {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	name := groupName(relPath)
+	if l.current != nil && l.current.name != name && l.current.name != "" {
+		l.closeLocked(l.current)
+	}
+	group, ok := l.groups[name]
+	if !ok {
+		group = &logGroup{name: name}
+		l.groups[name] = group
+	}
+	group.files++
+	group.pending++
+	l.current = group
+	return group
+}
+
+// done marks a file of the group as finished
+func (l *logGroups) done(group *logGroup) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	group.pending--
+	if group.listed && group.pending == 0 {
+		l.flush(group)
+	}
+}
+
+// finish ends the listing. Groups whose files are all done are printed.
+func (l *logGroups) finish() {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, group := range l.groups {
+		l.closeLocked(group)
+	}
+}
+
+// flushAll prints the groups that are still buffered, for example after the
+// download failed
+func (l *logGroups) flushAll() {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, group := range l.groups {
+		l.flush(group)
+	}
+}
+
+func (l *logGroups) closeLocked(group *logGroup) {
+	group.listed = true
+	if group.pending == 0 {
+		l.flush(group)
+	}
+}
+
+// flush prints the buffered lines of a group once, as one block. The caller
+// holds the mutex, so blocks never interleave.
+func (l *logGroups) flush(group *logGroup) {
+	if group.flushed {
+		return
+	}
+	group.flushed = true
+
+	group.mutex.Lock()
+	lines := group.buffer.String()
+	group.mutex.Unlock()
+	if lines == "" {
+		return
+	}
+
+	name := group.name
+	if name == "" {
+		name = "(top level)"
+	}
+	fmt.Printf("== %s (%d files) ==\n%s", name, group.files, lines)
+}
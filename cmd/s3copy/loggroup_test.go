@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadGroupOutput(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	for _, group := range []string{"alpha", "beta"} {
+		for i := range 4 {
+			fake.put(fmt.Sprintf("group-bucket/data/%s/file%d.txt", group, i), group)
+		}
+	}
+	fake.put("group-bucket/data/readme.txt", "top")
+	// every GET is delayed, so downloads of different prefixes overlap
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	setTestConfig("s3://group-bucket/data/", t.TempDir(), "", false, true, false, false)
+	maxWorkers = 6
+	groupOutput = true
+
+	var err error
+	output := captureStdout(func() {
+		err = downloadFromS3(context.Background())
+	})
+	require.NoError(t, err)
+
+	headers := regexp.MustCompile(`(?m)^== (.*) \((\d+) files\) ==$`)
+	blocks := headers.Split(output, -1)[1:]
+	names := headers.FindAllStringSubmatch(output, -1)
+	require.Len(t, names, 3, output)
+
+	seen := map[string]bool{}
+	for i, block := range blocks {
+		name := names[i][1]
+		seen[name] = true
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		assert.Equal(t, names[i][2], fmt.Sprint(len(lines)), "block %s", name)
+		for _, line := range lines {
+			if name == "(top level)" {
+				assert.Contains(t, line, "s3://group-bucket/data/readme.txt")
+			} else {
+				assert.Contains(t, line, "s3://group-bucket/data/"+name, "line of another prefix in block %s", name)
+			}
+		}
+	}
+	assert.Equal(t, map[string]bool{"alpha/": true, "beta/": true, "(top level)": true}, seen)
+}
+
+func TestLogGroupsTopLevelAfterSubdirectory(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	groups := newLogGroups()
+	output := captureStdout(func() {
+		for _, relPath := range []string{"a.txt", "sub/b.txt", "z.txt"} {
+			group := groups.add(relPath)
+			logInfoContext(withLogGroup(context.Background(), group), "%s\n", relPath)
+			groups.done(group)
+		}
+		groups.finish()
+	})
+	assert.Equal(t, "== sub/ (1 files) ==\nsub/b.txt\n== (top level) (2 files) ==\na.txt\nz.txt\n", output)
+}
+
+func TestGroupName(t *testing.T) {
+	assert.Equal(t, "alpha/", groupName("alpha/file.txt"))
+	assert.Equal(t, "alpha/", groupName("alpha/nested/file.txt"))
+	assert.Equal(t, "", groupName("file.txt"))
+}
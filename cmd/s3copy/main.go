@@ -2,41 +2,184 @@ package main
 
 import (
 	"context"
+	"crypto/ecdh"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"text/template"
 	"time"
 
-	"github.com/joho/godotenv"
+	mtypes "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager/types"
 	"github.com/urfave/cli/v3"
 )
 
 var (
-	source         string
-	destination    string
-	bucket         string
-	encrypt        bool
-	password       string
-	recursive      bool
-	envFile        string
-	listObjects    bool
-	filter         string
-	listDetailed   bool
-	ignorePatterns string
-	ignoreFile     string
-	maxWorkers     = 5
-	dryRun         bool
-	quiet          bool
-	verbose        bool
-	timeout        int
-	retries        int
-	forceOverwrite bool
-	syncMode       bool
-	syncCompare    = "checksum"
+	source                  string
+	destination             string
+	destinations            []string
+	expandEnv               bool
+	bucket                  string
+	encrypt                 bool
+	encryptExt              string
+	encryptExtensions       []string
+	password                string
+	recursive               bool
+	envFile                 string
+	showConfig              bool
+	configOnly              bool
+	printConfigFor          string
+	includeSecrets          bool
+	listObjects             bool
+	filter                  string
+	listDetailed            bool
+	listWithChecksum        bool
+	ignorePatterns          string
+	ignoreFile              string
+	noHidden                bool
+	maxWorkers              = 5
+	dryRun                  bool
+	quiet                   bool
+	verbose                 bool
+	summaryOnly             bool
+	showProgress            bool
+	preflight               bool
+	groupOutput             bool
+	timeout                 int
+	checksumOut             string
+	preferIPv4              bool
+	debugHTTP               bool
+	retries                 int
+	listTemplate            string
+	listTemplateParsed      *template.Template
+	retryDeadline           string
+	retryDeadlineDuration   time.Duration
+	forceOverwrite          bool
+	syncMode                bool
+	mirrorAdd               bool
+	scrubMode               bool
+	syncCompare             = "checksum"
+	integrityPolicy         = integrityETagFirst
+	syncDeleteScope         = "all"
+	sinceETag               string
+	excludeExisting         bool
+	mapFile                 string
+	skipSameSize            bool
+	lowercaseKeys           bool
+	normalizeUnicode        string
+	filterCmd               string
+	configStdin             bool
+	multipartThreshold      string
+	multipartThresholdBytes int64
+	encryptionMemory        string
+	trailingChecksum        bool
+	dirMode                 string
+	downloadDirMode         os.FileMode
+	fileMode                string
+	downloadFileMode        os.FileMode
+	uploadOrder             = "walk"
+	packFiles               bool
+	packThreshold           string
+	packThresholdBytes      int64
+	listCSV                 string
+	perFileTimeout          int
+	ignoreCase              bool
+	strict                  bool
+	expires                 string
+	expiresAt               time.Time
+	expireTag               string
+	verifyManifestPath      string
+	moveMode                bool
+	catMode                 bool
+	exactKey                bool
+	continueOnError         bool
+	catRange                string
+	downloadArchive         string
+	treeHash                bool
+	treeHashMarker          string
+	maxListConcurrency      = 1
+	ifNoneMatch             bool
+	assumeExistsOn403       bool
+	assumeExistsAction      string
+	showReport              bool
+	errorReport             string
+	completionMarker        string
+	checksumsFile           string
+	checksumsAlgorithm      = "sha256"
+	storeHMAC               bool
+	verifyEncryption        bool
+	grantFullControl        string
+	grantFullControlHeader  string
+	grantRead               string
+	grantReadHeader         string
+	concurrencyPerEndpoint  string
+	regionPerEndpoint       string
+	endpointRegions         map[string]string
+	endpointSlots           *concurrencyLimiter
+	adaptiveConcurrency     bool
+	adaptiveSlots           *adaptiveLimiter
+	maxOpenFiles            int
+	openFileSlots           *openFileLimiter
+	bwlimit                 string
+	bwlimitSchedule         string
+	bandwidth               *bandwidthLimiter
+	resumeUploads           bool
+	detailedExitCodes       bool
+	verifyDownloads         bool
+	retryOnChecksumMismatch bool
+	spotCheck               int
+	localEncryptionIndex    string
+	restoreFromIndex        bool
+	checksumChunked         string
+	uploadChecksumAlgorithm mtypes.ChecksumAlgorithm
+	listIncomplete          bool
+	abortIncomplete         bool
+	incompleteOlderThan     string
+	incompleteMinAge        time.Duration
+	contentType             string
+	cacheControl            string
+	contentLanguage         string
+	contentDisposition      string
+	runID                   string
+	syncMetadata            bool
+	progressInterval        string
+	progressPeriod          time.Duration
+	ifMetadata              []string
+	nameFromMetadata        string
+	flatten                 bool
+	unflatten               bool
+	checkFreeSpace          bool
+	minFree                 string
+	minFreeBytes            int64
+	metadataConditions      map[string]string
+	dedupeByETag            bool
+	byStorageClass          bool
+	startAfter              string
+	listLimit               int
+	recipientFile           string
+	identityFile            string
+	passwordMap             string
+	recipientKey            *ecdh.PublicKey
+	identityKey             *ecdh.PrivateKey
+	sourceRoot              string
+	datePrefix              bool
+	datePrefixTemplate      string
 )
 
 func main() {
-	app := &cli.Command{
+	err := newApp().Run(context.Background(), os.Args)
+	code := exitCode(err)
+	if err != nil && code != exitNothingToDo {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	if code != exitSuccess {
+		os.Exit(code)
+	}
+}
+
+// newApp returns the s3copy command with its flags
+func newApp() *cli.Command {
+	return &cli.Command{
 		Name:  "s3copy",
 		Usage: "Copy files between local storage and S3-compatible storage with optional encryption",
 		Description: `A CLI tool to copy files between local storage and S3-compatible storage.
@@ -47,14 +190,19 @@ Supports gitignore-style file filtering for selective copying.`,
 			&cli.StringFlag{
 				Name:        "source",
 				Aliases:     []string{"s"},
-				Usage:       "Source path (local file/directory or s3://bucket/key)",
+				Usage:       "Source path (local file/directory, s3://bucket/key, http(s) URL, or - for stdin)",
 				Destination: &source,
 			},
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:        "destination",
 				Aliases:     []string{"d"},
-				Usage:       "Destination path (local file/directory or s3://bucket/key)",
-				Destination: &destination,
+				Usage:       "Destination path (local file/directory or s3://bucket/key); repeat to upload to several S3 destinations",
+				Destination: &destinations,
+			},
+			&cli.BoolFlag{
+				Name:        "expand-env",
+				Usage:       "Expand $VAR and ${VAR} in the source, destination and bucket, for callers without a shell",
+				Destination: &expandEnv,
 			},
 			&cli.StringFlag{
 				Name:        "bucket",
@@ -68,12 +216,52 @@ Supports gitignore-style file filtering for selective copying.`,
 				Usage:       "Enable encryption/decryption (required for both encrypting and decrypting files)",
 				Destination: &encrypt,
 			},
+			&cli.StringFlag{
+				Name:        "encrypt-ext",
+				Usage:       "Comma-separated file extensions to encrypt with --encrypt (e.g. .pem,.key); other files are transferred in plaintext",
+				Destination: &encryptExt,
+			},
+			&cli.BoolFlag{
+				Name:        "hmac",
+				Usage:       "Store an HMAC of the encrypted object as x-amz-meta-hmac for integrity checks without decryption",
+				Destination: &storeHMAC,
+			},
+			&cli.BoolFlag{
+				Name:        "verify-encryption",
+				Usage:       "Verify the stored HMAC of encrypted objects at the source path without decrypting them",
+				Destination: &verifyEncryption,
+			},
 			&cli.StringFlag{
 				Name:        "password",
 				Aliases:     []string{"p"},
 				Usage:       "Encryption password (omit value to prompt interactively)",
 				Destination: &password,
 			},
+			&cli.StringFlag{
+				Name:        "recipient-file",
+				Usage:       "PEM file with an X25519 public key to encrypt uploads to instead of a password; decrypting needs the matching --identity-file",
+				Destination: &recipientFile,
+			},
+			&cli.StringFlag{
+				Name:        "identity-file",
+				Usage:       "PEM file with the X25519 private key that decrypts objects encrypted with --recipient-file",
+				Destination: &identityFile,
+			},
+			&cli.StringFlag{
+				Name:        "password-map",
+				Usage:       "File of \"pattern => id:password\" lines that encrypt matching keys with their own password; only the id is stored with the object",
+				Destination: &passwordMap,
+			},
+			&cli.StringFlag{
+				Name:        "encryption-memory",
+				Usage:       "Cap the memory used for chunk buffers by all concurrent encryptions together (e.g. 256MB)",
+				Destination: &encryptionMemory,
+			},
+			&cli.BoolFlag{
+				Name:        "trailing-checksum",
+				Usage:       "Write encrypted objects in format version 2, which ends with an authenticated trailer so a truncated object fails to decrypt",
+				Destination: &trailingChecksum,
+			},
 			&cli.BoolFlag{
 				Name:        "recursive",
 				Aliases:     []string{"r"},
@@ -82,16 +270,75 @@ Supports gitignore-style file filtering for selective copying.`,
 			},
 			&cli.StringFlag{
 				Name:        "env",
-				Usage:       "Path to .env file",
-				Value:       ".env",
+				Usage:       "Path to .env file (default: nearest .env in the current or a parent directory)",
 				Destination: &envFile,
 			},
+			&cli.StringFlag{
+				Name:        "region-per-endpoint",
+				Usage:       "Comma-separated list of endpoint=region; the region of the entry matching S3COPY_ENDPOINT replaces S3COPY_REGION",
+				Destination: &regionPerEndpoint,
+			},
+			&cli.BoolFlag{
+				Name:        "show-config",
+				Usage:       "Print the resolved connection settings and operation parameters with the source of each value (secrets redacted)",
+				Destination: &showConfig,
+			},
+			&cli.BoolFlag{
+				Name:        "config-only",
+				Usage:       "Print the resolved configuration like --show-config and exit without running the operation",
+				Destination: &configOnly,
+			},
+			&cli.StringFlag{
+				Name:        "print-config-for",
+				Usage:       "Print a config snippet for aws-cli or rclone with the resolved connection settings and exit (secret key redacted)",
+				Destination: &printConfigFor,
+			},
+			&cli.BoolFlag{
+				Name:        "include-secrets",
+				Usage:       "Include the secret key in the --print-config-for snippet",
+				Destination: &includeSecrets,
+			},
 			&cli.BoolFlag{
 				Name:        "list",
 				Aliases:     []string{"l"},
 				Usage:       "List objects in bucket",
 				Destination: &listObjects,
 			},
+			&cli.BoolFlag{
+				Name:        "continue-on-error",
+				Usage:       "Keep downloading or moving the other objects of a prefix when one fails, then report the failures and exit with an error",
+				Destination: &continueOnError,
+			},
+			&cli.BoolFlag{
+				Name:        "exact",
+				Usage:       "Require the S3 source to be an existing object key and fail instead of downloading everything under it as a prefix",
+				Destination: &exactKey,
+			},
+			&cli.BoolFlag{
+				Name:        "cat",
+				Usage:       "Write the body of the S3 source object to stdout",
+				Destination: &catMode,
+			},
+			&cli.StringFlag{
+				Name:        "range",
+				Usage:       "Only write these bytes with --cat: start-end (inclusive), start- or -length (not available with --encrypt)",
+				Destination: &catRange,
+			},
+			&cli.StringFlag{
+				Name:        "download-archive",
+				Usage:       "Download every object below the S3 source into this local .tar, .tar.gz, .tgz or .zip archive instead of separate files",
+				Destination: &downloadArchive,
+			},
+			&cli.BoolFlag{
+				Name:        "tree-hash",
+				Usage:       "Print a single hash over the relative paths and checksums of all files in the source directory",
+				Destination: &treeHash,
+			},
+			&cli.StringFlag{
+				Name:        "tree-hash-marker",
+				Usage:       "Compare the --tree-hash root with the one stored in this S3 object (s3://bucket/key) and update it when it changed",
+				Destination: &treeHashMarker,
+			},
 			&cli.StringFlag{
 				Name:        "filter",
 				Aliases:     []string{"f"},
@@ -103,6 +350,56 @@ Supports gitignore-style file filtering for selective copying.`,
 				Usage:       "Show detailed information when listing (storage class, ETag, etc.)",
 				Destination: &listDetailed,
 			},
+			&cli.BoolFlag{
+				Name:        "with-checksum",
+				Usage:       "Show the SHA256/CRC checksum or the local-md5 metadata of every object when listing; sends one HEAD request per object",
+				Destination: &listWithChecksum,
+			},
+			&cli.StringFlag{
+				Name:        "csv",
+				Usage:       "Export the listing as an inventory-style CSV file (used with --list)",
+				Destination: &listCSV,
+			},
+			&cli.StringFlag{
+				Name:        "start-after",
+				Usage:       "List only keys that sort after this key, to continue a previous listing (used with --list)",
+				Destination: &startAfter,
+			},
+			&cli.IntFlag{
+				Name:        "limit",
+				Usage:       "Maximum number of objects to list, 0 for no limit (used with --list)",
+				Destination: &listLimit,
+			},
+			&cli.BoolFlag{
+				Name:        "dedupe-by-etag",
+				Usage:       "Report objects with identical content (same ETag and size) and the space removing the copies would reclaim (used with --list)",
+				Destination: &dedupeByETag,
+			},
+			&cli.StringFlag{
+				Name:        "list-template",
+				Usage:       "Print every listed object with this Go template instead of the table, e.g. '{{.Key}}\\t{{.Size}}' (used with --list)",
+				Destination: &listTemplate,
+			},
+			&cli.BoolFlag{
+				Name:        "by-storage-class",
+				Usage:       "Print the object count and total size per storage class instead of the objects (used with --list)",
+				Destination: &byStorageClass,
+			},
+			&cli.BoolFlag{
+				Name:        "list-incomplete-uploads",
+				Usage:       "List the incomplete multipart uploads in the bucket (filtered by --filter)",
+				Destination: &listIncomplete,
+			},
+			&cli.BoolFlag{
+				Name:        "abort-incomplete",
+				Usage:       "Abort the incomplete multipart uploads listed by --list-incomplete-uploads",
+				Destination: &abortIncomplete,
+			},
+			&cli.StringFlag{
+				Name:        "incomplete-older-than",
+				Usage:       "Only list or abort incomplete uploads initiated longer ago than this duration (e.g. 24h, 7d)",
+				Destination: &incompleteOlderThan,
+			},
 			&cli.StringFlag{
 				Name:        "ignore",
 				Usage:       "Comma-separated list of patterns to ignore (gitignore syntax)",
@@ -110,20 +407,168 @@ Supports gitignore-style file filtering for selective copying.`,
 			},
 			&cli.StringFlag{
 				Name:        "ignore-file",
-				Usage:       "Path to file containing ignore patterns (one per line, gitignore syntax)",
+				Usage:       "Comma-separated list of files containing ignore patterns (one per line, gitignore syntax)",
 				Destination: &ignoreFile,
 			},
+			&cli.BoolFlag{
+				Name:        "flatten",
+				Usage:       "Upload the files of a directory directly below the destination prefix under their file name, keeping the relative path in original-path metadata",
+				Destination: &flatten,
+			},
+			&cli.StringFlag{
+				Name:        "map-file",
+				Usage:       "File of \"pattern => destination\" lines routing matching files to other keys or buckets during upload; the first matching line wins",
+				Destination: &mapFile,
+			},
+			&cli.BoolFlag{
+				Name:        "no-hidden",
+				Usage:       "Skip files and directories whose name starts with a dot",
+				Destination: &noHidden,
+			},
+			&cli.BoolFlag{
+				Name:        "ignore-case",
+				Usage:       "Match ignore patterns case-insensitively",
+				Destination: &ignoreCase,
+			},
+			&cli.BoolFlag{
+				Name:        "strict",
+				Usage:       "Fail instead of warning when every matched file was excluded by ignore patterns, or when two downloaded keys differ only in case on a case-insensitive file system",
+				Destination: &strict,
+			},
+			&cli.StringFlag{
+				Name:        "expires",
+				Usage:       "Set the Expires header on uploaded objects (RFC 3339 timestamp or duration like 72h or 7d)",
+				Destination: &expires,
+			},
+			&cli.StringFlag{
+				Name:        "expire-tag",
+				Usage:       "Object tag (key=value) added on upload for matching by a bucket lifecycle expiration rule",
+				Destination: &expireTag,
+			},
+			&cli.StringFlag{
+				Name:        "grant-full-control",
+				Usage:       "Grant full control of uploaded objects to comma-separated grantees (id=<canonical user id>, uri=<group uri> or emailAddress=<email>)",
+				Destination: &grantFullControl,
+			},
+			&cli.StringFlag{
+				Name:        "grant-read",
+				Usage:       "Grant read access to uploaded objects to comma-separated grantees (id=<canonical user id>, uri=<group uri> or emailAddress=<email>)",
+				Destination: &grantRead,
+			},
+			&cli.StringFlag{
+				Name:        "verify-manifest",
+				Usage:       "After downloading a prefix, verify the local files against an md5sum-style checksum manifest",
+				Destination: &verifyManifestPath,
+			},
 			&cli.IntFlag{
 				Name:        "max-workers",
 				Usage:       "Maximum number of concurrent workers for uploads/downloads",
 				Value:       5,
 				Destination: &maxWorkers,
 			},
+			&cli.IntFlag{
+				Name:        "max-list-concurrency",
+				Usage:       "Maximum number of concurrent listing requests when pre-listing the destination for --exclude-existing",
+				Value:       1,
+				Destination: &maxListConcurrency,
+			},
+			&cli.StringFlag{
+				Name:        "concurrency-per-endpoint",
+				Usage:       "Maximum number of concurrent transfers per endpoint host, either a number for every endpoint or a comma-separated list of endpoint=number (0 = unlimited, capped by --max-workers)",
+				Destination: &concurrencyPerEndpoint,
+			},
+			&cli.BoolFlag{
+				Name:        "adaptive-concurrency",
+				Usage:       "Start with a quarter of --max-workers and ramp up until S3 throttles (503 SlowDown, 429), then back off",
+				Destination: &adaptiveConcurrency,
+			},
+			&cli.IntFlag{
+				Name:        "max-open-files",
+				Usage:       "Maximum number of local files open at the same time during transfers, independent of --max-workers (0 for no limit)",
+				Destination: &maxOpenFiles,
+			},
+			&cli.StringFlag{
+				Name:        "bwlimit",
+				Usage:       "Limit the bandwidth of all transfers together to this rate per second (e.g. 10MB), or the rate outside the --bwlimit-schedule windows",
+				Destination: &bwlimit,
+			},
+			&cli.StringFlag{
+				Name:        "bwlimit-schedule",
+				Usage:       "Bandwidth limits by time of day, a comma-separated list of <HH:MM>-<HH:MM>:<rate> (e.g. \"08:00-18:00:5MB,18:00-08:00:unlimited\")",
+				Destination: &bwlimitSchedule,
+			},
+			&cli.BoolFlag{
+				Name:        "resume",
+				Usage:       "Continue an incomplete multipart upload of a large file instead of starting over, uploading only the missing parts",
+				Destination: &resumeUploads,
+			},
+			&cli.BoolFlag{
+				Name:        "detailed-exit-codes",
+				Usage:       "Exit with 2 when some files failed and 3 when there was nothing to do, instead of 1 and 0",
+				Destination: &detailedExitCodes,
+			},
+			&cli.BoolFlag{
+				Name:        "verify",
+				Usage:       "Verify downloads against the SHA256, CRC32C, CRC32 or SHA1 checksum stored with the object, when S3 returns one",
+				Destination: &verifyDownloads,
+			},
+			&cli.StringFlag{
+				Name:        "integrity-policy",
+				Usage:       "How files and objects are compared and downloads verified: etag-first, metadata-first, recompute-always or size-only",
+				Value:       integrityETagFirst,
+				Destination: &integrityPolicy,
+			},
+			&cli.IntFlag{
+				Name:        "spot-check",
+				Usage:       "After each transfer, compare this many random 64 KB ranges of the object with the local file",
+				Destination: &spotCheck,
+			},
+			&cli.BoolFlag{
+				Name:        "retry-on-checksum-mismatch",
+				Usage:       "With --verify or --spot-check, download a file again up to --retries times when its checksum does not match",
+				Destination: &retryOnChecksumMismatch,
+			},
+			&cli.StringFlag{
+				Name:        "local-encryption-index",
+				Usage:       "JSON file recording key, plaintext size and encryption parameters of every encrypted upload",
+				Destination: &localEncryptionIndex,
+			},
+			&cli.BoolFlag{
+				Name:        "restore-from-index",
+				Usage:       "Download and decrypt every object listed in --local-encryption-index into the destination directory",
+				Destination: &restoreFromIndex,
+			},
 			&cli.BoolFlag{
 				Name:        "dry-run",
 				Usage:       "Show what would be done without actually performing the operations",
 				Destination: &dryRun,
 			},
+			&cli.BoolFlag{
+				Name:        "report",
+				Usage:       "Print a per-phase timing breakdown (enumeration, hashing, transfer, delete) with total bytes and throughput at the end",
+				Destination: &showReport,
+			},
+			&cli.StringFlag{
+				Name:        "error-report",
+				Usage:       "Write the failed files (path, key, operation, error, whether the request was retried) to this JSON file",
+				Destination: &errorReport,
+			},
+			&cli.StringFlag{
+				Name:        "completion-marker",
+				Usage:       "After an upload, sync or move without failed files, write a JSON marker object with this key under the destination prefix, or to this s3:// URI",
+				Destination: &completionMarker,
+			},
+			&cli.StringFlag{
+				Name:        "checksums-file",
+				Usage:       "After a directory upload or sync without failed files, write a checksums file (e.g. SHA256SUMS) with this key under the destination prefix",
+				Destination: &checksumsFile,
+			},
+			&cli.StringFlag{
+				Name:        "checksums-algorithm",
+				Usage:       "Hash algorithm of --checksums-file: md5, sha1, sha256 or sha512",
+				Value:       "sha256",
+				Destination: &checksumsAlgorithm,
+			},
 			&cli.BoolFlag{
 				Name:        "quiet",
 				Usage:       "Suppress non-error output",
@@ -134,18 +579,54 @@ Supports gitignore-style file filtering for selective copying.`,
 				Usage:       "Enable verbose output",
 				Destination: &verbose,
 			},
+			&cli.BoolFlag{
+				Name:        "summary-only",
+				Usage:       "Print the end-of-run summary but no per-file lines; combined with --quiet it brings back only the summary",
+				Destination: &summaryOnly,
+			},
+			&cli.BoolFlag{
+				Name:        "progress",
+				Usage:       "Print periodic progress lines (every 10s unless --progress-interval is set), also with --quiet",
+				Destination: &showProgress,
+			},
 			&cli.IntFlag{
 				Name:        "timeout",
 				Usage:       "Timeout for operations in seconds (0 for no timeout)",
 				Value:       0,
 				Destination: &timeout,
 			},
+			&cli.StringFlag{
+				Name:        "checksum-out",
+				Usage:       "Write the MD5 of an upload from stdin or a URL to this file (- for stderr) and store it as local-md5 metadata",
+				Destination: &checksumOut,
+			},
+			&cli.IntFlag{
+				Name:        "per-file-timeout",
+				Usage:       "Timeout for each individual file transfer in seconds (0 for no timeout)",
+				Value:       0,
+				Destination: &perFileTimeout,
+			},
+			&cli.BoolFlag{
+				Name:        "debug-http",
+				Usage:       "Log every S3 request and response to stderr, with the Authorization header redacted",
+				Destination: &debugHTTP,
+			},
+			&cli.BoolFlag{
+				Name:        "prefer-ipv4",
+				Usage:       "Connect to the S3 endpoint over IPv4 only, for networks where IPv6 connections stall",
+				Destination: &preferIPv4,
+			},
 			&cli.IntFlag{
 				Name:        "retries",
 				Usage:       "Number of retry attempts for failed operations",
 				Value:       3,
 				Destination: &retries,
 			},
+			&cli.StringFlag{
+				Name:        "retry-deadline",
+				Usage:       "Stop retrying an S3 request once this much time passed since its first attempt (e.g. 2m), even when --retries allows more",
+				Destination: &retryDeadline,
+			},
 			&cli.BoolFlag{
 				Name:        "force",
 				Aliases:     []string{"force-overwrite"},
@@ -157,47 +638,928 @@ Supports gitignore-style file filtering for selective copying.`,
 				Usage:       "Sync mode: makes destination directory exactly match source directory (one-way sync)",
 				Destination: &syncMode,
 			},
+			&cli.BoolFlag{
+				Name:        "mirror-add",
+				Usage:       "Download only S3 objects that are missing locally, never overwriting or deleting local files",
+				Destination: &mirrorAdd,
+			},
+			&cli.BoolFlag{
+				Name:        "move",
+				Usage:       "Move objects within S3 using a server-side copy followed by a delete of the source",
+				Destination: &moveMode,
+			},
 			&cli.StringFlag{
 				Name:        "sync-compare",
 				Usage:       "Sync compare strategy: checksum (default) or size-time",
 				Value:       "checksum",
 				Destination: &syncCompare,
 			},
+			&cli.StringFlag{
+				Name:        "sync-delete-scope",
+				Usage:       "Local files an S3 to local sync may delete: all (every file missing on S3) or tracked (only files previously written by s3copy)",
+				Value:       "all",
+				Destination: &syncDeleteScope,
+			},
+			&cli.StringFlag{
+				Name:        "since-etag",
+				Usage:       "State file with the ETags of the previous sync; only files whose ETag, size or modification time changed since then are compared",
+				Destination: &sinceETag,
+			},
+			&cli.BoolFlag{
+				Name:        "sync-metadata",
+				Usage:       "In sync mode, update the Content-Type and Cache-Control of unchanged objects with a metadata-only copy when they differ from --content-type and --cache-control",
+				Destination: &syncMetadata,
+			},
+			&cli.BoolFlag{
+				Name:        "scrub",
+				Usage:       "In an S3 to local sync, verify every local file against the full content of its object and download it again when it differs",
+				Destination: &scrubMode,
+			},
+			&cli.BoolFlag{
+				Name:        "exclude-existing",
+				Usage:       "Skip uploading files whose destination key already exists on S3, without comparing checksums",
+				Destination: &excludeExisting,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-same-size",
+				Usage:       "Skip files whose destination already exists with the same size, without comparing checksums (faster but less safe)",
+				Destination: &skipSameSize,
+			},
+			&cli.BoolFlag{
+				Name:        "preflight",
+				Usage:       "Write and delete a test object in every upload destination before enumerating the source, to fail fast without write access",
+				Destination: &preflight,
+			},
+			&cli.BoolFlag{
+				Name:        "if-none-match",
+				Usage:       "Send If-None-Match: * on uploads so the server skips keys that already exist without a separate HEAD request",
+				Destination: &ifNoneMatch,
+			},
+			&cli.BoolFlag{
+				Name:        "assume-exists-on-403",
+				Usage:       "Treat a 403 from the existence check (HeadObject) as an existing object that cannot be compared instead of an error",
+				Destination: &assumeExistsOn403,
+			},
+			&cli.StringFlag{
+				Name:        "assume-exists-action",
+				Usage:       "What --assume-exists-on-403 does with such objects: skip (default) or upload",
+				Value:       "skip",
+				Destination: &assumeExistsAction,
+			},
+			&cli.StringFlag{
+				Name:        "source-root",
+				Usage:       "Local directory S3 keys are computed relative to when uploading, regardless of how the source path or glob is written",
+				Destination: &sourceRoot,
+			},
+			&cli.BoolFlag{
+				Name:        "date-prefix",
+				Usage:       "Insert a partition derived from each file's modification time (UTC) between the destination prefix and the file path",
+				Destination: &datePrefix,
+			},
+			&cli.StringFlag{
+				Name:        "date-prefix-template",
+				Usage:       "Partition template for --date-prefix with {year}, {month}, {day}, {hour} and {minute} placeholders",
+				Value:       defaultDatePrefixTemplate,
+				Destination: &datePrefixTemplate,
+			},
+			&cli.BoolFlag{
+				Name:        "lowercase-keys",
+				Usage:       "Normalize computed S3 keys to lowercase during upload and fail on key collisions",
+				Destination: &lowercaseKeys,
+			},
+			&cli.StringFlag{
+				Name:        "normalize-unicode",
+				Usage:       "Normalize the Unicode form of keys and relative paths during upload and sync: nfc or nfd",
+				Destination: &normalizeUnicode,
+			},
+			&cli.StringSliceFlag{
+				Name:        "if-metadata",
+				Usage:       "Only download objects whose user metadata has this key=value; repeat to require several entries",
+				Destination: &ifMetadata,
+			},
+			&cli.StringFlag{
+				Name:        "name-from-metadata",
+				Usage:       "Name downloaded files after this user metadata entry (e.g. original-filename) when the object has it, instead of the key's base name",
+				Destination: &nameFromMetadata,
+			},
+			&cli.BoolFlag{
+				Name:        "unflatten",
+				Usage:       "Restore downloaded files to the relative path a --flatten upload stored in their original-path metadata",
+				Destination: &unflatten,
+			},
+			&cli.BoolFlag{
+				Name:        "check-free-space",
+				Usage:       "Before a download, fail when the destination volume has less free space than the objects need",
+				Destination: &checkFreeSpace,
+			},
+			&cli.StringFlag{
+				Name:        "min-free",
+				Usage:       "Free space to keep on the destination volume on top of the objects (e.g. 5GB), implies --check-free-space",
+				Destination: &minFree,
+			},
+			&cli.StringFlag{
+				Name:        "dir-mode",
+				Usage:       "Octal permissions of directories created by downloads (e.g. 0700); default 0755 reduced by the umask",
+				Destination: &dirMode,
+			},
+			&cli.StringFlag{
+				Name:        "file-mode",
+				Usage:       "Octal permissions of downloaded files (e.g. 0600)",
+				Destination: &fileMode,
+			},
+			&cli.BoolFlag{
+				Name:        "group-output",
+				Usage:       "Print the log lines of a prefix download grouped by top-level prefix, one block per prefix when it completes",
+				Destination: &groupOutput,
+			},
+			&cli.StringFlag{
+				Name:        "filter-cmd",
+				Usage:       "Shell command to pipe each downloaded object through (stdin to stdout) before writing to disk",
+				Destination: &filterCmd,
+			},
+			&cli.BoolFlag{
+				Name:        "config-stdin",
+				Usage:       "Read connection settings and operation parameters as a JSON document from stdin",
+				Destination: &configStdin,
+			},
+			&cli.StringFlag{
+				Name:        "multipart-threshold",
+				Usage:       "Object size above which uploads use multipart (e.g. 64MB); default is the SDK default of 16MB",
+				Destination: &multipartThreshold,
+			},
+			&cli.StringFlag{
+				Name:        "content-type",
+				Usage:       "Content-Type header for uploaded objects, or auto to derive it from the file extension",
+				Destination: &contentType,
+			},
+			&cli.StringFlag{
+				Name:        "cache-control",
+				Usage:       "Cache-Control header for uploaded objects (e.g. max-age=3600)",
+				Destination: &cacheControl,
+			},
+			&cli.StringFlag{
+				Name:        "content-language",
+				Usage:       "Content-Language header for uploaded objects, one or more comma-separated BCP 47 tags (e.g. de-CH)",
+				Destination: &contentLanguage,
+			},
+			&cli.StringFlag{
+				Name:        "content-disposition",
+				Usage:       "Content-Disposition header for uploaded objects (e.g. attachment or inline; filename=\"report.pdf\")",
+				Destination: &contentDisposition,
+			},
+			&cli.StringFlag{
+				Name:        "run-id",
+				Usage:       "Identifier of this run, stored as run-id metadata on every uploaded object and in the error report and completion marker",
+				Destination: &runID,
+			},
+			&cli.StringFlag{
+				Name:        "checksum-chunked",
+				Usage:       "Checksum algorithm S3 validates for every uploaded part: crc32, crc32c, sha1 or sha256 (default: the SDK default, crc32)",
+				Destination: &checksumChunked,
+			},
+			&cli.StringFlag{
+				Name:        "progress-interval",
+				Usage:       "Print a one-line progress summary at this interval (e.g. 30s), for logs where a live progress display is not useful",
+				Destination: &progressInterval,
+			},
+			&cli.StringFlag{
+				Name:        "order",
+				Usage:       "Upload scheduling order for directories: walk (default), size-desc, or interleave",
+				Value:       "walk",
+				Destination: &uploadOrder,
+			},
+			&cli.BoolFlag{
+				Name:        "pack",
+				Usage:       "Upload small files of a directory together in tar pack objects, which downloads unpack automatically",
+				Destination: &packFiles,
+			},
+			&cli.StringFlag{
+				Name:        "pack-threshold",
+				Usage:       "Files smaller than this size are packed with --pack (default 256KB)",
+				Destination: &packThreshold,
+			},
 		},
+		DisableSliceFlagSeparator: true,
 		Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+			selectDestination()
+
+			recordFlagSources(cmd.IsSet)
+
+			if configStdin {
+				if err := loadJSONConfig(os.Stdin, cmd); err != nil {
+					return ctx, err
+				}
+			}
+
+			if err := expandPathArguments(); err != nil {
+				return ctx, err
+			}
+
+			if printConfigFor != "" {
+				return ctx, validateToolConfig(printConfigFor)
+			}
+			if includeSecrets {
+				return ctx, fmt.Errorf("include-secrets can only be used with --print-config-for")
+			}
+
+			if err := validateUnicodeForm(normalizeUnicode); err != nil {
+				return ctx, err
+			}
+
 			if maxWorkers < 1 {
 				return ctx, fmt.Errorf("max-workers must be at least 1")
 			}
 
-			if syncCompare != "checksum" && syncCompare != "size-time" {
-				return ctx, fmt.Errorf("sync-compare must be one of: checksum, size-time")
+			if maxListConcurrency < 1 {
+				return ctx, fmt.Errorf("max-list-concurrency must be at least 1")
 			}
 
-			if password == "" && cmd.IsSet("password") {
-				password = "PROMPT"
+			if concurrencyPerEndpoint != "" {
+				limiter, err := parseEndpointConcurrency(concurrencyPerEndpoint)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid concurrency-per-endpoint: %w", err)
+				}
+				endpointSlots = limiter
 			}
 
-			if !listObjects {
-				if source == "" {
-					return ctx, fmt.Errorf("source is required when not listing objects")
-				}
-				if destination == "" {
-					return ctx, fmt.Errorf("destination is required when not listing objects")
+			if regionPerEndpoint != "" {
+				regions, err := parseEndpointRegions(regionPerEndpoint)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid region-per-endpoint: %w", err)
 				}
+				endpointRegions = regions
+			}
 
-				if syncMode {
-					sourceIsS3 := strings.HasPrefix(source, "s3://")
-					destIsS3 := strings.HasPrefix(destination, "s3://")
+			if adaptiveConcurrency {
+				adaptiveSlots = newAdaptiveLimiter(maxWorkers)
+			}
 
-					if sourceIsS3 && destIsS3 {
-						return ctx, fmt.Errorf("S3 to S3 sync is not supported")
-					}
+			if maxOpenFiles < 0 {
+				return ctx, fmt.Errorf("max-open-files must not be negative")
+			}
+			if maxOpenFiles > 0 {
+				openFileSlots = newOpenFileLimiter(maxOpenFiles)
+			}
 
-					if !sourceIsS3 && !destIsS3 {
-						return ctx, fmt.Errorf("at least one of source or destination must be S3 in sync mode")
+			if bwlimit != "" || bwlimitSchedule != "" {
+				var schedule bandwidthSchedule
+				if bwlimit != "" {
+					rate, err := parseBandwidthRate(bwlimit)
+					if err != nil {
+						return ctx, fmt.Errorf("invalid bwlimit: %w", err)
+					}
+					schedule.fallback = rate
+				}
+				if bwlimitSchedule != "" {
+					windows, err := parseBandwidthSchedule(bwlimitSchedule)
+					if err != nil {
+						return ctx, fmt.Errorf("invalid bwlimit-schedule: %w", err)
 					}
+					schedule.windows = windows
+				}
+				bandwidth = newBandwidthLimiter(schedule)
+			}
 
-					if !sourceIsS3 {
+			if syncCompare != "checksum" && syncCompare != "size-time" {
+				return ctx, fmt.Errorf("sync-compare must be one of: checksum, size-time")
+			}
+
+			if err := validateIntegrityPolicy(integrityPolicy); err != nil {
+				return ctx, err
+			}
+
+			if syncDeleteScope != "all" && syncDeleteScope != "tracked" {
+				return ctx, fmt.Errorf("sync-delete-scope must be one of: all, tracked")
+			}
+
+			if sinceETag != "" && !syncMode {
+				return ctx, fmt.Errorf("since-etag can only be used with --sync")
+			}
+
+			if syncMetadata {
+				if !syncMode {
+					return ctx, fmt.Errorf("sync-metadata can only be used with --sync")
+				}
+				if contentType == "" && cacheControl == "" && contentLanguage == "" && contentDisposition == "" {
+					return ctx, fmt.Errorf("sync-metadata requires --content-type, --cache-control, --content-language or --content-disposition")
+				}
+				if !strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("sync-metadata requires an S3 destination")
+				}
+			}
+
+			if completionMarker != "" && !strings.HasPrefix(destination, "s3://") {
+				return ctx, fmt.Errorf("completion-marker requires an S3 destination")
+			}
+
+			if _, ok := checksumsAlgorithms[checksumsAlgorithm]; !ok {
+				return ctx, fmt.Errorf("invalid checksums-algorithm %q, use md5, sha1, sha256 or sha512", checksumsAlgorithm)
+			}
+			if cmd.IsSet("checksums-algorithm") && checksumsFile == "" {
+				return ctx, fmt.Errorf("checksums-algorithm requires --checksums-file")
+			}
+			if checksumsFile != "" {
+				if strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") || source == stdinSource || isHTTPSource(source) {
+					return ctx, fmt.Errorf("checksums-file can only be used when uploading a local directory to S3")
+				}
+				if !recursive && !syncMode {
+					return ctx, fmt.Errorf("checksums-file requires -r or --sync")
+				}
+				if strings.HasPrefix(checksumsFile, "s3://") || strings.HasSuffix(checksumsFile, "/") {
+					return ctx, fmt.Errorf("checksums-file must be a key under the destination prefix, e.g. SHA256SUMS")
+				}
+				if encrypt || packFiles || moveMode {
+					return ctx, fmt.Errorf("checksums-file cannot be combined with --encrypt, --pack or --move")
+				}
+			}
+
+			if scrubMode {
+				if !syncMode || !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("scrub can only be used with --sync from S3 to a local directory")
+				}
+				if sinceETag != "" || encrypt || filterCmd != "" {
+					return ctx, fmt.Errorf("scrub cannot be combined with --since-etag, --encrypt or --filter-cmd")
+				}
+			}
+
+			if ifNoneMatch && forceOverwrite {
+				return ctx, fmt.Errorf("if-none-match cannot be combined with --force")
+			}
+
+			if ifNoneMatch && syncMode {
+				return ctx, fmt.Errorf("if-none-match cannot be combined with sync mode")
+			}
+
+			if uploadOrder != "walk" && uploadOrder != "size-desc" && uploadOrder != "interleave" {
+				return ctx, fmt.Errorf("order must be one of: walk, size-desc, interleave")
+			}
+
+			if retryOnChecksumMismatch && !verifyDownloads && spotCheck == 0 {
+				return ctx, fmt.Errorf("retry-on-checksum-mismatch can only be used with --verify or --spot-check")
+			}
+
+			if spotCheck < 0 {
+				return ctx, fmt.Errorf("spot-check must not be negative")
+			}
+			if spotCheck > 0 && strings.HasPrefix(source, "s3://") == strings.HasPrefix(destination, "s3://") {
+				return ctx, fmt.Errorf("spot-check can only be used when uploading to or downloading from S3")
+			}
+			if spotCheck > 0 && (syncMode || len(uploadDestinations()) > 1 || source == stdinSource || isHTTPSource(source)) {
+				return ctx, fmt.Errorf("spot-check cannot be combined with --sync, multiple destinations, stdin or HTTP sources")
+			}
+
+			if packThreshold != "" && !packFiles {
+				return ctx, fmt.Errorf("pack-threshold can only be used with --pack")
+			}
+			if packFiles {
+				if strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("pack can only be used when uploading to S3")
+				}
+				if syncMode || moveMode || encrypt || datePrefix || excludeExisting {
+					return ctx, fmt.Errorf("pack cannot be combined with --sync, --move, --encrypt, --date-prefix or --exclude-existing")
+				}
+				packThresholdBytes = defaultPackThreshold
+				if packThreshold != "" {
+					threshold, err := parseByteSize(packThreshold)
+					if err != nil {
+						return ctx, fmt.Errorf("invalid pack-threshold: %w", err)
+					}
+					if threshold < 1 {
+						return ctx, fmt.Errorf("pack-threshold must be greater than zero")
+					}
+					packThresholdBytes = threshold
+				}
+			}
+
+			if mapFile != "" {
+				if strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") || isHTTPSource(source) {
+					return ctx, fmt.Errorf("map-file can only be used when uploading local files to S3")
+				}
+				if syncMode || moveMode || packFiles || datePrefix || excludeExisting || len(destinations) > 1 {
+					return ctx, fmt.Errorf("map-file cannot be combined with --sync, --move, --pack, --date-prefix, --exclude-existing or multiple destinations")
+				}
+				mappings, err := readMapFile(mapFile)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid map-file %s: %w", mapFile, err)
+				}
+				uploadMappings = mappings
+			}
+
+			if expires != "" {
+				parsed, err := parseExpires(expires, time.Now())
+				if err != nil {
+					return ctx, err
+				}
+				expiresAt = parsed
+			}
+
+			if expireTag != "" {
+				if tagKey, _, found := strings.Cut(expireTag, "="); !found || tagKey == "" {
+					return ctx, fmt.Errorf("expire-tag must be in key=value format")
+				}
+			}
+
+			if contentLanguage != "" {
+				header, err := parseContentLanguage(contentLanguage)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid content-language: %w", err)
+				}
+				contentLanguage = header
+			}
+
+			if contentDisposition != "" {
+				if err := validateContentDisposition(contentDisposition); err != nil {
+					return ctx, fmt.Errorf("invalid content-disposition: %w", err)
+				}
+			}
+
+			if runID != "" {
+				if err := validateRunID(runID); err != nil {
+					return ctx, fmt.Errorf("invalid run-id: %w", err)
+				}
+			}
+
+			if grantFullControl != "" {
+				header, err := parseGrantees(grantFullControl)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid grant-full-control: %w", err)
+				}
+				grantFullControlHeader = header
+			}
+
+			if grantRead != "" {
+				header, err := parseGrantees(grantRead)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid grant-read: %w", err)
+				}
+				grantReadHeader = header
+			}
+
+			if listCSV != "" && !listObjects {
+				return ctx, fmt.Errorf("csv can only be used with --list")
+			}
+
+			if (startAfter != "" || listLimit != 0) && !listObjects {
+				return ctx, fmt.Errorf("start-after and limit can only be used with --list")
+			}
+
+			if listLimit < 0 {
+				return ctx, fmt.Errorf("limit must not be negative")
+			}
+
+			if dedupeByETag && (startAfter != "" || listLimit != 0) {
+				return ctx, fmt.Errorf("dedupe-by-etag cannot be combined with --start-after or --limit")
+			}
+
+			if listWithChecksum && (!listObjects || listCSV != "" || dedupeByETag) {
+				return ctx, fmt.Errorf("with-checksum can only be used with --list and cannot be combined with --csv or --dedupe-by-etag")
+			}
+
+			if byStorageClass && (!listObjects || listCSV != "" || dedupeByETag || listWithChecksum) {
+				return ctx, fmt.Errorf("by-storage-class can only be used with --list and cannot be combined with --csv, --dedupe-by-etag or --with-checksum")
+			}
+
+			if listTemplate != "" {
+				if !listObjects || listCSV != "" || dedupeByETag || byStorageClass || listDetailed {
+					return ctx, fmt.Errorf("list-template can only be used with --list and cannot be combined with --csv, --dedupe-by-etag, --by-storage-class or --detailed")
+				}
+				tmpl, err := parseListTemplate(listTemplate)
+				if err != nil {
+					return ctx, err
+				}
+				listTemplateParsed = tmpl
+			}
+
+			if dedupeByETag && (!listObjects || listCSV != "") {
+				return ctx, fmt.Errorf("dedupe-by-etag can only be used with --list and cannot be combined with --csv")
+			}
+
+			if multipartThreshold != "" {
+				threshold, err := parseByteSize(multipartThreshold)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid multipart-threshold: %w", err)
+				}
+				if threshold < 1 {
+					return ctx, fmt.Errorf("multipart-threshold must be greater than zero")
+				}
+				multipartThresholdBytes = threshold
+			}
+
+			if preflight && (strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://")) {
+				return ctx, fmt.Errorf("preflight can only be used when uploading to S3")
+			}
+
+			if mirrorAdd {
+				if !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("mirror-add can only be used when downloading from S3")
+				}
+				if syncMode || moveMode || forceOverwrite || nameFromMetadata != "" || groupOutput {
+					return ctx, fmt.Errorf("mirror-add cannot be combined with --sync, --move, --force, --name-from-metadata or --group-output")
+				}
+			}
+
+			if skipSameSize && (syncMode || forceOverwrite || moveMode) {
+				return ctx, fmt.Errorf("skip-same-size cannot be combined with --sync, --move or --force")
+			}
+
+			if nameFromMetadata != "" {
+				if syncMode || !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("name-from-metadata can only be used when downloading from S3 without sync mode")
+				}
+				nameFromMetadata = normalizeMetadataKey(nameFromMetadata)
+			}
+
+			if flatten {
+				if strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") || isHTTPSource(source) || source == stdinSource {
+					return ctx, fmt.Errorf("flatten can only be used when uploading local files to S3")
+				}
+				if syncMode || packFiles || treeHash || mapFile != "" {
+					return ctx, fmt.Errorf("flatten cannot be combined with --sync, --pack, --tree-hash or --map-file")
+				}
+			}
+
+			if unflatten {
+				if syncMode || !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("unflatten can only be used when downloading from S3 without sync mode")
+				}
+				if nameFromMetadata != "" || mirrorAdd || exactKey || downloadArchive != "" {
+					return ctx, fmt.Errorf("unflatten cannot be combined with --name-from-metadata, --mirror-add, --exact or --download-archive")
+				}
+			}
+
+			if minFree != "" {
+				margin, err := parseByteSize(minFree)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid min-free: %w", err)
+				}
+				minFreeBytes = margin
+				checkFreeSpace = true
+			}
+
+			if checkFreeSpace {
+				if !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("check-free-space can only be used when downloading from S3")
+				}
+				if downloadArchive != "" || catMode {
+					return ctx, fmt.Errorf("check-free-space cannot be combined with --download-archive or --cat")
+				}
+			}
+
+			if groupOutput && (syncMode || !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://")) {
+				return ctx, fmt.Errorf("group-output can only be used when downloading from S3 without sync mode")
+			}
+
+			if dirMode != "" {
+				mode, err := parseOctalMode(dirMode)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid dir-mode: %w", err)
+				}
+				downloadDirMode = mode
+			}
+
+			if fileMode != "" {
+				mode, err := parseOctalMode(fileMode)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid file-mode: %w", err)
+				}
+				downloadFileMode = mode
+			}
+
+			if len(ifMetadata) > 0 {
+				conditions, err := parseMetadataConditions(ifMetadata)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid if-metadata: %w", err)
+				}
+				metadataConditions = conditions
+			}
+
+			if retryDeadline != "" {
+				deadline, err := time.ParseDuration(retryDeadline)
+				if err != nil || deadline <= 0 {
+					return ctx, fmt.Errorf("invalid retry-deadline %q, use a positive duration like 2m", retryDeadline)
+				}
+				retryDeadlineDuration = deadline
+			}
+
+			if progressInterval != "" {
+				interval, err := time.ParseDuration(progressInterval)
+				if err != nil || interval <= 0 {
+					return ctx, fmt.Errorf("invalid progress-interval %q, use a positive duration like 30s", progressInterval)
+				}
+				progressPeriod = interval
+			}
+
+			if checksumChunked != "" {
+				algorithm, err := parseChecksumAlgorithm(checksumChunked)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid checksum-chunked: %w", err)
+				}
+				uploadChecksumAlgorithm = algorithm
+			}
+
+			if encryptionMemory != "" {
+				if !encrypt {
+					return ctx, fmt.Errorf("encryption-memory can only be used with --encrypt")
+				}
+				memory, err := parseByteSize(encryptionMemory)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid encryption-memory: %w", err)
+				}
+				if memory < 1 {
+					return ctx, fmt.Errorf("encryption-memory must be greater than zero")
+				}
+				chunkBuffers = newChunkBufferPool(chunkBudget(memory))
+			}
+
+			if trailingChecksum && !encrypt {
+				return ctx, fmt.Errorf("trailing-checksum can only be used with --encrypt")
+			}
+
+			if password == "" && cmd.IsSet("password") {
+				password = "PROMPT"
+			}
+
+			if recipientFile != "" {
+				key, err := loadRecipientKey(recipientFile)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid recipient-file: %w", err)
+				}
+				recipientKey = key
+			}
+
+			if identityFile != "" {
+				key, err := loadIdentityKey(identityFile)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid identity-file: %w", err)
+				}
+				identityKey = key
+			}
+
+			if passwordMap != "" {
+				if !encrypt && !verifyEncryption {
+					return ctx, fmt.Errorf("password-map can only be used with --encrypt or --verify-encryption")
+				}
+				if recipientFile != "" || identityFile != "" {
+					return ctx, fmt.Errorf("password-map cannot be combined with recipient-file or identity-file")
+				}
+				rules, err := readPasswordMap(passwordMap)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid password-map: %w", err)
+				}
+				passwordRules = rules
+			}
+
+			if usesRecipientKeys() {
+				if password != "" {
+					return ctx, fmt.Errorf("recipient-file and identity-file cannot be combined with --password")
+				}
+				if !encrypt && !verifyEncryption && !restoreFromIndex {
+					return ctx, fmt.Errorf("recipient-file and identity-file can only be used with --encrypt, --verify-encryption or --restore-from-index")
+				}
+			}
+
+			if configStdin && needsPasswordPrompt() {
+				return ctx, fmt.Errorf("config-stdin reads stdin, so the password cannot be prompted for; set password in the JSON config")
+			}
+
+			if resumeUploads && encrypt {
+				return ctx, fmt.Errorf("resume cannot be combined with --encrypt")
+			}
+
+			if resumeUploads && len(destinations) > 1 {
+				return ctx, fmt.Errorf("resume cannot be combined with multiple destinations")
+			}
+
+			if assumeExistsAction != "skip" && assumeExistsAction != "upload" {
+				return ctx, fmt.Errorf("invalid assume-exists-action %q, use skip or upload", assumeExistsAction)
+			}
+			if cmd.IsSet("assume-exists-action") && !assumeExistsOn403 {
+				return ctx, fmt.Errorf("assume-exists-action requires --assume-exists-on-403")
+			}
+
+			if encryptExt != "" {
+				if !encrypt {
+					return ctx, fmt.Errorf("encrypt-ext can only be used with --encrypt")
+				}
+				extensions, err := parseEncryptExtensions(encryptExt)
+				if err != nil {
+					return ctx, fmt.Errorf("invalid encrypt-ext: %w", err)
+				}
+				encryptExtensions = extensions
+			}
+
+			if storeHMAC && !encrypt {
+				return ctx, fmt.Errorf("hmac can only be used with --encrypt")
+			}
+
+			if isHTTPSource(source) {
+				if !strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("an HTTP source can only be uploaded to S3")
+				}
+				if syncMode || moveMode || storeHMAC || resumeUploads || packFiles || treeHash || len(destinations) > 1 {
+					return ctx, fmt.Errorf("an HTTP source cannot be combined with --sync, --move, --hmac, --resume, --pack, --tree-hash or multiple destinations")
+				}
+			}
+
+			if source == stdinSource {
+				if !strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("stdin can only be uploaded to S3")
+				}
+				if configStdin {
+					return ctx, fmt.Errorf("stdin cannot be the source with --config-stdin")
+				}
+				if syncMode || moveMode || storeHMAC || resumeUploads || packFiles || treeHash || len(destinations) > 1 {
+					return ctx, fmt.Errorf("stdin cannot be combined with --sync, --move, --hmac, --resume, --pack, --tree-hash or multiple destinations")
+				}
+			}
+
+			if checksumOut != "" && source != stdinSource && !isHTTPSource(source) {
+				return ctx, fmt.Errorf("checksum-out can only be used when uploading stdin or an HTTP source")
+			}
+
+			if incompleteOlderThan != "" {
+				age, err := parseDayDuration(incompleteOlderThan)
+				if err != nil || age <= 0 {
+					return ctx, fmt.Errorf("invalid incomplete-older-than %q, use a positive duration like 24h or 7d", incompleteOlderThan)
+				}
+				incompleteMinAge = age
+			}
+
+			if listIncomplete || abortIncomplete {
+				if bucket == "" {
+					return ctx, fmt.Errorf("bucket is required when listing incomplete uploads")
+				}
+				return ctx, nil
+			}
+
+			if incompleteOlderThan != "" {
+				return ctx, fmt.Errorf("incomplete-older-than can only be used with --list-incomplete-uploads or --abort-incomplete")
+			}
+
+			if restoreFromIndex {
+				if localEncryptionIndex == "" {
+					return ctx, fmt.Errorf("restore-from-index requires --local-encryption-index")
+				}
+				if destination == "" || strings.HasPrefix(destination, "s3://") {
+					return ctx, fmt.Errorf("restore-from-index requires a local destination directory")
+				}
+				encrypt = true
+				return ctx, nil
+			}
+
+			if localEncryptionIndex != "" && !encrypt {
+				return ctx, fmt.Errorf("local-encryption-index can only be used with --encrypt or --restore-from-index")
+			}
+
+			if catRange != "" {
+				if !catMode {
+					return ctx, fmt.Errorf("range can only be used with --cat")
+				}
+				if encrypt {
+					return ctx, fmt.Errorf("range cannot be combined with --encrypt, encrypted objects can only be decrypted as a whole")
+				}
+				if _, err := parseByteRange(catRange); err != nil {
+					return ctx, err
+				}
+			}
+
+			if treeHashMarker != "" && !treeHash {
+				return ctx, fmt.Errorf("tree-hash-marker can only be used with --tree-hash")
+			}
+
+			if treeHash {
+				if source == "" || strings.HasPrefix(source, "s3://") {
+					return ctx, fmt.Errorf("tree-hash requires a local source directory")
+				}
+				if info, err := os.Stat(source); err != nil || !info.IsDir() {
+					return ctx, fmt.Errorf("tree-hash requires a local source directory")
+				}
+				if treeHashMarker != "" && !strings.HasPrefix(treeHashMarker, "s3://") {
+					return ctx, fmt.Errorf("tree-hash-marker must be an S3 URI (s3://bucket/key)")
+				}
+				return ctx, nil
+			}
+
+			if catMode {
+				if !strings.HasPrefix(source, "s3://") {
+					return ctx, fmt.Errorf("cat requires an S3 source")
+				}
+				return ctx, nil
+			}
+
+			if continueOnError {
+				if !strings.HasPrefix(source, "s3://") || (strings.HasPrefix(destination, "s3://") && !moveMode) {
+					return ctx, fmt.Errorf("continue-on-error can only be used when downloading from S3 or with --move")
+				}
+				if syncMode || exactKey || downloadArchive != "" {
+					return ctx, fmt.Errorf("continue-on-error cannot be combined with --sync, which always continues, --exact or --download-archive")
+				}
+			}
+
+			if exactKey {
+				if !strings.HasPrefix(source, "s3://") {
+					return ctx, fmt.Errorf("exact requires an S3 source")
+				}
+				if recursive || syncMode || moveMode || downloadArchive != "" {
+					return ctx, fmt.Errorf("exact downloads a single object and cannot be combined with --recursive, --sync, --move or --download-archive")
+				}
+			}
+
+			if downloadArchive != "" {
+				if !strings.HasPrefix(source, "s3://") {
+					return ctx, fmt.Errorf("download-archive requires an S3 source")
+				}
+				if destination != "" {
+					return ctx, fmt.Errorf("download-archive replaces --destination, do not set both")
+				}
+				if syncMode || moveMode || mirrorAdd {
+					return ctx, fmt.Errorf("download-archive cannot be combined with --sync, --move or --mirror-add")
+				}
+				if _, err := archiveFormatFor(downloadArchive); err != nil {
+					return ctx, err
+				}
+				return ctx, nil
+			}
+
+			if verifyEncryption {
+				if !strings.HasPrefix(source, "s3://") {
+					return ctx, fmt.Errorf("verify-encryption requires an S3 source")
+				}
+				return ctx, nil
+			}
+
+			if !listObjects {
+				if source == "" {
+					return ctx, fmt.Errorf("source is required when not listing objects")
+				}
+				if destination == "" {
+					return ctx, fmt.Errorf("destination is required when not listing objects")
+				}
+
+				if verifyManifestPath != "" && (syncMode || !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://")) {
+					return ctx, fmt.Errorf("verify-manifest can only be used when downloading from S3")
+				}
+
+				if sourceRoot != "" {
+					if syncMode || strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") {
+						return ctx, fmt.Errorf("source-root can only be used when uploading to S3 without sync mode")
+					}
+					if info, err := os.Stat(sourceRoot); err != nil || !info.IsDir() {
+						return ctx, fmt.Errorf("source-root must be an existing directory")
+					}
+				}
+
+				if datePrefix {
+					if syncMode || strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") {
+						return ctx, fmt.Errorf("date-prefix can only be used when uploading to S3 without sync mode")
+					}
+					if err := validateDatePrefixTemplate(datePrefixTemplate); err != nil {
+						return ctx, fmt.Errorf("invalid date-prefix-template: %w", err)
+					}
+				} else if cmd.IsSet("date-prefix-template") {
+					return ctx, fmt.Errorf("date-prefix-template requires --date-prefix")
+				}
+
+				if len(metadataConditions) > 0 && (syncMode || !strings.HasPrefix(source, "s3://") || strings.HasPrefix(destination, "s3://")) {
+					return ctx, fmt.Errorf("if-metadata can only be used when downloading from S3")
+				}
+
+				if moveMode {
+					if syncMode {
+						return ctx, fmt.Errorf("move cannot be combined with sync mode")
+					}
+					if !strings.HasPrefix(source, "s3://") || !strings.HasPrefix(destination, "s3://") || len(destinations) > 1 {
+						return ctx, fmt.Errorf("move requires an S3 source and a single S3 destination")
+					}
+				}
+
+				if len(destinations) > 1 {
+					if syncMode {
+						return ctx, fmt.Errorf("multiple destinations are not supported in sync mode")
+					}
+					if strings.HasPrefix(source, "s3://") {
+						return ctx, fmt.Errorf("multiple destinations are only supported for uploads")
+					}
+					for _, dest := range destinations {
+						if !strings.HasPrefix(dest, "s3://") {
+							return ctx, fmt.Errorf("all destinations must be S3 paths when uploading to multiple destinations")
+						}
+					}
+				}
+
+				if syncMode {
+					sourceIsS3 := strings.HasPrefix(source, "s3://")
+					destIsS3 := strings.HasPrefix(destination, "s3://")
+
+					if sourceIsS3 && destIsS3 {
+						return ctx, fmt.Errorf("S3 to S3 sync is not supported")
+					}
+
+					if !sourceIsS3 && !destIsS3 {
+						return ctx, fmt.Errorf("at least one of source or destination must be S3 in sync mode")
+					}
+
+					if !sourceIsS3 {
 						if info, err := os.Stat(source); err != nil {
 							return ctx, fmt.Errorf("source directory does not exist: %w", err)
 						} else if !info.IsDir() {
@@ -226,29 +1588,39 @@ Supports gitignore-style file filtering for selective copying.`,
 			return runCopy()
 		},
 	}
+}
 
-	if err := app.Run(context.Background(), os.Args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+// selectDestination makes the first --destination the destination of the
+// run; the others are further upload targets
+func selectDestination() {
+	if len(destinations) > 0 {
+		destination = destinations[0]
 	}
 }
 
-func runCopy() error {
-	if err := godotenv.Load(envFile); err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Warning: Could not load %s file: %v\n", envFile, err)
+func runCopy() (err error) {
+	if !configStdin {
+		if envFile == "" {
+			envFile = findEnvFile()
 		}
+		loadEnvConfig(envFile)
 	}
+	resolveRegion()
 
-	config = Config{
-		Endpoint:     getEnvOrDefault("S3COPY_ENDPOINT", ""),
-		AccessKey:    getEnvOrDefault("S3COPY_ACCESS_KEY", ""),
-		SecretKey:    getEnvOrDefault("S3COPY_SECRET_KEY", ""),
-		Region:       getEnvOrDefault("S3COPY_REGION", "us-east-1"),
-		UsePathStyle: getEnvOrDefault("S3COPY_USE_PATH_STYLE", "false") == "true",
+	if printConfigFor != "" {
+		return writeToolConfig(os.Stdout, printConfigFor)
 	}
 
-	if config.AccessKey == "" || config.SecretKey == "" {
+	if showConfig || configOnly {
+		printEffectiveConfig()
+		if configOnly {
+			return nil
+		}
+	}
+
+	// --tree-hash without a marker only reads local files
+	needsS3 := !treeHash || treeHashMarker != ""
+	if needsS3 && (config.AccessKey == "" || config.SecretKey == "") {
 		return fmt.Errorf("missing required environment variables (S3COPY_ACCESS_KEY, S3COPY_SECRET_KEY)")
 	}
 
@@ -256,38 +1628,163 @@ func runCopy() error {
 		return fmt.Errorf("error initializing ignore patterns: %w", err)
 	}
 
+	if treeHash {
+		if err := printTreeHash(context.Background()); err != nil {
+			return fmt.Errorf("error computing tree hash: %w", err)
+		}
+		return nil
+	}
+
+	if listIncomplete || abortIncomplete {
+		if err := handleIncompleteUploads(context.Background()); err != nil {
+			return fmt.Errorf("error handling incomplete uploads: %w", err)
+		}
+		return nil
+	}
+
 	if listObjects {
+		if dedupeByETag {
+			if err := listDuplicateObjects(); err != nil {
+				return fmt.Errorf("error finding duplicate objects: %w", err)
+			}
+			return nil
+		}
+		if byStorageClass {
+			if err := listByStorageClass(); err != nil {
+				return fmt.Errorf("error listing storage classes: %w", err)
+			}
+			return nil
+		}
+		if listCSV != "" {
+			if err := exportS3ObjectsCSV(listCSV); err != nil {
+				return fmt.Errorf("error exporting objects: %w", err)
+			}
+			return nil
+		}
+		if listTemplateParsed != nil {
+			if err := listS3ObjectsTemplate(os.Stdout, listTemplateParsed); err != nil {
+				return fmt.Errorf("error listing objects: %w", err)
+			}
+			return nil
+		}
 		if err := listS3Objects(); err != nil {
 			return fmt.Errorf("error listing objects: %w", err)
 		}
 		return nil
 	}
 
-	if encrypt {
-		if password == "" || password == "PROMPT" {
-			var err error
-			password, err = getPasswordFromUser()
-			if err != nil {
-				return fmt.Errorf("error getting password: %w", err)
-			}
-			if password == "" {
-				return fmt.Errorf("empty password provided for encryption")
-			}
+	if needsPasswordPrompt() {
+		var err error
+		password, err = getPasswordFromUser()
+		if err != nil {
+			return fmt.Errorf("error getting password: %w", err)
+		}
+		if password == "" {
+			return fmt.Errorf("empty password provided for encryption")
 		}
 	}
 
+	report.reset()
+	if showReport {
+		defer report.print()
+	}
+	failures.reset()
+	if errorReport != "" {
+		defer func() {
+			if reportErr := writeErrorReport(errorReport, err); reportErr != nil && err == nil {
+				err = fmt.Errorf("error writing error report: %w", reportErr)
+			}
+		}()
+	}
+	defer startProgressReporter(currentOutputMode().progressInterval)()
+
 	ctx := context.Background()
 	if timeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
-		defer cancel()
+		var finish func(error) error
+		ctx, finish = withRunTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer func() {
+			err = finish(err)
+		}()
+	}
+
+	if restoreFromIndex {
+		if err := restoreFromEncryptionIndex(ctx); err != nil {
+			return fmt.Errorf("error restoring from index: %w", err)
+		}
+		logSummary("Restore operation completed successfully!\n")
+		return nil
+	}
+
+	if localEncryptionIndex != "" && !dryRun {
+		defer func() {
+			if indexErr := indexRecorder.save(localEncryptionIndex); indexErr != nil && err == nil {
+				err = fmt.Errorf("error writing encryption index: %w", indexErr)
+			}
+		}()
 	}
 
-	if syncMode {
+	if verifyEncryption {
+		if err := verifyEncryptedObjects(ctx); err != nil {
+			return fmt.Errorf("error verifying objects: %w", err)
+		}
+		return nil
+	}
+
+	if catMode {
+		if err := catS3Object(ctx, os.Stdout); err != nil {
+			return fmt.Errorf("error reading object: %w", err)
+		}
+		return nil
+	}
+
+	if downloadArchive != "" {
+		if err := downloadToArchive(ctx); err != nil {
+			return fmt.Errorf("error downloading to archive: %w", err)
+		}
+		logSummary("Archive download completed successfully!\n")
+		return nil
+	}
+
+	if completionMarker != "" {
+		defer func() {
+			if markerErr := writeCompletionMarker(ctx, err); markerErr != nil && err == nil {
+				err = fmt.Errorf("error writing completion marker: %w", markerErr)
+			}
+		}()
+	}
+
+	// deferred after the marker so it is written first, and the marker
+	// still signals a finished run
+	if checksumsFile != "" {
+		defer func() {
+			if checksumsErr := writeChecksumsFile(ctx, err); checksumsErr != nil && err == nil {
+				err = fmt.Errorf("error writing checksums file: %w", checksumsErr)
+			}
+		}()
+	}
+
+	if preflight && !dryRun {
+		if err := checkWriteAccess(ctx); err != nil {
+			return err
+		}
+	}
+
+	if syncMode || mirrorAdd {
 		if err := syncDirectories(ctx); err != nil {
+			if errors.Is(err, errNothingToDo) {
+				return err
+			}
 			return fmt.Errorf("error syncing directories: %w", err)
 		}
-		logInfo("Sync operation completed successfully!\n")
+		logSummary("Sync operation completed successfully!\n")
+		return nil
+	}
+
+	if moveMode {
+		if err := moveS3Objects(ctx); err != nil {
+			return fmt.Errorf("error moving objects: %w", err)
+		}
+		logSummary("Move operation completed successfully!\n")
 		return nil
 	}
 
@@ -312,6 +1809,11 @@ func runCopy() error {
 		}
 	}
 
-	logInfo("Copy operation completed successfully!\n")
+	if detailedExitCodes && !dryRun && report.transferredFiles() == 0 {
+		logInfo("Nothing to copy, all files were skipped\n")
+		return errNothingToDo
+	}
+
+	logSummary("Copy operation completed successfully!\n")
 	return nil
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -165,6 +169,60 @@ func TestRunCopyTimeout(t *testing.T) {
 	})
 }
 
+func TestRunCopyTimeoutReport(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(map[string]string{
+		"slow/data/a.txt": "fast",
+		"slow/data/b.txt": "never arrives",
+		"slow/data/c.txt": "not started",
+	})
+	server := useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/slow/data/b.txt" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	t.Setenv("S3COPY_ENDPOINT", server.URL)
+	t.Setenv("S3COPY_ACCESS_KEY", "access")
+	t.Setenv("S3COPY_SECRET_KEY", "secret")
+	t.Setenv("S3COPY_REGION", "us-east-1")
+	t.Setenv("S3COPY_USE_PATH_STYLE", "true")
+
+	destDir := t.TempDir()
+	setTestConfig("s3://slow/data/", destDir, "", false, true, true, false)
+	envFile = filepath.Join(t.TempDir(), "missing.env")
+	maxWorkers = 1
+	timeout = 1
+
+	var err error
+	captureStdout(func() {
+		err = runCopy()
+	})
+	require.Error(t, err)
+
+	var timedOut *runTimeoutError
+	require.ErrorAs(t, err, &timedOut)
+	assert.Equal(t, int64(3), timedOut.queued)
+	assert.Equal(t, int64(1), timedOut.completed)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, strings.HasPrefix(err.Error(), "operation timed out after 1s: 1 of 3 queued files finished, 2 cancelled or not started: "), err.Error())
+
+	entries, readErr := os.ReadDir(destDir)
+	require.NoError(t, readErr)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"a.txt"}, names, "the cancelled transfer leaves no temp file")
+}
+
 func TestGetEnvOrDefault(t *testing.T) {
 	t.Run("environment variable exists", func(t *testing.T) {
 		_ = os.Setenv("TEST_VAR", "test-value")
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// parseManifest reads a checksum manifest in md5sum format ("<md5>  <path>")
+// and returns the expected MD5 for each slash-separated relative path.
+// Blank lines and lines starting with # are ignored.
+func parseManifest(r io.Reader) (map[string]string, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	entries := make(map[string]string)
+	for lineNum, line := range contentLines(string(content)) {
+		checksum, path, found := strings.Cut(line, " ")
+		path = strings.TrimPrefix(strings.TrimLeft(path, " "), "*")
+		if !found || path == "" || len(checksum) != 32 {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", lineNum, line)
+		}
+
+		path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+		if _, exists := entries[path]; exists {
+			return nil, fmt.Errorf("duplicate manifest entry for %s on line %d", path, lineNum)
+		}
+		entries[path] = strings.ToLower(checksum)
+	}
+
+	return entries, nil
+}
+
+// verifyManifest checks every file listed in the manifest against the files
+// in localDir, hashing them in parallel, and reports mismatched or missing files
+func verifyManifest(ctx context.Context, manifestPath, localDir string) error {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %w", err)
+	}
+	entries, err := parseManifest(file)
+	closeWithLog(file, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	type verifyTask struct {
+		relPath  string
+		expected string
+	}
+
+	tasks := make([]verifyTask, 0, len(entries))
+	for relPath, expected := range entries {
+		tasks = append(tasks, verifyTask{relPath: relPath, expected: expected})
+	}
+
+	var mu sync.Mutex
+	var mismatched, missing []string
+
+	err = runWorkerPool(ctx, tasks, maxWorkers, func(_ context.Context, task verifyTask) error {
+		actual, hashErr := calculateFileMD5(filepath.Join(localDir, filepath.FromSlash(task.relPath)))
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case errors.Is(hashErr, os.ErrNotExist):
+			missing = append(missing, task.relPath)
+		case hashErr != nil:
+			return fmt.Errorf("failed to hash %s: %w", task.relPath, hashErr)
+		case actual != task.expected:
+			mismatched = append(mismatched, task.relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(mismatched) == 0 && len(missing) == 0 {
+		logSummary("Verified %d files against manifest %s\n", len(entries), manifestPath)
+		return nil
+	}
+
+	sort.Strings(mismatched)
+	sort.Strings(missing)
+	for _, relPath := range mismatched {
+		fmt.Fprintf(os.Stderr, "Checksum mismatch: %s\n", relPath)
+	}
+	for _, relPath := range missing {
+		fmt.Fprintf(os.Stderr, "Missing file: %s\n", relPath)
+	}
+
+	return fmt.Errorf("manifest verification failed: %d mismatched, %d missing", len(mismatched), len(missing))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	t.Run("md5sum format", func(t *testing.T) {
+		input := "# restore manifest\n" +
+			"d41d8cd98f00b204e9800998ecf8427e  empty.txt\n" +
+			"\n" +
+			"5D41402ABC4B2A76B9719D911017C592 *./docs/hello.txt\n"
+
+		entries, err := parseManifest(strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"empty.txt":      "d41d8cd98f00b204e9800998ecf8427e",
+			"docs/hello.txt": "5d41402abc4b2a76b9719d911017c592",
+		}, entries)
+	})
+
+	t.Run("invalid line", func(t *testing.T) {
+		_, err := parseManifest(strings.NewReader("not-a-checksum\n"))
+		assert.ErrorContains(t, err, "invalid manifest line 1")
+	})
+
+	t.Run("duplicate entry", func(t *testing.T) {
+		input := "d41d8cd98f00b204e9800998ecf8427e  a.txt\nd41d8cd98f00b204e9800998ecf8427e  a.txt\n"
+		_, err := parseManifest(strings.NewReader(input))
+		assert.ErrorContains(t, err, "duplicate manifest entry")
+	})
+}
+
+func TestVerifyManifest(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	maxWorkers = 3
+	quiet = true
+
+	localDir := t.TempDir()
+	files := map[string]string{
+		"a.txt":        "alpha",
+		"b.txt":        "bravo",
+		"nested/c.txt": "charlie",
+	}
+
+	var manifest strings.Builder
+	for relPath, content := range files {
+		fullPath := filepath.Join(localDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0644))
+
+		checksum, err := calculateFileMD5(fullPath)
+		require.NoError(t, err)
+		fmt.Fprintf(&manifest, "%s  %s\n", checksum, relPath)
+	}
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.txt")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest.String()), 0644))
+
+	t.Run("all files match", func(t *testing.T) {
+		err := verifyManifest(context.Background(), manifestPath, localDir)
+		assert.NoError(t, err)
+	})
+
+	t.Run("corrupted and missing files fail", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, "nested", "c.txt"), []byte("corrupted"), 0644))
+		require.NoError(t, os.Remove(filepath.Join(localDir, "b.txt")))
+
+		err := verifyManifest(context.Background(), manifestPath, localDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 mismatched, 1 missing")
+	})
+}
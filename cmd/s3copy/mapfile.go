@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// mapFileSeparator separates the pattern from the destination in a line of
+// a --map-file
+const mapFileSeparator = "=>"
+
+// uploadMapping is one rule of a --map-file: files whose relative path
+// matches pattern are uploaded to keyPattern, in bucket when the rule names
+// one and in the bucket of the destination otherwise
+type uploadMapping struct {
+	pattern    string
+	matcher    *ignore.GitIgnore
+	bucket     string
+	keyPattern string
+}
+
+// uploadMappings holds the rules of --map-file in file order
+var uploadMappings []uploadMapping
+
+// readMapFile parses a --map-file. Every line holds a pattern with the
+// syntax of --ignore, "=>" and a destination: a key pattern, or an
+// s3://bucket/key pattern to write to another bucket. The key pattern may use
+// {path}, {dir} and {name}; without {path} or {name} it is a prefix the
+// relative path is appended to. Empty lines and lines starting with # are
+// skipped.
+func readMapFile(filePath string) ([]uploadMapping, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings []uploadMapping
+	for lineNum, line := range contentLines(string(content)) {
+		mapping, err := parseUploadMapping(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+func parseUploadMapping(line string) (uploadMapping, error) {
+	pattern, dest, found := strings.Cut(line, mapFileSeparator)
+	pattern, dest = strings.TrimSpace(pattern), strings.TrimSpace(dest)
+	if !found || pattern == "" || dest == "" {
+		return uploadMapping{}, fmt.Errorf("expected \"pattern => destination\", got %q", line)
+	}
+	if strings.HasPrefix(pattern, "!") {
+		return uploadMapping{}, fmt.Errorf("negated pattern %q is not supported, list the more specific pattern first", pattern)
+	}
+
+	mapping := uploadMapping{pattern: pattern, matcher: ignore.CompileIgnoreLines(pattern), keyPattern: dest}
+	if strings.HasPrefix(dest, "s3://") {
+		mapping.bucket, mapping.keyPattern, _ = strings.Cut(strings.TrimPrefix(dest, "s3://"), "/")
+		if mapping.bucket == "" {
+			return uploadMapping{}, fmt.Errorf("invalid destination %q, use s3://bucket/key", dest)
+		}
+	}
+
+	unknown := strings.NewReplacer("{path}", "", "{dir}", "", "{name}", "").Replace(mapping.keyPattern)
+	if strings.ContainsAny(unknown, "{}") {
+		return uploadMapping{}, fmt.Errorf("unknown placeholder in %q, use {path}, {dir} or {name}", dest)
+	}
+	if strings.Contains(mapping.keyPattern, "{dir}") && !strings.Contains(mapping.keyPattern, "{name}") && !strings.Contains(mapping.keyPattern, "{path}") {
+		return uploadMapping{}, fmt.Errorf("destination %q uses {dir} without {name}, so files of a directory would share a key", dest)
+	}
+	return mapping, nil
+}
+
+// key renders the key pattern for a relative path
+func (m uploadMapping) key(relPath string) string {
+	if !strings.Contains(m.keyPattern, "{path}") && !strings.Contains(m.keyPattern, "{name}") {
+		return strings.TrimPrefix(path.Join(m.keyPattern, relPath), "/")
+	}
+	dir := path.Dir(relPath)
+	if dir == "." {
+		dir = ""
+	}
+	rendered := strings.NewReplacer("{path}", relPath, "{dir}", dir, "{name}", path.Base(relPath)).Replace(m.keyPattern)
+	return strings.TrimPrefix(path.Clean(rendered), "/")
+}
+
+// mapUploadTargets returns the targets of the first --map-file rule that
+// matches relPath, the path of a file relative to the uploaded directory or
+// its name for single files. Files no rule matches keep their targets.
+func mapUploadTargets(targets []uploadTarget, relPath string) []uploadTarget {
+	if len(uploadMappings) == 0 {
+		return targets
+	}
+
+	relKey := filepath.ToSlash(relPath)
+	for _, mapping := range uploadMappings {
+		if !mapping.matcher.MatchesPath(relKey) {
+			continue
+		}
+		logVerbose("Mapping %s with rule %q\n", relKey, mapping.pattern)
+		mapped := make([]uploadTarget, len(targets))
+		for i, target := range targets {
+			mapped[i] = uploadTarget{bucket: target.bucket, key: mapping.key(normalizeKeyPath(relKey))}
+			if mapping.bucket != "" {
+				mapped[i].bucket = mapping.bucket
+			}
+		}
+		return mapped
+	}
+	return targets
+}
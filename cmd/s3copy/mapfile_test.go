@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUploadMapping(t *testing.T) {
+	mapping, err := parseUploadMapping("*.log => s3://log-archive/app/{dir}/{name}")
+	require.NoError(t, err)
+	assert.Equal(t, "log-archive", mapping.bucket)
+	assert.Equal(t, "app/2025/server.log", mapping.key("2025/server.log"))
+	assert.Equal(t, "app/server.log", mapping.key("server.log"))
+
+	mapping, err = parseUploadMapping("images/** => media/")
+	require.NoError(t, err)
+	assert.Empty(t, mapping.bucket)
+	assert.Equal(t, "media/images/a/b.png", mapping.key("images/a/b.png"))
+
+	mapping, err = parseUploadMapping("*.csv => flat/{name}")
+	require.NoError(t, err)
+	assert.Equal(t, "flat/q1.csv", mapping.key("reports/2025/q1.csv"))
+
+	for _, line := range []string{
+		"*.log",
+		"=> logs/",
+		"*.log =>",
+		"!*.log => logs/",
+		"*.log => logs/{ext}",
+		"*.log => logs/{dir}",
+		"*.log => s3:///logs/",
+	} {
+		_, err := parseUploadMapping(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestMapFileUpload(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	srcDir := t.TempDir()
+	for relPath, content := range map[string]string{
+		"app.log":             "app",
+		"nested/worker.log":   "worker",
+		"nested/config.yaml":  "config",
+		"docs/readme.md":      "readme",
+		"docs/old/legacy.txt": "legacy",
+	} {
+		localPath := filepath.Join(srcDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+
+	mapPath := filepath.Join(t.TempDir(), "map.txt")
+	require.NoError(t, os.WriteFile(mapPath, []byte(`# logs go to their own bucket
+*.log => s3://logs/{path}
+
+/docs/old/ => archive/
+`), 0644))
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	setTestConfig(srcDir, "s3://data/current/", "", false, true, true, false)
+	mappings, err := readMapFile(mapPath)
+	require.NoError(t, err)
+	require.Len(t, mappings, 2)
+	uploadMappings = mappings
+
+	require.NoError(t, uploadToS3(context.Background()))
+	assert.Equal(t, map[string]string{
+		"logs/app.log":                     "app",
+		"logs/nested/worker.log":           "worker",
+		"data/current/nested/config.yaml":  "config",
+		"data/current/docs/readme.md":      "readme",
+		"data/archive/docs/old/legacy.txt": "legacy",
+	}, fake.contents())
+}
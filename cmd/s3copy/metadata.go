@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// metadataDiffers reports whether the stored headers of an object differ from
+// the ones configured with --content-type, --cache-control,
+// --content-language and --content-disposition. Headers that are not
+// configured are not compared.
+func metadataDiffers(key string, head *s3.HeadObjectOutput) bool {
+	if wantType := contentTypeFor(key); wantType != "" && wantType != aws.ToString(head.ContentType) {
+		return true
+	}
+	return (cacheControl != "" && cacheControl != aws.ToString(head.CacheControl)) ||
+		(contentLanguage != "" && contentLanguage != aws.ToString(head.ContentLanguage)) ||
+		(contentDisposition != "" && contentDisposition != aws.ToString(head.ContentDisposition))
+}
+
+// updateObjectMetadata replaces the headers of an object with a metadata-only
+// CopyObject onto itself. User metadata such as the stored MD5 is kept. It
+// returns false when the headers already match.
+func updateObjectMetadata(ctx context.Context, s3Client *s3.Client, bucket, key string) (bool, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get object metadata: %w", err)
+	}
+
+	if !metadataDiffers(key, head) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		CopySource:         aws.String((&url.URL{Path: bucket + "/" + key}).EscapedPath()),
+		CopySourceIfMatch:  head.ETag,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           head.Metadata,
+		ContentType:        head.ContentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		Expires:            head.Expires,
+	}
+	if head.StorageClass != "" {
+		input.StorageClass = types.StorageClass(head.StorageClass)
+	}
+	if wantType := contentTypeFor(key); wantType != "" {
+		input.ContentType = aws.String(wantType)
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if contentLanguage != "" {
+		input.ContentLanguage = aws.String(contentLanguage)
+	}
+	if contentDisposition != "" {
+		input.ContentDisposition = aws.String(contentDisposition)
+	}
+	if grantFullControlHeader != "" {
+		input.GrantFullControl = aws.String(grantFullControlHeader)
+	}
+	if grantReadHeader != "" {
+		input.GrantRead = aws.String(grantReadHeader)
+	}
+
+	if _, err := s3Client.CopyObject(ctx, input); err != nil {
+		return false, fmt.Errorf("failed to update object metadata: %w", err)
+	}
+	return true, nil
+}
+
+// addObjectMetadata adds user metadata to an object with a metadata-only
+// CopyObject onto itself. The existing metadata and headers are kept.
+func addObjectMetadata(ctx context.Context, s3Client *s3.Client, bucket, key string, metadata map[string]string) error {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get object metadata: %w", err)
+	}
+
+	merged := make(map[string]string, len(head.Metadata)+len(metadata))
+	maps.Copy(merged, head.Metadata)
+	maps.Copy(merged, metadata)
+	input := &s3.CopyObjectInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		CopySource:         aws.String((&url.URL{Path: bucket + "/" + key}).EscapedPath()),
+		CopySourceIfMatch:  head.ETag,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           merged,
+		ContentType:        head.ContentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		Expires:            head.Expires,
+	}
+	if head.StorageClass != "" {
+		input.StorageClass = types.StorageClass(head.StorageClass)
+	}
+	if grantFullControlHeader != "" {
+		input.GrantFullControl = aws.String(grantFullControlHeader)
+	}
+	if grantReadHeader != "" {
+		input.GrantRead = aws.String(grantReadHeader)
+	}
+
+	if _, err := s3Client.CopyObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to update object metadata: %w", err)
+	}
+	return nil
+}
+
+// updateMetadataFiles runs updateObjectMetadata for the S3 objects a sync
+// found content-identical to their local files
+func updateMetadataFiles(ctx context.Context, s3Client *s3.Client, bucket string, files []FileInfo, result *SyncResult) error {
+	var mutex sync.Mutex
+
+	return runWorkerPool(ctx, files, maxWorkers, func(workerCtx context.Context, file FileInfo) error {
+		release, err := acquireTransferSlot(workerCtx, bucket)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		updated, err := updateObjectMetadata(workerCtx, s3Client, bucket, file.Path)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to update metadata of %s: %v", file.RelPath, err))
+			recordFailure(operationMetadata, "", fmt.Sprintf("s3://%s/%s", bucket, file.Path), err)
+			return nil
+		}
+		if !updated {
+			return nil
+		}
+
+		if dryRun {
+			logInfo("Would update metadata: %s\n", file.RelPath)
+		} else {
+			logInfo("Updated metadata: %s\n", file.RelPath)
+		}
+		result.MetadataUpdated = append(result.MetadataUpdated, file.RelPath)
+		return nil
+	})
+}
+
+// parseMetadataConditions parses the key=value entries of --if-metadata.
+// Keys are lowercased because S3 returns user metadata keys in lowercase.
+func parseMetadataConditions(values []string) (map[string]string, error) {
+	conditions := make(map[string]string, len(values))
+	for _, value := range values {
+		key, expected, found := strings.Cut(value, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !found || key == "" {
+			return nil, fmt.Errorf("%q must be in key=value format", value)
+		}
+		conditions[key] = expected
+	}
+	return conditions, nil
+}
+
+// metadataMatches reports whether the user metadata of an object has every
+// entry of --if-metadata
+func metadataMatches(metadata map[string]string) bool {
+	for key, expected := range metadataConditions {
+		if actual, ok := metadata[key]; !ok || actual != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// objectMetadataMatches fetches the user metadata of an object and checks it
+// against --if-metadata. Without conditions no request is made.
+func objectMetadataMatches(ctx context.Context, s3Client *s3.Client, bucket, key string) (bool, error) {
+	if len(metadataConditions) == 0 {
+		return true, nil
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get object metadata: %w", err)
+	}
+	return metadataMatches(head.Metadata), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// normalizeMetadataKey turns a --name-from-metadata value into the key of the
+// user metadata map, which the SDK returns lowercased and without the
+// x-amz-meta- prefix
+func normalizeMetadataKey(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.TrimPrefix(name, "x-amz-meta-")
+}
+
+// metadataFileName returns the file name stored in the --name-from-metadata
+// entry of an object's metadata. Values that are not a plain file name, such
+// as paths or "..", are rejected so a crafted object cannot write outside the
+// destination directory.
+func metadataFileName(metadata map[string]string) (string, error) {
+	name, ok := metadata[nameFromMetadata]
+	if !ok || name == "" {
+		return "", nil
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("unsafe file name %q in metadata %s", name, nameFromMetadata)
+	}
+	return name, nil
+}
+
+// applyMetadataFileName replaces the base name of localPath with the name
+// from the object's metadata. Objects without the entry, or with an unsafe
+// value, keep the name derived from their key.
+func applyMetadataFileName(metadata map[string]string, key, localPath string) string {
+	name, err := metadataFileName(metadata)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v, using the key name\n", key, err)
+		return localPath
+	}
+	if name == "" {
+		return localPath
+	}
+	return filepath.Join(filepath.Dir(localPath), name)
+}
+
+// metadataLocalPath reads the metadata of an object and returns the local
+// path --name-from-metadata maps it to
+func metadataLocalPath(ctx context.Context, s3Client *s3.Client, bucketName, key, localPath string) (string, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get object metadata: %w", err)
+	}
+	return applyMetadataFileName(head.Metadata, key, localPath), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataFileName(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	nameFromMetadata = normalizeMetadataKey("X-Amz-Meta-Original-Filename")
+	assert.Equal(t, "original-filename", nameFromMetadata)
+
+	name, err := metadataFileName(map[string]string{"original-filename": "Quarterly Report.pdf"})
+	require.NoError(t, err)
+	assert.Equal(t, "Quarterly Report.pdf", name)
+
+	name, err = metadataFileName(map[string]string{"other": "x"})
+	require.NoError(t, err)
+	assert.Empty(t, name)
+
+	for _, unsafe := range []string{"..", ".", "../../etc/passwd", "sub/name.txt", `..\evil.txt`} {
+		_, err := metadataFileName(map[string]string{"original-filename": unsafe})
+		assert.Error(t, err, unsafe)
+	}
+}
+
+func TestDownloadNameFromMetadata(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	original := func(name string) http.Header {
+		return http.Header{"X-Amz-Meta-Original-Filename": {name}}
+	}
+	fake.store("vault/uploads/2f1c9a.bin", &fakeObject{content: "report", header: original("report.pdf")})
+	fake.store("vault/uploads/img/7b44e0.bin", &fakeObject{content: "photo", header: original("holiday.jpg")})
+	fake.put("vault/uploads/plain.txt", "plain")
+	fake.store("vault/uploads/escape-9d2e.bin", &fakeObject{content: "evil", header: original("../../escape.txt")})
+	useFakeS3(t, fake)
+
+	configure := func(src, dst string) {
+		setTestConfig(src, dst, "", false, true, true, false)
+		nameFromMetadata = "original-filename"
+	}
+
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	t.Run("prefix download", func(t *testing.T) {
+		destDir := t.TempDir()
+		configure("s3://vault/uploads/", destDir)
+
+		var err error
+		warnings := captureStderr(func() {
+			err = downloadFromS3(context.Background())
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "report", readFile(t, filepath.Join(destDir, "report.pdf")))
+		assert.Equal(t, "photo", readFile(t, filepath.Join(destDir, "img", "holiday.jpg")), "the directory comes from the key")
+		assert.Equal(t, "plain", readFile(t, filepath.Join(destDir, "plain.txt")), "objects without the entry keep the key name")
+		assert.Equal(t, "evil", readFile(t, filepath.Join(destDir, "escape-9d2e.bin")), "unsafe names fall back to the key name")
+		assert.NoFileExists(t, filepath.Join(filepath.Dir(filepath.Dir(destDir)), "escape.txt"))
+		assert.Contains(t, warnings, `unsafe file name "../../escape.txt"`)
+	})
+
+	t.Run("single object into a directory", func(t *testing.T) {
+		destDir := t.TempDir()
+		configure("s3://vault/uploads/2f1c9a.bin", destDir+string(filepath.Separator))
+		require.NoError(t, downloadFromS3(context.Background()))
+		assert.Equal(t, "report", readFile(t, filepath.Join(destDir, "report.pdf")))
+	})
+
+	t.Run("explicit file name wins", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "mine.pdf")
+		configure("s3://vault/uploads/2f1c9a.bin", destPath)
+		require.NoError(t, downloadFromS3(context.Background()))
+		assert.Equal(t, "report", readFile(t, destPath))
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorAdd(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	useFakeS3(t, newFakeS3(map[string]string{
+		"mirror-bucket/data/existing.txt":   "new content on S3",
+		"mirror-bucket/data/new.txt":        "new file",
+		"mirror-bucket/data/sub/nested.txt": "nested file",
+	}))
+
+	destDir := t.TempDir()
+	existing := filepath.Join(destDir, "existing.txt")
+	localOnly := filepath.Join(destDir, "local-only.txt")
+	require.NoError(t, os.WriteFile(existing, []byte("local content"), 0644))
+	require.NoError(t, os.WriteFile(localOnly, []byte("not on S3"), 0644))
+
+	setTestConfig("s3://mirror-bucket/data/", destDir, "", false, true, true, false)
+	mirrorAdd = true
+
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+
+	result, err := syncS3ToLocal(context.Background(), s3Client)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"new.txt", "sub/nested.txt"}, result.Downloaded)
+	assert.Empty(t, result.Deleted)
+
+	content, err := os.ReadFile(existing)
+	require.NoError(t, err)
+	assert.Equal(t, "local content", string(content), "existing files are never overwritten")
+	assert.FileExists(t, localOnly, "local files missing on S3 are never deleted")
+
+	content, err = os.ReadFile(filepath.Join(destDir, "sub", "nested.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "nested file", string(content))
+
+	result, err = syncS3ToLocal(context.Background(), s3Client)
+	require.NoError(t, err)
+	assert.Empty(t, result.Downloaded, "a second run has nothing to add")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// splitS3URI splits an s3:// path into bucket and key. When the global bucket
+// is set, the path is interpreted relative to it like the download path does.
+func splitS3URI(s3Path string) (string, string, error) {
+	s3Path = strings.TrimPrefix(s3Path, "s3://")
+
+	if bucket != "" {
+		return bucket, strings.TrimPrefix(s3Path, bucket+"/"), nil
+	}
+
+	parts := strings.SplitN(s3Path, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid S3 format, use s3://bucket/key or specify bucket with -b flag")
+	}
+	return parts[0], parts[1], nil
+}
+
+// moveS3Objects renames a key, or with --recursive every key under a prefix,
+// using a server-side CopyObject followed by a DeleteObject of the source.
+// The object data never leaves the storage provider.
+func moveS3Objects(ctx context.Context) error {
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	srcBucket, srcKey, err := splitS3URI(source)
+	if err != nil {
+		return err
+	}
+	dstBucket, dstKey, err := splitS3URI(destination)
+	if err != nil {
+		return err
+	}
+
+	if !recursive {
+		if dstKey == "" || strings.HasSuffix(dstKey, "/") {
+			dstKey += path.Base(srcKey)
+		}
+		if srcBucket == dstBucket && srcKey == dstKey {
+			return fmt.Errorf("source and destination are the same object")
+		}
+		err := moveS3Object(ctx, s3Client, srcBucket, srcKey, dstBucket, dstKey)
+		recordFailure(operationMove, "", fmt.Sprintf("s3://%s/%s", srcBucket, srcKey), err)
+		return err
+	}
+
+	// the source prefix is a directory: logs-archive/ is not inside logs
+	srcPrefix := srcKey
+	if srcPrefix != "" {
+		srcPrefix = strings.TrimSuffix(srcPrefix, "/") + "/"
+	}
+	if srcBucket == dstBucket && strings.HasPrefix(strings.TrimSuffix(dstKey, "/")+"/", srcPrefix) {
+		return fmt.Errorf("destination prefix %s must not be inside source prefix %s", dstKey, srcKey)
+	}
+
+	type moveTask struct {
+		srcKey string
+		dstKey string
+	}
+
+	// with --continue-on-error a failed object is collected here instead of
+	// stopping the other workers
+	var objectErrors []string
+	var objectErrorsMutex sync.Mutex
+	var moved atomic.Int64
+
+	err = runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task moveTask) error {
+		release, err := acquireTransferSlot(workerCtx, srcBucket, dstBucket)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		err = moveS3Object(workerCtx, s3Client, srcBucket, task.srcKey, dstBucket, task.dstKey)
+		if err == nil {
+			moved.Add(1)
+			return nil
+		}
+		recordFailure(operationMove, "", fmt.Sprintf("s3://%s/%s", srcBucket, task.srcKey), err)
+		err = fmt.Errorf("failed to move %s: %w", task.srcKey, err)
+		if !continueOnError || workerCtx.Err() != nil {
+			return err
+		}
+		logInfoContext(workerCtx, "Error: %v\n", err)
+		objectErrorsMutex.Lock()
+		objectErrors = append(objectErrors, err.Error())
+		objectErrorsMutex.Unlock()
+		return nil
+	}, func(producerCtx context.Context, taskChan chan<- moveTask) error {
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(srcBucket),
+			Prefix: aws.String(srcKey),
+		})
+
+		foundObjects := false
+		for paginator.HasMorePages() {
+			page, pageErr := paginator.NextPage(producerCtx)
+			if pageErr != nil {
+				return fmt.Errorf("failed to list objects: %w", pageErr)
+			}
+
+			for _, obj := range page.Contents {
+				// skip keys that only share the leading string, like a
+				// sibling destination
+				if *obj.Key != srcKey && !strings.HasPrefix(*obj.Key, srcPrefix) {
+					continue
+				}
+				foundObjects = true
+
+				relPath := strings.TrimPrefix(strings.TrimPrefix(*obj.Key, srcKey), "/")
+				if relPath == "" {
+					relPath = path.Base(*obj.Key)
+				}
+
+				targetKey := relPath
+				if dstKey != "" {
+					targetKey = strings.TrimSuffix(dstKey, "/") + "/" + relPath
+				}
+
+				select {
+				case <-producerCtx.Done():
+					return producerCtx.Err()
+				case taskChan <- moveTask{srcKey: *obj.Key, dstKey: targetKey}:
+				}
+			}
+		}
+
+		if !foundObjects {
+			return fmt.Errorf("no objects found with prefix: %s", srcKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(objectErrors) > 0 {
+		printObjectErrors("Move", "Moved", moved.Load(), objectErrors)
+		return &partialFailureError{fmt.Errorf("move completed with %d error(s)", len(objectErrors))}
+	}
+	return nil
+}
+
+// moveS3Object copies a single object to its new key and deletes the source.
+// The two calls are not atomic: if the delete fails, the object exists under
+// both keys and the error is returned so the move can be retried.
+func moveS3Object(ctx context.Context, s3Client *s3.Client, srcBucket, srcKey, dstBucket, dstKey string) error {
+	logInfo("Moving s3://%s/%s to s3://%s/%s\n", srcBucket, srcKey, dstBucket, dstKey)
+
+	if dryRun {
+		return nil
+	}
+
+	copySource := (&url.URL{Path: srcBucket + "/" + srcKey}).EscapedPath()
+	if _, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(copySource),
+	}); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(srcKey),
+	}); err != nil {
+		return fmt.Errorf("copied to s3://%s/%s but failed to delete source: %w", dstBucket, dstKey, err)
+	}
+
+	return nil
+}
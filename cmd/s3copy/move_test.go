@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitS3URI(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	bucket = ""
+	b, k, err := splitS3URI("s3://mybucket/path/to/key.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "mybucket", b)
+	assert.Equal(t, "path/to/key.txt", k)
+
+	_, _, err = splitS3URI("s3://mybucket")
+	assert.Error(t, err)
+
+	bucket = "other"
+	b, k, err = splitS3URI("s3://other/key.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "other", b)
+	assert.Equal(t, "key.txt", k)
+}
+
+func TestMoveS3Objects(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-move-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	putObject := func(key string, content []byte) {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+	}
+
+	getObject := func(key string) []byte {
+		result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		})
+		require.NoError(t, err)
+		defer closeWithLog(result.Body, key)
+		content, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		return content
+	}
+
+	objectExists := func(key string) bool {
+		exists, _, _, err := checkS3ObjectExists(ctx, s3Client, bucketName, key)
+		require.NoError(t, err)
+		return exists
+	}
+
+	t.Run("move single key", func(t *testing.T) {
+		content := []byte("content to be moved")
+		putObject("old/name.txt", content)
+
+		setTestConfig(fmt.Sprintf("s3://%s/old/name.txt", bucketName), fmt.Sprintf("s3://%s/new/name.txt", bucketName), "", false, false, true, false)
+		moveMode = true
+
+		require.NoError(t, moveS3Objects(ctx))
+
+		assert.False(t, objectExists("old/name.txt"))
+		assert.True(t, objectExists("new/name.txt"))
+		assert.Equal(t, content, getObject("new/name.txt"))
+	})
+
+	t.Run("move prefix recursively", func(t *testing.T) {
+		putObject("src/a.txt", []byte("a"))
+		putObject("src/sub/b.txt", []byte("b"))
+
+		setTestConfig(fmt.Sprintf("s3://%s/src/", bucketName), fmt.Sprintf("s3://%s/dst/", bucketName), "", false, true, true, false)
+		moveMode = true
+
+		require.NoError(t, moveS3Objects(ctx))
+
+		assert.False(t, objectExists("src/a.txt"))
+		assert.False(t, objectExists("src/sub/b.txt"))
+		assert.Equal(t, []byte("a"), getObject("dst/a.txt"))
+		assert.Equal(t, []byte("b"), getObject("dst/sub/b.txt"))
+	})
+
+	t.Run("dry run leaves objects in place", func(t *testing.T) {
+		putObject("dry/key.txt", []byte("dry"))
+
+		setTestConfig(fmt.Sprintf("s3://%s/dry/key.txt", bucketName), fmt.Sprintf("s3://%s/moved/key.txt", bucketName), "", false, false, true, false)
+		moveMode = true
+		dryRun = true
+
+		require.NoError(t, moveS3Objects(ctx))
+
+		assert.True(t, objectExists("dry/key.txt"))
+		assert.False(t, objectExists("moved/key.txt"))
+	})
+
+	t.Run("destination inside source prefix", func(t *testing.T) {
+		setTestConfig(fmt.Sprintf("s3://%s/dst/", bucketName), fmt.Sprintf("s3://%s/dst/nested/", bucketName), "", false, true, true, false)
+		moveMode = true
+
+		err := moveS3Objects(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must not be inside source prefix")
+	})
+
+}
+
+func TestMoveContinueOnError(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	defer failures.reset()
+
+	move := func(t *testing.T, continueMove bool) (*fakeS3, error) {
+		fake := newFakeS3(map[string]string{"moves/src/a.txt": "a", "moves/src/locked.txt": "locked", "moves/src/sub/b.txt": "b"})
+		useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if copySource, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source")); strings.HasSuffix(copySource, "moves/src/locked.txt") {
+				writeFakeError(w, http.StatusForbidden, "AccessDenied")
+				return
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		setTestConfig("s3://moves/src/", "s3://moves/dst/", "", false, true, true, false)
+		moveMode = true
+		maxWorkers = 1
+		continueOnError = continueMove
+		detailedExitCodes = true
+		failures.reset()
+		var err error
+		captureStdout(func() {
+			err = moveS3Objects(context.Background())
+		})
+		return fake, err
+	}
+
+	t.Run("the other objects are still moved", func(t *testing.T) {
+		fake, err := move(t, true)
+		require.Error(t, err)
+		assert.Equal(t, "move completed with 1 error(s)", err.Error())
+		assert.Equal(t, exitPartial, exitCode(fmt.Errorf("error moving objects: %w", err)))
+
+		assert.Equal(t, map[string]string{"moves/dst/a.txt": "a", "moves/src/locked.txt": "locked", "moves/dst/sub/b.txt": "b"}, fake.contents())
+		recorded := failures.list()
+		require.Len(t, recorded, 1)
+		assert.Equal(t, "s3://moves/src/locked.txt", recorded[0].Key)
+	})
+
+	t.Run("without the flag the first failure stops the move", func(t *testing.T) {
+		fake, err := move(t, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "src/locked.txt")
+		assert.Equal(t, exitFatal, exitCode(err))
+		assert.Contains(t, fake.contents(), "moves/src/sub/b.txt")
+	})
+}
+
+func TestMoveSiblingPrefix(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(map[string]string{
+		"bucket/logs/app.log":         "app",
+		"bucket/logs/sub/db.log":      "db",
+		"bucket/logs-archive/old.log": "old",
+	})
+	useFakeS3(t, fake)
+
+	setTestConfig("s3://bucket/logs", "s3://bucket/logs-archive/", "", false, true, true, false)
+	moveMode = true
+	require.NoError(t, moveS3Objects(context.Background()))
+
+	assert.Equal(t, map[string]string{
+		"bucket/logs-archive/app.log":    "app",
+		"bucket/logs-archive/sub/db.log": "db",
+		"bucket/logs-archive/old.log":    "old",
+	}, fake.contents(), "the objects of the sibling prefix are not moved")
+
+	setTestConfig("s3://bucket/logs-archive", "s3://bucket/logs-archive/nested/", "", false, true, true, false)
+	moveMode = true
+	err := moveS3Objects(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must not be inside source prefix")
+}
@@ -0,0 +1,31 @@
+package main
+
+import "context"
+
+// openFileLimiter caps the number of local files that are read or written at
+// the same time with --max-open-files. A worker holds a slot from the moment
+// it starts on a file until it closed it, so large directory transfers with
+// many workers stay below the process limit on open files. A download that
+// decrypts holds two files, the encrypted and the decrypted temporary file,
+// with one slot.
+type openFileLimiter struct {
+	slots chan struct{}
+}
+
+func newOpenFileLimiter(limit int) *openFileLimiter {
+	return &openFileLimiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire waits for a free slot and returns the function that releases it.
+// A nil limiter does not limit.
+func (l *openFileLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	}
+}
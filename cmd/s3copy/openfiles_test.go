@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenFileLimiter(t *testing.T) {
+	var unlimited *openFileLimiter
+	release, err := unlimited.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+
+	limiter := newOpenFileLimiter(1)
+	release, err = limiter.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release, err = limiter.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	var inFlight, peak atomic.Int32
+	fake := newFakeS3(nil)
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			fake.ServeHTTP(w, r)
+			return
+		}
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		fake.ServeHTTP(w, r)
+	}))
+
+	localDir := t.TempDir()
+	for i := range 60 {
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, fmt.Sprintf("file-%02d.txt", i)), []byte(fmt.Sprint(i)), 0644))
+	}
+
+	setTestConfig(localDir, "s3://many/files/", "", false, true, true, false)
+	maxWorkers = 16
+	maxOpenFiles = 2
+	openFileSlots = newOpenFileLimiter(maxOpenFiles)
+	failures.reset()
+	defer failures.reset()
+
+	require.NoError(t, uploadToS3(context.Background()))
+	assert.Len(t, fake.contents(), 60)
+	assert.LessOrEqual(t, peak.Load(), int32(2), "no more files in transfer than --max-open-files")
+	assert.Empty(t, failures.list())
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// packDirName is the directory below a destination prefix that holds the
+	// pack objects written with --pack
+	packDirName = ".s3copy-pack"
+	// packIndexName is the last entry of every pack, listing its files
+	packIndexName = ".s3copy-pack-index.json"
+	// defaultPackThreshold is the file size from which files are uploaded
+	// as their own objects when --pack-threshold is not set
+	defaultPackThreshold = 256 * 1024
+	// packObjectSize and packMaxFiles close a pack
+	packObjectSize = 64 * 1024 * 1024
+	packMaxFiles   = 10000
+)
+
+// packIndex is the index at the end of a pack. The offsets point at the data
+// of each file inside the tar archive, so a single file can be read with a
+// range request.
+type packIndex struct {
+	Files []packIndexEntry `json:"files"`
+}
+
+type packIndexEntry struct {
+	Name    string `json:"name"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime"`
+}
+
+// packsFile reports whether a file of the given size is uploaded in a pack
+func packsFile(size int64) bool {
+	return packFiles && size < packThresholdBytes
+}
+
+// packBatch collects the small files of a directory upload until they fill
+// a pack
+type packBatch struct {
+	tasks []uploadTask
+	size  int64
+}
+
+// add adds a file and reports whether the pack is full
+func (b *packBatch) add(task uploadTask) bool {
+	b.tasks = append(b.tasks, task)
+	b.size += task.size
+	return b.size >= packObjectSize || len(b.tasks) >= packMaxFiles
+}
+
+// take returns the collected files as one upload task and empties the batch
+func (b *packBatch) take() uploadTask {
+	task := uploadTask{pack: b.tasks, size: b.size}
+	b.tasks, b.size = nil, 0
+	return task
+}
+
+// packMemberName returns the name of a file in a pack: its path relative to
+// the uploaded directory with the key normalization applied
+func packMemberName(relPath string) string {
+	return normalizeKeyPath(filepath.ToSlash(relPath))
+}
+
+// packKey returns the key of a pack below a destination prefix
+func packKey(prefix, name string) string {
+	return path.Join(prefix, packDirName, name)
+}
+
+// isPackKey reports whether a key names a pack written with --pack
+func isPackKey(key string) bool {
+	dir, name := path.Split(key)
+	return dir != "" && path.Base(dir) == packDirName && strings.HasPrefix(name, "pack-") && strings.HasSuffix(name, ".tar")
+}
+
+// writePack writes the files of a pack as a tar archive followed by its index
+func writePack(writer io.Writer, tasks []uploadTask) error {
+	counter := &countingWriter{writer: writer}
+	tarWriter := tar.NewWriter(counter)
+
+	var index packIndex
+	var newest time.Time
+	for _, task := range tasks {
+		entry, err := addPackMember(tarWriter, counter, task)
+		if err != nil {
+			return err
+		}
+		index.Files = append(index.Files, entry)
+		if modTime, _ := time.Parse(time.RFC3339Nano, entry.ModTime); modTime.After(newest) {
+			newest = modTime
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode pack index: %w", err)
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: packIndexName, Size: int64(len(data)), Mode: 0644, ModTime: newest}); err != nil {
+		return fmt.Errorf("failed to write pack index: %w", err)
+	}
+	if _, err := tarWriter.Write(data); err != nil {
+		return fmt.Errorf("failed to write pack index: %w", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+	return nil
+}
+
+// addPackMember copies a file into the pack and returns its index entry
+func addPackMember(tarWriter *tar.Writer, counter *countingWriter, task uploadTask) (packIndexEntry, error) {
+	file, err := os.Open(task.localPath)
+	if err != nil {
+		return packIndexEntry{}, fmt.Errorf("failed to open file %s: %w", task.localPath, err)
+	}
+	defer closeWithLog(file, task.localPath)
+
+	info, err := file.Stat()
+	if err != nil {
+		return packIndexEntry{}, fmt.Errorf("failed to stat file %s: %w", task.localPath, err)
+	}
+
+	name := packMemberName(task.relPath)
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	}); err != nil {
+		return packIndexEntry{}, fmt.Errorf("failed to add %s to pack: %w", task.localPath, err)
+	}
+	offset := counter.written
+	if _, err := io.CopyN(tarWriter, file, info.Size()); err != nil {
+		return packIndexEntry{}, fmt.Errorf("failed to add %s to pack: %w", task.localPath, err)
+	}
+
+	return packIndexEntry{Name: name, Offset: offset, Size: info.Size(), ModTime: info.ModTime().UTC().Format(time.RFC3339Nano)}, nil
+}
+
+// uploadPack writes the files of a pack task to a temporary tar archive and
+// uploads it below every prefix. The pack is named after the hash of its
+// content, so uploading the same files again replaces it instead of adding a
+// second copy. The uploaded packs are added to written.
+func uploadPack(ctx context.Context, uploader *uploadManager, tasks []uploadTask, prefixes []uploadTarget, written *writtenPacks) (err error) {
+	defer func() {
+		for _, task := range tasks {
+			keys := make([]string, len(task.targets))
+			for i, target := range task.targets {
+				keys[i] = target.String()
+			}
+			recordFailure(operationUpload, task.localPath, strings.Join(keys, ", "), err)
+		}
+	}()
+
+	packFile, err := os.CreateTemp("", ".s3copy-pack-*")
+	if err != nil {
+		return fmt.Errorf("failed to create pack: %w", err)
+	}
+	packPath := packFile.Name()
+	defer removeTempFile(packPath)
+	defer closeWithLog(packFile, packPath)
+
+	hash := sha256.New()
+	if err := writePack(io.MultiWriter(packFile, hash), tasks); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("pack-%x.tar", hash.Sum(nil)[:8])
+	for _, prefix := range prefixes {
+		target := uploadTarget{bucket: prefix.bucket, key: packKey(prefix.key, name)}
+		if _, err := packFile.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind pack: %w", err)
+		}
+		input := &manager.UploadObjectInput{
+			Bucket:      aws.String(target.bucket),
+			Key:         aws.String(target.key),
+			Body:        packFile,
+			ContentType: aws.String("application/x-tar"),
+		}
+		applyUploadOptions(input)
+		if _, err := uploader.UploadObject(ctx, input); err != nil {
+			return fmt.Errorf("failed to upload pack %s: %w", target, err)
+		}
+		written.add(target)
+		logInfo("Uploaded %d files packed in %s\n", len(tasks), target)
+	}
+
+	for _, task := range tasks {
+		report.addFile(task.localPath)
+	}
+	return nil
+}
+
+// writtenPacks collects the packs a directory upload wrote
+type writtenPacks struct {
+	mutex   sync.Mutex
+	targets map[uploadTarget]bool
+}
+
+func newWrittenPacks() *writtenPacks {
+	return &writtenPacks{targets: make(map[uploadTarget]bool)}
+}
+
+func (w *writtenPacks) add(target uploadTarget) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.targets[target] = true
+}
+
+// removeSupersededPacks deletes the packs below the prefixes that the
+// upload did not write. They hold files of an earlier upload that are now
+// in a newer pack, uploaded as their own objects or gone, and a download
+// would otherwise restore their old content.
+func removeSupersededPacks(ctx context.Context, s3Client *s3.Client, prefixes []uploadTarget, written *writtenPacks) error {
+	for _, prefix := range prefixes {
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(prefix.bucket),
+			Prefix: aws.String(packKey(prefix.key, "") + "/"),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list packs: %w", err)
+			}
+			for _, obj := range page.Contents {
+				target := uploadTarget{bucket: prefix.bucket, key: aws.ToString(obj.Key)}
+				if !isPackKey(target.key) || written.targets[target] {
+					continue
+				}
+				if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+					Bucket: aws.String(target.bucket),
+					Key:    aws.String(target.key),
+				}); err != nil {
+					return fmt.Errorf("failed to remove superseded pack %s: %w", target, err)
+				}
+				logInfo("Removed superseded pack %s\n", target)
+			}
+		}
+	}
+	return nil
+}
+
+// downloadPack downloads a pack and extracts its files below destDir
+func downloadPack(ctx context.Context, s3Client *s3.Client, bucketName, key, destDir string) error {
+	logInfoContext(ctx, "Unpacking s3://%s/%s to %s\n", bucketName, key, destDir)
+	if dryRun {
+		return nil
+	}
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer closeWithLog(result.Body, "pack body")
+
+	return unpack(result.Body, destDir)
+}
+
+// packObject is a pack found by the listing of a prefix download
+type packObject struct {
+	key      string
+	destDir  string
+	modified time.Time
+}
+
+// unpackAll unpacks the packs of a prefix download one after another, oldest
+// first. Two packs can hold the same file, for example when an upload was
+// interrupted before it removed the packs it replaced, and the newest copy
+// has to win. A failed pack is passed to skip, which reports whether to go
+// on with the next one.
+func unpackAll(ctx context.Context, s3Client *s3.Client, bucketName string, packs []packObject, skip func(error) bool) error {
+	slices.SortFunc(packs, func(a, b packObject) int {
+		if c := a.modified.Compare(b.modified); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	for _, pack := range packs {
+		report.queueFile()
+		err := downloadPack(ctx, s3Client, bucketName, pack.key, pack.destDir)
+		report.completeFile()
+		recordFailure(operationDownload, pack.destDir, fmt.Sprintf("s3://%s/%s", bucketName, pack.key), err)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("failed to unpack %s: %w", pack.key, err)
+		if ctx.Err() != nil || !skip(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackedFile is a file of a pack extracted to a temporary path
+type unpackedFile struct {
+	tempPath  string
+	localPath string
+	name      string
+	size      int64
+}
+
+// unpack extracts a pack below destDir. The files are extracted to
+// temporary files and only moved into place once the index at the end of the
+// pack confirmed that it is complete.
+func unpack(reader io.Reader, destDir string) error {
+	var files []unpackedFile
+	defer func() {
+		for _, file := range files {
+			removeTempFile(file.tempPath)
+		}
+	}()
+
+	var index *packIndex
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read pack: %w", err)
+		}
+
+		if header.Name == packIndexName {
+			index = &packIndex{}
+			if err := json.NewDecoder(tarReader).Decode(index); err != nil {
+				return fmt.Errorf("failed to read pack index: %w", err)
+			}
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(header.Name)) {
+			return fmt.Errorf("pack contains an invalid entry %q", header.Name)
+		}
+		localPath := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		file, err := extractPackMember(tarReader, localPath)
+		if err != nil {
+			return err
+		}
+		file.name = header.Name
+		files = append(files, file)
+	}
+
+	if index == nil {
+		return fmt.Errorf("pack has no index, it is incomplete")
+	}
+	if len(index.Files) != len(files) {
+		return fmt.Errorf("pack is incomplete: the index lists %d files, found %d", len(index.Files), len(files))
+	}
+	for i, entry := range index.Files {
+		if entry.Name != files[i].name || entry.Size != files[i].size {
+			return fmt.Errorf("pack does not match its index at %s", entry.Name)
+		}
+	}
+
+	for i, file := range files {
+		if err := applyDownloadFileMode(file.tempPath); err != nil {
+			return err
+		}
+		if err := os.Rename(file.tempPath, file.localPath); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", file.localPath, err)
+		}
+		if modTime, err := time.Parse(time.RFC3339Nano, index.Files[i].ModTime); err == nil {
+			if err := os.Chtimes(file.localPath, modTime, modTime); err != nil {
+				logVerbose("Warning: could not set modification time of %s: %v\n", file.localPath, err)
+			}
+		}
+		report.addFile(file.localPath)
+	}
+	return nil
+}
+
+// extractPackMember copies the current entry of a pack to a temporary file
+// next to localPath
+func extractPackMember(reader io.Reader, localPath string) (unpackedFile, error) {
+	if err := makeDownloadDir(filepath.Dir(localPath)); err != nil {
+		return unpackedFile{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(localPath), ".s3copy-tmp-*")
+	if err != nil {
+		return unpackedFile{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	size, err := io.Copy(tempFile, reader)
+	closeWithLog(tempFile, tempPath)
+	if err != nil {
+		removeTempFile(tempPath)
+		return unpackedFile{}, fmt.Errorf("failed to extract %s: %w", localPath, err)
+	}
+	return unpackedFile{tempPath: tempPath, localPath: localPath, size: size}, nil
+}
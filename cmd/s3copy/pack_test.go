@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPackKey(t *testing.T) {
+	assert.True(t, isPackKey(".s3copy-pack/pack-0123.tar"))
+	assert.True(t, isPackKey("backup/.s3copy-pack/pack-0123.tar"))
+	assert.False(t, isPackKey("pack-0123.tar"))
+	assert.False(t, isPackKey("backup/.s3copy-pack/notes.txt"))
+	assert.False(t, isPackKey("backup/other/pack-0123.tar"))
+}
+
+func TestPackUnpack(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, true, true, false)
+
+	srcDir := t.TempDir()
+	modTime := time.Date(2025, 4, 1, 12, 30, 15, 123456789, time.UTC)
+	var tasks []uploadTask
+	for i, relPath := range []string{"a.txt", "nested/b.txt", "nested/deeper/c.json", "empty"} {
+		localPath := filepath.Join(srcDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(strings.Repeat(relPath, i)), 0644))
+		require.NoError(t, os.Chtimes(localPath, modTime, modTime))
+		tasks = append(tasks, uploadTask{localPath: localPath, relPath: filepath.FromSlash(relPath)})
+	}
+
+	var pack bytes.Buffer
+	require.NoError(t, writePack(&pack, tasks))
+
+	destDir := t.TempDir()
+	require.NoError(t, unpack(bytes.NewReader(pack.Bytes()), destDir))
+	for i, task := range tasks {
+		localPath := filepath.Join(destDir, task.relPath)
+		content, err := os.ReadFile(localPath)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat(filepath.ToSlash(task.relPath), i), string(content))
+
+		info, err := os.Stat(localPath)
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(modTime), "modification time of %s", task.relPath)
+	}
+
+	t.Run("truncated pack extracts nothing", func(t *testing.T) {
+		// the end of the archive holds the index
+		truncated := pack.Bytes()[:pack.Len()-2048]
+		emptyDir := t.TempDir()
+		err := unpack(bytes.NewReader(truncated), emptyDir)
+		require.Error(t, err)
+
+		entries, err := os.ReadDir(emptyDir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.True(t, entry.IsDir(), "unexpected file %s", entry.Name())
+		}
+	})
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"large.bin": strings.Repeat("x", 4096),
+	}
+	for i := range 25 {
+		files[fmt.Sprintf("logs/day-%02d.log", i)] = fmt.Sprintf("entry %d\n", i)
+	}
+	for relPath, content := range files {
+		localPath := filepath.Join(srcDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+
+	fake := newFakeS3(nil)
+	server := useFakeS3(t, fake)
+
+	t.Setenv("S3COPY_ENDPOINT", server.URL)
+	t.Setenv("S3COPY_ACCESS_KEY", "access")
+	t.Setenv("S3COPY_SECRET_KEY", "secret")
+	t.Setenv("S3COPY_REGION", "us-east-1")
+	t.Setenv("S3COPY_USE_PATH_STYLE", strconv.FormatBool(true))
+
+	setTestConfig(srcDir, "s3://archive/data/", "", false, true, true, false)
+	envFile = filepath.Join(t.TempDir(), "missing.env")
+	packFiles = true
+	packThresholdBytes = 1024
+	var err error
+	captureStdout(func() {
+		err = runCopy()
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, fake.count("PutObject"), "the small files share one pack")
+	assert.Contains(t, fake.contents(), "archive/data/large.bin")
+	var packKeys []string
+	for objectPath := range fake.contents() {
+		if isPackKey(objectPath) {
+			packKeys = append(packKeys, objectPath)
+		}
+	}
+	require.Len(t, packKeys, 1)
+	assert.True(t, strings.HasPrefix(packKeys[0], "archive/data/.s3copy-pack/pack-"))
+
+	destDir := t.TempDir()
+	setTestConfig("s3://archive/data/", destDir, "", false, true, true, false)
+	captureStdout(func() {
+		err = downloadFromS3(t.Context())
+	})
+	require.NoError(t, err)
+
+	for relPath, content := range files {
+		downloaded, err := os.ReadFile(filepath.Join(destDir, filepath.FromSlash(relPath)))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(downloaded), relPath)
+	}
+	_, err = os.Stat(filepath.Join(destDir, packDirName))
+	assert.True(t, os.IsNotExist(err), "packs are not stored as files")
+}
+
+func TestPackRepackChangedFile(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "config.txt"), []byte("version 1"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "other.txt"), []byte("unchanged"), 0644))
+
+	packKeys := func() []string {
+		var keys []string
+		for objectPath := range fake.contents() {
+			if isPackKey(objectPath) {
+				keys = append(keys, objectPath)
+			}
+		}
+		return keys
+	}
+	upload := func() {
+		setTestConfig(srcDir, "s3://archive/data/", "", false, true, true, false)
+		packFiles = true
+		packThresholdBytes = 1024
+		captureStdout(func() {
+			require.NoError(t, uploadToS3(t.Context()))
+		})
+	}
+	download := func() string {
+		destDir := t.TempDir()
+		setTestConfig("s3://archive/data/", destDir, "", false, true, true, false)
+		captureStdout(func() {
+			require.NoError(t, downloadFromS3(t.Context()))
+		})
+		content, err := os.ReadFile(filepath.Join(destDir, "config.txt"))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	upload()
+	first := packKeys()
+	require.Len(t, first, 1)
+	assert.Equal(t, "version 1", download())
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "config.txt"), []byte("version 2"), 0644))
+	upload()
+	second := packKeys()
+	require.Len(t, second, 1, "the superseded pack is removed")
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, "version 2", download())
+
+	t.Run("packs left by an interrupted upload unpack oldest first", func(t *testing.T) {
+		var stale bytes.Buffer
+		localPath := filepath.Join(t.TempDir(), "config.txt")
+		require.NoError(t, os.WriteFile(localPath, []byte("version 0"), 0644))
+		require.NoError(t, writePack(&stale, []uploadTask{{localPath: localPath, relPath: "config.txt"}}))
+		// the stale pack sorts after the current one, only its age tells them apart
+		fake.store("archive/data/.s3copy-pack/pack-ffffffffffffffff.tar", &fakeObject{
+			content:  stale.String(),
+			modified: time.Now().Add(-time.Hour),
+		})
+		assert.Equal(t, "version 2", download())
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// passwordIDMetadataKey records which --password-map entry encrypted an
+// object, so a download picks the same password even when the rules changed
+// or the object was renamed. Only the identifier is stored, never the
+// password.
+const passwordIDMetadataKey = "password-id"
+
+// passwordIDPattern limits identifiers to characters that are safe in S3
+// user metadata
+var passwordIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// passwordRule is one line of a --password-map: objects whose key matches
+// pattern are encrypted with password and tagged with id
+type passwordRule struct {
+	pattern  string
+	matcher  *ignore.GitIgnore
+	id       string
+	password string
+}
+
+// passwordRules holds the rules of --password-map in file order
+var passwordRules []passwordRule
+
+// readPasswordMap parses a --password-map. Every line holds a pattern with the
+// syntax of --ignore, "=>" and id:password. The password is everything after
+// the first colon, so it may contain colons itself. Empty lines and lines
+// starting with # are skipped.
+func readPasswordMap(filePath string) ([]passwordRule, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []passwordRule
+	ids := map[string]string{}
+	for lineNum, line := range contentLines(string(content)) {
+		rule, err := parsePasswordRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if existing, ok := ids[rule.id]; ok && existing != rule.password {
+			return nil, fmt.Errorf("line %d: id %q is already used with a different password", lineNum, rule.id)
+		}
+		ids[rule.id] = rule.password
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules found")
+	}
+	return rules, nil
+}
+
+// parsePasswordRule parses one line of a --password-map. Errors never quote
+// the line, as it holds a password.
+func parsePasswordRule(line string) (passwordRule, error) {
+	pattern, entry, found := strings.Cut(line, mapFileSeparator)
+	pattern, entry = strings.TrimSpace(pattern), strings.TrimSpace(entry)
+	if !found || pattern == "" || entry == "" {
+		return passwordRule{}, fmt.Errorf("expected \"pattern => id:password\"")
+	}
+	if strings.HasPrefix(pattern, "!") {
+		return passwordRule{}, fmt.Errorf("negated pattern %q is not supported, list the more specific pattern first", pattern)
+	}
+
+	id, secret, found := strings.Cut(entry, ":")
+	if !found || secret == "" {
+		return passwordRule{}, fmt.Errorf("pattern %q has no password, use \"pattern => id:password\"", pattern)
+	}
+	if !passwordIDPattern.MatchString(id) {
+		return passwordRule{}, fmt.Errorf("invalid id %q for pattern %q, use letters, digits, '.', '_' and '-'", id, pattern)
+	}
+	return passwordRule{pattern: pattern, matcher: ignore.CompileIgnoreLines(pattern), id: id, password: secret}, nil
+}
+
+// matchPasswordRule returns the first rule whose pattern matches key
+func matchPasswordRule(key string) (passwordRule, bool) {
+	for _, rule := range passwordRules {
+		if rule.matcher.MatchesPath(key) {
+			return rule, true
+		}
+	}
+	return passwordRule{}, false
+}
+
+// uploadPassword returns the password to encrypt key with and the id to
+// record with the object. Keys no rule matches use --password and record no
+// id.
+func uploadPassword(key string) (string, string, error) {
+	if rule, ok := matchPasswordRule(key); ok {
+		return rule.password, rule.id, nil
+	}
+	if password == "" && len(passwordRules) > 0 {
+		return "", "", fmt.Errorf("no --password-map rule matches %s and no --password is set", key)
+	}
+	return password, "", nil
+}
+
+// objectPassword returns the password to decrypt key with. The id recorded at
+// upload wins; objects without one are matched by key like an upload, and
+// fall back to --password.
+func objectPassword(key string, metadata map[string]string) (string, error) {
+	if len(passwordRules) == 0 {
+		return password, nil
+	}
+	if id := metadata[passwordIDMetadataKey]; id != "" {
+		for _, rule := range passwordRules {
+			if rule.id == id {
+				return rule.password, nil
+			}
+		}
+		return "", fmt.Errorf("%s was encrypted with password id %q, which is not in --password-map", key, id)
+	}
+	secret, _, err := uploadPassword(key)
+	return secret, err
+}
+
+// withPasswordID records the --password-map id in the upload metadata when
+// the object was encrypted with a mapped password
+func withPasswordID(metadata map[string]string, id string) map[string]string {
+	if id == "" {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[passwordIDMetadataKey] = id
+	return metadata
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPasswordMap(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		mapPath := filepath.Join(t.TempDir(), "passwords.txt")
+		require.NoError(t, os.WriteFile(mapPath, []byte(content), 0600))
+		return mapPath
+	}
+
+	t.Run("rules in file order", func(t *testing.T) {
+		rules, err := readPasswordMap(write(t, "# finance\nreports/** => finance:first\n\n*.txt => team:with:colons\n"))
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+		assert.Equal(t, "finance", rules[0].id)
+		assert.Equal(t, "first", rules[0].password)
+		assert.Equal(t, "team", rules[1].id)
+		assert.Equal(t, "with:colons", rules[1].password)
+	})
+
+	t.Run("invalid lines", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"no separator":        "*.txt finance:secret-value",
+			"no password":         "*.txt => finance",
+			"empty password":      "*.txt => finance:",
+			"invalid id":          "*.txt => fin ance:secret-value",
+			"negated pattern":     "!*.txt => finance:secret-value",
+			"conflicting id":      "*.txt => finance:secret-value\n*.csv => finance:other-value",
+			"no rules":            "# nothing here\n",
+			"empty pattern":       "=> finance:secret-value",
+			"missing destination": "*.txt =>",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := readPasswordMap(write(t, content))
+				require.Error(t, err)
+				assert.NotContains(t, err.Error(), "secret-value")
+				assert.NotContains(t, err.Error(), "other-value")
+			})
+		}
+	})
+
+	t.Run("an id may repeat with the same password", func(t *testing.T) {
+		rules, err := readPasswordMap(write(t, "*.txt => team:same\n*.md => team:same\n"))
+		require.NoError(t, err)
+		assert.Len(t, rules, 2)
+	})
+}
+
+func TestObjectPassword(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	setTestConfig("", "", "", true, false, true, false)
+	password = "fallback"
+	assert.Equal(t, "fallback", mustObjectPassword(t, "any/key", nil), "without a map --password is used")
+
+	passwordRules = []passwordRule{
+		mustParsePasswordRule(t, "reports/** => finance:first"),
+		mustParsePasswordRule(t, "*.txt => team:second"),
+	}
+	assert.Equal(t, "first", mustObjectPassword(t, "reports/q1.csv", nil))
+	assert.Equal(t, "second", mustObjectPassword(t, "notes.txt", nil))
+	assert.Equal(t, "fallback", mustObjectPassword(t, "other.bin", nil))
+	assert.Equal(t, "first", mustObjectPassword(t, "renamed.txt", map[string]string{passwordIDMetadataKey: "finance"}),
+		"the recorded id wins over the key")
+
+	_, err := objectPassword("old.txt", map[string]string{passwordIDMetadataKey: "removed"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"removed"`)
+
+	password = ""
+	_, _, err = uploadPassword("other.bin")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no --password-map rule matches other.bin")
+}
+
+func mustParsePasswordRule(t *testing.T, line string) passwordRule {
+	t.Helper()
+	rule, err := parsePasswordRule(line)
+	require.NoError(t, err)
+	return rule
+}
+
+func mustObjectPassword(t *testing.T, key string, metadata map[string]string) string {
+	t.Helper()
+	secret, err := objectPassword(key, metadata)
+	require.NoError(t, err)
+	return secret
+}
+
+func TestPasswordMapRoundTrip(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"reports/q1.csv": "quarterly numbers",
+		"notes.txt":      "team notes",
+	}
+	for key, content := range files {
+		localPath := filepath.Join(srcDir, filepath.FromSlash(key))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+
+	mapPath := filepath.Join(t.TempDir(), "passwords.txt")
+	require.NoError(t, os.WriteFile(mapPath, []byte("reports/** => finance:first-secret\n*.txt => team:second-secret\n"), 0600))
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	setTestConfig("", "", "", true, false, true, false)
+	password = ""
+	rules, err := readPasswordMap(mapPath)
+	require.NoError(t, err)
+	passwordRules = rules
+
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	require.NoError(t, err)
+	for key := range files {
+		require.NoError(t, performS3Upload(ctx, newUploader(s3Client), "bucket", key, filepath.Join(srcDir, filepath.FromSlash(key)), false))
+	}
+
+	quarterly, _ := fake.object("bucket/reports/q1.csv")
+	assert.Equal(t, "finance", quarterly.metadata(passwordIDMetadataKey))
+	notes, _ := fake.object("bucket/notes.txt")
+	assert.Equal(t, "team", notes.metadata(passwordIDMetadataKey))
+	for path := range fake.contents() {
+		object, _ := fake.object(path)
+		for _, values := range object.header {
+			for _, value := range values {
+				assert.NotContains(t, value, "secret", "metadata of %s must not hold a password", path)
+			}
+		}
+	}
+
+	// each object only opens with its own password
+	err = decryptStreamWithSize(io.Discard, strings.NewReader(notes.content), -1, "first-secret")
+	require.Error(t, err)
+	var plain bytes.Buffer
+	require.NoError(t, decryptStreamWithSize(&plain, strings.NewReader(notes.content), -1, "second-secret"))
+	assert.Equal(t, "team notes", plain.String())
+
+	dstDir := t.TempDir()
+	for key, content := range files {
+		localPath := filepath.Join(dstDir, filepath.FromSlash(key))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, performS3Download(ctx, getDownloader(s3Client), "bucket", key, localPath, false))
+		downloaded, err := os.ReadFile(localPath)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(downloaded))
+	}
+}
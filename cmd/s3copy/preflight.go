@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// preflightPrefix is the folder below the destination prefix that holds the
+// test objects of --preflight
+const preflightPrefix = ".s3copy-writetest/"
+
+// preflightKey returns a fresh test key next to the objects an upload to
+// dest writes
+func preflightKey(dest string) (string, string, error) {
+	parsedBucket, key, err := parseS3Path(dest, bucket, true, source)
+	if err != nil {
+		return "", "", err
+	}
+	if parsedBucket == "" {
+		parsedBucket = bucket
+	}
+
+	prefix := ""
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix = key[:i+1]
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", fmt.Errorf("failed to generate test key: %w", err)
+	}
+	return parsedBucket, prefix + preflightPrefix + hex.EncodeToString(suffix), nil
+}
+
+// checkWriteAccess writes and deletes an empty test object under every
+// upload destination, so missing permissions stop the run before the source
+// is enumerated and hashed. A failed delete only warns, since write-only
+// policies are common for backups.
+func checkWriteAccess(ctx context.Context) error {
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	for _, dest := range uploadDestinations() {
+		testBucket, testKey, err := preflightKey(dest)
+		if err != nil {
+			return err
+		}
+
+		_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String(testKey),
+			Body:   bytes.NewReader(nil),
+		})
+		if err != nil {
+			return fmt.Errorf("preflight check failed, cannot write to %s: %w", dest, err)
+		}
+
+		_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String(testKey),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: preflight could not delete its test object s3://%s/%s: %v\n", testBucket, testKey, err)
+		}
+		logVerbose("Preflight: write access to %s confirmed\n", dest)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckWriteAccess(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	run := func(t *testing.T, denied ...string) (*fakeS3, string, error) {
+		t.Helper()
+		fake := newFakeS3(nil)
+		fake.denied = map[string]bool{}
+		for _, operation := range denied {
+			fake.denied[operation] = true
+		}
+		useFakeS3(t, fake)
+		setTestConfig(t.TempDir(), "s3://backups/hosts/web1/", "", false, true, false, false)
+
+		var err error
+		warning := captureStderr(func() {
+			err = checkWriteAccess(context.Background())
+		})
+		return fake, warning, err
+	}
+
+	t.Run("read-only bucket", func(t *testing.T) {
+		fake, _, err := run(t, "PutObject", "DeleteObject")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "preflight check failed, cannot write to s3://backups/hosts/web1/")
+		assert.Contains(t, err.Error(), "AccessDenied")
+		assert.Zero(t, fake.count("DeleteObject"))
+	})
+
+	t.Run("writable bucket", func(t *testing.T) {
+		fake, warning, err := run(t)
+		require.NoError(t, err)
+		assert.Empty(t, warning)
+		puts := fake.paths("PutObject")
+		require.Len(t, puts, 1)
+		assert.True(t, strings.HasPrefix(puts[0], "backups/hosts/web1/.s3copy-writetest/"), puts[0])
+		assert.Equal(t, puts, fake.paths("DeleteObject"), "the test object is removed")
+		assert.Empty(t, fake.contents())
+	})
+
+	t.Run("write-only bucket", func(t *testing.T) {
+		_, warning, err := run(t, "DeleteObject")
+		require.NoError(t, err)
+		assert.Contains(t, warning, "could not delete its test object")
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+)
+
+// startProgressReporter prints report.progressLine every interval until the
+// returned stop function is called. Stop waits for the reporter to exit, so
+// no progress line is printed after it returns. A zero interval disables it.
+func startProgressReporter(interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fmt.Println(report.progressLine())
+			}
+		}
+	})
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// transferProgressFunc receives the bytes of an object transferred so far
+// and the size of the object, or -1 when it is not known yet. A transfer
+// that ended, successfully or not, reports zero bytes.
+type transferProgressFunc func(bucket, key string, transferred, total int64)
+
+// withTransferProgress is a transfer manager option that passes the progress
+// of every upload and download of the manager to onProgress
+func withTransferProgress(onProgress transferProgressFunc) func(*manager.Options) {
+	return func(o *manager.Options) {
+		o.ObjectProgressListeners.Register(&transferProgressListener{
+			onProgress:  onProgress,
+			transferred: map[any]int64{},
+		})
+	}
+}
+
+// reportTransferProgress is the transfer manager option the commands use;
+// the progress lines count the bytes of running transfers through it
+func reportTransferProgress(o *manager.Options) {
+	withTransferProgress(func(bucket, key string, transferred, total int64) {
+		report.transferProgress(bucket, key, transferred)
+	})(o)
+}
+
+// transferProgressListener forwards the byte counts the transfer manager
+// reports for each object to onProgress. Parts finish on several goroutines
+// at once, so the calls are serialized and a count that arrives after a
+// larger one of the same transfer is dropped.
+type transferProgressListener struct {
+	mutex       sync.Mutex
+	onProgress  transferProgressFunc
+	transferred map[any]int64
+}
+
+// progressEventObject returns the bucket and key of a transfer manager input
+func progressEventObject(input any) (string, string) {
+	switch in := input.(type) {
+	case *manager.UploadObjectInput:
+		return aws.ToString(in.Bucket), aws.ToString(in.Key)
+	case *manager.DownloadObjectInput:
+		return aws.ToString(in.Bucket), aws.ToString(in.Key)
+	}
+	return "", ""
+}
+
+func (l *transferProgressListener) OnObjectBytesTransferred(_ context.Context, event *manager.ObjectBytesTransferredEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if event.BytesTransferred <= l.transferred[event.Input] {
+		return
+	}
+	l.transferred[event.Input] = event.BytesTransferred
+	bucket, key := progressEventObject(event.Input)
+	l.onProgress(bucket, key, event.BytesTransferred, event.TotalBytes)
+}
+
+func (l *transferProgressListener) OnObjectTransferComplete(_ context.Context, event *manager.ObjectTransferCompleteEvent) {
+	l.finish(event.Input)
+}
+
+func (l *transferProgressListener) OnObjectTransferFailed(_ context.Context, event *manager.ObjectTransferFailedEvent) {
+	l.finish(event.Input)
+}
+
+func (l *transferProgressListener) finish(input any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.transferred, input)
+	bucket, key := progressEventObject(input)
+	l.onProgress(bucket, key, 0, -1)
+}
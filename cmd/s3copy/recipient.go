@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// recipientHeaderMagic starts the header of objects whose data key is wrapped
+// to an X25519 recipient public key instead of being derived from a password
+var recipientHeaderMagic = []byte("s3copy-x25519-v1")
+
+const (
+	x25519KeySize  = 32
+	dataKeySize    = chacha20poly1305.KeySize
+	wrappedKeySize = dataKeySize + chacha20poly1305.Overhead
+)
+
+// recipientKeyMaterialSize is the size of the magic, the ephemeral public key
+// and the wrapped data key that replace the salt in the header
+var recipientKeyMaterialSize = len(recipientHeaderMagic) + x25519KeySize + wrappedKeySize
+
+// usesRecipientKeys reports whether --recipient-file or --identity-file
+// replaces the password
+func usesRecipientKeys() bool {
+	return recipientKey != nil || identityKey != nil
+}
+
+// encryptionRecipient returns the public key new objects are encrypted to.
+// Without --recipient-file it is the public key of the identity.
+func encryptionRecipient() *ecdh.PublicKey {
+	if recipientKey != nil {
+		return recipientKey
+	}
+	return identityKey.PublicKey()
+}
+
+// loadRecipientKey reads an X25519 public key from a PEM file, for example
+// one written by "openssl pkey -in identity.pem -pubout"
+func loadRecipientKey(path string) (*ecdh.PublicKey, error) {
+	block, err := readPEMBlock(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	key, ok := parsed.(*ecdh.PublicKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an X25519 public key", path)
+	}
+	return key, nil
+}
+
+// loadIdentityKey reads an X25519 private key from a PEM file, for example
+// one written by "openssl genpkey -algorithm X25519"
+func loadIdentityKey(path string) (*ecdh.PrivateKey, error) {
+	block, err := readPEMBlock(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	key, ok := parsed.(*ecdh.PrivateKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an X25519 private key", path)
+	}
+	return key, nil
+}
+
+// readPEMBlock returns the first PEM block of the given type in a file
+func readPEMBlock(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no %s PEM block found in %s", blockType, path)
+		}
+		if block.Type == blockType {
+			return block, nil
+		}
+	}
+}
+
+// wrappingKey derives the key that seals the data key from the X25519 shared
+// secret, bound to both public keys
+func wrappingKey(sharedSecret, ephemeralPublic, recipientPublic []byte) ([]byte, error) {
+	salt := append(bytes.Clone(ephemeralPublic), recipientPublic...)
+	return hkdf.Key(sha256.New, sharedSecret, salt, "s3copy x25519 data key", chacha20poly1305.KeySize)
+}
+
+// wrapDataKey encrypts the data key to the recipient with a fresh ephemeral
+// key pair and returns the key material stored in the header
+func wrapDataKey(recipient *ecdh.PublicKey, dataKey []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %v", err)
+	}
+	sharedSecret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %v", err)
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	key, err := wrappingKey(sharedSecret, ephemeralPublic, recipient.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %v", err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AEAD cipher: %v", err)
+	}
+
+	// The wrapping key is used for a single seal, so a zero nonce is safe
+	keyMaterial := append(bytes.Clone(recipientHeaderMagic), ephemeralPublic...)
+	return aead.Seal(keyMaterial, make([]byte, chacha20poly1305.NonceSize), dataKey, nil), nil
+}
+
+// unwrapDataKey recovers the data key from the header key material with the
+// identity's private key
+func unwrapDataKey(identity *ecdh.PrivateKey, keyMaterial []byte) ([]byte, error) {
+	if len(keyMaterial) != recipientKeyMaterialSize || !bytes.HasPrefix(keyMaterial, recipientHeaderMagic) {
+		return nil, fmt.Errorf("object is not encrypted to a recipient key")
+	}
+	ephemeralPublic := keyMaterial[len(recipientHeaderMagic) : len(recipientHeaderMagic)+x25519KeySize]
+	wrapped := keyMaterial[len(recipientHeaderMagic)+x25519KeySize:]
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+	sharedSecret, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %v", err)
+	}
+
+	key, err := wrappingKey(sharedSecret, ephemeralPublic, identity.PublicKey().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %v", err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AEAD cipher: %v", err)
+	}
+
+	dataKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), wrapped, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key (wrong identity?): %v", err)
+	}
+	return dataKey, nil
+}
+
+// newRecipientKeyMaterial generates a random data key and wraps it to the
+// configured recipient
+func newRecipientKeyMaterial() (keyMaterial, dataKey []byte, err error) {
+	dataKey = make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	keyMaterial, err = wrapDataKey(encryptionRecipient(), dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyMaterial, dataKey, nil
+}
+
+// readRecipientKeyMaterial reads the recipient key material of a header and
+// unwraps the data key. Decryption needs --identity-file.
+func readRecipientKeyMaterial(reader io.Reader) (keyMaterial, dataKey []byte, err error) {
+	if identityKey == nil {
+		return nil, nil, fmt.Errorf("decrypting objects encrypted to a recipient key requires --identity-file")
+	}
+	keyMaterial = make([]byte, recipientKeyMaterialSize)
+	if _, err := io.ReadFull(reader, keyMaterial); err != nil {
+		return nil, nil, fmt.Errorf("failed to read encryption header: %v", err)
+	}
+	dataKey, err = unwrapDataKey(identityKey, keyMaterial)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyMaterial, dataKey, nil
+}
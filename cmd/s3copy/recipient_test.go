@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeX25519KeyFiles writes a new identity and its public key as PEM files,
+// in the format produced by openssl genpkey -algorithm X25519
+func writeX25519KeyFiles(t *testing.T) (identityPath, recipientPath string, identity *ecdh.PrivateKey) {
+	t.Helper()
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(identity)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(identity.PublicKey())
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	identityPath = filepath.Join(dir, "identity.pem")
+	recipientPath = filepath.Join(dir, "recipient.pem")
+	require.NoError(t, os.WriteFile(identityPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+	require.NoError(t, os.WriteFile(recipientPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644))
+	return identityPath, recipientPath, identity
+}
+
+func TestWrapDataKey(t *testing.T) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	dataKey := bytes.Repeat([]byte{7}, dataKeySize)
+
+	keyMaterial, err := wrapDataKey(identity.PublicKey(), dataKey)
+	require.NoError(t, err)
+	assert.Len(t, keyMaterial, recipientKeyMaterialSize)
+	assert.True(t, bytes.HasPrefix(keyMaterial, recipientHeaderMagic))
+
+	t.Run("round trip", func(t *testing.T) {
+		unwrapped, err := unwrapDataKey(identity, keyMaterial)
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, unwrapped)
+	})
+
+	t.Run("fresh ephemeral key per wrap", func(t *testing.T) {
+		other, err := wrapDataKey(identity.PublicKey(), dataKey)
+		require.NoError(t, err)
+		assert.NotEqual(t, keyMaterial, other)
+	})
+
+	t.Run("wrong identity", func(t *testing.T) {
+		other, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		_, err = unwrapDataKey(other, keyMaterial)
+		assert.ErrorContains(t, err, "wrong identity")
+	})
+
+	t.Run("tampered key material", func(t *testing.T) {
+		tampered := bytes.Clone(keyMaterial)
+		tampered[len(tampered)-1] ^= 1
+		_, err := unwrapDataKey(identity, tampered)
+		assert.Error(t, err)
+	})
+
+	t.Run("password header", func(t *testing.T) {
+		_, err := unwrapDataKey(identity, make([]byte, recipientKeyMaterialSize))
+		assert.ErrorContains(t, err, "not encrypted to a recipient key")
+	})
+}
+
+func TestLoadX25519Keys(t *testing.T) {
+	identityPath, recipientPath, identity := writeX25519KeyFiles(t)
+
+	recipient, err := loadRecipientKey(recipientPath)
+	require.NoError(t, err)
+	assert.True(t, recipient.Equal(identity.PublicKey()))
+
+	loaded, err := loadIdentityKey(identityPath)
+	require.NoError(t, err)
+	assert.True(t, loaded.Equal(identity))
+
+	_, err = loadRecipientKey(identityPath)
+	assert.ErrorContains(t, err, "no PUBLIC KEY PEM block")
+
+	_, err = loadIdentityKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.ErrorContains(t, err, "failed to read key file")
+}
+
+func TestRecipientEncryptionRoundTrip(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	identityPath, recipientPath, _ := writeX25519KeyFiles(t)
+	plaintext := make([]byte, DefaultEncryptionChunkSize*2+123)
+	_, err := rand.Read(plaintext)
+	require.NoError(t, err)
+
+	// The backup operator only holds the public key
+	password = ""
+	recipientKey, err = loadRecipientKey(recipientPath)
+	require.NoError(t, err)
+	identityKey = nil
+
+	var encrypted bytes.Buffer
+	require.NoError(t, encryptStream(&encrypted, bytes.NewReader(plaintext)))
+	assert.True(t, bytes.HasPrefix(encrypted.Bytes(), recipientHeaderMagic))
+
+	err = decryptStreamFromReader(&bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()))
+	assert.ErrorContains(t, err, "requires --identity-file")
+
+	t.Run("identity decrypts", func(t *testing.T) {
+		recipientKey = nil
+		identityKey, err = loadIdentityKey(identityPath)
+		require.NoError(t, err)
+
+		var decrypted bytes.Buffer
+		require.NoError(t, decryptStreamFromReader(&decrypted, bytes.NewReader(encrypted.Bytes())))
+		assert.Equal(t, plaintext, decrypted.Bytes())
+	})
+
+	t.Run("password does not decrypt", func(t *testing.T) {
+		recipientKey = nil
+		identityKey = nil
+		password = "some-password"
+
+		var decrypted bytes.Buffer
+		err := decryptStreamFromReader(&decrypted, bytes.NewReader(encrypted.Bytes()))
+		assert.Error(t, err)
+		assert.Zero(t, decrypted.Len())
+	})
+
+	t.Run("hmac with recipient key", func(t *testing.T) {
+		password = ""
+		identityKey, err = loadIdentityKey(identityPath)
+		require.NoError(t, err)
+		storeHMAC = true
+
+		file, err := os.CreateTemp(t.TempDir(), "plain")
+		require.NoError(t, err)
+		defer closeWithLog(file, file.Name())
+		_, err = file.Write(plaintext)
+		require.NoError(t, err)
+		_, err = file.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		header, objectHMAC, err := prepareEncryption(file, password)
+		require.NoError(t, err)
+
+		var sealed bytes.Buffer
+		require.NoError(t, encryptWithHeader(&sealed, file, header, cryptoWorkers()))
+
+		ok, err := checkCiphertextHMAC(bytes.NewReader(sealed.Bytes()), objectHMAC, password)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
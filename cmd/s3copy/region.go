@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// s3Provider is an S3-compatible service recognized by its endpoint host.
+// regions returns the region strings the provider accepts for a host, the
+// first being the one to use; nil means the provider ignores the region.
+type s3Provider struct {
+	name    string
+	host    *regexp.Regexp
+	regions func(match []string) []string
+}
+
+// regionFromHost returns the region embedded in the host
+func regionFromHost(match []string) []string {
+	return []string{match[1]}
+}
+
+var s3Providers = []s3Provider{
+	{name: "AWS S3", host: regexp.MustCompile(`^(?:[^.]+\.)*s3[.-](?:dualstack\.)?([a-z]{2}(?:-gov)?-[a-z]+-\d+)\.amazonaws\.com$`), regions: regionFromHost},
+	{name: "Cloudflare R2", host: regexp.MustCompile(`\.r2\.cloudflarestorage\.com$`), regions: func([]string) []string { return []string{"auto", "us-east-1"} }},
+	{name: "Backblaze B2", host: regexp.MustCompile(`^s3\.([a-z0-9-]+)\.backblazeb2\.com$`), regions: regionFromHost},
+	{name: "Wasabi", host: regexp.MustCompile(`^s3\.([a-z0-9-]+)\.wasabisys\.com$`), regions: regionFromHost},
+	{name: "Wasabi", host: regexp.MustCompile(`^s3\.wasabisys\.com$`), regions: func([]string) []string { return []string{"us-east-1"} }},
+	{name: "Scaleway", host: regexp.MustCompile(`^s3\.([a-z0-9-]+)\.scw\.cloud$`), regions: regionFromHost},
+	{name: "DigitalOcean Spaces", host: regexp.MustCompile(`^[a-z0-9]+\.digitaloceanspaces\.com$`), regions: func([]string) []string { return nil }},
+}
+
+// detectProvider returns the provider serving an endpoint and the regions it
+// accepts, or an empty name for endpoints that are not recognized, such as
+// MinIO or Ceph on a custom host
+func detectProvider(endpoint string) (string, []string) {
+	if endpoint == "" {
+		return "", nil
+	}
+	host := endpoint
+	if parsed, err := url.Parse(endpoint); err == nil && parsed.Host != "" {
+		host = parsed.Hostname()
+	}
+	host = strings.ToLower(host)
+
+	for _, provider := range s3Providers {
+		if match := provider.host.FindStringSubmatch(host); match != nil {
+			return provider.name, provider.regions(match)
+		}
+	}
+	return "", nil
+}
+
+// parseEndpointRegions parses a comma-separated list of endpoint=region
+// entries
+func parseEndpointRegions(value string) (map[string]string, error) {
+	regions := make(map[string]string)
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 || strings.TrimSpace(entry[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid entry %q, use <endpoint>=<region>", entry)
+		}
+		regions[strings.TrimSpace(entry[:i])] = strings.TrimSpace(entry[i+1:])
+	}
+	return regions, nil
+}
+
+// resolveRegion applies --region-per-endpoint to the configured endpoint and
+// checks the region against the provider the endpoint belongs to. A region
+// that was never set is replaced by the one the provider expects; an
+// explicitly set region that the provider does not accept only triggers a
+// warning, because providers add regions over time.
+func resolveRegion() {
+	if region, ok := endpointRegions[config.Endpoint]; ok {
+		config.Region = region
+		configSources["region"] = sourceFlag + " region-per-endpoint"
+	}
+
+	provider, expected := detectProvider(config.Endpoint)
+	if len(expected) == 0 || slices.Contains(expected, config.Region) {
+		return
+	}
+
+	if configSource("region") == sourceDefault {
+		logVerbose("Using region %s for %s endpoint %s\n", expected[0], provider, config.Endpoint)
+		config.Region = expected[0]
+		configSources["region"] = "provider " + provider
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: region %q is likely wrong for %s endpoint %s, which expects %q; requests may fail with signature errors\n",
+		config.Region, provider, config.Endpoint, expected[0])
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectProvider(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		provider string
+		regions  []string
+	}{
+		{"https://s3.eu-central-1.amazonaws.com", "AWS S3", []string{"eu-central-1"}},
+		{"https://s3-us-west-2.amazonaws.com", "AWS S3", []string{"us-west-2"}},
+		{"https://s3.dualstack.ap-southeast-2.amazonaws.com", "AWS S3", []string{"ap-southeast-2"}},
+		{"https://0123abcd.r2.cloudflarestorage.com", "Cloudflare R2", []string{"auto", "us-east-1"}},
+		{"https://s3.us-west-004.backblazeb2.com", "Backblaze B2", []string{"us-west-004"}},
+		{"https://s3.eu-central-2.wasabisys.com", "Wasabi", []string{"eu-central-2"}},
+		{"https://s3.wasabisys.com", "Wasabi", []string{"us-east-1"}},
+		{"https://s3.fr-par.scw.cloud", "Scaleway", []string{"fr-par"}},
+		{"https://fra1.digitaloceanspaces.com", "DigitalOcean Spaces", nil},
+		{"http://localhost:9000", "", nil},
+		{"https://ceph.internal:7480", "", nil},
+		{"", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			provider, regions := detectProvider(tt.endpoint)
+			assert.Equal(t, tt.provider, provider)
+			assert.Equal(t, tt.regions, regions)
+		})
+	}
+}
+
+func TestParseEndpointRegions(t *testing.T) {
+	regions, err := parseEndpointRegions("http://minio:9000=us-east-1, https://ceph.internal:7480 = default")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"http://minio:9000": "us-east-1", "https://ceph.internal:7480": "default"}, regions)
+
+	for _, invalid := range []string{"us-east-1", "=us-east-1", "http://minio:9000="} {
+		_, err := parseEndpointRegions(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	resolve := func(endpoint, region, origin string) (string, string) {
+		setTestConfig("", "", "", false, false, false, false)
+		config = Config{Endpoint: endpoint, Region: region}
+		if origin != "" {
+			configSources["region"] = origin
+		}
+		warning := captureStderr(resolveRegion)
+		return config.Region, warning
+	}
+
+	t.Run("default region is replaced by the provider region", func(t *testing.T) {
+		region, warning := resolve("https://0123abcd.r2.cloudflarestorage.com", "us-east-2", "")
+		assert.Equal(t, "auto", region)
+		assert.Empty(t, warning)
+		assert.Equal(t, "provider Cloudflare R2", configSource("region"))
+
+		region, _ = resolve("https://s3.us-west-004.backblazeb2.com", "us-east-1", "")
+		assert.Equal(t, "us-west-004", region)
+	})
+
+	t.Run("accepted region is kept", func(t *testing.T) {
+		region, warning := resolve("https://0123abcd.r2.cloudflarestorage.com", "us-east-1", "env S3COPY_REGION")
+		assert.Equal(t, "us-east-1", region)
+		assert.Empty(t, warning)
+	})
+
+	t.Run("explicit wrong region warns", func(t *testing.T) {
+		region, warning := resolve("https://s3.eu-central-2.wasabisys.com", "us-east-1", "env S3COPY_REGION")
+		assert.Equal(t, "us-east-1", region, "an explicit region is never overridden")
+		assert.Contains(t, warning, `region "us-east-1" is likely wrong for Wasabi`)
+		assert.Contains(t, warning, `expects "eu-central-2"`)
+	})
+
+	t.Run("providers that ignore the region and unknown endpoints", func(t *testing.T) {
+		region, warning := resolve("https://fra1.digitaloceanspaces.com", "us-east-1", "env S3COPY_REGION")
+		assert.Equal(t, "us-east-1", region)
+		assert.Empty(t, warning)
+
+		region, warning = resolve("http://localhost:9000", "eu-west-1", sourceStdin)
+		assert.Equal(t, "eu-west-1", region)
+		assert.Empty(t, warning)
+	})
+
+	t.Run("region per endpoint", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+		endpointRegions = map[string]string{"http://minio:9000": "eu-west-1", "http://other:9000": "us-west-2"}
+		config = Config{Endpoint: "http://minio:9000", Region: "us-east-1"}
+		configSources["region"] = "env S3COPY_REGION"
+
+		resolveRegion()
+		assert.Equal(t, "eu-west-1", config.Region)
+		assert.Equal(t, "flag region-per-endpoint", configSource("region"))
+	})
+
+	t.Run("region per endpoint is checked against the provider", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, false, false)
+		endpointRegions = map[string]string{"https://s3.fr-par.scw.cloud": "nl-ams"}
+		config = Config{Endpoint: "https://s3.fr-par.scw.cloud", Region: "us-east-1"}
+
+		warning := captureStderr(resolveRegion)
+		assert.Equal(t, "nl-ams", config.Region)
+		assert.Contains(t, warning, `region "nl-ams" is likely wrong for Scaleway`)
+	})
+}
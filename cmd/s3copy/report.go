@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type reportPhase int
+
+const (
+	phaseEnumeration reportPhase = iota
+	phaseHashing
+	phaseTransfer
+	phaseDelete
+	phaseCount
+)
+
+var reportPhaseNames = [phaseCount]string{"Enumeration", "Hashing", "Transfer", "Delete"}
+
+// timingReport collects the per-phase timings and the transferred bytes
+// printed by --report, and the file counters printed by --progress-interval
+type timingReport struct {
+	mutex     sync.Mutex
+	start     time.Time
+	durations [phaseCount]time.Duration
+	files     int64
+	bytes     int64
+	queued    int64
+	completed int64
+	// inFlight holds the bytes of the transfers that are still running
+	inFlight map[uploadTarget]int64
+}
+
+var report = &timingReport{start: time.Now()}
+
+// reset clears all measurements and restarts the total timer
+func (r *timingReport) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.start = time.Now()
+	r.durations = [phaseCount]time.Duration{}
+	r.files = 0
+	r.bytes = 0
+	r.queued = 0
+	r.completed = 0
+	r.inFlight = nil
+}
+
+// track starts timing a phase and returns the function that stops it.
+// Phases run by several workers at once accumulate the time of every worker.
+func (r *timingReport) track(phase reportPhase) func() {
+	start := time.Now()
+	return func() {
+		r.add(phase, time.Since(start))
+	}
+}
+
+func (r *timingReport) add(phase reportPhase, duration time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.durations[phase] += duration
+}
+
+// addFile counts a transferred local file and adds its size. The file is
+// only stat'ed when --report, progress lines or --adaptive-concurrency need
+// the transferred bytes.
+func (r *timingReport) addFile(filePath string) {
+	r.mutex.Lock()
+	r.files++
+	r.mutex.Unlock()
+
+	if !showReport && !currentOutputMode().progress() && adaptiveSlots == nil {
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		logVerbose("Warning: Could not stat %s for report: %v\n", filePath, err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bytes += info.Size()
+}
+
+// addStream counts a transferred file without a local path, such as an HTTP
+// source, with the number of bytes read from it
+func (r *timingReport) addStream(size int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.files++
+	r.bytes += size
+}
+
+// transferProgress records the bytes a running transfer has moved so far.
+// Transfers are told apart by bucket and key, as the same key can be
+// uploaded to several destinations at once. Zero removes the transfer once
+// it ended; the completed file is then counted by addFile.
+func (r *timingReport) transferProgress(bucket, key string, transferred int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	object := uploadTarget{bucket: bucket, key: key}
+	if transferred == 0 {
+		delete(r.inFlight, object)
+		return
+	}
+	if r.inFlight == nil {
+		r.inFlight = map[uploadTarget]int64{}
+	}
+	r.inFlight[object] = transferred
+}
+
+// queueFile counts a file that was handed to a transfer worker
+func (r *timingReport) queueFile() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queued++
+}
+
+// completeFile counts a queued file whose transfer finished, whether it was
+// transferred, skipped or failed
+func (r *timingReport) completeFile() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.completed++
+}
+
+// fileCounts returns the number of files handed to workers and the number of
+// those that finished
+func (r *timingReport) fileCounts() (queued, completed int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.queued, r.completed
+}
+
+// progressLine returns the one-line summary printed by --progress-interval.
+// Directory transfers enumerate while they run, so the total grows until the
+// enumeration is done.
+func (r *timingReport) progressLine() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	elapsed := time.Since(r.start)
+	transferred := r.bytes
+	for _, inFlight := range r.inFlight {
+		transferred += inFlight
+	}
+	return fmt.Sprintf("Progress: %d/%d files, %s transferred, %s, elapsed %s",
+		r.completed, max(r.queued, r.completed), formatBytes(transferred), r.throughputLocked(elapsed), elapsed.Round(time.Second))
+}
+
+// throughput returns the transferred bytes per second since the last reset
+func (r *timingReport) throughput() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.throughputLocked(time.Since(r.start))
+}
+
+func (r *timingReport) throughputLocked(elapsed time.Duration) string {
+	if seconds := elapsed.Seconds(); seconds > 0 && r.bytes > 0 {
+		return formatBytes(int64(float64(r.bytes)/seconds)) + "/s"
+	}
+	return "n/a"
+}
+
+// transferredFiles returns the number of files transferred since the last reset
+func (r *timingReport) transferredFiles() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.files
+}
+
+// print writes the timing breakdown, total bytes and effective throughput
+func (r *timingReport) print() {
+	if !showReport || !currentOutputMode().summary() {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fmt.Println("\n=== Timing Report ===")
+	for phase, name := range reportPhaseNames {
+		fmt.Printf("%-12s %s\n", name+":", r.durations[phase].Round(time.Millisecond))
+	}
+	fmt.Printf("%-12s %s\n", "Total:", time.Since(r.start).Round(time.Millisecond))
+
+	throughput := "n/a"
+	if transfer := r.durations[phaseTransfer]; transfer > 0 && r.bytes > 0 {
+		throughput = formatBytes(int64(float64(r.bytes)/transfer.Seconds())) + "/s"
+	}
+	fmt.Printf("%-12s %s (%s)\n", "Transferred:", formatBytes(r.bytes), throughput)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingReport(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("accumulates phases and bytes", func(t *testing.T) {
+		showReport = true
+		quiet = false
+		r := &timingReport{}
+		r.reset()
+
+		r.add(phaseEnumeration, 1500*time.Millisecond)
+		r.add(phaseHashing, 250*time.Millisecond)
+		r.add(phaseHashing, 250*time.Millisecond)
+		r.add(phaseTransfer, 2*time.Second)
+
+		filePath := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(filePath, make([]byte, 4096), 0644))
+		r.addFile(filePath)
+		r.addFile(filePath)
+
+		output := captureStdout(r.print)
+
+		assert.Contains(t, output, "=== Timing Report ===")
+		assert.Contains(t, output, "Enumeration: 1.5s")
+		assert.Contains(t, output, "Hashing:     500ms")
+		assert.Contains(t, output, "Transfer:    2s")
+		assert.Contains(t, output, "Delete:      0s")
+		assert.Contains(t, output, "Transferred: 8.0 KB (4.0 KB/s)")
+	})
+
+	t.Run("track measures elapsed time", func(t *testing.T) {
+		r := &timingReport{}
+		stop := r.track(phaseDelete)
+		time.Sleep(10 * time.Millisecond)
+		stop()
+
+		assert.GreaterOrEqual(t, r.durations[phaseDelete], 10*time.Millisecond)
+	})
+
+	t.Run("no bytes without report flag", func(t *testing.T) {
+		showReport = false
+		r := &timingReport{}
+
+		filePath := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(filePath, []byte("data"), 0644))
+		r.addFile(filePath)
+
+		assert.Zero(t, r.bytes)
+		assert.Equal(t, int64(1), r.transferredFiles())
+	})
+
+	t.Run("suppressed when quiet", func(t *testing.T) {
+		showReport = true
+		quiet = true
+		r := &timingReport{}
+		r.add(phaseTransfer, time.Second)
+
+		assert.Empty(t, captureStdout(r.print))
+	})
+}
+
+func TestProgressReporter(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("progress line counts files and bytes", func(t *testing.T) {
+		progressPeriod = time.Second
+		r := &timingReport{}
+		r.reset()
+
+		filePath := filepath.Join(t.TempDir(), "data.bin")
+		require.NoError(t, os.WriteFile(filePath, make([]byte, 2048), 0644))
+		for range 3 {
+			r.queueFile()
+		}
+		r.addFile(filePath)
+		r.completeFile()
+
+		line := r.progressLine()
+		assert.True(t, strings.HasPrefix(line, "Progress: 1/3 files, 2.0 KB transferred, "), line)
+		assert.Contains(t, line, "elapsed")
+	})
+
+	t.Run("total never lags behind completed files", func(t *testing.T) {
+		r := &timingReport{}
+		r.reset()
+		r.completeFile()
+
+		assert.True(t, strings.HasPrefix(r.progressLine(), "Progress: 1/1 files, 0 B transferred, n/a"))
+	})
+
+	t.Run("same key in two buckets counts twice", func(t *testing.T) {
+		r := &timingReport{}
+		r.reset()
+		r.transferProgress("first", "data.bin", 1024)
+		r.transferProgress("second", "data.bin", 1024)
+		assert.Contains(t, r.progressLine(), "2.0 KB transferred")
+
+		r.transferProgress("first", "data.bin", 0)
+		assert.Contains(t, r.progressLine(), "1.0 KB transferred")
+	})
+
+	t.Run("prints periodically and stops cleanly", func(t *testing.T) {
+		output := captureStdout(func() {
+			stop := startProgressReporter(5 * time.Millisecond)
+			time.Sleep(30 * time.Millisecond)
+			stop()
+			fmt.Println("stopped")
+			time.Sleep(20 * time.Millisecond)
+		})
+
+		running, stopped, found := strings.Cut(output, "stopped\n")
+		require.True(t, found)
+		assert.Contains(t, running, "Progress: ")
+		assert.Empty(t, stopped)
+	})
+
+	t.Run("disabled without interval", func(t *testing.T) {
+		output := captureStdout(func() {
+			stop := startProgressReporter(0)
+			time.Sleep(10 * time.Millisecond)
+			stop()
+		})
+
+		assert.Empty(t, output)
+	})
+}
+
+func TestProgressIntervalUpload(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-progress-interval-bucket"
+
+	_, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Setenv("S3COPY_ENDPOINT", config.Endpoint)
+	t.Setenv("S3COPY_ACCESS_KEY", config.AccessKey)
+	t.Setenv("S3COPY_SECRET_KEY", config.SecretKey)
+	t.Setenv("S3COPY_REGION", config.Region)
+	t.Setenv("S3COPY_USE_PATH_STYLE", strconv.FormatBool(config.UsePathStyle))
+
+	localDir := t.TempDir()
+	for i := range 5 {
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, fmt.Sprintf("file%d.txt", i)), []byte(strings.Repeat("x", 1024)), 0644))
+	}
+
+	setTestConfig(localDir, fmt.Sprintf("s3://%s/progress/", bucketName), "", false, true, true, false)
+	envFile = filepath.Join(t.TempDir(), "missing.env")
+	progressPeriod = time.Millisecond
+
+	var runErr error
+	output := captureStdout(func() {
+		runErr = runCopy()
+	})
+	require.NoError(t, runErr)
+
+	assert.Contains(t, output, "Progress: ")
+	assert.Contains(t, output, "/5 files")
+}
+
+func TestMultipleDestinationsCountFilesOnce(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	localDir := t.TempDir()
+	for i := range 3 {
+		require.NoError(t, os.WriteFile(filepath.Join(localDir, fmt.Sprintf("file%d.txt", i)), []byte("content"), 0644))
+	}
+
+	setTestConfig(localDir, "s3://first/tree/", "", false, true, true, false)
+	destinations = []string{"s3://first/tree/", "s3://second/tree/"}
+	report.reset()
+	defer report.reset()
+
+	require.NoError(t, uploadToS3(context.Background()))
+	assert.Equal(t, 6, fake.count("PutObject"))
+	assert.Equal(t, int64(3), report.transferredFiles(), "each source file counts once")
+}
+
+func TestTransferProgressCallback(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	content := strings.Repeat("0123456789", 400)
+	fake := newFakeS3(nil)
+	fake.store("bucket/object.txt", &fakeObject{content: content, parts: []int{1000, 1000, 1000, 1000}})
+	useFakeS3(t, fake)
+
+	setTestConfig("", "", "", false, false, true, false)
+
+	var mutex sync.Mutex
+	var counts, totals []int64
+	onProgress := func(bucket, key string, transferred, total int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.Equal(t, "bucket", bucket)
+		assert.Equal(t, "object.txt", key)
+		counts = append(counts, transferred)
+		totals = append(totals, total)
+	}
+
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+	file, err := os.Create(filepath.Join(t.TempDir(), "object.txt"))
+	require.NoError(t, err)
+	defer closeWithLog(file, "object.txt")
+
+	downloader := manager.New(s3Client, reportTransferProgress, withTransferProgress(onProgress))
+	_, err = downloader.DownloadObject(context.Background(), &manager.DownloadObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("object.txt"),
+		WriterAt: file,
+	})
+	require.NoError(t, err)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Greater(t, len(counts), 2)
+	assert.Equal(t, int64(0), counts[len(counts)-1], "the end of the transfer is reported as zero bytes")
+	counts, totals = counts[:len(counts)-1], totals[:len(totals)-1]
+	for i := 1; i < len(counts); i++ {
+		assert.Greater(t, counts[i], counts[i-1])
+	}
+	assert.Equal(t, int64(len(content)), counts[len(counts)-1])
+	assert.Equal(t, int64(len(content)), totals[len(totals)-1])
+	assert.Empty(t, report.inFlight)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// resumablePart is one part of a resumable multipart upload
+type resumablePart struct {
+	number int32
+	offset int64
+	size   int64
+}
+
+// shouldResumeUpload reports whether a file is uploaded with the resumable
+// multipart flow. Files below the multipart threshold are sent in one request
+// and have nothing to resume.
+func shouldResumeUpload(size int64) bool {
+	threshold := int64(DefaultMultipartThreshold)
+	if multipartThresholdBytes > 0 {
+		threshold = multipartThresholdBytes
+	}
+	return resumeUploads && size >= threshold
+}
+
+// resumePartSize returns the part size for a file. It only depends on the file
+// size, so every run splits the same file into the same parts.
+func resumePartSize(size int64) int64 {
+	partSize := int64(DefaultResumePartSize)
+	if minSize := (size + MaxUploadParts - 1) / MaxUploadParts; minSize > partSize {
+		partSize = minSize
+	}
+	return partSize
+}
+
+// splitResumableParts splits a file of the given size into parts
+func splitResumableParts(size, partSize int64) []resumablePart {
+	var parts []resumablePart
+	for offset := int64(0); offset < size; offset += partSize {
+		parts = append(parts, resumablePart{
+			number: int32(len(parts) + 1),
+			offset: offset,
+			size:   min(partSize, size-offset),
+		})
+	}
+	return parts
+}
+
+// uploadResumable uploads a file with a multipart flow that, unlike the
+// transfer manager, leaves the uploaded parts in place when it fails. When an
+// incomplete upload for the key exists, its parts are checked against the
+// local file and only the missing ones are uploaded. The requests are sent
+// with the client of uploader, and its progress listeners are told about
+// every finished part and the end of the upload.
+func uploadResumable(ctx context.Context, uploader *uploadManager, file *os.File, size int64, input *manager.UploadObjectInput) (err error) {
+	s3Client := uploader.s3Client
+	bucketName, s3Key := aws.ToString(input.Bucket), aws.ToString(input.Key)
+	parts := splitResumableParts(size, resumePartSize(size))
+
+	uploadID, existingParts, err := findResumableUpload(ctx, s3Client, bucketName, s3Key)
+	if err != nil {
+		return err
+	}
+
+	completed := make(map[int32]types.CompletedPart)
+	if uploadID != "" {
+		completed, err = matchUploadedParts(file, parts, existingParts)
+		if err != nil {
+			logInfo("Discarding incomplete upload of s3://%s/%s: %v\n", bucketName, s3Key, err)
+			if _, abortErr := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucketName),
+				Key:      aws.String(s3Key),
+				UploadId: aws.String(uploadID),
+			}); abortErr != nil {
+				return fmt.Errorf("failed to abort incomplete upload: %w", abortErr)
+			}
+			uploadID = ""
+			completed = make(map[int32]types.CompletedPart)
+		} else {
+			logInfo("Resuming upload of s3://%s/%s (%d of %d parts already uploaded)\n", bucketName, s3Key, len(completed), len(parts))
+		}
+	}
+
+	if uploadID == "" {
+		created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:             input.Bucket,
+			Key:                input.Key,
+			Metadata:           input.Metadata,
+			Expires:            input.Expires,
+			Tagging:            input.Tagging,
+			GrantFullControl:   input.GrantFullControl,
+			GrantRead:          input.GrantRead,
+			ContentType:        input.ContentType,
+			CacheControl:       input.CacheControl,
+			ContentLanguage:    input.ContentLanguage,
+			ContentDisposition: input.ContentDisposition,
+			ChecksumAlgorithm:  types.ChecksumAlgorithm(uploadChecksumAlgorithm),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create multipart upload: %w", err)
+		}
+		uploadID = aws.ToString(created.UploadId)
+	}
+
+	var missing []resumablePart
+	var transferred int64
+	for _, part := range parts {
+		if _, ok := completed[part.number]; ok {
+			transferred += part.size
+		} else {
+			missing = append(missing, part)
+		}
+	}
+	defer func() {
+		if err != nil {
+			for _, listener := range uploader.listeners.ObjectTransferFailed {
+				listener.OnObjectTransferFailed(ctx, &manager.ObjectTransferFailedEvent{Input: input, Error: err, BytesTransferred: transferred, TotalBytes: size})
+			}
+			return
+		}
+		for _, listener := range uploader.listeners.ObjectTransferComplete {
+			listener.OnObjectTransferComplete(ctx, &manager.ObjectTransferCompleteEvent{Input: input, BytesTransferred: transferred, TotalBytes: size})
+		}
+	}()
+
+	var mutex sync.Mutex
+	err = runWorkerPool(ctx, missing, DefaultResumePartConcurrency, func(workerCtx context.Context, part resumablePart) error {
+		result, err := s3Client.UploadPart(workerCtx, &s3.UploadPartInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(s3Key),
+			UploadId:          aws.String(uploadID),
+			PartNumber:        aws.Int32(part.number),
+			Body:              io.NewSectionReader(file, part.offset, part.size),
+			ChecksumAlgorithm: types.ChecksumAlgorithm(uploadChecksumAlgorithm),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", part.number, err)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		transferred += part.size
+		for _, listener := range uploader.listeners.ObjectBytesTransferred {
+			listener.OnObjectBytesTransferred(workerCtx, &manager.ObjectBytesTransferredEvent{
+				Input:            input,
+				BytesTransferred: transferred,
+				TotalBytes:       size,
+			})
+		}
+		completed[part.number] = types.CompletedPart{
+			ETag:           result.ETag,
+			PartNumber:     aws.Int32(part.number),
+			ChecksumCRC32:  result.ChecksumCRC32,
+			ChecksumCRC32C: result.ChecksumCRC32C,
+			ChecksumSHA1:   result.ChecksumSHA1,
+			ChecksumSHA256: result.ChecksumSHA256,
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w (run again with --resume to continue the upload)", err)
+	}
+
+	completedParts := make([]types.CompletedPart, 0, len(completed))
+	for _, part := range completed {
+		completedParts = append(completedParts, part)
+	}
+	slices.SortFunc(completedParts, func(a, b types.CompletedPart) int {
+		return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+	})
+
+	completeInput := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(s3Key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+	}
+	if ifNoneMatch {
+		completeInput.IfNoneMatch = aws.String("*")
+	}
+	if _, err := s3Client.CompleteMultipartUpload(ctx, completeInput); err != nil {
+		if isPreconditionFailed(err) {
+			return err
+		}
+		return fmt.Errorf("failed to complete multipart upload: %w (run again with --resume to continue the upload)", err)
+	}
+
+	return nil
+}
+
+// findResumableUpload returns the most recently initiated incomplete upload
+// for a key and its uploaded parts, or an empty upload ID when there is none
+func findResumableUpload(ctx context.Context, s3Client *s3.Client, bucketName, s3Key string) (string, []types.Part, error) {
+	var uploadID string
+	var initiated time.Time
+
+	paginator := s3.NewListMultipartUploadsPaginator(s3Client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(s3Key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if aws.ToString(upload.Key) != s3Key {
+				continue
+			}
+			if uploadID == "" || aws.ToTime(upload.Initiated).After(initiated) {
+				uploadID = aws.ToString(upload.UploadId)
+				initiated = aws.ToTime(upload.Initiated)
+			}
+		}
+	}
+
+	if uploadID == "" {
+		return "", nil, nil
+	}
+
+	var parts []types.Part
+	partsPaginator := s3.NewListPartsPaginator(s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(s3Key),
+		UploadId: aws.String(uploadID),
+	})
+	for partsPaginator.HasMorePages() {
+		page, err := partsPaginator.NextPage(ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list uploaded parts: %w", err)
+		}
+		parts = append(parts, page.Parts...)
+	}
+
+	return uploadID, parts, nil
+}
+
+// matchUploadedParts checks the parts of an incomplete upload against the
+// local file. Every uploaded part must have the expected size and the MD5 of
+// the local bytes as ETag, otherwise the upload belongs to a different version
+// of the file and cannot be resumed.
+func matchUploadedParts(file *os.File, parts []resumablePart, uploaded []types.Part) (map[int32]types.CompletedPart, error) {
+	completed := make(map[int32]types.CompletedPart, len(uploaded))
+
+	for _, existing := range uploaded {
+		number := aws.ToInt32(existing.PartNumber)
+		if number < 1 || int(number) > len(parts) {
+			return nil, fmt.Errorf("unexpected part number %d", number)
+		}
+
+		part := parts[number-1]
+		if aws.ToInt64(existing.Size) != part.size {
+			return nil, fmt.Errorf("part %d has size %d, expected %d", number, aws.ToInt64(existing.Size), part.size)
+		}
+
+		hash := md5.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, part.offset, part.size)); err != nil {
+			return nil, fmt.Errorf("failed to read part %d: %w", number, err)
+		}
+		if normalizeETag(aws.ToString(existing.ETag)) != hex.EncodeToString(hash.Sum(nil)) {
+			return nil, fmt.Errorf("part %d does not match the local file", number)
+		}
+
+		completed[number] = types.CompletedPart{
+			ETag:           existing.ETag,
+			PartNumber:     aws.Int32(number),
+			ChecksumCRC32:  existing.ChecksumCRC32,
+			ChecksumCRC32C: existing.ChecksumCRC32C,
+			ChecksumSHA1:   existing.ChecksumSHA1,
+			ChecksumSHA256: existing.ChecksumSHA256,
+		}
+	}
+
+	return completed, nil
+}
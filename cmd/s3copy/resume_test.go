@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitResumableParts(t *testing.T) {
+	parts := splitResumableParts(20, 8)
+	assert.Equal(t, []resumablePart{
+		{number: 1, offset: 0, size: 8},
+		{number: 2, offset: 8, size: 8},
+		{number: 3, offset: 16, size: 4},
+	}, parts)
+
+	assert.Len(t, splitResumableParts(16, 8), 2)
+	assert.Empty(t, splitResumableParts(0, 8))
+
+	assert.Equal(t, int64(DefaultResumePartSize), resumePartSize(100*1024*1024))
+	hugeSize := int64(DefaultResumePartSize)*MaxUploadParts + 1
+	assert.LessOrEqual(t, len(splitResumableParts(hugeSize, resumePartSize(hugeSize))), MaxUploadParts)
+}
+
+func TestShouldResumeUpload(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	resumeUploads = false
+	assert.False(t, shouldResumeUpload(100*1024*1024))
+
+	resumeUploads = true
+	multipartThresholdBytes = 0
+	assert.False(t, shouldResumeUpload(DefaultMultipartThreshold-1))
+	assert.True(t, shouldResumeUpload(DefaultMultipartThreshold))
+
+	multipartThresholdBytes = 1024
+	assert.True(t, shouldResumeUpload(1024))
+}
+
+func TestMatchUploadedParts(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer closeWithLog(file, filePath)
+
+	etag := func(data []byte) *string {
+		sum := md5.Sum(data)
+		return aws.String("\"" + hex.EncodeToString(sum[:]) + "\"")
+	}
+
+	parts := splitResumableParts(int64(len(content)), 8)
+
+	completed, err := matchUploadedParts(file, parts, []types.Part{
+		{PartNumber: aws.Int32(2), Size: aws.Int64(8), ETag: etag(content[8:16])},
+	})
+	require.NoError(t, err)
+	assert.Len(t, completed, 1)
+	assert.Contains(t, completed, int32(2))
+
+	_, err = matchUploadedParts(file, parts, []types.Part{
+		{PartNumber: aws.Int32(1), Size: aws.Int64(8), ETag: etag([]byte("changed!"))},
+	})
+	assert.ErrorContains(t, err, "does not match")
+
+	_, err = matchUploadedParts(file, parts, []types.Part{
+		{PartNumber: aws.Int32(1), Size: aws.Int64(5), ETag: etag(content[:5])},
+	})
+	assert.ErrorContains(t, err, "has size")
+
+	_, err = matchUploadedParts(file, parts, []types.Part{
+		{PartNumber: aws.Int32(4), Size: aws.Int64(8), ETag: etag(content[:8])},
+	})
+	assert.ErrorContains(t, err, "unexpected part number")
+}
+
+func TestResumeMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-resume-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	content := make([]byte, DefaultResumePartSize+64*1024)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+
+	filePath := filepath.Join(t.TempDir(), "large.bin")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+
+	key := "resume/large.bin"
+
+	// Simulate an interrupted upload that transferred only the first part
+	created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	require.NoError(t, err)
+	_, err = s3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(key),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       bytes.NewReader(content[:DefaultResumePartSize]),
+	})
+	require.NoError(t, err)
+
+	setTestConfig(filePath, fmt.Sprintf("s3://%s/%s", bucketName, key), "", false, false, false, false)
+	resumeUploads = true
+	multipartThresholdBytes = 5 * 1024 * 1024
+
+	output := captureStdout(func() {
+		err = performS3Upload(ctx, newUploader(s3Client), bucketName, key, filePath, false)
+	})
+	require.NoError(t, err)
+	assert.Contains(t, output, "1 of 2 parts already uploaded")
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	require.NoError(t, err)
+	defer closeWithLog(result.Body, key)
+	downloaded, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+
+	uploads, err := s3Client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(key),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, uploads.Uploads)
+}
+
+func TestResumableUploadUsesUploaderClient(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	server := useFakeS3(t, fake)
+
+	filePath := filepath.Join(t.TempDir(), "large.bin")
+	content := bytes.Repeat([]byte("x"), DefaultResumePartSize+1024)
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+
+	setTestConfig(filePath, "s3://bucket/large.bin", "", false, false, true, false)
+	resumeUploads = true
+	multipartThresholdBytes = 1024
+	// the shared client points nowhere; only the uploader's client reaches the server
+	config.Endpoint = "http://127.0.0.1:1"
+	resetS3Client()
+
+	s3Client := s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		Region:       "us-east-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("access", "secret", ""),
+	})
+
+	var mutex sync.Mutex
+	var progress []int64
+	onProgress := func(bucket, key string, transferred, total int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		progress = append(progress, transferred)
+	}
+
+	uploader := newUploader(s3Client, withTransferProgress(onProgress))
+	require.NoError(t, performS3Upload(context.Background(), uploader, "bucket", "large.bin", filePath, false))
+
+	assert.Equal(t, []string{"ListMultipartUploads", "CreateMultipartUpload", "UploadPart", "UploadPart", "CompleteMultipartUpload"}, fake.operations())
+	assert.Equal(t, string(content), fake.content("bucket/large.bin"))
+	require.NotEmpty(t, progress, "finished parts are reported to the progress listener")
+	assert.Equal(t, int64(len(content)), slices.Max(progress))
+	assert.Equal(t, int64(0), progress[len(progress)-1], "the end of the upload is reported")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// retryStartKey holds the time an S3 operation started, before its first
+// attempt
+type retryStartKey struct{}
+
+// deadlineRetryer stops retrying an operation once --retry-deadline has
+// passed since its first attempt, even when --retries allows more attempts
+type deadlineRetryer struct {
+	aws.RetryerV2
+	deadline time.Duration
+}
+
+// GetRetryToken is asked before every retry. Refusing the token ends the
+// retry loop with the error of the last attempt.
+func (r *deadlineRetryer) GetRetryToken(ctx context.Context, opErr error) (func(error) error, error) {
+	if start, ok := ctx.Value(retryStartKey{}).(time.Time); ok {
+		if elapsed := time.Since(start); elapsed >= r.deadline {
+			return nil, fmt.Errorf("retry deadline of %s exceeded after %s", r.deadline, elapsed.Round(time.Millisecond))
+		}
+	}
+	return r.RetryerV2.GetRetryToken(ctx, opErr)
+}
+
+// withRetryDeadline wraps retryer with --retry-deadline when it is set
+func withRetryDeadline(retryer aws.Retryer) aws.Retryer {
+	retryerV2, ok := retryer.(aws.RetryerV2)
+	if retryDeadlineDuration <= 0 || !ok {
+		return retryer
+	}
+	return &deadlineRetryer{RetryerV2: retryerV2, deadline: retryDeadlineDuration}
+}
+
+// addRetryStart records the start of every operation for deadlineRetryer.
+// It runs once per operation, outside the retry loop.
+func addRetryStart(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3CopyRetryStart", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		return next.HandleInitialize(context.WithValue(ctx, retryStartKey{}, time.Now()), in)
+	}), middleware.Before)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDeadline(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	var attempts atomic.Int32
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	setTestConfig("", "", "", false, false, true, false)
+	retries = 10
+	retryDeadlineDuration = 250 * time.Millisecond
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+
+	_, err = s3Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("slow.txt"),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "retry deadline of 250ms exceeded")
+	// every attempt takes 100ms, so the third one ends past the deadline at the latest
+	assert.GreaterOrEqual(t, attempts.Load(), int32(2))
+	assert.LessOrEqual(t, attempts.Load(), int32(3))
+}
+
+func TestWithRetryDeadline(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	retryer := aws.NopRetryer{}
+	retryDeadlineDuration = 0
+	assert.Equal(t, aws.Retryer(retryer), withRetryDeadline(retryer))
+
+	retryDeadlineDuration = time.Minute
+	wrapped, ok := withRetryDeadline(retryer).(*deadlineRetryer)
+	require.True(t, ok)
+	assert.Equal(t, time.Minute, wrapped.deadline)
+
+	// operations that did not pass the start middleware are not limited
+	_, err := wrapped.GetRetryToken(context.Background(), nil)
+	assert.NoError(t, err)
+	expired := context.WithValue(context.Background(), retryStartKey{}, time.Now().Add(-2*time.Minute))
+	_, err = wrapped.GetRetryToken(expired, nil)
+	assert.Error(t, err)
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -68,88 +77,423 @@ func checkS3ObjectExists(ctx context.Context, s3Client *s3.Client, bucket, key s
 		return false, "", nil, err
 	}
 
-	etag = ""
-	if result.ETag != nil {
-		etag = strings.Trim(*result.ETag, "\"")
+	return true, normalizeETag(aws.ToString(result.ETag)), result.Metadata, nil
+}
+
+// normalizeETag strips the quotes of an ETag and the W/ prefix that some
+// gateways put in front of it, so ETags compare equal to a hex MD5 however
+// the server formats them
+func normalizeETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	if len(etag) > 2 && (etag[:2] == "W/" || etag[:2] == "w/") {
+		etag = etag[2:]
 	}
+	return strings.Trim(etag, `"`)
+}
 
-	return true, etag, result.Metadata, nil
+// isNotFound reports whether a HEAD request failed because the object does
+// not exist. MinIO answers with a plain 404 instead of NoSuchKey.
+func isNotFound(err error) bool {
+	var notFound *types.NoSuchKey
+	return errors.As(err, &notFound) || strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "NotFound")
 }
 
-func listS3Objects() error {
-	ctx := context.Background()
-	s3Client, err := getS3Client(ctx)
+// isForbidden reports whether a request failed with HTTP 403. HEAD responses
+// have no body, so the status code is the only reliable signal.
+func isForbidden(err error) bool {
+	var responseErr *awshttp.ResponseError
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusForbidden
+}
+
+// listS3KeySet lists all object keys under the given prefix and returns them as a set.
+// With --max-list-concurrency above 1 the prefix is split into shards at the next
+// "/" and up to that many shards are listed in parallel.
+func listS3KeySet(ctx context.Context, s3Client s3.ListObjectsV2APIClient, bucket, prefix string) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+
+	if maxListConcurrency <= 1 {
+		_, err := listS3Keys(ctx, s3Client, bucket, prefix, "", func(key string) {
+			keys[key] = struct{}{}
+		})
+		return keys, err
+	}
+
+	shards, err := listS3Keys(ctx, s3Client, bucket, prefix, "/", func(key string) {
+		keys[key] = struct{}{}
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get S3 client: %v", err)
+		return nil, err
+	}
+	logVerbose("Listing %d shards under prefix %s with up to %d concurrent requests\n", len(shards), prefix, maxListConcurrency)
+
+	var mutex sync.Mutex
+	err = runWorkerPool(ctx, shards, maxListConcurrency, func(workerCtx context.Context, shard string) error {
+		_, shardErr := listS3Keys(workerCtx, s3Client, bucket, shard, "", func(key string) {
+			mutex.Lock()
+			keys[key] = struct{}{}
+			mutex.Unlock()
+		})
+		return shardErr
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return keys, nil
+}
+
+// listS3Keys pages through the objects under prefix and calls addKey for every
+// key. With a delimiter, the common prefixes are collected and returned.
+func listS3Keys(ctx context.Context, s3Client s3.ListObjectsV2APIClient, bucket, prefix, delimiter string, addKey func(string)) ([]string, error) {
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
 	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
 
+	var commonPrefixes []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			if obj.Key != nil {
+				addKey(*obj.Key)
+			}
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			if commonPrefix.Prefix != nil {
+				commonPrefixes = append(commonPrefixes, *commonPrefix.Prefix)
+			}
+		}
+	}
+
+	return commonPrefixes, nil
+}
+
+// newListingInput returns the ListObjectsV2 input of --list, with the prefix
+// from --filter and the position from --start-after. With --limit the pages
+// are not larger than needed.
+func newListingInput() *s3.ListObjectsV2Input {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}
 	if filter != "" {
 		input.Prefix = aws.String(filter)
 	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
+	if listLimit > 0 {
+		input.MaxKeys = aws.Int32(int32(min(listLimit, 1000)))
+	}
+	return input
+}
+
+// printContinuationHint tells the user how to list the next batch when
+// --limit stopped the listing before the end
+func printContinuationHint(limitReached bool, lastKey string) {
+	if limitReached {
+		fmt.Printf("Limit of %d objects reached, continue with --start-after %q\n", listLimit, lastKey)
+	}
+}
+
+// forEachListedObject pages through a listing and calls fn for every object.
+// With --limit it stops after that many objects and reports whether more
+// objects follow, together with the last key passed to fn.
+func forEachListedObject(ctx context.Context, s3Client s3.ListObjectsV2APIClient, input *s3.ListObjectsV2Input, fn func(types.Object) error) (limitReached bool, lastKey string, err error) {
+	var count int
+	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
+	for paginator.HasMorePages() {
+		if listLimit > 0 && count == listLimit {
+			return true, lastKey, nil
+		}
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, lastKey, fmt.Errorf("failed to get next page: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			if listLimit > 0 && count == listLimit {
+				return true, lastKey, nil
+			}
+			if err := fn(obj); err != nil {
+				return false, lastKey, err
+			}
+			lastKey = aws.ToString(obj.Key)
+			count++
+		}
+	}
+	return false, lastKey, nil
+}
+
+func listS3Objects() error {
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %v", err)
+	}
+
+	input := newListingInput()
 
 	fmt.Printf("Listing objects in bucket '%s'", bucket)
 	if filter != "" {
 		fmt.Printf(" with prefix '%s'", filter)
 	}
+	if startAfter != "" {
+		fmt.Printf(" after '%s'", startAfter)
+	}
 	fmt.Println(":")
 	fmt.Println()
 
 	var totalObjects int64
 	var totalSize int64
 
+	checksumHeader := ""
+	if listWithChecksum {
+		checksumHeader = " Checksum"
+		warnListChecksumCost()
+	}
+
 	if listDetailed {
-		fmt.Printf("%-50s %10s %-20s %-15s %-35s\n", "Key", "Size", "Last Modified", "Storage Class", "ETag")
+		fmt.Printf("%-50s %10s %-20s %-15s %-35s%s\n", "Key", "Size", "Last Modified", "Storage Class", "ETag", checksumHeader)
 		fmt.Printf("%-50s %10s %-20s %-15s %-35s\n", strings.Repeat("-", 50), strings.Repeat("-", 10), strings.Repeat("-", 20), strings.Repeat("-", 15), strings.Repeat("-", 35))
 	} else {
-		fmt.Printf("%-50s %10s %-20s\n", "Key", "Size", "Last Modified")
+		fmt.Printf("%-50s %10s %-20s%s\n", "Key", "Size", "Last Modified", checksumHeader)
 		fmt.Printf("%-50s %10s %-20s\n", strings.Repeat("-", 50), strings.Repeat("-", 10), strings.Repeat("-", 20))
 	}
 
-	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
+	printObject := func(obj types.Object, checksum string) {
+		if checksum != "" {
+			checksum = " " + checksum
+		}
+		if listDetailed {
+			storageClass := ""
+			if obj.StorageClass != "" {
+				storageClass = string(obj.StorageClass)
+			}
+			etag := normalizeETag(aws.ToString(obj.ETag))
+			if len(etag) > 32 {
+				etag = etag[:32] + "..."
+			}
+			fmt.Printf("%-50s %10s %-20s %-15s %-35s%s\n",
+				truncateString(*obj.Key, 50),
+				formatBytes(*obj.Size),
+				obj.LastModified.Format("2006-01-02 15:04:05"),
+				storageClass,
+				etag,
+				checksum)
+		} else {
+			fmt.Printf("%-50s %10s %-20s%s\n",
+				truncateString(*obj.Key, 50),
+				formatBytes(*obj.Size),
+				obj.LastModified.Format("2006-01-02 15:04:05"),
+				checksum)
+		}
+	}
+
+	var batch *checksumBatch
+	if listWithChecksum {
+		batch = &checksumBatch{s3Client: s3Client, bucketName: bucket, print: printObject}
+	}
 
+	limitReached, lastKey, err := forEachListedObject(ctx, s3Client, input, func(obj types.Object) error {
+		totalObjects++
+		totalSize += *obj.Size
+
+		if batch != nil {
+			batch.add(ctx, obj)
+			return nil
+		}
+		printObject(obj, "")
+		return nil
+	})
+	if batch != nil {
+		batch.flush(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("Total: %d objects, %s\n", totalObjects, formatBytes(totalSize))
+	printContinuationHint(limitReached, lastKey)
+
+	return nil
+}
+
+var inventoryCSVHeader = []string{"bucket", "key", "size", "last_modified", "etag", "storage_class"}
+
+// inventoryCSVRecord converts a listed object into an inventory-style CSV record
+func inventoryCSVRecord(bucketName string, obj types.Object) []string {
+	var size int64
+	if obj.Size != nil {
+		size = *obj.Size
+	}
+
+	lastModified := ""
+	if obj.LastModified != nil {
+		lastModified = obj.LastModified.UTC().Format(time.RFC3339)
+	}
+
+	etag := normalizeETag(aws.ToString(obj.ETag))
+
+	return []string{
+		bucketName,
+		aws.ToString(obj.Key),
+		strconv.FormatInt(size, 10),
+		lastModified,
+		etag,
+		string(obj.StorageClass),
+	}
+}
+
+// exportS3ObjectsCSV streams the object listing of the bucket to a CSV file page by page
+func exportS3ObjectsCSV(csvPath string) error {
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %v", err)
+	}
+
+	file, err := os.Create(csvPath)
+	if err != nil {
+		return fmt.Errorf("failed to create CSV file: %v", err)
+	}
+	defer closeWithLog(file, csvPath)
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(inventoryCSVHeader); err != nil {
+		return fmt.Errorf("failed to write CSV header: %v", err)
+	}
+
+	var totalObjects int64
+	limitReached, lastKey, err := forEachListedObject(ctx, s3Client, newListingInput(), func(obj types.Object) error {
+		if err := writer.Write(inventoryCSVRecord(bucket, obj)); err != nil {
+			return fmt.Errorf("failed to write CSV record: %v", err)
+		}
+		totalObjects++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV file: %v", err)
+	}
+
+	logSummary("Exported %d objects to %s\n", totalObjects, csvPath)
+	if currentOutputMode().summary() {
+		printContinuationHint(limitReached, lastKey)
+	}
+	return nil
+}
+
+// duplicateGroup is a set of objects with the same ETag and size
+type duplicateGroup struct {
+	etag string
+	size int64
+	keys []string
+}
+
+// reclaimable returns the bytes freed if all but one copy were removed
+func (g duplicateGroup) reclaimable() int64 {
+	return g.size * int64(len(g.keys)-1)
+}
+
+// findDuplicateObjects groups the objects under prefix by ETag and size and
+// returns the groups with more than one member, largest reclaimable space first.
+// The ETag of a single-part upload is the MD5 of its content. Multipart ETags
+// also depend on the part size, so identical content uploaded with different
+// part sizes is not detected.
+func findDuplicateObjects(ctx context.Context, s3Client s3.ListObjectsV2APIClient, bucketName, prefix string) ([]duplicateGroup, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+
+	type groupKey struct {
+		etag string
+		size int64
+	}
+	groups := make(map[groupKey][]string)
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to get next page: %v", err)
+			return nil, fmt.Errorf("failed to get next page: %w", err)
 		}
 
 		for _, obj := range page.Contents {
-			totalObjects++
-			totalSize += *obj.Size
-
-			if listDetailed {
-				storageClass := ""
-				if obj.StorageClass != "" {
-					storageClass = string(obj.StorageClass)
-				}
-				etag := ""
-				if obj.ETag != nil {
-					etag = strings.Trim(*obj.ETag, "\"")
-					if len(etag) > 32 {
-						etag = etag[:32] + "..."
-					}
-				}
-				fmt.Printf("%-50s %10s %-20s %-15s %-35s\n",
-					truncateString(*obj.Key, 50),
-					formatBytes(*obj.Size),
-					obj.LastModified.Format("2006-01-02 15:04:05"),
-					storageClass,
-					etag)
-			} else {
-				fmt.Printf("%-50s %10s %-20s\n",
-					truncateString(*obj.Key, 50),
-					formatBytes(*obj.Size),
-					obj.LastModified.Format("2006-01-02 15:04:05"))
+			etag := normalizeETag(aws.ToString(obj.ETag))
+			if obj.Key == nil || etag == "" {
+				continue
 			}
+			key := groupKey{etag: etag, size: aws.ToInt64(obj.Size)}
+			groups[key] = append(groups[key], *obj.Key)
 		}
 	}
 
-	fmt.Println()
-	fmt.Printf("Total: %d objects, %s\n", totalObjects, formatBytes(totalSize))
+	var duplicates []duplicateGroup
+	for key, keys := range groups {
+		if len(keys) < 2 {
+			continue
+		}
+		slices.Sort(keys)
+		duplicates = append(duplicates, duplicateGroup{etag: key.etag, size: key.size, keys: keys})
+	}
+	slices.SortFunc(duplicates, func(a, b duplicateGroup) int {
+		return cmp.Or(cmp.Compare(b.reclaimable(), a.reclaimable()), strings.Compare(a.keys[0], b.keys[0]))
+	})
+	return duplicates, nil
+}
+
+// listDuplicateObjects prints the sets of objects with identical content and
+// the space that removing the redundant copies would reclaim
+func listDuplicateObjects() error {
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %v", err)
+	}
+
+	duplicates, err := findDuplicateObjects(ctx, s3Client, bucket, filter)
+	if err != nil {
+		return err
+	}
 
+	fmt.Printf("Duplicate objects in bucket '%s'", bucket)
+	if filter != "" {
+		fmt.Printf(" with prefix '%s'", filter)
+	}
+	fmt.Println(":")
+
+	var redundantCopies int
+	var reclaimable int64
+	for _, group := range duplicates {
+		fmt.Println()
+		fmt.Printf("ETag %s (%d copies, %s each)\n", group.etag, len(group.keys), formatBytes(group.size))
+		for _, key := range group.keys {
+			fmt.Printf("  %s\n", key)
+		}
+		redundantCopies += len(group.keys) - 1
+		reclaimable += group.reclaimable()
+	}
+
+	fmt.Println()
+	fmt.Printf("Total: %d duplicate sets, %d redundant copies, %s reclaimable\n", len(duplicates), redundantCopies, formatBytes(reclaimable))
 	return nil
 }
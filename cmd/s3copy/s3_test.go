@@ -4,10 +4,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -249,3 +257,353 @@ func TestListS3ObjectsDetailed(t *testing.T) {
 		assert.Contains(t, output, "dir/file3.txt")
 	})
 }
+
+func TestNormalizeETag(t *testing.T) {
+	tests := map[string]string{
+		`"0123456789abcdef0123456789abcdef"`:      "0123456789abcdef0123456789abcdef",
+		`0123456789abcdef0123456789abcdef`:        "0123456789abcdef0123456789abcdef",
+		`W/"0123456789abcdef0123456789abcdef"`:    "0123456789abcdef0123456789abcdef",
+		`w/"0123456789abcdef0123456789abcdef"`:    "0123456789abcdef0123456789abcdef",
+		` W/"fedcba9876543210fedcba9876543210-3"`: "fedcba9876543210fedcba9876543210-3",
+		`""`: "",
+		``:   "",
+		`W/`: "W/",
+	}
+	for etag, expected := range tests {
+		assert.Equal(t, expected, normalizeETag(etag), etag)
+	}
+
+	modified := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	record := inventoryCSVRecord("my-bucket", types.Object{
+		Key:          aws.String("weak.txt"),
+		Size:         aws.Int64(1),
+		LastModified: &modified,
+		ETag:         aws.String(`W/"abc123"`),
+	})
+	assert.Equal(t, "abc123", record[4])
+}
+
+func TestInventoryCSVRecord(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	record := inventoryCSVRecord("my-bucket", types.Object{
+		Key:          aws.String("reports/q1, final.csv"),
+		Size:         aws.Int64(1234),
+		LastModified: &modified,
+		ETag:         aws.String("\"abc123\""),
+		StorageClass: types.ObjectStorageClassStandard,
+	})
+	assert.Equal(t, []string{"my-bucket", "reports/q1, final.csv", "1234", "2024-03-01T12:30:00Z", "abc123", "STANDARD"}, record)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	require.NoError(t, writer.Write(inventoryCSVHeader))
+	require.NoError(t, writer.Write(record))
+	writer.Flush()
+
+	assert.Equal(t, "bucket,key,size,last_modified,etag,storage_class\n"+
+		"my-bucket,\"reports/q1, final.csv\",1234,2024-03-01T12:30:00Z,abc123,STANDARD\n", buf.String())
+}
+
+func TestExportS3ObjectsCSV(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-csv-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	for _, key := range []string{"a.txt", "dir/b,c.txt"} {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("csv content")),
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("export bucket listing", func(t *testing.T) {
+		restore := preserveGlobalVars()
+		defer restore()
+
+		bucket = bucketName
+		filter = ""
+		quiet = true
+		csvPath := filepath.Join(t.TempDir(), "inventory.csv")
+
+		err := exportS3ObjectsCSV(csvPath)
+		require.NoError(t, err)
+
+		file, err := os.Open(csvPath)
+		require.NoError(t, err)
+		defer closeWithLog(file, csvPath)
+
+		records, err := csv.NewReader(file).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, inventoryCSVHeader, records[0])
+		assert.Equal(t, bucketName, records[1][0])
+		assert.Equal(t, "a.txt", records[1][1])
+		assert.Equal(t, "11", records[1][2])
+		assert.Equal(t, "dir/b,c.txt", records[2][1])
+	})
+}
+
+// shardedListClient serves ten shards under "data/" and records the highest
+// number of concurrent ListObjectsV2 calls
+type shardedListClient struct {
+	active    atomic.Int32
+	maxActive atomic.Int32
+}
+
+func (c *shardedListClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	active := c.active.Add(1)
+	defer c.active.Add(-1)
+	for {
+		current := c.maxActive.Load()
+		if active <= current || c.maxActive.CompareAndSwap(current, active) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	output := &s3.ListObjectsV2Output{}
+	if aws.ToString(params.Delimiter) == "/" {
+		output.Contents = []types.Object{{Key: aws.String("data/root.txt")}}
+		for i := range 10 {
+			output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(fmt.Sprintf("data/%d/", i))})
+		}
+		return output, nil
+	}
+
+	output.Contents = []types.Object{{Key: aws.String(aws.ToString(params.Prefix) + "file.txt")}}
+	return output, nil
+}
+
+func TestListS3KeySetConcurrency(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("sharded listing respects the cap", func(t *testing.T) {
+		maxListConcurrency = 3
+		client := &shardedListClient{}
+
+		keys, err := listS3KeySet(context.Background(), client, "bucket", "data/")
+		require.NoError(t, err)
+
+		assert.Len(t, keys, 11)
+		assert.Contains(t, keys, "data/root.txt")
+		assert.Contains(t, keys, "data/9/file.txt")
+		assert.LessOrEqual(t, client.maxActive.Load(), int32(3))
+		assert.Greater(t, client.maxActive.Load(), int32(1))
+	})
+
+	t.Run("default lists sequentially", func(t *testing.T) {
+		maxListConcurrency = 1
+		client := &shardedListClient{}
+
+		keys, err := listS3KeySet(context.Background(), client, "bucket", "data/")
+		require.NoError(t, err)
+
+		assert.Len(t, keys, 1)
+		assert.Equal(t, int32(1), client.maxActive.Load())
+	})
+}
+
+// pagedListClient serves a fixed object listing two objects per page
+type pagedListClient struct {
+	objects []types.Object
+}
+
+func (c *pagedListClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	start := 0
+	if params.ContinuationToken != nil {
+		start, _ = strconv.Atoi(*params.ContinuationToken)
+	}
+	end := min(start+2, len(c.objects))
+
+	output := &s3.ListObjectsV2Output{Contents: c.objects[start:end]}
+	if end < len(c.objects) {
+		output.IsTruncated = aws.Bool(true)
+		output.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func TestFindDuplicateObjects(t *testing.T) {
+	object := func(key, etag string, size int64) types.Object {
+		return types.Object{Key: aws.String(key), ETag: aws.String(`"` + etag + `"`), Size: aws.Int64(size)}
+	}
+	client := &pagedListClient{objects: []types.Object{
+		object("a.txt", "aaa", 10),
+		object("backup/a.txt", "aaa", 10),
+		object("big.bin", "bbb-2", 1000),
+		object("copy/big.bin", "bbb-2", 1000),
+		object("other/a.txt", "aaa", 10),
+		object("unique.txt", "ccc", 10),
+		object("same-etag-other-size.txt", "aaa", 11),
+	}}
+
+	duplicates, err := findDuplicateObjects(context.Background(), client, "bucket", "")
+	require.NoError(t, err)
+	require.Len(t, duplicates, 2)
+
+	assert.Equal(t, "bbb-2", duplicates[0].etag)
+	assert.Equal(t, []string{"big.bin", "copy/big.bin"}, duplicates[0].keys)
+	assert.Equal(t, int64(1000), duplicates[0].reclaimable())
+
+	assert.Equal(t, "aaa", duplicates[1].etag)
+	assert.Equal(t, []string{"a.txt", "backup/a.txt", "other/a.txt"}, duplicates[1].keys)
+	assert.Equal(t, int64(20), duplicates[1].reclaimable())
+}
+
+func TestListDuplicateObjects(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-dedupe-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	objects := map[string]string{
+		"photos/img1.jpg":        "duplicate content",
+		"photos/copy/img1.jpg":   "duplicate content",
+		"archive/2024/img1.jpg":  "duplicate content",
+		"photos/img2.jpg":        "unique content",
+		"docs/readme.txt":        "readme",
+		"docs/backup/readme.txt": "readme",
+	}
+	for key, content := range objects {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(content)),
+		})
+		require.NoError(t, err)
+	}
+
+	bucket = bucketName
+	filter = ""
+
+	var listErr error
+	output := captureStdout(func() {
+		listErr = listDuplicateObjects()
+	})
+	require.NoError(t, listErr)
+
+	assert.Contains(t, output, "(3 copies, 17 B each)")
+	assert.Contains(t, output, "  archive/2024/img1.jpg\n  photos/copy/img1.jpg\n  photos/img1.jpg\n")
+	assert.Contains(t, output, "  docs/backup/readme.txt\n  docs/readme.txt\n")
+	assert.NotContains(t, output, "img2.jpg")
+	assert.Contains(t, output, "Total: 2 duplicate sets, 3 redundant copies, 40 B reclaimable")
+
+	filter = "docs/"
+	output = captureStdout(func() {
+		listErr = listDuplicateObjects()
+	})
+	require.NoError(t, listErr)
+	assert.Contains(t, output, "Total: 1 duplicate sets, 1 redundant copies, 6 B reclaimable")
+}
+
+func TestForEachListedObjectLimit(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	client := &pagedListClient{}
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		client.objects = append(client.objects, types.Object{Key: aws.String(key)})
+	}
+
+	collect := func(limit int) ([]string, bool, string) {
+		listLimit = limit
+		var keys []string
+		limitReached, lastKey, err := forEachListedObject(context.Background(), client, &s3.ListObjectsV2Input{Bucket: aws.String("bucket")}, func(obj types.Object) error {
+			keys = append(keys, aws.ToString(obj.Key))
+			return nil
+		})
+		require.NoError(t, err)
+		return keys, limitReached, lastKey
+	}
+
+	keys, limitReached, lastKey := collect(0)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+	assert.False(t, limitReached)
+	assert.Equal(t, "e", lastKey)
+
+	keys, limitReached, lastKey = collect(3)
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.True(t, limitReached)
+	assert.Equal(t, "c", lastKey)
+
+	// The limit ends on a page boundary, the next page is not requested
+	keys, limitReached, lastKey = collect(4)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, keys)
+	assert.True(t, limitReached)
+	assert.Equal(t, "d", lastKey)
+
+	keys, limitReached, _ = collect(5)
+	assert.Len(t, keys, 5)
+	assert.False(t, limitReached)
+}
+
+func TestListS3ObjectsStartAfter(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-start-after-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	for _, key := range []string{"logs/01.txt", "logs/02.txt", "logs/03.txt", "logs/04.txt", "logs/05.txt"} {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("log")),
+		})
+		require.NoError(t, err)
+	}
+
+	list := func() string {
+		var listErr error
+		output := captureStdout(func() {
+			listErr = listS3Objects()
+		})
+		require.NoError(t, listErr)
+		return output
+	}
+
+	bucket = bucketName
+	filter = "logs/"
+
+	t.Run("start after omits keys at or before the marker", func(t *testing.T) {
+		startAfter = "logs/02.txt"
+		listLimit = 0
+		output := list()
+
+		assert.NotContains(t, output, "logs/01.txt")
+		assert.NotContains(t, output, "logs/02.txt")
+		assert.Contains(t, output, "logs/03.txt")
+		assert.Contains(t, output, "logs/05.txt")
+		assert.Contains(t, output, "Total: 3 objects")
+		assert.NotContains(t, output, "Limit of")
+	})
+
+	t.Run("paging with limit", func(t *testing.T) {
+		startAfter = ""
+		listLimit = 2
+		output := list()
+
+		assert.Contains(t, output, "logs/02.txt")
+		assert.NotContains(t, output, "logs/03.txt")
+		assert.Contains(t, output, `continue with --start-after "logs/02.txt"`)
+
+		startAfter = "logs/04.txt"
+		output = list()
+		assert.Contains(t, output, "logs/05.txt")
+		assert.Contains(t, output, "Total: 1 objects")
+		assert.NotContains(t, output, "Limit of")
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// scrubTask is a local file and the object it mirrors
+type scrubTask struct {
+	local  FileInfo
+	remote FileInfo
+}
+
+// expectedObjectMD5 returns the MD5 an object's content is supposed to have:
+// the local-md5 metadata written by s3copy, or the ETag of a single-part
+// object that is not encrypted with KMS or a customer key. It returns an
+// empty string when neither is known.
+func expectedObjectMD5(output *s3.GetObjectOutput) string {
+	if storedMD5 := output.Metadata["local-md5"]; storedMD5 != "" {
+		return storedMD5
+	}
+	etag := normalizeETag(aws.ToString(output.ETag))
+	if etag == "" || strings.Contains(etag, "-") || aws.ToString(output.SSECustomerAlgorithm) != "" ||
+		output.ServerSideEncryption == types.ServerSideEncryptionAwsKms || output.ServerSideEncryption == types.ServerSideEncryptionAwsKmsDsse {
+		return ""
+	}
+	return etag
+}
+
+// scrubObjectMD5 reads the whole object and returns the MD5 of its content.
+// S3 validates the additional checksum of the object while it is read, and
+// the content is checked against expectedObjectMD5, so damage on the S3 side
+// is reported as an error instead of being copied over the local file.
+func scrubObjectMD5(ctx context.Context, s3Client *s3.Client, bucket, key string) (string, error) {
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}, func(o *s3.Options) {
+		// most objects have no additional checksum, which is not worth a warning
+		o.DisableLogOutputChecksumValidationSkipped = true
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+	defer closeWithLog(output.Body, key)
+
+	h := md5.New()
+	if _, err := io.Copy(h, output.Body); err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+
+	if expected := expectedObjectMD5(output); expected != "" && expected != actual {
+		return "", fmt.Errorf("S3 copy is damaged: content has MD5 %s, expected %s", actual, expected)
+	}
+	return actual, nil
+}
+
+// scrubFiles verifies every local file of an S3 to local sync against the
+// full content of its object, without relying on the size, modification time
+// or ETag shortcuts of the normal comparison, and downloads the object again
+// when the local copy differs
+func scrubFiles(ctx context.Context, s3Client *s3.Client, bucket string, tasks []scrubTask, result *SyncResult) error {
+	downloader := getDownloader(s3Client)
+
+	var mutex sync.Mutex
+	addError := func(operation string, task scrubTask, message string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.Errors = append(result.Errors, fmt.Sprintf("%s %s: %v", message, task.local.RelPath, err))
+		recordFailure(operation, task.local.Path, fmt.Sprintf("s3://%s/%s", bucket, task.remote.Path), err)
+	}
+
+	return runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task scrubTask) error {
+		defer report.completeFile()
+
+		release, err := acquireTransferSlot(workerCtx, bucket)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		remoteMD5, err := scrubObjectMD5(workerCtx, s3Client, bucket, task.remote.Path)
+		if err != nil {
+			addError(operationScrub, task, "Failed to verify", err)
+			return nil
+		}
+		localMD5, err := calculateFileMD5(task.local.Path)
+		if err != nil {
+			addError(operationScrub, task, "Failed to verify", err)
+			return nil
+		}
+		if localMD5 == remoteMD5 {
+			logVerbose("Verified: %s\n", task.local.RelPath)
+			return nil
+		}
+
+		if dryRun {
+			logInfo("Would repair: %s\n", task.local.RelPath)
+		} else {
+			if err := downloadSingleFile(workerCtx, downloader, bucket, task.remote.Path, task.local.Path); err != nil {
+				addError(operationDownload, task, "Failed to repair", err)
+				return nil
+			}
+			setSyncedModTime(task.remote, task.local.Path)
+			logInfo("Repaired: %s\n", task.local.RelPath)
+		}
+		mutex.Lock()
+		result.Repaired = append(result.Repaired, task.local.RelPath)
+		mutex.Unlock()
+		return nil
+	}, func(producerCtx context.Context, taskChan chan<- scrubTask) error {
+		for _, task := range tasks {
+			select {
+			case <-producerCtx.Done():
+				return producerCtx.Err()
+			case taskChan <- task:
+				report.queueFile()
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncScrub(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(map[string]string{
+		"mirror/backup/intact.txt":   "intact content",
+		"mirror/backup/rotten.txt":   "rotten content",
+		"mirror/backup/missing.txt":  "missing content",
+		"mirror/backup/sub/deep.txt": "deep content",
+	})
+	// local-md5 metadata that does not match the content is an object that
+	// was damaged on the S3 side
+	fake.store("mirror/backup/archive.bin", &fakeObject{
+		content: "original archivX",
+		header:  http.Header{"X-Amz-Meta-Local-Md5": {md5Hex("original archive")}},
+	})
+	useFakeS3(t, fake)
+
+	destDir := t.TempDir()
+	writeLocal := func(relPath, content string) {
+		path := filepath.Join(destDir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	readLocal := func(relPath string) string {
+		content, err := os.ReadFile(filepath.Join(destDir, filepath.FromSlash(relPath)))
+		require.NoError(t, err)
+		return string(content)
+	}
+	writeLocal("intact.txt", "intact content")
+	// same size as the object, so a size or ETag shortcut could not tell
+	writeLocal("rotten.txt", "rotten contenT")
+	writeLocal("archive.bin", "original archive")
+	writeLocal("sub/deep.txt", "deep content")
+
+	setTestConfig("s3://mirror/backup/", destDir, "", false, true, true, false)
+	scrubMode = true
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+
+	t.Run("dry run only reports", func(t *testing.T) {
+		dryRun = true
+		defer func() { dryRun = false }()
+
+		result, err := syncS3ToLocal(context.Background(), s3Client)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"rotten.txt"}, result.Repaired)
+		assert.Equal(t, "rotten contenT", readLocal("rotten.txt"))
+	})
+
+	t.Run("repairs the local copy", func(t *testing.T) {
+		fake.resetRequests()
+		result, err := syncS3ToLocal(context.Background(), s3Client)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"rotten.txt"}, result.Repaired)
+		assert.Equal(t, []string{"missing.txt"}, result.Downloaded)
+		assert.Equal(t, "rotten content", readLocal("rotten.txt"))
+		assert.Equal(t, "missing content", readLocal("missing.txt"))
+		assert.Equal(t, "intact content", readLocal("intact.txt"))
+
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "archive.bin")
+		assert.Contains(t, result.Errors[0], "S3 copy is damaged")
+		assert.Equal(t, "original archive", readLocal("archive.bin"), "a damaged object never replaces the local file")
+		intactGets := 0
+		for _, path := range fake.paths("GetObject") {
+			if path == "mirror/backup/intact.txt" {
+				intactGets++
+			}
+		}
+		assert.Equal(t, 1, intactGets, "intact files are read once per scrub")
+	})
+
+	t.Run("second scrub finds nothing to repair", func(t *testing.T) {
+		result, err := syncS3ToLocal(context.Background(), s3Client)
+		require.NoError(t, err)
+		assert.Empty(t, result.Repaired)
+		assert.Empty(t, result.Downloaded)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sources a configuration value can come from, printed by --show-config
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceStdin   = "stdin"
+)
+
+// redactedValue replaces secrets in the --show-config output
+const redactedValue = "********"
+
+// configSources records where each connection setting and operation
+// parameter was resolved from, keyed by flag name. Missing entries are
+// defaults.
+var configSources = map[string]string{}
+
+// connectionSetting is a field of Config with the environment variable it is
+// read from
+type connectionSetting struct {
+	name   string
+	envKey string
+	secret bool
+	value  func() any
+}
+
+var connectionSettings = []connectionSetting{
+	{name: "endpoint", envKey: "S3COPY_ENDPOINT", value: func() any { return config.Endpoint }},
+	{name: "access-key", envKey: "S3COPY_ACCESS_KEY", value: func() any { return config.AccessKey }},
+	{name: "secret-key", envKey: "S3COPY_SECRET_KEY", secret: true, value: func() any { return config.SecretKey }},
+	{name: "region", envKey: "S3COPY_REGION", value: func() any { return config.Region }},
+	{name: "use-path-style", envKey: "S3COPY_USE_PATH_STYLE", value: func() any { return config.UsePathStyle }},
+}
+
+// operationParameter is an operation parameter that can be set by a flag or
+// by the JSON config of --config-stdin
+type operationParameter struct {
+	name   string
+	secret bool
+	value  func() any
+}
+
+var operationParameters = []operationParameter{
+	{name: "source", value: func() any { return source }},
+	{name: "destination", value: func() any { return strings.Join(uploadDestinations(), ", ") }},
+	{name: "bucket", value: func() any { return bucket }},
+	{name: "encrypt", value: func() any { return encrypt }},
+	{name: "password", secret: true, value: func() any { return password }},
+	{name: "recursive", value: func() any { return recursive }},
+	{name: "list", value: func() any { return listObjects }},
+	{name: "filter", value: func() any { return filter }},
+	{name: "detailed", value: func() any { return listDetailed }},
+	{name: "ignore", value: func() any { return ignorePatterns }},
+	{name: "ignore-file", value: func() any { return ignoreFile }},
+	{name: "max-workers", value: func() any { return maxWorkers }},
+	{name: "dry-run", value: func() any { return dryRun }},
+	{name: "quiet", value: func() any { return quiet }},
+	{name: "verbose", value: func() any { return verbose }},
+	{name: "timeout", value: func() any { return timeout }},
+	{name: "retries", value: func() any { return retries }},
+	{name: "force", value: func() any { return forceOverwrite }},
+	{name: "sync", value: func() any { return syncMode }},
+	{name: "sync-compare", value: func() any { return syncCompare }},
+	{name: "exclude-existing", value: func() any { return excludeExisting }},
+	{name: "lowercase-keys", value: func() any { return lowercaseKeys }},
+	{name: "filter-cmd", value: func() any { return filterCmd }},
+}
+
+// recordFlagSources marks the operation parameters set on the command line
+func recordFlagSources(isSet func(name string) bool) {
+	for _, parameter := range operationParameters {
+		if isSet(parameter.name) {
+			configSources[parameter.name] = sourceFlag
+		}
+	}
+}
+
+// presentEnvKeys returns the connection environment variables that are set
+func presentEnvKeys() map[string]bool {
+	present := make(map[string]bool)
+	for _, setting := range connectionSettings {
+		if os.Getenv(setting.envKey) != "" {
+			present[setting.envKey] = true
+		}
+	}
+	return present
+}
+
+// recordEnvSources marks the connection settings read from the environment.
+// Variables that were only set after loading the .env file came from it.
+func recordEnvSources(setBeforeEnvFile map[string]bool, envFilePath string) {
+	for _, setting := range connectionSettings {
+		switch {
+		case setBeforeEnvFile[setting.envKey]:
+			configSources[setting.name] = sourceEnv + " " + setting.envKey
+		case os.Getenv(setting.envKey) != "":
+			configSources[setting.name] = sourceFile + " " + envFilePath
+		}
+	}
+}
+
+// configSource returns where a value was resolved from
+func configSource(name string) string {
+	if origin, ok := configSources[name]; ok {
+		return origin
+	}
+	return sourceDefault
+}
+
+// formatConfigValue formats a value for --show-config, redacting secrets
+func formatConfigValue(value any, secret bool) string {
+	text := fmt.Sprint(value)
+	if text == "" {
+		return "(not set)"
+	}
+	if secret {
+		return redactedValue
+	}
+	return text
+}
+
+// printEffectiveConfig writes the resolved connection settings and operation
+// parameters with the source of each value
+func printEffectiveConfig() {
+	fmt.Println("Connection:")
+	for _, setting := range connectionSettings {
+		fmt.Printf("  %-18s %-40s (%s)\n", setting.name, formatConfigValue(setting.value(), setting.secret), configSource(setting.name))
+	}
+	fmt.Println("Operation parameters:")
+	for _, parameter := range operationParameters {
+		fmt.Printf("  %-18s %-40s (%s)\n", parameter.name, formatConfigValue(parameter.value(), parameter.secret), configSource(parameter.name))
+	}
+}
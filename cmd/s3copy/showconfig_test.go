@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearConnectionEnv unsets the connection variables for the duration of a
+// test, restoring them afterwards
+func clearConnectionEnv(t *testing.T) {
+	t.Helper()
+	for _, setting := range connectionSettings {
+		t.Setenv(setting.envKey, "")
+		require.NoError(t, os.Unsetenv(setting.envKey))
+	}
+}
+
+// configLine returns the value and source printed for a setting
+func configLine(t *testing.T, output, name string) (value, origin string) {
+	t.Helper()
+	match := regexp.MustCompile(`(?m)^  ` + regexp.QuoteMeta(name) + `\s+(.*?)\s+\((.*)\)$`).FindStringSubmatch(output)
+	require.NotNil(t, match, "no line for %s in:\n%s", name, output)
+	return match[1], match[2]
+}
+
+func TestShowConfigEnvProvenance(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, false, false, false)
+	clearConnectionEnv(t)
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(envPath, []byte(
+		"S3COPY_ENDPOINT=http://from-file:9000\nS3COPY_ACCESS_KEY=file-access\nS3COPY_SECRET_KEY=file-secret\n"), 0600))
+	t.Setenv("S3COPY_ENDPOINT", "http://from-env:9000")
+
+	loadEnvConfig(envPath)
+	recordFlagSources(func(name string) bool { return name == "source" || name == "password" })
+	source = "./data"
+	password = "hunter2"
+
+	output := captureStdout(printEffectiveConfig)
+
+	value, origin := configLine(t, output, "endpoint")
+	assert.Equal(t, "http://from-env:9000", value)
+	assert.Equal(t, "env S3COPY_ENDPOINT", origin)
+
+	value, origin = configLine(t, output, "access-key")
+	assert.Equal(t, "file-access", value)
+	assert.Equal(t, "file "+envPath, origin)
+
+	value, origin = configLine(t, output, "secret-key")
+	assert.Equal(t, redactedValue, value)
+	assert.Equal(t, "file "+envPath, origin)
+
+	value, origin = configLine(t, output, "region")
+	assert.Equal(t, "us-east-1", value)
+	assert.Equal(t, sourceDefault, origin)
+
+	value, origin = configLine(t, output, "source")
+	assert.Equal(t, "./data", value)
+	assert.Equal(t, sourceFlag, origin)
+
+	value, origin = configLine(t, output, "password")
+	assert.Equal(t, redactedValue, value)
+	assert.Equal(t, sourceFlag, origin)
+
+	_, origin = configLine(t, output, "recursive")
+	assert.Equal(t, sourceDefault, origin)
+
+	assert.NotContains(t, output, "file-secret")
+	assert.NotContains(t, output, "hunter2")
+}
+
+func TestShowConfigJSONProvenance(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, false, false, false)
+	password = ""
+
+	recordFlagSources(func(name string) bool { return name == "max-workers" || name == "recursive" })
+	input := `{
+		"endpoint": "http://json:9000",
+		"access_key": "json-key",
+		"secret_key": "json-secret",
+		"region": "",
+		"max_workers": 8,
+		"sync_compare": "size-time"
+	}`
+	require.NoError(t, loadJSONConfig(strings.NewReader(input), newApp()))
+
+	output := captureStdout(printEffectiveConfig)
+
+	_, origin := configLine(t, output, "endpoint")
+	assert.Equal(t, sourceStdin, origin)
+
+	value, origin := configLine(t, output, "secret-key")
+	assert.Equal(t, redactedValue, value)
+	assert.Equal(t, sourceStdin, origin)
+
+	_, origin = configLine(t, output, "region")
+	assert.Equal(t, sourceDefault, origin)
+
+	value, origin = configLine(t, output, "max-workers")
+	assert.Equal(t, "8", value)
+	assert.Equal(t, sourceStdin, origin, "the JSON config overrides the flag")
+
+	_, origin = configLine(t, output, "recursive")
+	assert.Equal(t, sourceFlag, origin)
+
+	_, origin = configLine(t, output, "sync-compare")
+	assert.Equal(t, sourceStdin, origin)
+
+	value, _ = configLine(t, output, "password")
+	assert.Equal(t, "(not set)", value)
+	assert.NotContains(t, output, "json-secret")
+}
+
+func TestWriteToolConfig(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, false, false, false)
+	config = Config{
+		Endpoint:     "https://minio.example.com:9000",
+		AccessKey:    "tool-key",
+		SecretKey:    "tool-secret",
+		Region:       "eu-central-1",
+		UsePathStyle: true,
+	}
+
+	t.Run("aws-cli", func(t *testing.T) {
+		var output strings.Builder
+		require.NoError(t, writeToolConfig(&output, "aws-cli"))
+		assert.Contains(t, output.String(), "[profile s3copy]\n")
+		assert.Contains(t, output.String(), "endpoint_url = https://minio.example.com:9000\n")
+		assert.Contains(t, output.String(), "region = eu-central-1\n")
+		assert.Contains(t, output.String(), "addressing_style = path\n")
+		assert.Contains(t, output.String(), "aws_access_key_id = tool-key\n")
+		assert.Contains(t, output.String(), "aws_secret_access_key = "+redactedValue+"\n")
+		assert.NotContains(t, output.String(), "tool-secret")
+	})
+
+	t.Run("rclone", func(t *testing.T) {
+		var output strings.Builder
+		require.NoError(t, writeToolConfig(&output, "rclone"))
+		assert.Contains(t, output.String(), "[s3copy]\ntype = s3\nprovider = Other\n")
+		assert.Contains(t, output.String(), "endpoint = https://minio.example.com:9000\n")
+		assert.Contains(t, output.String(), "region = eu-central-1\n")
+		assert.Contains(t, output.String(), "force_path_style = true\n")
+		assert.NotContains(t, output.String(), "tool-secret")
+	})
+
+	t.Run("include secrets", func(t *testing.T) {
+		includeSecrets = true
+		defer func() { includeSecrets = false }()
+		var output strings.Builder
+		require.NoError(t, writeToolConfig(&output, "rclone"))
+		assert.Contains(t, output.String(), "secret_access_key = tool-secret\n")
+	})
+
+	t.Run("AWS without endpoint", func(t *testing.T) {
+		config.Endpoint = ""
+		config.UsePathStyle = false
+		var output strings.Builder
+		require.NoError(t, writeToolConfig(&output, "rclone"))
+		assert.Contains(t, output.String(), "provider = AWS\n")
+		assert.NotContains(t, output.String(), "endpoint")
+
+		output.Reset()
+		require.NoError(t, writeToolConfig(&output, "aws-cli"))
+		assert.NotContains(t, output.String(), "endpoint_url")
+		assert.NotContains(t, output.String(), "addressing_style")
+	})
+
+	require.Error(t, writeToolConfig(io.Discard, "s3cmd"))
+}
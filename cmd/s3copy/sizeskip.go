@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3ObjectSize returns the size of an object, or -1 when it does not exist
+func s3ObjectSize(ctx context.Context, bucketName, key string) (int64, error) {
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	result, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) || strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "NotFound") {
+			return -1, nil
+		}
+		return 0, fmt.Errorf("could not check S3 object: %w", err)
+	}
+	return aws.ToInt64(result.ContentLength), nil
+}
+
+// sameSizeOnS3 reports whether --skip-same-size skips the upload of filePath
+// because the object already exists with the same number of bytes. Nothing
+// is hashed, so a file that changed without changing its size is skipped as
+// well.
+func sameSizeOnS3(ctx context.Context, bucketName, key, filePath string) bool {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	size, err := s3ObjectSize(ctx, bucketName, key)
+	if err != nil {
+		logVerbose("Warning: %v\n", err)
+		return false
+	}
+	return size == info.Size()
+}
+
+// sameSizeLocally reports whether --skip-same-size skips the download of an
+// object because localPath already exists with the same number of bytes
+func sameSizeLocally(ctx context.Context, bucketName, key, localPath string) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	size, err := s3ObjectSize(ctx, bucketName, key)
+	if err != nil {
+		logVerboseContext(ctx, "Warning: %v\n", err)
+		return false
+	}
+	return size == info.Size()
+}
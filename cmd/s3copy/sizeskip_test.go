@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipSameSize(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(map[string]string{
+		"media/same.bin":    "0123456789",
+		"media/changed.bin": "0123",
+	})
+	useFakeS3(t, fake)
+
+	localDir := t.TempDir()
+	// same size but different content, which --skip-same-size cannot detect
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "same.bin"), []byte("abcdefghij"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "changed.bin"), []byte("0123456789"), 0644))
+
+	configure := func(src, dst string) {
+		setTestConfig(src, dst, "", false, false, false, false)
+		skipSameSize = true
+		fake.resetRequests()
+	}
+
+	t.Run("upload skips an object with the same size", func(t *testing.T) {
+		configure(filepath.Join(localDir, "same.bin"), "s3://media/same.bin")
+		output := captureStdout(func() {
+			require.NoError(t, uploadToS3(context.Background()))
+		})
+		assert.Contains(t, output, "object already exists on S3 with the same size")
+		assert.Zero(t, fake.count("PutObject"))
+		assert.Equal(t, "0123456789", fake.content("media/same.bin"))
+	})
+
+	t.Run("upload transfers an object with a different size", func(t *testing.T) {
+		configure(filepath.Join(localDir, "changed.bin"), "s3://media/changed.bin")
+		captureStdout(func() {
+			require.NoError(t, uploadToS3(context.Background()))
+		})
+		assert.Equal(t, 1, fake.count("PutObject"))
+		assert.Equal(t, "0123456789", fake.content("media/changed.bin"))
+	})
+
+	t.Run("download skips a local file with the same size", func(t *testing.T) {
+		destPath := filepath.Join(localDir, "same.bin")
+		configure("s3://media/same.bin", destPath)
+		output := captureStdout(func() {
+			require.NoError(t, downloadFromS3(context.Background()))
+		})
+		assert.Contains(t, output, "local file already exists with the same size")
+		assert.Zero(t, fake.count("GetObject"))
+		content, err := os.ReadFile(destPath)
+		require.NoError(t, err)
+		assert.Equal(t, "abcdefghij", string(content))
+	})
+
+	t.Run("download transfers an object with a different size", func(t *testing.T) {
+		fake.put("media/changed.bin", "0123")
+		destPath := filepath.Join(localDir, "changed.bin")
+		configure("s3://media/changed.bin", destPath)
+		captureStdout(func() {
+			require.NoError(t, downloadFromS3(context.Background()))
+		})
+		assert.Equal(t, 1, fake.count("GetObject"))
+		content, err := os.ReadFile(destPath)
+		require.NoError(t, err)
+		assert.Equal(t, "0123", string(content))
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// spotCheckRangeSize is the length of each byte range --spot-check compares
+const spotCheckRangeSize = 64 * 1024
+
+// spotCheckOffsets picks count random range offsets in a file of size bytes,
+// sorted. A file no larger than count ranges is compared as a whole.
+func spotCheckOffsets(size int64, count int) []int64 {
+	if size <= int64(count)*spotCheckRangeSize {
+		var offsets []int64
+		for offset := int64(0); offset < size; offset += spotCheckRangeSize {
+			offsets = append(offsets, offset)
+		}
+		return offsets
+	}
+	offsets := make([]int64, count)
+	for i := range offsets {
+		offsets[i] = rand.Int64N(size - spotCheckRangeSize + 1)
+	}
+	slices.Sort(offsets)
+	return offsets
+}
+
+// spotCheckObject compares --spot-check random byte ranges of an object with
+// the local file at the same offsets. It reads a few ranges instead of the
+// whole object, so it finds most damage but cannot prove that the two are
+// identical.
+func spotCheckObject(ctx context.Context, bucketName, key, localPath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+	return compareRanges(ctx, s3Client, bucketName, key, localPath, spotCheckOffsets(info.Size(), spotCheck))
+}
+
+// compareRanges compares the ranges of spotCheckRangeSize bytes at offsets of
+// an object and a local file
+func compareRanges(ctx context.Context, s3Client *s3.Client, bucketName, key, localPath string, offsets []int64) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer closeWithLog(file, localPath)
+
+	local := make([]byte, spotCheckRangeSize)
+	for _, offset := range offsets {
+		n, err := file.ReadAt(local, offset)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %w", localPath, err)
+		}
+		if n == 0 {
+			continue
+		}
+		end := offset + int64(n) - 1
+
+		output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read bytes %d-%d of s3://%s/%s: %w", offset, end, bucketName, key, err)
+		}
+		remote, err := io.ReadAll(io.LimitReader(output.Body, int64(n)+1))
+		closeWithLog(output.Body, key)
+		if err != nil {
+			return fmt.Errorf("failed to read bytes %d-%d of s3://%s/%s: %w", offset, end, bucketName, key, err)
+		}
+		if !bytes.Equal(local[:n], remote) {
+			return fmt.Errorf("spot check %w for %s: bytes %d-%d differ from s3://%s/%s", errChecksumMismatch, localPath, offset, end, bucketName, key)
+		}
+	}
+	logVerbose("Spot-checked %d range(s) of %s\n", len(offsets), localPath)
+	return nil
+}
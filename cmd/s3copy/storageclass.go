@@ -0,0 +1,96 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// storageClassTally is the number and total size of the objects in one
+// storage class
+type storageClassTally struct {
+	class string
+	count int64
+	size  int64
+}
+
+// storageClassTallies accumulates the listed objects per storage class
+type storageClassTallies map[string]*storageClassTally
+
+// add counts an object. Objects without a storage class are STANDARD, which
+// S3 omits from some listings.
+func (t storageClassTallies) add(obj types.Object) {
+	class := string(obj.StorageClass)
+	if class == "" {
+		class = string(types.ObjectStorageClassStandard)
+	}
+	tally, ok := t[class]
+	if !ok {
+		tally = &storageClassTally{class: class}
+		t[class] = tally
+	}
+	tally.count++
+	if obj.Size != nil {
+		tally.size += *obj.Size
+	}
+}
+
+// sorted returns the tallies with the largest total size first
+func (t storageClassTallies) sorted() []storageClassTally {
+	tallies := make([]storageClassTally, 0, len(t))
+	for _, tally := range t {
+		tallies = append(tallies, *tally)
+	}
+	slices.SortFunc(tallies, func(a, b storageClassTally) int {
+		if bySize := cmp.Compare(b.size, a.size); bySize != 0 {
+			return bySize
+		}
+		return strings.Compare(a.class, b.class)
+	})
+	return tallies
+}
+
+// listByStorageClass prints the object count and total size per storage
+// class instead of the individual objects
+func listByStorageClass() error {
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %v", err)
+	}
+
+	tallies := make(storageClassTallies)
+	limitReached, lastKey, err := forEachListedObject(ctx, s3Client, newListingInput(), func(obj types.Object) error {
+		tallies.add(obj)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Storage classes in bucket '%s'", bucket)
+	if filter != "" {
+		fmt.Printf(" with prefix '%s'", filter)
+	}
+	fmt.Println(":")
+	fmt.Println()
+
+	fmt.Printf("%-25s %10s %12s\n", "Storage Class", "Objects", "Size")
+	fmt.Printf("%-25s %10s %12s\n", strings.Repeat("-", 25), strings.Repeat("-", 10), strings.Repeat("-", 12))
+
+	var totalObjects, totalSize int64
+	for _, tally := range tallies.sorted() {
+		fmt.Printf("%-25s %10d %12s\n", tally.class, tally.count, formatBytes(tally.size))
+		totalObjects += tally.count
+		totalSize += tally.size
+	}
+
+	fmt.Println()
+	fmt.Printf("Total: %d objects, %s\n", totalObjects, formatBytes(totalSize))
+	printContinuationHint(limitReached, lastKey)
+	return nil
+}
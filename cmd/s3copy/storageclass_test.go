@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListByStorageClass(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/archive" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>archive</Name><KeyCount>6</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>2024/a.tar</Key><Size>3145728</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"a"</ETag><StorageClass>GLACIER</StorageClass></Contents>` +
+			`<Contents><Key>2024/b.tar</Key><Size>1048576</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"b"</ETag><StorageClass>GLACIER</StorageClass></Contents>` +
+			`<Contents><Key>2025/c.tar</Key><Size>2048</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"c"</ETag><StorageClass>STANDARD_IA</StorageClass></Contents>` +
+			`<Contents><Key>current/d.txt</Key><Size>100</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"d"</ETag><StorageClass>STANDARD</StorageClass></Contents>` +
+			`<Contents><Key>current/e.txt</Key><Size>24</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"e"</ETag></Contents>` +
+			`<Contents><Key>current/f.txt</Key><Size>900</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified><ETag>"f"</ETag><StorageClass>STANDARD_IA</StorageClass></Contents>` +
+			`</ListBucketResult>`))
+	}))
+
+	setTestConfig("", "", "archive", false, false, false, false)
+	listObjects = true
+	byStorageClass = true
+
+	var err error
+	output := captureStdout(func() {
+		err = listByStorageClass()
+	})
+	require.NoError(t, err)
+
+	rows := regexp.MustCompile(`(?m)^(GLACIER|STANDARD_IA|STANDARD)\s+(\d+)\s+(.+)$`).FindAllStringSubmatch(output, -1)
+	require.Len(t, rows, 3, output)
+	assert.Equal(t, []string{"GLACIER", "2", "4.0 MB"}, rows[0][1:], "largest class first")
+	assert.Equal(t, []string{"STANDARD_IA", "2", "2.9 KB"}, rows[1][1:])
+	assert.Equal(t, []string{"STANDARD", "2", "124 B"}, rows[2][1:], "objects without a class are STANDARD")
+	assert.Contains(t, output, "Total: 6 objects")
+	assert.NotContains(t, output, "2024/a.tar")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+)
+
+// stdinSource is the --source value that uploads the data piped into s3copy
+const stdinSource = "-"
+
+// maxCopySize is the largest object CopyObject copies in one request, which
+// limits storing the checksum of a stream as metadata
+const maxCopySize = 5 * 1024 * 1024 * 1024
+
+// uploadFromStdin streams stdin to the destination key. Stdin has no name,
+// so the destination has to be a full key.
+func uploadFromStdin(ctx context.Context, uploader *uploadManager) error {
+	if strings.HasSuffix(destination, "/") {
+		return fmt.Errorf("uploading from stdin needs a destination with a full key, not a prefix")
+	}
+	targets, err := resolveUploadTargets(false, "stdin")
+	if err != nil {
+		return err
+	}
+	target := targets[0]
+
+	logInfo("Uploading stdin to %s\n", target)
+	if dryRun {
+		return nil
+	}
+
+	defer report.track(phaseTransfer)()
+	report.queueFile()
+	defer report.completeFile()
+
+	err = uploadStream(ctx, uploader, target, target.key, os.Stdin, -1, "")
+	recordFailure(operationUpload, stdinSource, target.String(), err)
+	return err
+}
+
+// uploadStream uploads a stream that has no local file, such as stdin or an
+// HTTP source, through the encryption pipe with --encrypt. plainSize is the
+// length of the stream, or -1 when it is not known in advance, and
+// contentType the type the stream announced. The stream is hashed while it
+// is uploaded for --checksum-out.
+func uploadStream(ctx context.Context, uploader *uploadManager, target uploadTarget, name string, stream io.Reader, plainSize int64, contentType string) error {
+	counter := &countingWriter{writer: io.Discard}
+	hash := md5.New()
+	body := io.TeeReader(stream, io.MultiWriter(counter, hash))
+
+	input := &manager.UploadObjectInput{
+		Bucket: aws.String(target.bucket),
+		Key:    aws.String(target.key),
+		Body:   body,
+	}
+
+	encryptFile := shouldEncryptFile(name)
+	var encReader *io.PipeReader
+	encErrChan := make(chan error, 1)
+	if encryptFile {
+		secret, passwordID, err := uploadPassword(target.key)
+		if err != nil {
+			return err
+		}
+		header, err := newEncryptionHeader(secret)
+		if err != nil {
+			return err
+		}
+		input.Metadata = withPasswordID(markEncrypted(nil, plainSize), passwordID)
+
+		var encWriter *io.PipeWriter
+		encReader, encWriter = io.Pipe()
+		input.Body = encReader
+		go func() {
+			// a failed read must fail the upload instead of ending the
+			// object early
+			err := encryptWithHeader(encWriter, body, header, cryptoWorkers())
+			_ = encWriter.CloseWithError(err)
+			encErrChan <- err
+		}()
+	}
+	applyUploadOptions(input)
+	if input.ContentType == nil && !encryptFile && contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	_, uploadErr := uploader.UploadObject(ctx, input)
+	if encryptFile {
+		_ = encReader.CloseWithError(uploadErr)
+		if encErr := <-encErrChan; encErr != nil && uploadErr == nil {
+			return fmt.Errorf("encryption failed: %w", encErr)
+		}
+	}
+	if ifNoneMatch && isPreconditionFailed(uploadErr) {
+		logInfo("Skipping %s (object already exists on S3)\n", target)
+		return nil
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+	report.addStream(counter.written)
+
+	if checksumOut == "" {
+		return nil
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err := writeStreamChecksum(checksum, target); err != nil {
+		return err
+	}
+	// like localUploadMetadata, never store the plaintext MD5 next to ciphertext
+	if encryptFile {
+		return nil
+	}
+	return storeStreamChecksum(ctx, target, checksum, counter.written)
+}
+
+// writeStreamChecksum writes the MD5 of an uploaded stream as an md5sum-style
+// line to --checksum-out, or to stderr for "-"
+func writeStreamChecksum(checksum string, target uploadTarget) error {
+	line := fmt.Sprintf("%s  %s\n", checksum, target)
+	if checksumOut == "-" {
+		fmt.Fprint(os.Stderr, line)
+		return nil
+	}
+	if err := os.WriteFile(checksumOut, []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum: %w", err)
+	}
+	return nil
+}
+
+// storeStreamChecksum stores the MD5 of an uploaded stream as local-md5
+// metadata, like the uploads of local files, with a metadata-only copy. The
+// checksum is only known once the stream ended, after the object was created.
+func storeStreamChecksum(ctx context.Context, target uploadTarget, checksum string, size int64) error {
+	if size > maxCopySize {
+		logInfo("Warning: not storing the checksum of %s as metadata, objects larger than 5 GB cannot be copied in place\n", target)
+		return nil
+	}
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+	if err := addObjectMetadata(ctx, s3Client, target.bucket, target.key, map[string]string{"local-md5": checksum}); err != nil {
+		return fmt.Errorf("failed to store checksum of %s: %w", target, err)
+	}
+	return nil
+}
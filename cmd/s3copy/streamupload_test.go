@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeStdin replaces os.Stdin with a pipe that delivers content
+func pipeStdin(t *testing.T, content string) {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	original := os.Stdin
+	os.Stdin = reader
+	t.Cleanup(func() {
+		os.Stdin = original
+		closeWithLog(reader, "stdin pipe")
+	})
+	go func() {
+		_, _ = writer.WriteString(content)
+		closeWithLog(writer, "stdin pipe")
+	}()
+}
+
+func TestUploadFromStdin(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	configure := func(dst string) {
+		setTestConfig(stdinSource, dst, "", false, false, true, false)
+	}
+
+	content := strings.Repeat("piped data ", 1000)
+	sum := md5.Sum([]byte(content))
+	expectedMD5 := hex.EncodeToString(sum[:])
+
+	t.Run("uploads stdin and writes the checksum", func(t *testing.T) {
+		configure("s3://backups/db.sql")
+		checksumOut = filepath.Join(t.TempDir(), "db.sql.md5")
+		pipeStdin(t, content)
+
+		require.NoError(t, uploadToS3(context.Background()))
+		assert.Equal(t, content, fake.content("backups/db.sql"))
+
+		written, err := os.ReadFile(checksumOut)
+		require.NoError(t, err)
+		assert.Equal(t, expectedMD5+"  s3://backups/db.sql\n", string(written))
+		object, _ := fake.object("backups/db.sql")
+		assert.Equal(t, expectedMD5, object.metadata("Local-Md5"))
+		assert.Equal(t, 1, fake.count("CopyObject"), "the checksum is added by copying the object onto itself")
+	})
+
+	t.Run("checksum is of the plaintext when encrypting", func(t *testing.T) {
+		configure("s3://backups/secret.sql")
+		checksumOut = filepath.Join(t.TempDir(), "secret.sql.md5")
+		encrypt = true
+		password = "testpassword123"
+		pipeStdin(t, content)
+
+		require.NoError(t, uploadToS3(context.Background()))
+		assert.NotEqual(t, content, fake.content("backups/secret.sql"))
+		written, err := os.ReadFile(checksumOut)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(written), expectedMD5+"  "))
+		object, _ := fake.object("backups/secret.sql")
+		assert.Empty(t, object.metadata("Local-Md5"), "the plaintext MD5 is not stored with the encrypted object")
+	})
+
+	t.Run("needs a full key", func(t *testing.T) {
+		configure("s3://backups/dumps/")
+		pipeStdin(t, content)
+
+		err := uploadToS3(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "full key")
+	})
+}
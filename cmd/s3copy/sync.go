@@ -25,10 +25,12 @@ type FileInfo struct {
 }
 
 type SyncResult struct {
-	Uploaded   []string
-	Downloaded []string
-	Deleted    []string
-	Errors     []string
+	Uploaded        []string
+	Downloaded      []string
+	Deleted         []string
+	MetadataUpdated []string
+	Repaired        []string
+	Errors          []string
 }
 
 func syncDirectories(ctx context.Context) error {
@@ -53,7 +55,11 @@ func syncDirectories(ctx context.Context) error {
 
 	printSyncSummary(result)
 	if len(result.Errors) > 0 {
-		return fmt.Errorf("sync completed with %d error(s)", len(result.Errors))
+		return &partialFailureError{fmt.Errorf("sync completed with %d error(s)", len(result.Errors))}
+	}
+
+	if detailedExitCodes && len(result.Uploaded)+len(result.Downloaded)+len(result.Deleted)+len(result.MetadataUpdated)+len(result.Repaired) == 0 {
+		return errNothingToDo
 	}
 
 	return nil
@@ -88,11 +94,24 @@ func syncS3ToLocal(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 		return result, fmt.Errorf("failed to list S3 files: %v", err)
 	}
 
-	localFiles, err := listLocalFilesWithOptions(destination, shouldUseChecksumCompare())
+	localFiles, err := listLocalFilesWithOptions(destination, localChecksums() && !mirrorAdd && !scrubMode)
 	if err != nil {
 		return result, fmt.Errorf("failed to list local files: %v", err)
 	}
 
+	comparer, err := newSyncComparer(s3Client, s3Bucket, s3Prefix)
+	if err != nil {
+		return result, err
+	}
+
+	var tracked map[string]struct{}
+	if tracksSyncDeletes() && !mirrorAdd {
+		tracked, err = loadSyncState(destination)
+		if err != nil {
+			return result, err
+		}
+	}
+
 	s3FileMap := make(map[string]FileInfo)
 	localFileMap := make(map[string]FileInfo)
 
@@ -101,15 +120,24 @@ func syncS3ToLocal(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 	}
 
 	for _, file := range localFiles {
+		if file.RelPath == syncStateFileName {
+			continue
+		}
 		localFileMap[file.RelPath] = file
 	}
 
 	var toDownload []FileInfo
 	var toDelete []FileInfo
+	var toScrub []scrubTask
 
 	for relPath, s3File := range s3FileMap {
 		if localFile, exists := localFileMap[relPath]; exists {
-			if !filesAreSameByMode(ctx, s3Client, localFile, s3File, s3Bucket) {
+			// --mirror-add keeps every existing local file, changed or not
+			if mirrorAdd {
+				logVerbose("Keeping existing local file: %s\n", relPath)
+			} else if scrubMode {
+				toScrub = append(toScrub, scrubTask{local: localFile, remote: s3File})
+			} else if !comparer.same(ctx, localFile, s3File) {
 				toDownload = append(toDownload, s3File)
 			}
 		} else {
@@ -118,23 +146,68 @@ func syncS3ToLocal(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 	}
 
 	for relPath, localFile := range localFileMap {
-		if _, exists := s3FileMap[relPath]; !exists {
+		if _, exists := s3FileMap[relPath]; !exists && !mirrorAdd {
+			if _, written := tracked[relPath]; tracked != nil && !written {
+				logVerbose("Keeping local file not written by s3copy: %s\n", relPath)
+				continue
+			}
 			toDelete = append(toDelete, localFile)
 		}
 	}
 
+	if len(toDownload) > 0 && checkFreeSpace {
+		var required int64
+		for _, file := range toDownload {
+			required += file.Size
+		}
+		if err := ensureFreeSpace(destination, required); err != nil {
+			return result, err
+		}
+	}
+
 	if len(toDownload) > 0 {
-		if err := downloadFiles(ctx, s3Client, s3Bucket, toDownload, &result); err != nil {
+		stopTransfer := report.track(phaseTransfer)
+		err := downloadFiles(ctx, s3Client, s3Bucket, toDownload, &result)
+		stopTransfer()
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if len(toScrub) > 0 {
+		stopTransfer := report.track(phaseTransfer)
+		err := scrubFiles(ctx, s3Client, s3Bucket, toScrub, &result)
+		stopTransfer()
+		if err != nil {
 			return result, err
 		}
 	}
 
 	if len(toDelete) > 0 {
-		if err := deleteLocalFiles(toDelete, &result); err != nil {
+		stopDelete := report.track(phaseDelete)
+		err := deleteLocalFiles(toDelete, &result)
+		stopDelete()
+		if err != nil {
 			return result, err
 		}
 	}
 
+	if tracked != nil && !dryRun {
+		for relPath := range s3FileMap {
+			tracked[relPath] = struct{}{}
+		}
+		if err := makeDownloadDir(destination); err != nil {
+			return result, fmt.Errorf("failed to create destination directory: %w", err)
+		}
+		if err := saveSyncState(destination, tracked); err != nil {
+			return result, err
+		}
+	}
+
+	if err := comparer.saveState(); err != nil {
+		return result, err
+	}
+
 	return result, nil
 }
 
@@ -162,7 +235,7 @@ func syncLocalToS3(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 		s3Prefix += "/"
 	}
 
-	localFiles, err := listLocalFilesWithOptions(source, shouldUseChecksumCompare())
+	localFiles, err := listLocalFilesWithOptions(source, localChecksums())
 	if err != nil {
 		return result, fmt.Errorf("failed to list local files: %v", err)
 	}
@@ -172,11 +245,17 @@ func syncLocalToS3(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 		return result, fmt.Errorf("failed to list S3 files: %v", err)
 	}
 
+	comparer, err := newSyncComparer(s3Client, s3Bucket, s3Prefix)
+	if err != nil {
+		return result, err
+	}
+
 	localFileMap := make(map[string]FileInfo)
 	s3FileMap := make(map[string]FileInfo)
 
 	for _, file := range localFiles {
 		localFileMap[file.RelPath] = file
+		uploadedChecksums.record(file.Path, file.MD5Hash, []uploadTarget{{bucket: s3Bucket, key: s3Prefix + file.RelPath}})
 	}
 
 	for _, file := range s3Files {
@@ -185,11 +264,14 @@ func syncLocalToS3(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 
 	var toUpload []FileInfo
 	var toDelete []FileInfo
+	var toUpdateMetadata []FileInfo
 
 	for relPath, localFile := range localFileMap {
 		if s3File, exists := s3FileMap[relPath]; exists {
-			if !filesAreSameByMode(ctx, s3Client, localFile, s3File, s3Bucket) {
+			if !comparer.same(ctx, localFile, s3File) {
 				toUpload = append(toUpload, localFile)
+			} else if syncMetadata {
+				toUpdateMetadata = append(toUpdateMetadata, s3File)
 			}
 		} else {
 			toUpload = append(toUpload, localFile)
@@ -197,27 +279,52 @@ func syncLocalToS3(ctx context.Context, s3Client *s3.Client) (SyncResult, error)
 	}
 
 	for relPath, s3File := range s3FileMap {
-		if _, exists := localFileMap[relPath]; !exists {
-			toDelete = append(toDelete, s3File)
+		if _, exists := localFileMap[relPath]; exists {
+			continue
 		}
+		if isChecksumsFileTarget(uploadTarget{bucket: s3Bucket, key: s3File.Path}) {
+			continue
+		}
+		toDelete = append(toDelete, s3File)
 	}
 
 	if len(toUpload) > 0 {
-		if err := uploadFiles(ctx, s3Client, s3Bucket, s3Prefix, toUpload, &result); err != nil {
+		stopTransfer := report.track(phaseTransfer)
+		err := uploadFiles(ctx, s3Client, s3Bucket, s3Prefix, toUpload, &result)
+		stopTransfer()
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if len(toUpdateMetadata) > 0 {
+		stopTransfer := report.track(phaseTransfer)
+		err := updateMetadataFiles(ctx, s3Client, s3Bucket, toUpdateMetadata, &result)
+		stopTransfer()
+		if err != nil {
 			return result, err
 		}
 	}
 
 	if len(toDelete) > 0 {
-		if err := deleteS3Files(ctx, s3Client, s3Bucket, toDelete, &result); err != nil {
+		stopDelete := report.track(phaseDelete)
+		err := deleteS3Files(ctx, s3Client, s3Bucket, toDelete, &result)
+		stopDelete()
+		if err != nil {
 			return result, err
 		}
 	}
 
+	if err := comparer.saveState(); err != nil {
+		return result, err
+	}
+
 	return result, nil
 }
 
 func listS3Files(ctx context.Context, s3Client *s3.Client, bucket, prefix string) ([]FileInfo, error) {
+	defer report.track(phaseEnumeration)()
+
 	var files []FileInfo
 
 	input := &s3.ListObjectsV2Input{
@@ -247,12 +354,13 @@ func listS3Files(ctx context.Context, s3Client *s3.Client, bucket, prefix string
 			if prefix != "" {
 				relPath = strings.TrimPrefix(key, prefix)
 			}
+			relPath = normalizeUnicodePath(relPath)
 
 			if relPath == "" {
 				continue
 			}
 
-			if shouldIgnoreFile(relPath) {
+			if shouldIgnoreRelPath(relPath) {
 				continue
 			}
 
@@ -272,9 +380,7 @@ func listS3Files(ctx context.Context, s3Client *s3.Client, bucket, prefix string
 				file.ModTime = obj.LastModified.Unix()
 			}
 
-			if obj.ETag != nil {
-				file.MD5Hash = strings.Trim(*obj.ETag, "\"")
-			}
+			file.MD5Hash = normalizeETag(aws.ToString(obj.ETag))
 
 			files = append(files, file)
 		}
@@ -290,12 +396,27 @@ func listLocalFiles(rootPath string) ([]FileInfo, error) {
 func listLocalFilesWithOptions(rootPath string, calculateChecksums bool) ([]FileInfo, error) {
 	var files []FileInfo
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	// hashing is reported as its own phase, so it is excluded from enumeration
+	start := time.Now()
+	var hashTime time.Duration
+	defer func() {
+		report.add(phaseEnumeration, time.Since(start)-hashTime)
+	}()
+
+	rootPath, err := resolveSymlinkedDir(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
 		if info.IsDir() {
+			if noHidden && path != rootPath && isHiddenPath(info.Name()) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -304,15 +425,17 @@ func listLocalFilesWithOptions(rootPath string, calculateChecksums bool) ([]File
 			return err
 		}
 
-		relPath = filepath.ToSlash(relPath)
+		relPath = normalizeUnicodePath(filepath.ToSlash(relPath))
 
-		if shouldIgnoreFile(relPath) {
+		if shouldIgnoreRelPath(relPath) {
 			return nil
 		}
 
 		md5Hash := ""
 		if calculateChecksums {
+			hashStart := time.Now()
 			md5Hash, err = calculateFileMD5(path)
+			hashTime += time.Since(hashStart)
 			if err != nil {
 				return fmt.Errorf("failed to calculate MD5 for %s: %v", path, err)
 			}
@@ -342,13 +465,18 @@ func filesAreSame(file1, file2 FileInfo) bool {
 	return file1.MD5Hash == file2.MD5Hash
 }
 
+// filesAreSameWithMetadataCheck compares a local file with its object under
+// --integrity-policy. The listing ETag settles etag-first and size-only
+// comparisons without a request; the others read the metadata with HEAD.
 func filesAreSameWithMetadataCheck(ctx context.Context, s3Client *s3.Client, localFile, s3File FileInfo, bucket string) bool {
 	if localFile.Size != s3File.Size {
 		return false
 	}
 
-	if localFile.MD5Hash == s3File.MD5Hash {
-		return true
+	object := objectIntegrity{size: s3File.Size, etag: s3File.MD5Hash}
+	if integrityPolicy == integritySizeOnly || (integrityPolicy == integrityETagFirst && localFile.MD5Hash == s3File.MD5Hash) {
+		same, _ := integrityMatch(integrityPolicy, localFile.Size, localFile.MD5Hash, object)
+		return same
 	}
 
 	headInput := &s3.HeadObjectInput{
@@ -358,19 +486,32 @@ func filesAreSameWithMetadataCheck(ctx context.Context, s3Client *s3.Client, loc
 
 	headResult, headErr := s3Client.HeadObject(ctx, headInput)
 	if headErr == nil && headResult.Metadata != nil {
-		if storedMD5, exists := headResult.Metadata["local-md5"]; exists {
-			return localFile.MD5Hash == storedMD5
+		if isEncryptedObject(headResult.Metadata) {
+			return false
 		}
+		object.storedMD5 = headResult.Metadata["local-md5"]
 	}
 
-	return localFile.MD5Hash == s3File.MD5Hash
+	object, err := resolveObjectIntegrity(ctx, s3Client, bucket, s3File.Path, localFile.Size, object)
+	if err != nil {
+		logVerbose("Warning: could not recompute checksum of %s: %v\n", s3File.Path, err)
+		return false
+	}
+	same, _ := integrityMatch(integrityPolicy, localFile.Size, localFile.MD5Hash, object)
+	return same
 }
 
 func shouldUseChecksumCompare() bool {
 	return syncCompare != "size-time"
 }
 
+// filesAreSameByMode compares a local file with its object. Files that
+// --encrypt and --encrypt-ext select are compared with encryptedFileIsSame,
+// all others with the --sync-compare strategy, which rejects encrypted objects.
 func filesAreSameByMode(ctx context.Context, s3Client *s3.Client, localFile, s3File FileInfo, bucket string) bool {
+	if shouldEncryptFile(localFile.RelPath) {
+		return encryptedFileIsSame(ctx, s3Client, localFile, s3File, bucket)
+	}
 	if shouldUseChecksumCompare() {
 		return filesAreSameWithMetadataCheck(ctx, s3Client, localFile, s3File, bucket)
 	}
@@ -396,7 +537,7 @@ func filesAreSameWithMtimeCheck(ctx context.Context, s3Client *s3.Client, localF
 		Bucket: aws.String(bucket),
 		Key:    aws.String(s3File.Path),
 	})
-	if headErr != nil || headResult.Metadata == nil {
+	if headErr != nil || headResult.Metadata == nil || isEncryptedObject(headResult.Metadata) {
 		return false
 	}
 
@@ -421,11 +562,19 @@ type downloadSyncTask struct {
 }
 
 func downloadFiles(ctx context.Context, s3Client *s3.Client, bucket string, files []FileInfo, result *SyncResult) error {
-	downloader := manager.New(s3Client)
+	downloader := getDownloader(s3Client)
 
 	var mutex sync.Mutex
 
 	return runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task downloadSyncTask) error {
+		defer report.completeFile()
+
+		release, err := acquireTransferSlot(workerCtx, task.bucket)
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		if dryRun {
 			logInfo("Would download: %s\n", task.file.RelPath)
 			mutex.Lock()
@@ -435,9 +584,10 @@ func downloadFiles(ctx context.Context, s3Client *s3.Client, bucket string, file
 		}
 
 		destDir := filepath.Dir(task.destPath)
-		if err := os.MkdirAll(destDir, 0755); err != nil {
+		if err := makeDownloadDir(destDir); err != nil {
 			mutex.Lock()
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to create directory %s: %v", destDir, err))
+			recordFailure(operationCreateDir, destDir, "", err)
 			mutex.Unlock()
 			return nil // Continue processing other files instead of stopping
 		}
@@ -445,16 +595,12 @@ func downloadFiles(ctx context.Context, s3Client *s3.Client, bucket string, file
 		if err := downloadSingleFile(workerCtx, task.downloader, task.bucket, task.file.Path, task.destPath); err != nil {
 			mutex.Lock()
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to download %s: %v", task.file.RelPath, err))
+			recordFailure(operationDownload, task.destPath, fmt.Sprintf("s3://%s/%s", task.bucket, task.file.Path), err)
 			mutex.Unlock()
 			return nil // Continue processing other files instead of stopping
 		}
 
-		if !shouldUseChecksumCompare() && task.file.ModTime > 0 {
-			modTime := time.Unix(task.file.ModTime, 0)
-			if err := os.Chtimes(task.destPath, modTime, modTime); err != nil {
-				logVerbose("Warning: failed to set file mtime for %s: %v\n", task.destPath, err)
-			}
-		}
+		setSyncedModTime(task.file, task.destPath)
 
 		logInfo("Downloaded: %s\n", task.file.RelPath)
 		mutex.Lock()
@@ -474,25 +620,46 @@ func downloadFiles(ctx context.Context, s3Client *s3.Client, bucket string, file
 			case <-producerCtx.Done():
 				return producerCtx.Err()
 			case taskChan <- task:
+				report.queueFile()
 			}
 		}
 		return nil
 	})
 }
 
+// setSyncedModTime gives a downloaded file the modification time of its
+// object, which the size-time compare strategy relies on
+func setSyncedModTime(file FileInfo, destPath string) {
+	if shouldUseChecksumCompare() || file.ModTime <= 0 {
+		return
+	}
+	modTime := time.Unix(file.ModTime, 0)
+	if err := os.Chtimes(destPath, modTime, modTime); err != nil {
+		logVerbose("Warning: failed to set file mtime for %s: %v\n", destPath, err)
+	}
+}
+
 type uploadSyncTask struct {
 	file     FileInfo
 	bucket   string
 	s3Key    string
-	uploader *manager.Client
+	uploader *uploadManager
 }
 
 func uploadFiles(ctx context.Context, s3Client *s3.Client, bucket, prefix string, files []FileInfo, result *SyncResult) error {
-	uploader := manager.New(s3Client)
+	uploader := getUploader(s3Client)
 
 	var mutex sync.Mutex
 
 	return runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task uploadSyncTask) error {
+		defer report.completeFile()
+
+		release, err := acquireTransferSlot(workerCtx, task.bucket)
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		if dryRun {
 			logInfo("Would upload: %s\n", task.file.RelPath)
 			mutex.Lock()
@@ -504,6 +671,7 @@ func uploadFiles(ctx context.Context, s3Client *s3.Client, bucket, prefix string
 		if err := uploadSingleFile(workerCtx, task.uploader, task.bucket, task.s3Key, task.file.Path); err != nil {
 			mutex.Lock()
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to upload %s: %v", task.file.RelPath, err))
+			recordFailure(operationUpload, task.file.Path, fmt.Sprintf("s3://%s/%s", task.bucket, task.s3Key), err)
 			mutex.Unlock()
 			return nil // Continue processing other files instead of stopping
 		}
@@ -526,6 +694,7 @@ func uploadFiles(ctx context.Context, s3Client *s3.Client, bucket, prefix string
 			case <-producerCtx.Done():
 				return producerCtx.Err()
 			case taskChan <- task:
+				report.queueFile()
 			}
 		}
 		return nil
@@ -542,6 +711,7 @@ func deleteLocalFiles(files []FileInfo, result *SyncResult) error {
 
 		if err := os.Remove(file.Path); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to delete local file %s: %v", file.RelPath, err))
+			recordFailure(operationDeleteLocal, file.Path, "", err)
 			continue
 		}
 
@@ -566,6 +736,7 @@ func deleteS3Files(ctx context.Context, s3Client *s3.Client, bucket string, file
 
 		if err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to delete S3 file %s: %v", file.RelPath, err))
+			recordFailure(operationDelete, "", fmt.Sprintf("s3://%s/%s", bucket, file.Path), err)
 			continue
 		}
 
@@ -579,12 +750,13 @@ func downloadSingleFile(ctx context.Context, downloader *manager.Client, bucket,
 	return downloadFileWithParams(ctx, downloader, bucket, key, destPath, false)
 }
 
-func uploadSingleFile(ctx context.Context, uploader *manager.Client, bucket, key, filePath string) error {
+func uploadSingleFile(ctx context.Context, uploader *uploadManager, bucket, key, filePath string) error {
 	return uploadFileWithParams(ctx, uploader, bucket, key, filePath, false)
 }
 
 func printSyncSummary(result SyncResult) {
-	if quiet {
+	mode := currentOutputMode()
+	if !mode.summary() {
 		return
 	}
 
@@ -592,7 +764,7 @@ func printSyncSummary(result SyncResult) {
 
 	if len(result.Uploaded) > 0 {
 		fmt.Printf("Uploaded: %d files\n", len(result.Uploaded))
-		if verbose {
+		if mode.summaryDetails() {
 			for _, file := range result.Uploaded {
 				fmt.Printf("  up %s\n", file)
 			}
@@ -601,7 +773,7 @@ func printSyncSummary(result SyncResult) {
 
 	if len(result.Downloaded) > 0 {
 		fmt.Printf("Downloaded: %d files\n", len(result.Downloaded))
-		if verbose {
+		if mode.summaryDetails() {
 			for _, file := range result.Downloaded {
 				fmt.Printf("  down %s\n", file)
 			}
@@ -610,13 +782,31 @@ func printSyncSummary(result SyncResult) {
 
 	if len(result.Deleted) > 0 {
 		fmt.Printf("Deleted: %d files\n", len(result.Deleted))
-		if verbose {
+		if mode.summaryDetails() {
 			for _, file := range result.Deleted {
 				fmt.Printf("  delete %s\n", file)
 			}
 		}
 	}
 
+	if len(result.MetadataUpdated) > 0 {
+		fmt.Printf("Metadata updated: %d files\n", len(result.MetadataUpdated))
+		if mode.summaryDetails() {
+			for _, file := range result.MetadataUpdated {
+				fmt.Printf("  meta %s\n", file)
+			}
+		}
+	}
+
+	if len(result.Repaired) > 0 {
+		fmt.Printf("Repaired: %d files\n", len(result.Repaired))
+		if mode.summaryDetails() {
+			for _, file := range result.Repaired {
+				fmt.Printf("  repair %s\n", file)
+			}
+		}
+	}
+
 	if len(result.Errors) > 0 {
 		fmt.Printf("Errors: %d\n", len(result.Errors))
 		for _, err := range result.Errors {
@@ -624,7 +814,7 @@ func printSyncSummary(result SyncResult) {
 		}
 	}
 
-	total := len(result.Uploaded) + len(result.Downloaded) + len(result.Deleted)
+	total := len(result.Uploaded) + len(result.Downloaded) + len(result.Deleted) + len(result.MetadataUpdated) + len(result.Repaired)
 	if total == 0 && len(result.Errors) == 0 {
 		fmt.Println("Directories are already in sync!")
 	}
@@ -416,6 +416,153 @@ func TestSyncS3ToLocal(t *testing.T) {
 	})
 }
 
+func TestSyncState(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tracked, err := loadSyncState(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, tracked)
+
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "kept.txt"), []byte("kept"), 0644))
+	require.NoError(t, saveSyncState(tempDir, map[string]struct{}{"kept.txt": {}, "deleted.txt": {}}))
+
+	tracked, err = loadSyncState(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"kept.txt": {}}, tracked)
+
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, syncStateFileName), []byte("{"), 0644))
+	_, err = loadSyncState(tempDir)
+	assert.ErrorContains(t, err, "invalid sync state")
+}
+
+func TestSyncS3ToLocalTrackedDeletes(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "sync-tracked-deletes-bucket"
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	for _, key := range []string{"shared/a.txt", "shared/b.txt"} {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   strings.NewReader("content of " + key),
+		})
+		require.NoError(t, err)
+	}
+
+	tempDir := t.TempDir()
+	unrelatedFile := filepath.Join(tempDir, "my-notes.txt")
+	require.NoError(t, os.WriteFile(unrelatedFile, []byte("not from S3"), 0644))
+
+	setTestConfig(fmt.Sprintf("s3://%s/shared/", bucketName), tempDir, "", false, true, true, false)
+	syncDeleteScope = "tracked"
+
+	result, err := syncS3ToLocal(ctx, s3Client)
+	require.NoError(t, err)
+	assert.Len(t, result.Downloaded, 2)
+	assert.Empty(t, result.Deleted)
+	assert.FileExists(t, unrelatedFile)
+	assert.FileExists(t, filepath.Join(tempDir, syncStateFileName))
+
+	_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("shared/b.txt"),
+	})
+	require.NoError(t, err)
+
+	result, err = syncS3ToLocal(ctx, s3Client)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt"}, result.Deleted)
+	assert.NoFileExists(t, filepath.Join(tempDir, "b.txt"))
+	assert.FileExists(t, filepath.Join(tempDir, "a.txt"))
+	assert.FileExists(t, unrelatedFile)
+
+	tracked, err := loadSyncState(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"a.txt": {}}, tracked)
+
+	syncDeleteScope = "all"
+	result, err = syncS3ToLocal(ctx, s3Client)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"my-notes.txt"}, result.Deleted)
+	assert.FileExists(t, filepath.Join(tempDir, syncStateFileName))
+}
+
+func TestSyncMetadataOnlyUpdate(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "sync-metadata-bucket"
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "index.html"), []byte("<html></html>"), 0644))
+
+	setTestConfig(tempDir, fmt.Sprintf("s3://%s/site/", bucketName), "", false, true, true, false)
+	syncMode = true
+	contentType = "text/plain"
+
+	result, err := syncLocalToS3(ctx, s3Client)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, result.Uploaded)
+
+	before, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("site/index.html"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", aws.ToString(before.ContentType))
+
+	contentType = "text/html"
+
+	t.Run("content type ignored without sync-metadata", func(t *testing.T) {
+		result, err := syncLocalToS3(ctx, s3Client)
+		require.NoError(t, err)
+		assert.Empty(t, result.Uploaded)
+		assert.Empty(t, result.MetadataUpdated)
+	})
+
+	t.Run("dry run reports the update", func(t *testing.T) {
+		syncMetadata = true
+		dryRun = true
+		defer func() { dryRun = false }()
+
+		result, err := syncLocalToS3(ctx, s3Client)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"index.html"}, result.MetadataUpdated)
+	})
+
+	t.Run("metadata-only update", func(t *testing.T) {
+		syncMetadata = true
+
+		result, err := syncLocalToS3(ctx, s3Client)
+		require.NoError(t, err)
+		assert.Empty(t, result.Uploaded)
+		assert.Empty(t, result.Errors)
+		assert.Equal(t, []string{"index.html"}, result.MetadataUpdated)
+
+		after, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("site/index.html"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "text/html", aws.ToString(after.ContentType))
+		assert.Equal(t, before.Metadata, after.Metadata)
+
+		result, err = syncLocalToS3(ctx, s3Client)
+		require.NoError(t, err)
+		assert.Empty(t, result.Uploaded)
+		assert.Empty(t, result.MetadataUpdated)
+	})
+}
+
 func TestSyncWithIgnorePatterns(t *testing.T) {
 	ctx := context.Background()
 	bucketName := "sync-ignore-test-bucket"
@@ -817,3 +964,67 @@ func TestSyncDryRun(t *testing.T) {
 		}
 	})
 }
+
+func TestSyncMixedEncryptedAndPlainObjects(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "sync-mixed-encryption-bucket"
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("public notes"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "server.pem"), []byte("private key material"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "certs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "certs", "client.PEM"), make([]byte, DefaultEncryptionChunkSize+10), 0600))
+
+	setTestConfig(tempDir, fmt.Sprintf("s3://%s/secrets/", bucketName), "", true, true, true, false)
+	syncMode = true
+	password = "mixed-password"
+	encryptExtensions = []string{".pem"}
+
+	result, err := syncLocalToS3(ctx, s3Client)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"notes.txt", "server.pem", "certs/client.PEM"}, result.Uploaded)
+
+	for key, encrypted := range map[string]bool{"notes.txt": false, "server.pem": true, "certs/client.PEM": true} {
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("secrets/" + key),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, encrypted, isEncryptedObject(head.Metadata), key)
+	}
+
+	t.Run("unchanged files of both kinds are skipped", func(t *testing.T) {
+		for _, compare := range []string{"checksum", "size-time"} {
+			syncCompare = compare
+			result, err := syncLocalToS3(ctx, s3Client)
+			require.NoError(t, err)
+			assert.Empty(t, result.Uploaded, compare)
+			assert.Empty(t, result.Errors, compare)
+		}
+	})
+
+	t.Run("changed encrypted file is uploaded again", func(t *testing.T) {
+		syncCompare = "checksum"
+		later := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(tempDir, "server.pem"), later, later))
+
+		result, err := syncLocalToS3(ctx, s3Client)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"server.pem"}, result.Uploaded)
+	})
+
+	t.Run("plain object of a file that is now encrypted is replaced", func(t *testing.T) {
+		encryptExtensions = []string{".pem", ".txt"}
+		defer func() { encryptExtensions = []string{".pem"} }()
+
+		result, err := syncLocalToS3(ctx, s3Client)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"notes.txt"}, result.Uploaded)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// syncStateFileName is the file in the sync destination that lists the files
+// written by s3copy when --sync-delete-scope is tracked
+const syncStateFileName = ".s3copy-sync-state.json"
+
+// syncState is the content of the sync state file
+type syncState struct {
+	Files []string `json:"files"`
+}
+
+// loadSyncState returns the set of files tracked in a destination directory.
+// A missing state file means no file has been written by s3copy yet.
+func loadSyncState(localDir string) (map[string]struct{}, error) {
+	tracked := make(map[string]struct{})
+
+	data, err := os.ReadFile(filepath.Join(localDir, syncStateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return tracked, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	var state syncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid sync state %s: %w", syncStateFileName, err)
+	}
+	for _, relPath := range state.Files {
+		tracked[relPath] = struct{}{}
+	}
+	return tracked, nil
+}
+
+// saveSyncState records the files that exist locally after a sync and were
+// either downloaded by it or tracked before
+func saveSyncState(localDir string, candidates map[string]struct{}) error {
+	state := syncState{Files: []string{}}
+	for relPath := range candidates {
+		if _, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(relPath))); err == nil {
+			state.Files = append(state.Files, relPath)
+		}
+	}
+	slices.Sort(state.Files)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, syncStateFileName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	return nil
+}
+
+// tracksSyncDeletes reports whether deletions are limited to tracked files
+func tracksSyncDeletes() bool {
+	return syncDeleteScope == "tracked"
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -108,9 +109,25 @@ func captureStdout(fn func()) string {
 	return buf.String()
 }
 
+func captureStderr(fn func()) string {
+	oldStderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+
+	fn()
+
+	closeWithLog(w, "captured stderr")
+	os.Stderr = oldStderr
+
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, r)
+	return buf.String()
+}
+
 func setTestConfig(src, dst, bkt string, enc, rec, qu, verb bool) {
 	source = src
 	destination = dst
+	destinations = nil
 	bucket = bkt
 	encrypt = enc
 	recursive = rec
@@ -129,11 +146,146 @@ func setTestConfig(src, dst, bkt string, enc, rec, qu, verb bool) {
 	forceOverwrite = false
 	syncMode = false
 	syncCompare = "checksum"
+	syncDeleteScope = "all"
+	excludeExisting = false
+	lowercaseKeys = false
+	filterCmd = ""
+	configStdin = false
+	multipartThreshold = ""
+	multipartThresholdBytes = 0
+	uploadOrder = "walk"
+	listCSV = ""
+	perFileTimeout = 0
+	ignoreCase = false
+	strict = false
+	expires = ""
+	expiresAt = time.Time{}
+	expireTag = ""
+	verifyManifestPath = ""
+	moveMode = false
+	maxListConcurrency = 1
+	ifNoneMatch = false
+	showReport = false
+	storeHMAC = false
+	verifyEncryption = false
+	grantFullControl = ""
+	grantFullControlHeader = ""
+	grantRead = ""
+	grantReadHeader = ""
+	resumeUploads = false
+	detailedExitCodes = false
+	verifyDownloads = false
+	localEncryptionIndex = ""
+	restoreFromIndex = false
+	indexRecorder = &encryptionIndexRecorder{}
+	checksumChunked = ""
+	uploadChecksumAlgorithm = ""
+	listIncomplete = false
+	abortIncomplete = false
+	incompleteOlderThan = ""
+	incompleteMinAge = 0
+	contentType = ""
+	cacheControl = ""
+	syncMetadata = false
+	progressInterval = ""
+	progressPeriod = 0
+	ifMetadata = nil
+	metadataConditions = nil
+	dedupeByETag = false
+	startAfter = ""
+	listLimit = 0
+	recipientFile = ""
+	identityFile = ""
+	recipientKey = nil
+	identityKey = nil
+	sourceRoot = ""
+	summaryOnly = false
+	showProgress = false
+	datePrefix = false
+	datePrefixTemplate = defaultDatePrefixTemplate
+	encryptExt = ""
+	encryptExtensions = nil
+	showConfig = false
+	configOnly = false
+	configSources = map[string]string{}
+	assumeExistsOn403 = false
+	assumeExistsAction = "skip"
+	adaptiveConcurrency = false
+	adaptiveSlots = nil
+	catMode = false
+	catRange = ""
+	normalizeUnicode = ""
+	treeHash = false
+	treeHashMarker = ""
+	dirMode = ""
+	downloadDirMode = 0
+	fileMode = ""
+	downloadFileMode = 0
+	sinceETag = ""
+	groupOutput = false
+	listWithChecksum = false
+	noHidden = false
+	preflight = false
+	errorReport = ""
+	skipSameSize = false
+	byStorageClass = false
+	encryptionMemory = ""
+	chunkBuffers = newChunkBufferPool(0)
+	nameFromMetadata = ""
+	regionPerEndpoint = ""
+	endpointRegions = nil
+	mirrorAdd = false
+	bwlimit = ""
+	bwlimitSchedule = ""
+	bandwidth = nil
+	scrubMode = false
+	completionMarker = ""
+	trailingChecksum = false
+	packFiles = false
+	packThreshold = ""
+	packThresholdBytes = 0
+	contentLanguage = ""
+	contentDisposition = ""
+	retryOnChecksumMismatch = false
+	downloadArchive = ""
+	runID = ""
+	maxOpenFiles = 0
+	openFileSlots = nil
+	exactKey = false
+	mapFile = ""
+	uploadMappings = nil
+	continueOnError = false
+	checksumOut = ""
+	flatten = false
+	unflatten = false
+	flattened = newFlattenedFiles()
+	integrityPolicy = integrityETagFirst
+	preferIPv4 = false
+	printConfigFor = ""
+	includeSecrets = false
+	debugHTTP = false
+	checkFreeSpace = false
+	minFree = ""
+	minFreeBytes = 0
+	spotCheck = 0
+	expandEnv = false
+	retryDeadline = ""
+	retryDeadlineDuration = 0
+	listTemplate = ""
+	listTemplateParsed = nil
+	passwordMap = ""
+	passwordRules = nil
+	checksumsFile = ""
+	checksumsAlgorithm = "sha256"
+	uploadedChecksums = &checksumsRecorder{}
+	concurrencyPerEndpoint = ""
+	endpointSlots = nil
 }
 
 func preserveGlobalVars() func() {
 	originalSource := source
 	originalDestination := destination
+	originalDestinations := destinations
 	originalBucket := bucket
 	originalEncrypt := encrypt
 	originalRecursive := recursive
@@ -153,11 +305,146 @@ func preserveGlobalVars() func() {
 	originalIgnoreFile := ignoreFile
 	originalIgnoreMatcher := ignoreMatcher
 	originalSyncCompare := syncCompare
+	originalSyncDeleteScope := syncDeleteScope
 	originalPassword := password
+	originalExcludeExisting := excludeExisting
+	originalLowercaseKeys := lowercaseKeys
+	originalFilterCmd := filterCmd
+	originalConfigStdin := configStdin
+	originalMultipartThreshold := multipartThreshold
+	originalMultipartThresholdBytes := multipartThresholdBytes
+	originalUploadOrder := uploadOrder
+	originalListCSV := listCSV
+	originalPerFileTimeout := perFileTimeout
+	originalIgnoreCase := ignoreCase
+	originalStrict := strict
+	originalExpires := expires
+	originalExpiresAt := expiresAt
+	originalExpireTag := expireTag
+	originalVerifyManifestPath := verifyManifestPath
+	originalMoveMode := moveMode
+	originalMaxListConcurrency := maxListConcurrency
+	originalIfNoneMatch := ifNoneMatch
+	originalShowReport := showReport
+	originalStoreHMAC := storeHMAC
+	originalVerifyEncryption := verifyEncryption
+	originalGrantFullControl := grantFullControl
+	originalGrantFullControlHeader := grantFullControlHeader
+	originalGrantRead := grantRead
+	originalGrantReadHeader := grantReadHeader
+	originalResumeUploads := resumeUploads
+	originalDetailedExitCodes := detailedExitCodes
+	originalVerifyDownloads := verifyDownloads
+	originalLocalEncryptionIndex := localEncryptionIndex
+	originalRestoreFromIndex := restoreFromIndex
+	originalIndexRecorder := indexRecorder
+	originalChecksumChunked := checksumChunked
+	originalUploadChecksumAlgorithm := uploadChecksumAlgorithm
+	originalListIncomplete := listIncomplete
+	originalAbortIncomplete := abortIncomplete
+	originalIncompleteOlderThan := incompleteOlderThan
+	originalIncompleteMinAge := incompleteMinAge
+	originalContentType := contentType
+	originalCacheControl := cacheControl
+	originalSyncMetadata := syncMetadata
+	originalProgressInterval := progressInterval
+	originalProgressPeriod := progressPeriod
+	originalIfMetadata := ifMetadata
+	originalMetadataConditions := metadataConditions
+	originalDedupeByETag := dedupeByETag
+	originalStartAfter := startAfter
+	originalListLimit := listLimit
+	originalRecipientFile := recipientFile
+	originalIdentityFile := identityFile
+	originalRecipientKey := recipientKey
+	originalIdentityKey := identityKey
+	originalSourceRoot := sourceRoot
+	originalSummaryOnly := summaryOnly
+	originalShowProgress := showProgress
+	originalDatePrefix := datePrefix
+	originalDatePrefixTemplate := datePrefixTemplate
+	originalEncryptExt := encryptExt
+	originalEncryptExtensions := encryptExtensions
+	originalShowConfig := showConfig
+	originalConfigOnly := configOnly
+	originalConfigSources := configSources
+	originalAssumeExistsOn403 := assumeExistsOn403
+	originalAssumeExistsAction := assumeExistsAction
+	originalAdaptiveConcurrency := adaptiveConcurrency
+	originalAdaptiveSlots := adaptiveSlots
+	originalCatMode := catMode
+	originalCatRange := catRange
+	originalNormalizeUnicode := normalizeUnicode
+	originalTreeHash := treeHash
+	originalTreeHashMarker := treeHashMarker
+	originalDirMode := dirMode
+	originalDownloadDirMode := downloadDirMode
+	originalFileMode := fileMode
+	originalDownloadFileMode := downloadFileMode
+	originalSinceETag := sinceETag
+	originalGroupOutput := groupOutput
+	originalListWithChecksum := listWithChecksum
+	originalNoHidden := noHidden
+	originalPreflight := preflight
+	originalErrorReport := errorReport
+	originalSkipSameSize := skipSameSize
+	originalByStorageClass := byStorageClass
+	originalEncryptionMemory := encryptionMemory
+	originalChunkBuffers := chunkBuffers
+	originalNameFromMetadata := nameFromMetadata
+	originalRegionPerEndpoint := regionPerEndpoint
+	originalEndpointRegions := endpointRegions
+	originalMirrorAdd := mirrorAdd
+	originalBwlimit := bwlimit
+	originalBwlimitSchedule := bwlimitSchedule
+	originalBandwidth := bandwidth
+	originalScrubMode := scrubMode
+	originalCompletionMarker := completionMarker
+	originalTrailingChecksum := trailingChecksum
+	originalPackFiles := packFiles
+	originalPackThreshold := packThreshold
+	originalPackThresholdBytes := packThresholdBytes
+	originalContentLanguage := contentLanguage
+	originalContentDisposition := contentDisposition
+	originalRetryOnChecksumMismatch := retryOnChecksumMismatch
+	originalDownloadArchive := downloadArchive
+	originalRunID := runID
+	originalMaxOpenFiles := maxOpenFiles
+	originalOpenFileSlots := openFileSlots
+	originalExactKey := exactKey
+	originalMapFile := mapFile
+	originalUploadMappings := uploadMappings
+	originalContinueOnError := continueOnError
+	originalChecksumOut := checksumOut
+	originalFlatten := flatten
+	originalUnflatten := unflatten
+	originalFlattened := flattened
+	originalIntegrityPolicy := integrityPolicy
+	originalPreferIPv4 := preferIPv4
+	originalPrintConfigFor := printConfigFor
+	originalIncludeSecrets := includeSecrets
+	originalDebugHTTP := debugHTTP
+	originalCheckFreeSpace := checkFreeSpace
+	originalMinFree := minFree
+	originalMinFreeBytes := minFreeBytes
+	originalSpotCheck := spotCheck
+	originalExpandEnv := expandEnv
+	originalRetryDeadline := retryDeadline
+	originalRetryDeadlineDuration := retryDeadlineDuration
+	originalListTemplate := listTemplate
+	originalListTemplateParsed := listTemplateParsed
+	originalPasswordMap := passwordMap
+	originalPasswordRules := passwordRules
+	originalChecksumsFile := checksumsFile
+	originalChecksumsAlgorithm := checksumsAlgorithm
+	originalUploadedChecksums := uploadedChecksums
+	originalConcurrencyPerEndpoint := concurrencyPerEndpoint
+	originalEndpointSlots := endpointSlots
 
 	return func() {
 		source = originalSource
 		destination = originalDestination
+		destinations = originalDestinations
 		bucket = originalBucket
 		encrypt = originalEncrypt
 		recursive = originalRecursive
@@ -177,6 +464,140 @@ func preserveGlobalVars() func() {
 		ignoreFile = originalIgnoreFile
 		ignoreMatcher = originalIgnoreMatcher
 		syncCompare = originalSyncCompare
+		syncDeleteScope = originalSyncDeleteScope
 		password = originalPassword
+		excludeExisting = originalExcludeExisting
+		lowercaseKeys = originalLowercaseKeys
+		filterCmd = originalFilterCmd
+		configStdin = originalConfigStdin
+		multipartThreshold = originalMultipartThreshold
+		multipartThresholdBytes = originalMultipartThresholdBytes
+		uploadOrder = originalUploadOrder
+		listCSV = originalListCSV
+		perFileTimeout = originalPerFileTimeout
+		ignoreCase = originalIgnoreCase
+		strict = originalStrict
+		expires = originalExpires
+		expiresAt = originalExpiresAt
+		expireTag = originalExpireTag
+		verifyManifestPath = originalVerifyManifestPath
+		moveMode = originalMoveMode
+		maxListConcurrency = originalMaxListConcurrency
+		ifNoneMatch = originalIfNoneMatch
+		showReport = originalShowReport
+		storeHMAC = originalStoreHMAC
+		verifyEncryption = originalVerifyEncryption
+		grantFullControl = originalGrantFullControl
+		grantFullControlHeader = originalGrantFullControlHeader
+		grantRead = originalGrantRead
+		grantReadHeader = originalGrantReadHeader
+		resumeUploads = originalResumeUploads
+		detailedExitCodes = originalDetailedExitCodes
+		verifyDownloads = originalVerifyDownloads
+		localEncryptionIndex = originalLocalEncryptionIndex
+		restoreFromIndex = originalRestoreFromIndex
+		indexRecorder = originalIndexRecorder
+		checksumChunked = originalChecksumChunked
+		uploadChecksumAlgorithm = originalUploadChecksumAlgorithm
+		listIncomplete = originalListIncomplete
+		abortIncomplete = originalAbortIncomplete
+		incompleteOlderThan = originalIncompleteOlderThan
+		incompleteMinAge = originalIncompleteMinAge
+		contentType = originalContentType
+		cacheControl = originalCacheControl
+		syncMetadata = originalSyncMetadata
+		progressInterval = originalProgressInterval
+		progressPeriod = originalProgressPeriod
+		ifMetadata = originalIfMetadata
+		metadataConditions = originalMetadataConditions
+		dedupeByETag = originalDedupeByETag
+		startAfter = originalStartAfter
+		listLimit = originalListLimit
+		recipientFile = originalRecipientFile
+		identityFile = originalIdentityFile
+		recipientKey = originalRecipientKey
+		identityKey = originalIdentityKey
+		sourceRoot = originalSourceRoot
+		summaryOnly = originalSummaryOnly
+		showProgress = originalShowProgress
+		datePrefix = originalDatePrefix
+		datePrefixTemplate = originalDatePrefixTemplate
+		encryptExt = originalEncryptExt
+		encryptExtensions = originalEncryptExtensions
+		showConfig = originalShowConfig
+		configOnly = originalConfigOnly
+		configSources = originalConfigSources
+		assumeExistsOn403 = originalAssumeExistsOn403
+		assumeExistsAction = originalAssumeExistsAction
+		adaptiveConcurrency = originalAdaptiveConcurrency
+		adaptiveSlots = originalAdaptiveSlots
+		catMode = originalCatMode
+		catRange = originalCatRange
+		normalizeUnicode = originalNormalizeUnicode
+		treeHash = originalTreeHash
+		treeHashMarker = originalTreeHashMarker
+		dirMode = originalDirMode
+		downloadDirMode = originalDownloadDirMode
+		fileMode = originalFileMode
+		downloadFileMode = originalDownloadFileMode
+		sinceETag = originalSinceETag
+		groupOutput = originalGroupOutput
+		listWithChecksum = originalListWithChecksum
+		noHidden = originalNoHidden
+		preflight = originalPreflight
+		errorReport = originalErrorReport
+		skipSameSize = originalSkipSameSize
+		byStorageClass = originalByStorageClass
+		encryptionMemory = originalEncryptionMemory
+		chunkBuffers = originalChunkBuffers
+		nameFromMetadata = originalNameFromMetadata
+		regionPerEndpoint = originalRegionPerEndpoint
+		endpointRegions = originalEndpointRegions
+		mirrorAdd = originalMirrorAdd
+		bwlimit = originalBwlimit
+		bwlimitSchedule = originalBwlimitSchedule
+		bandwidth = originalBandwidth
+		scrubMode = originalScrubMode
+		completionMarker = originalCompletionMarker
+		trailingChecksum = originalTrailingChecksum
+		packFiles = originalPackFiles
+		packThreshold = originalPackThreshold
+		packThresholdBytes = originalPackThresholdBytes
+		contentLanguage = originalContentLanguage
+		contentDisposition = originalContentDisposition
+		retryOnChecksumMismatch = originalRetryOnChecksumMismatch
+		downloadArchive = originalDownloadArchive
+		runID = originalRunID
+		maxOpenFiles = originalMaxOpenFiles
+		openFileSlots = originalOpenFileSlots
+		exactKey = originalExactKey
+		mapFile = originalMapFile
+		uploadMappings = originalUploadMappings
+		continueOnError = originalContinueOnError
+		checksumOut = originalChecksumOut
+		flatten = originalFlatten
+		unflatten = originalUnflatten
+		flattened = originalFlattened
+		integrityPolicy = originalIntegrityPolicy
+		preferIPv4 = originalPreferIPv4
+		printConfigFor = originalPrintConfigFor
+		includeSecrets = originalIncludeSecrets
+		debugHTTP = originalDebugHTTP
+		checkFreeSpace = originalCheckFreeSpace
+		minFree = originalMinFree
+		minFreeBytes = originalMinFreeBytes
+		spotCheck = originalSpotCheck
+		expandEnv = originalExpandEnv
+		retryDeadline = originalRetryDeadline
+		retryDeadlineDuration = originalRetryDeadlineDuration
+		listTemplate = originalListTemplate
+		listTemplateParsed = originalListTemplateParsed
+		passwordMap = originalPasswordMap
+		passwordRules = originalPasswordRules
+		checksumsFile = originalChecksumsFile
+		checksumsAlgorithm = originalChecksumsAlgorithm
+		uploadedChecksums = originalUploadedChecksums
+		concurrencyPerEndpoint = originalConcurrencyPerEndpoint
+		endpointSlots = originalEndpointSlots
 	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// toolConfigProfile names the profile or remote in the snippets of
+// --print-config-for
+const toolConfigProfile = "s3copy"
+
+// toolConfigWriters maps the --print-config-for tools to the functions
+// that write their config snippet
+var toolConfigWriters = map[string]func(w io.Writer, secretKey string){
+	"aws-cli": writeAWSCLIConfig,
+	"rclone":  writeRcloneConfig,
+}
+
+// validateToolConfig checks a --print-config-for value
+func validateToolConfig(tool string) error {
+	if _, ok := toolConfigWriters[tool]; !ok {
+		return fmt.Errorf("invalid print-config-for %q, use aws-cli or rclone", tool)
+	}
+	return nil
+}
+
+// writeToolConfig writes a config snippet for another S3 tool from the
+// resolved connection settings. The secret key is redacted unless
+// --include-secrets is set.
+func writeToolConfig(w io.Writer, tool string) error {
+	writeConfig, ok := toolConfigWriters[tool]
+	if !ok {
+		return validateToolConfig(tool)
+	}
+	secretKey := redactedValue
+	if includeSecrets {
+		secretKey = config.SecretKey
+	}
+	writeConfig(w, secretKey)
+	return nil
+}
+
+// writeAWSCLIConfig writes a profile for ~/.aws/config and its credentials
+// for ~/.aws/credentials. endpoint_url needs AWS CLI 2.13 or later.
+func writeAWSCLIConfig(w io.Writer, secretKey string) {
+	fmt.Fprintln(w, "# ~/.aws/config")
+	fmt.Fprintf(w, "[profile %s]\n", toolConfigProfile)
+	fmt.Fprintf(w, "region = %s\n", config.Region)
+	if config.Endpoint != "" {
+		fmt.Fprintf(w, "endpoint_url = %s\n", config.Endpoint)
+	}
+	if config.UsePathStyle {
+		fmt.Fprintln(w, "s3 =")
+		fmt.Fprintln(w, "    addressing_style = path")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "# ~/.aws/credentials")
+	fmt.Fprintf(w, "[%s]\n", toolConfigProfile)
+	fmt.Fprintf(w, "aws_access_key_id = %s\n", config.AccessKey)
+	fmt.Fprintf(w, "aws_secret_access_key = %s\n", secretKey)
+}
+
+// writeRcloneConfig writes a remote for rclone.conf. Custom endpoints use
+// the generic provider, AWS itself the AWS provider.
+func writeRcloneConfig(w io.Writer, secretKey string) {
+	provider := "AWS"
+	if config.Endpoint != "" {
+		provider = "Other"
+	}
+	fmt.Fprintln(w, "# rclone.conf")
+	fmt.Fprintf(w, "[%s]\n", toolConfigProfile)
+	fmt.Fprintln(w, "type = s3")
+	fmt.Fprintf(w, "provider = %s\n", provider)
+	fmt.Fprintf(w, "access_key_id = %s\n", config.AccessKey)
+	fmt.Fprintf(w, "secret_access_key = %s\n", secretKey)
+	fmt.Fprintf(w, "region = %s\n", config.Region)
+	if config.Endpoint != "" {
+		fmt.Fprintf(w, "endpoint = %s\n", config.Endpoint)
+	}
+	fmt.Fprintf(w, "force_path_style = %t\n", config.UsePathStyle)
+}
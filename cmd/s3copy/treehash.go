@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// treeHashMetadataKey stores the root in the metadata of the marker object,
+// so a HeadObject is enough to compare it
+const treeHashMetadataKey = "tree-hash"
+
+// treeNode is a directory of the tree --tree-hash hashes: the MD5 of every
+// file and the subdirectories, keyed by name
+type treeNode struct {
+	files map[string]string
+	dirs  map[string]*treeNode
+}
+
+func newTreeNode() *treeNode {
+	return &treeNode{files: make(map[string]string), dirs: make(map[string]*treeNode)}
+}
+
+// add inserts a file by its slash-separated relative path
+func (n *treeNode) add(relPath, md5Hash string) {
+	dir, name, nested := strings.Cut(relPath, "/")
+	if !nested {
+		n.files[dir] = md5Hash
+		return
+	}
+	child, ok := n.dirs[dir]
+	if !ok {
+		child = newTreeNode()
+		n.dirs[dir] = child
+	}
+	child.add(name, md5Hash)
+}
+
+// sum hashes the sorted entries of the directory. A file entry is its name
+// and MD5, a directory entry its name and the sum of the directory, so the
+// root changes whenever any path or content below it changes. Names are
+// length-prefixed to keep entries unambiguous.
+func (n *treeNode) sum() []byte {
+	names := make([]string, 0, len(n.files)+len(n.dirs))
+	for name := range n.files {
+		names = append(names, name)
+	}
+	for name := range n.dirs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		if md5Hash, ok := n.files[name]; ok {
+			fmt.Fprintf(h, "file %d:%s %s\n", len(name), name, md5Hash)
+		}
+		if child, ok := n.dirs[name]; ok {
+			fmt.Fprintf(h, "dir %d:%s %x\n", len(name), name, child.sum())
+		}
+	}
+	return h.Sum(nil)
+}
+
+// computeTreeHash returns the hex root hash of the files listLocalFiles
+// returns for a directory. The sync state file is left out, it changes on
+// every sync without the tree changing.
+func computeTreeHash(files []FileInfo) string {
+	root := newTreeNode()
+	for _, file := range files {
+		if file.RelPath == syncStateFileName {
+			continue
+		}
+		root.add(file.RelPath, file.MD5Hash)
+	}
+	return hex.EncodeToString(root.sum())
+}
+
+// printTreeHash prints the tree hash of the source directory. With
+// --tree-hash-marker it compares the root with the one stored in the marker
+// object and stores the new root when it changed.
+func printTreeHash(ctx context.Context) error {
+	files, err := listLocalFiles(source)
+	if err != nil {
+		return fmt.Errorf("failed to list local files: %w", err)
+	}
+	root := computeTreeHash(files)
+	fmt.Printf("%s  %s (%d files)\n", root, source, len(files))
+
+	if treeHashMarker == "" {
+		return nil
+	}
+
+	markerBucket, markerKey, err := splitS3URI(treeHashMarker)
+	if err != nil {
+		return err
+	}
+	s3Client, err := getS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client: %w", err)
+	}
+
+	exists, _, metadata, err := checkS3ObjectExists(ctx, s3Client, markerBucket, markerKey)
+	if err != nil {
+		return fmt.Errorf("failed to read tree hash marker: %w", err)
+	}
+	switch {
+	case !exists:
+		logSummary("No tree hash marker at %s yet\n", treeHashMarker)
+	case metadata[treeHashMetadataKey] == root:
+		logSummary("Tree unchanged since the marker at %s was written\n", treeHashMarker)
+		return nil
+	default:
+		logSummary("Tree changed, the marker at %s has %s\n", treeHashMarker, metadata[treeHashMetadataKey])
+	}
+
+	if dryRun {
+		logInfo("Would write tree hash marker %s\n", treeHashMarker)
+		return nil
+	}
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(markerBucket),
+		Key:         aws.String(markerKey),
+		Body:        strings.NewReader(root + "\n"),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]string{treeHashMetadataKey: root},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write tree hash marker: %w", err)
+	}
+	logInfo("Wrote tree hash marker %s\n", treeHashMarker)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeTreeHash(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, true, false, false)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("beta"), 0644))
+
+	rootOf := func(t *testing.T) string {
+		t.Helper()
+		files, err := listLocalFiles(dir)
+		require.NoError(t, err)
+		return computeTreeHash(files)
+	}
+
+	initial := rootOf(t)
+	assert.Len(t, initial, 64)
+	assert.Equal(t, initial, rootOf(t), "stable while nothing changes")
+
+	files, err := listLocalFiles(dir)
+	require.NoError(t, err)
+	slices.Reverse(files)
+	assert.Equal(t, initial, computeTreeHash(files), "independent of the listing order")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, syncStateFileName), []byte("{}"), 0644))
+	assert.Equal(t, initial, rootOf(t), "the sync state file is ignored")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("BETA"), 0644))
+	changed := rootOf(t)
+	assert.NotEqual(t, initial, changed, "changes when a file changes")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("beta"), 0644))
+	assert.Equal(t, initial, rootOf(t), "back to the original content")
+
+	require.NoError(t, os.Rename(filepath.Join(dir, "sub", "b.txt"), filepath.Join(dir, "sub", "c.txt")))
+	assert.NotEqual(t, initial, rootOf(t), "changes when a file is renamed")
+
+	flat := computeTreeHash([]FileInfo{{RelPath: "sub/b.txt", MD5Hash: "x"}})
+	nested := computeTreeHash([]FileInfo{{RelPath: "sub/b/txt", MD5Hash: "x"}})
+	assert.NotEqual(t, flat, nested)
+}
+
+func TestPrintTreeHashMarker(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644))
+
+	setTestConfig(dir, "", "", false, true, false, false)
+	treeHash = true
+	treeHashMarker = "s3://markers/photos.treehash"
+
+	run := func(t *testing.T) string {
+		t.Helper()
+		var err error
+		output := captureStdout(func() {
+			err = printTreeHash(context.Background())
+		})
+		require.NoError(t, err)
+		return output
+	}
+
+	output := run(t)
+	assert.Contains(t, output, "No tree hash marker at s3://markers/photos.treehash yet")
+	assert.Equal(t, 1, fake.count("PutObject"))
+	marker, ok := fake.object("markers/photos.treehash")
+	require.True(t, ok)
+	assert.NotEmpty(t, marker.metadata("Tree-Hash"))
+
+	output = run(t)
+	assert.Contains(t, output, "Tree unchanged")
+	assert.Equal(t, 1, fake.count("PutObject"), "an unchanged root is not written again")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("ALPHA"), 0644))
+	output = run(t)
+	assert.Contains(t, output, "Tree changed")
+	assert.Equal(t, 2, fake.count("PutObject"))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// unicodeForms are the normalization forms --normalize-unicode accepts.
+// macOS file systems return names in NFD while Linux and Windows usually
+// store NFC, so the same visible name can produce two different keys.
+var unicodeForms = map[string]norm.Form{
+	"nfc": norm.NFC,
+	"nfd": norm.NFD,
+}
+
+// validateUnicodeForm checks the value of --normalize-unicode
+func validateUnicodeForm(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, ok := unicodeForms[value]; !ok {
+		return fmt.Errorf("invalid normalize-unicode value %q, must be nfc or nfd", value)
+	}
+	return nil
+}
+
+// normalizeUnicodePath converts a key or relative path to the form selected
+// with --normalize-unicode and returns it unchanged when the flag is not set
+func normalizeUnicodePath(p string) string {
+	form, ok := unicodeForms[normalizeUnicode]
+	if !ok {
+		return p
+	}
+	return form.String(p)
+}
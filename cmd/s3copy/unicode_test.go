@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// "café.txt" with a precomposed é (NFC, as stored by Linux) and with e
+// followed by a combining acute accent (NFD, as returned by macOS)
+const (
+	nfcName = "caf\u00e9.txt"
+	nfdName = "cafe\u0301.txt"
+)
+
+func TestNormalizeUnicodePath(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	normalizeUnicode = ""
+	assert.Equal(t, nfdName, normalizeUnicodePath(nfdName), "unchanged without the flag")
+
+	normalizeUnicode = "nfc"
+	assert.Equal(t, nfcName, normalizeUnicodePath(nfdName))
+	assert.Equal(t, nfcName, normalizeUnicodePath(nfcName))
+
+	normalizeUnicode = "nfd"
+	assert.Equal(t, nfdName, normalizeUnicodePath(nfcName))
+
+	assert.NoError(t, validateUnicodeForm(""))
+	assert.NoError(t, validateUnicodeForm("nfc"))
+	assert.Error(t, validateUnicodeForm("NFKC"))
+}
+
+func TestNormalizeKeyUnicodeCollision(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	lowercaseKeys = false
+	normalizeUnicode = "nfc"
+
+	assert.Equal(t, "docs/"+nfcName, normalizeKeyPath("docs/"+nfdName))
+
+	run := newUploadRun()
+	require.NoError(t, run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/" + normalizeKeyPath(nfdName)}}, "/mac/"+nfdName))
+	err := run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/" + normalizeKeyPath(nfcName)}}, "/linux/"+nfcName)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "key collision")
+}
+
+func TestListLocalFilesNormalizesUnicode(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	setTestConfig("", "", "", false, true, false, false)
+	normalizeUnicode = "nfc"
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, nfdName), []byte("data"), 0644))
+
+	files, err := listLocalFilesWithOptions(dir, false)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, nfcName, files[0].RelPath, "compared in sync under the NFC name")
+	assert.Equal(t, filepath.Join(dir, nfdName), files[0].Path, "read from the name on disk")
+}
+
+func TestUploadNormalizeUnicode(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+	resetS3Client()
+	defer resetS3Client()
+
+	config = Config{
+		AccessKey: "dummy",
+		SecretKey: "dummy",
+		Region:    "us-east-1",
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, nfdName), []byte("data"), 0644))
+
+	setTestConfig(dir, "s3://bucket/docs/", "", false, true, false, false)
+	dryRun = true
+	normalizeUnicode = "nfc"
+
+	var err error
+	output := captureStdout(func() {
+		err = uploadToS3(context.Background())
+	})
+	require.NoError(t, err)
+
+	match := regexp.MustCompile(`s3://bucket/(\S+)`).FindStringSubmatch(output)
+	require.NotNil(t, match, output)
+	assert.Equal(t, "docs/"+nfcName, match[1])
+}
@@ -1,17 +1,30 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"mime"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	mtypes "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
 )
 
 func uploadToS3(ctx context.Context) error {
@@ -20,7 +33,15 @@ func uploadToS3(ctx context.Context) error {
 		return fmt.Errorf("failed to get S3 client: %w", err)
 	}
 
-	uploader := manager.New(s3Client)
+	uploader := getUploader(s3Client)
+	run := newUploadRun()
+
+	if isHTTPSource(source) {
+		return uploadFromURL(ctx, uploader)
+	}
+	if source == stdinSource {
+		return uploadFromStdin(ctx, uploader)
+	}
 
 	matches, err := filepath.Glob(source)
 	if err != nil {
@@ -33,26 +54,32 @@ func uploadToS3(ctx context.Context) error {
 			return fmt.Errorf("failed to stat source: %w", err)
 		}
 
-		parsedBucket, s3Key, err := parseS3Path(destination, bucket, info.IsDir(), source)
+		targets, err := resolveUploadTargets(info.IsDir() || sourceRoot != "", source)
 		if err != nil {
 			return err
 		}
 
-		if parsedBucket != "" {
-			bucket = parsedBucket
-		}
-
 		if info.IsDir() {
 			if !recursive {
 				return fmt.Errorf("source is a directory, use -r flag for recursive copy")
 			}
-			return uploadDirectory(ctx, uploader, source, s3Key)
+			if err := uploadDirectory(ctx, uploader, source, targets, run); err != nil {
+				return err
+			}
+			return run.result()
 		}
 
-		return uploadFile(ctx, uploader, source, s3Key)
+		targets, err = fileUploadTargets(targets, source, info, false)
+		if err != nil {
+			return err
+		}
+		defer report.track(phaseTransfer)()
+		report.queueFile()
+		defer report.completeFile()
+		return uploadFile(ctx, uploader, source, targets)
 	}
 
-	var parsedBucket, s3Key string
+	var targets []uploadTarget
 
 	if len(matches) == 1 {
 		info, statErr := os.Stat(matches[0])
@@ -62,24 +89,27 @@ func uploadToS3(ctx context.Context) error {
 			return fmt.Errorf("source is a directory, use -r flag for recursive copy")
 		}
 
-		parsedBucket, s3Key, err = parseS3Path(destination, bucket, isDir, matches[0])
+		targets, err = resolveUploadTargets(isDir || sourceRoot != "", matches[0])
 		if err != nil {
 			return err
 		}
 	} else {
-		parsedBucket, s3Key, err = parseS3Path(destination, bucket, true, "")
+		targets, err = resolveUploadTargets(true, "")
 		if err != nil {
 			return err
 		}
 	}
 
-	if parsedBucket != "" {
-		bucket = parsedBucket
+	if normalizesKeys() && len(matches) > 1 {
+		if err := checkGlobKeys(matches, targets); err != nil {
+			return err
+		}
 	}
 
 	for _, match := range matches {
 		if shouldIgnoreFile(match) {
 			logInfo("Ignoring: %s\n", match)
+			run.ignored++
 			continue
 		}
 
@@ -91,98 +121,649 @@ func uploadToS3(ctx context.Context) error {
 
 		if info.IsDir() {
 			if recursive {
-				var dirS3Key string
-				if len(matches) == 1 {
-					dirS3Key = s3Key
-				} else {
-					dirS3Key = filepath.Join(s3Key, filepath.Base(match))
-					dirS3Key = strings.ReplaceAll(dirS3Key, "\\", "/")
-				}
-				if err := uploadDirectory(ctx, uploader, match, dirS3Key); err != nil {
+				dirTargets := globDirTargets(targets, match, len(matches) > 1)
+				if err := uploadDirectory(ctx, uploader, match, dirTargets, run); err != nil {
 					return err
 				}
 			} else {
 				logInfo("Skipping directory: %s (use -r flag for recursive copy)\n", match)
 			}
 		} else {
-			key := s3Key
-			if len(matches) > 1 {
-				key = filepath.Join(s3Key, filepath.Base(match))
-				key = strings.ReplaceAll(key, "\\", "/")
+			fileTargets, err := fileUploadTargets(targets, match, info, len(matches) > 1)
+			if err != nil {
+				return err
+			}
+			if err := run.claimTargets(fileTargets, match); err != nil {
+				return err
 			}
-			if err := uploadFile(ctx, uploader, match, key); err != nil {
+			run.included++
+			stopTransfer := report.track(phaseTransfer)
+			report.queueFile()
+			err = uploadFile(ctx, uploader, match, fileTargets)
+			report.completeFile()
+			stopTransfer()
+			if err := run.skipTimedOut(ctx, err); err != nil {
 				return err
 			}
 		}
 	}
 
-	return nil
+	return run.result()
+}
+
+// resolveSymlinkedDir returns the directory a symlinked source points to,
+// because filepath.Walk does not descend into a symlinked root. Other paths,
+// including symlinks to files, are returned unchanged so their base name
+// still names the object.
+func resolveSymlinkedDir(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlink %s: %w", path, err)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return path, nil
+	}
+	return resolved, nil
+}
+
+// walkLocalDir is filepath.Walk that also descends into a root that is a
+// symlink to a directory. The paths passed to fn keep root as their prefix,
+// so keys and ignore patterns are still relative to the source as given.
+func walkLocalDir(root string, fn filepath.WalkFunc) error {
+	resolved, err := resolveSymlinkedDir(root)
+	if err != nil {
+		return err
+	}
+	if resolved == root {
+		return filepath.Walk(root, fn)
+	}
+	return filepath.Walk(resolved, func(path string, info os.FileInfo, err error) error {
+		relPath, relErr := filepath.Rel(resolved, path)
+		if relErr != nil {
+			return relErr
+		}
+		if relPath == "." {
+			return fn(root, info, err)
+		}
+		return fn(filepath.Join(root, relPath), info, err)
+	})
+}
+
+// uploadTarget is a bucket and key an upload is written to
+type uploadTarget struct {
+	bucket string
+	key    string
+}
+
+func (t uploadTarget) String() string {
+	return fmt.Sprintf("s3://%s/%s", t.bucket, t.key)
 }
 
-func uploadDirectory(ctx context.Context, uploader *manager.Client, localDir, s3Prefix string) error {
-	type uploadTask struct {
-		localPath string
-		s3Key     string
+// targetBuckets returns the bucket of every target
+func targetBuckets(targets []uploadTarget) []string {
+	buckets := make([]string, len(targets))
+	for i, target := range targets {
+		buckets[i] = target.bucket
 	}
+	return buckets
+}
 
-	return runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task uploadTask) error {
-		if err := uploadFile(workerCtx, uploader, task.localPath, task.s3Key); err != nil {
-			return fmt.Errorf("failed to upload %s: %w", task.localPath, err)
+// uploadDestinations returns all destinations of the current upload. Multiple
+// destinations are only set when --destination is repeated.
+func uploadDestinations() []string {
+	if len(destinations) > 1 {
+		return destinations
+	}
+	return []string{destination}
+}
+
+// resolveUploadTargets parses every upload destination into a bucket and base
+// key. The bucket of the first destination becomes the global bucket.
+func resolveUploadTargets(isDir bool, localPath string) ([]uploadTarget, error) {
+	var targets []uploadTarget
+	for _, dest := range uploadDestinations() {
+		// only the file name of localPath can end up in a key
+		parsedBucket, s3Key, err := parseS3Path(dest, bucket, isDir, normalizeKeyPath(localPath))
+		if err != nil {
+			return nil, err
+		}
+		if parsedBucket == "" {
+			parsedBucket = bucket
 		}
+		targets = append(targets, uploadTarget{bucket: parsedBucket, key: s3Key})
+	}
+
+	if targets[0].bucket != "" {
+		bucket = targets[0].bucket
+	}
+
+	return targets, nil
+}
+
+// uploadRelPath returns the path of a local file that is appended to the
+// upload prefix: relative to --source-root when it is set, otherwise
+// relative to base
+func uploadRelPath(base, localPath string) (string, error) {
+	if sourceRoot == "" {
+		return filepath.Rel(base, localPath)
+	}
+
+	root, err := filepath.Abs(sourceRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid source root: %w", err)
+	}
+	absPath, err := filepath.Abs(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", localPath, err)
+	}
+	relPath, err := filepath.Rel(root, absPath)
+	if err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside the source root %s", localPath, sourceRoot)
+	}
+	return relPath, nil
+}
+
+// fileUploadTargets returns the targets of a single file or of a file
+// matched by a glob source. With multiple matches the file name is appended
+// to the destination prefix.
+func fileUploadTargets(targets []uploadTarget, localPath string, info os.FileInfo, multiple bool) ([]uploadTarget, error) {
+	var relPath string
+	if sourceRoot != "" {
+		var err error
+		relPath, err = uploadRelPath("", localPath)
+		if err != nil {
+			return nil, err
+		}
+		targets = joinUploadTargets(targets, normalizeKeyPath(relPath))
+	} else if multiple {
+		relPath = filepath.Base(localPath)
+		targets = joinUploadTargets(targets, normalizeKeyPath(relPath))
+	}
+	targets = mapUploadTargets(targets, cmp.Or(relPath, filepath.Base(localPath)))
+	return addDatePrefix(targets, normalizeKeyPath(relPath), info.ModTime()), nil
+}
+
+// globDirTargets returns the prefixes a directory matched by a glob source
+// is uploaded to. With multiple matches every directory gets its own prefix.
+func globDirTargets(targets []uploadTarget, localDir string, multiple bool) []uploadTarget {
+	if multiple && sourceRoot == "" {
+		return joinUploadTargets(targets, normalizeKeyPath(filepath.Base(localDir)))
+	}
+	return targets
+}
+
+// directoryFileTargets returns the targets of a file below an uploaded
+// directory
+func directoryFileTargets(prefixes []uploadTarget, relPath string, modTime time.Time) []uploadTarget {
+	keyPath := normalizeKeyPath(relPath)
+	return addDatePrefix(mapUploadTargets(joinUploadTargets(prefixes, flattenRelPath(keyPath)), relPath), keyPath, modTime)
+}
+
+// joinUploadTargets appends a relative path to the key of every target
+func joinUploadTargets(targets []uploadTarget, relPath string) []uploadTarget {
+	joined := make([]uploadTarget, len(targets))
+	for i, target := range targets {
+		joined[i] = uploadTarget{
+			bucket: target.bucket,
+			key:    strings.ReplaceAll(filepath.Join(target.key, relPath), "\\", "/"),
+		}
+	}
+	return joined
+}
+
+// uploadManager is the transfer manager client for uploads together with
+// the S3 client it sends its requests with and its progress listeners. The
+// requests the transfer manager does not offer, like the parts of a
+// resumable upload, go through s3Client and so share its throttling, retry
+// deadline, debug logging and If-None-Match precondition.
+type uploadManager struct {
+	*manager.Client
+	s3Client  *s3.Client
+	listeners manager.ObjectProgressListeners
+}
+
+// newUploader creates a transfer manager client for uploads, applying the
+// configured multipart threshold, the If-None-Match precondition and optFns
+func newUploader(s3Client *s3.Client, optFns ...func(*manager.Options)) *uploadManager {
+	if ifNoneMatch {
+		s3Client = s3.New(s3Client.Options(), func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, addIfNoneMatch)
+		})
+	}
+	uploader := &uploadManager{s3Client: s3Client}
+	optFns = append([]func(*manager.Options){applyUploaderOptions}, optFns...)
+	optFns = append(optFns, func(o *manager.Options) {
+		uploader.listeners = o.ObjectProgressListeners.Copy()
+	})
+	uploader.Client = manager.New(s3Client, optFns...)
+	return uploader
+}
+
+// applyUploaderOptions sets the transfer manager options configured by flags
+func applyUploaderOptions(o *manager.Options) {
+	if multipartThresholdBytes > 0 {
+		o.MultipartUploadThreshold = multipartThresholdBytes
+	}
+	if uploadChecksumAlgorithm != "" {
+		o.ChecksumAlgorithm = uploadChecksumAlgorithm
+	}
+}
+
+// parseChecksumAlgorithm validates the --checksum-chunked algorithm name
+func parseChecksumAlgorithm(value string) (mtypes.ChecksumAlgorithm, error) {
+	algorithm := mtypes.ChecksumAlgorithm(strings.ToUpper(value))
+	switch algorithm {
+	case mtypes.ChecksumAlgorithmCrc32, mtypes.ChecksumAlgorithmCrc32c, mtypes.ChecksumAlgorithmSha1, mtypes.ChecksumAlgorithmSha256:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("unsupported checksum algorithm %q, use crc32, crc32c, sha1 or sha256", value)
+}
+
+// addIfNoneMatch sets "If-None-Match: *" on the requests that create an
+// object, so the server rejects the upload when the key already exists. For
+// multipart uploads the precondition is evaluated on completion.
+func addIfNoneMatch(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3CopyIfNoneMatch", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		switch params := in.Parameters.(type) {
+		case *s3.PutObjectInput:
+			params.IfNoneMatch = aws.String("*")
+		case *s3.CompleteMultipartUploadInput:
+			params.IfNoneMatch = aws.String("*")
+		}
+		return next.HandleInitialize(ctx, in)
+	}), middleware.Before)
+}
+
+// isPreconditionFailed reports whether an upload was rejected because of the
+// If-None-Match precondition, i.e. the object already exists
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// uploadRun holds the state of a single uploadToS3 call: the computed S3 keys,
+// so key normalization can detect two local files mapping to the same key, and
+// counters of included and ignored entries.
+type uploadRun struct {
+	keys     map[string]string
+	included int
+	ignored  int
+	timedOut atomic.Int64
+}
+
+func newUploadRun() *uploadRun {
+	return &uploadRun{keys: make(map[string]string)}
+}
+
+// normalizesKeys reports whether --normalize-unicode or --lowercase-keys
+// can map two local files to the same key
+func normalizesKeys() bool {
+	return lowercaseKeys || normalizeUnicode != ""
+}
+
+// normalizeKeyPath applies --normalize-unicode and --lowercase-keys to the
+// part of a key computed from a local path. The destination prefix and keys
+// given by the user are not changed.
+func normalizeKeyPath(p string) string {
+	p = normalizeUnicodePath(p)
+	if lowercaseKeys {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// claimTargets records the normalized keys of a local file, returning an
+// error when another local file already maps to one of them
+func (u *uploadRun) claimTargets(targets []uploadTarget, localPath string) error {
+	if !normalizesKeys() {
 		return nil
-	}, func(producerCtx context.Context, taskChan chan<- uploadTask) error {
-		walkErr := filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
+	}
+
+	for _, target := range targets {
+		if existing, exists := u.keys[target.String()]; exists && existing != localPath {
+			return fmt.Errorf("key collision after normalization: %s and %s both map to %s", existing, localPath, target)
+		}
+		u.keys[target.String()] = localPath
+	}
+	return nil
+}
+
+// checkGlobKeys computes the keys of every file a glob source matches before
+// the first transfer, so a collision after key normalization fails the run
+// before any object is written
+func checkGlobKeys(matches []string, targets []uploadTarget) error {
+	run := newUploadRun()
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || shouldIgnoreFile(match) {
+			continue
+		}
+
+		if !info.IsDir() {
+			fileTargets, err := fileUploadTargets(targets, match, info, len(matches) > 1)
 			if err != nil {
 				return err
 			}
-
-			if producerCtx.Err() != nil {
-				return producerCtx.Err()
+			if err := run.claimTargets(fileTargets, match); err != nil {
+				return err
 			}
+			continue
+		}
+		if !recursive {
+			continue
+		}
 
-			if info.IsDir() {
-				if shouldIgnoreFile(path) {
-					logInfo("Ignoring directory: %s\n", path)
+		prefixes := globDirTargets(targets, match, len(matches) > 1)
+		err = walkLocalDir(match, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if shouldIgnoreFile(path) {
+				if info.IsDir() {
 					return filepath.SkipDir
 				}
 				return nil
 			}
-
-			if shouldIgnoreFile(path) {
-				logInfo("Ignoring file: %s\n", path)
+			if info.IsDir() {
 				return nil
 			}
-
-			relPath, relErr := filepath.Rel(localDir, path)
-			if relErr != nil {
-				return relErr
+			relPath, err := uploadRelPath(match, path)
+			if err != nil {
+				return err
 			}
+			return run.claimTargets(directoryFileTargets(prefixes, relPath, info.ModTime()), path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			task := uploadTask{
-				localPath: path,
-				s3Key:     strings.ReplaceAll(filepath.Join(s3Prefix, relPath), "\\", "/"),
+// skipTimedOut returns nil for a file that exceeded --per-file-timeout, which
+// was already recorded as a failure, so the other files are still uploaded
+func (u *uploadRun) skipTimedOut(ctx context.Context, err error) error {
+	if !isFileTimeout(err) || ctx.Err() != nil {
+		return err
+	}
+	logInfoContext(ctx, "Error: %v\n", err)
+	u.timedOut.Add(1)
+	return nil
+}
+
+// result returns the error of an upload whose transfers all ran: a file that
+// timed out makes it a partial failure
+func (u *uploadRun) result() error {
+	if timedOut := u.timedOut.Load(); timedOut > 0 {
+		return &partialFailureError{fmt.Errorf("upload completed with %d timed out file(s)", timedOut)}
+	}
+	return u.checkAllIgnored()
+}
+
+// checkAllIgnored reports when ignore patterns excluded every matched file,
+// which usually means the patterns are too aggressive
+func (u *uploadRun) checkAllIgnored() error {
+	if u.included > 0 || u.ignored == 0 {
+		return nil
+	}
+
+	if strict {
+		return fmt.Errorf("all matched files were ignored by patterns")
+	}
+	fmt.Fprintf(os.Stderr, "Warning: all matched files were ignored by patterns, nothing was uploaded\n")
+	return nil
+}
+
+// uploadTask is a local file of a directory upload and the targets it is
+// written to, or with --pack a batch of small files uploaded as one pack
+type uploadTask struct {
+	localPath string
+	relPath   string
+	targets   []uploadTarget
+	size      int64
+	pack      []uploadTask
+}
+
+// uploadDirectory uploads every file below localDir to the prefixes. In walk
+// order the walk feeds a bounded channel, so uploads start while the walk is
+// still running and memory stays flat for huge trees. The other orders, key
+// normalization and dry runs need the complete file list first.
+func uploadDirectory(ctx context.Context, uploader *uploadManager, localDir string, prefixes []uploadTarget, run *uploadRun) error {
+	var existingKeys []map[string]struct{}
+	if excludeExisting {
+		stopEnumeration := report.track(phaseEnumeration)
+		s3Client, err := getS3Client(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get S3 client: %w", err)
+		}
+		for _, prefix := range prefixes {
+			keys, err := listS3KeySet(ctx, s3Client, prefix.bucket, prefix.key)
+			if err != nil {
+				return fmt.Errorf("failed to list existing objects: %w", err)
 			}
+			logVerbose("Found %d existing objects under prefix %s\n", len(keys), prefix.key)
+			existingKeys = append(existingKeys, keys)
+		}
+		stopEnumeration()
+	}
+
+	if dryRun {
+		var tasks []uploadTask
+		if err := walkUploadTasks(ctx, localDir, prefixes, existingKeys, run, func(task uploadTask) error {
+			tasks = append(tasks, task)
+			return nil
+		}); err != nil {
+			return err
+		}
+		printUploadMappings(tasks)
+		return nil
+	}
+
+	defer report.track(phaseTransfer)()
+	written := newWrittenPacks()
+	err := runWorkerPoolStream(ctx, maxWorkers, func(workerCtx context.Context, task uploadTask) error {
+		defer report.completeFile()
 
+		targets := task.targets
+		if task.pack != nil {
+			targets = prefixes
+		}
+		release, err := acquireTransferSlot(workerCtx, targetBuckets(targets)...)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		if task.pack != nil {
+			return uploadPack(workerCtx, uploader, task.pack, prefixes, written)
+		}
+		if err := uploadFile(workerCtx, uploader, task.localPath, task.targets); err != nil {
+			return run.skipTimedOut(workerCtx, fmt.Errorf("failed to upload %s: %w", task.localPath, err))
+		}
+		return nil
+	}, func(producerCtx context.Context, taskChan chan<- uploadTask) error {
+		send := func(task uploadTask) error {
 			select {
 			case <-producerCtx.Done():
 				return producerCtx.Err()
 			case taskChan <- task:
+				report.queueFile()
+				return nil
+			}
+		}
+
+		var batch packBatch
+		dispatch := func(task uploadTask) error {
+			if !packsFile(task.size) {
+				return send(task)
+			}
+			if batch.add(task) {
+				return send(batch.take())
+			}
+			return nil
+		}
+
+		var pending []uploadTask
+		walkErr := walkUploadTasks(producerCtx, localDir, prefixes, existingKeys, run, func(task uploadTask) error {
+			// with key normalization every key is checked for collisions
+			// before the first upload starts
+			if uploadOrder != "walk" || normalizesKeys() {
+				pending = append(pending, task)
 				return nil
 			}
+			return dispatch(task)
 		})
 
 		if errors.Is(walkErr, context.Canceled) {
 			return producerCtx.Err()
 		}
-		return walkErr
+		if walkErr != nil {
+			return walkErr
+		}
+
+		for _, task := range orderBySize(pending, uploadOrder, func(task uploadTask) int64 { return task.size }) {
+			if err := dispatch(task); err != nil {
+				return err
+			}
+		}
+		if len(batch.tasks) > 0 {
+			return send(batch.take())
+		}
+		return nil
 	})
+	if err != nil || !packFiles {
+		return err
+	}
+	return removeSupersededPacks(ctx, uploader.s3Client, prefixes, written)
 }
 
-func uploadFile(ctx context.Context, uploader *manager.Client, filePath, s3Key string) error {
-	return uploadFileWithParams(ctx, uploader, bucket, s3Key, filePath, true)
+// walkUploadTasks walks localDir, applies the ignore patterns, key
+// normalization and --exclude-existing, and calls emit for every file that
+// has to be uploaded
+func walkUploadTasks(ctx context.Context, localDir string, prefixes []uploadTarget, existingKeys []map[string]struct{}, run *uploadRun, emit func(uploadTask) error) error {
+	return walkLocalDir(localDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if info.IsDir() {
+			if shouldIgnoreFile(path) {
+				logInfo("Ignoring directory: %s\n", path)
+				run.ignored++
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if shouldIgnoreFile(path) {
+			logInfo("Ignoring file: %s\n", path)
+			run.ignored++
+			return nil
+		}
+
+		relPath, relErr := uploadRelPath(localDir, path)
+		if relErr != nil {
+			return relErr
+		}
+
+		targets := directoryFileTargets(prefixes, relPath, info.ModTime())
+		if err := run.claimTargets(targets, path); err != nil {
+			return err
+		}
+		if err := flattened.record(path, relPath, targets); err != nil {
+			return err
+		}
+		uploadedChecksums.record(path, "", targets)
+		run.included++
+
+		if existingKeys != nil {
+			var missing []uploadTarget
+			for i, target := range targets {
+				if _, exists := existingKeys[i][target.key]; !exists {
+					missing = append(missing, target)
+				}
+			}
+			if len(missing) == 0 {
+				logInfo("Skipping %s (key already exists on S3)\n", path)
+				return nil
+			}
+			targets = missing
+		}
+
+		return emit(uploadTask{
+			localPath: path,
+			relPath:   relPath,
+			targets:   targets,
+			size:      info.Size(),
+		})
+	})
 }
 
-func uploadFileWithParams(ctx context.Context, uploader *manager.Client, bucketName, s3Key, filePath string, checkSkipExisting bool) error {
+// printUploadMappings prints the local path to S3 key mapping of a dry-run
+// directory upload, sorted by local path so the output is deterministic
+func printUploadMappings(tasks []uploadTask) {
+	slices.SortFunc(tasks, func(a, b uploadTask) int {
+		return strings.Compare(a.localPath, b.localPath)
+	})
+
+	for _, task := range tasks {
+		for _, target := range task.targets {
+			if packsFile(task.size) {
+				logInfo("%s -> %s (packed)\n", task.localPath, target)
+				continue
+			}
+			logInfo("%s -> %s\n", task.localPath, target)
+		}
+	}
+	logInfo("Dry run: %d files would be uploaded\n", len(tasks))
+}
+
+func uploadFile(ctx context.Context, uploader *uploadManager, filePath string, targets []uploadTarget) error {
+	var err error
+	if len(targets) == 1 {
+		err = uploadFileWithParams(ctx, uploader, targets[0].bucket, targets[0].key, filePath, true)
+	} else {
+		var release func()
+		release, err = openFileSlots.acquire(ctx)
+		if err == nil {
+			err = runWithFileTimeout(ctx, filePath, func(fileCtx context.Context) error {
+				return performS3UploadToTargets(fileCtx, uploader, filePath, targets)
+			})
+			release()
+		}
+	}
+	if err != nil {
+		keys := make([]string, len(targets))
+		for i, target := range targets {
+			keys[i] = target.String()
+		}
+		recordFailure(operationUpload, filePath, strings.Join(keys, ", "), err)
+	}
+	return err
+}
+
+func uploadFileWithParams(ctx context.Context, uploader *uploadManager, bucketName, s3Key, filePath string, checkSkipExisting bool) error {
+	// the wait for a --max-open-files slot does not count against --per-file-timeout
+	release, err := openFileSlots.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runWithFileTimeout(ctx, filePath, func(fileCtx context.Context) error {
+		return performS3Upload(fileCtx, uploader, bucketName, s3Key, filePath, checkSkipExisting)
+	})
+}
+
+func performS3Upload(ctx context.Context, uploader *uploadManager, bucketName, s3Key, filePath string, checkSkipExisting bool) error {
 	if checkSkipExisting {
 		logInfo("Uploading %s to s3://%s/%s\n", filePath, bucketName, s3Key)
 	}
@@ -191,28 +772,21 @@ func uploadFileWithParams(ctx context.Context, uploader *manager.Client, bucketN
 		return nil
 	}
 
-	var localMD5 string
-	localMTime := ""
-	if !encrypt {
-		if md5Hash, err := calculateFileMD5(filePath); err == nil {
-			localMD5 = md5Hash
-		} else {
-			logVerbose("Warning: Could not calculate MD5 for %s: %v\n", filePath, err)
-		}
+	encryptFile := shouldEncryptFile(filePath)
+	if checkSkipExisting && skipSameSize && !encryptFile && sameSizeOnS3(ctx, bucketName, s3Key, filePath) {
+		logInfo("Skipping %s (object already exists on S3 with the same size)\n", filePath)
+		return nil
 	}
 
-	if fileInfo, statErr := os.Stat(filePath); statErr == nil {
-		localMTime = strconv.FormatInt(fileInfo.ModTime().Unix(), 10)
-	} else {
-		logVerbose("Warning: Could not stat %s for mtime metadata: %v\n", filePath, statErr)
-	}
+	localMD5, localMTime := localUploadMetadata(filePath)
+	uploadedChecksums.recordMD5(filePath, localMD5)
 
-	if checkSkipExisting && !forceOverwrite && !encrypt && localMD5 != "" {
+	if checkSkipExisting && !forceOverwrite && !encryptFile && !ifNoneMatch && localMD5 != "" {
 		s3Client, err := getS3Client(ctx)
 		if err != nil {
 			logVerbose("Warning: Could not get S3 client for checksum check: %v\n", err)
 		} else {
-			skip, err := compareFileChecksums(ctx, s3Client, bucketName, s3Key, localMD5)
+			skip, err := compareFileChecksums(ctx, s3Client, bucketName, s3Key, filePath, localMD5)
 			if err != nil {
 				logVerbose("Warning: %v\n", err)
 			} else if skip {
@@ -230,29 +804,46 @@ func uploadFileWithParams(ctx context.Context, uploader *manager.Client, bucketN
 
 	var reader io.Reader = file
 
-	if encrypt {
+	if encryptFile {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat file %s: %w", filePath, err)
+		}
+		secret, passwordID, err := uploadPassword(s3Key)
+		if err != nil {
+			return err
+		}
+		header, objectHMAC, err := prepareEncryption(file, secret)
+		if err != nil {
+			return err
+		}
+
 		pipeReader, pipeWriter := io.Pipe()
 		reader = pipeReader
 
 		errChan := make(chan error, 1)
 		go func() {
 			defer closeWithLog(pipeWriter, "pipe writer")
-			errChan <- encryptStream(pipeWriter, file)
+			errChan <- encryptWithHeader(pipeWriter, file, header, cryptoWorkers())
 		}()
 
 		putInput := &manager.UploadObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(s3Key),
-			Body:   reader,
-		}
-		if localMTime != "" {
-			putInput.Metadata = map[string]string{
-				"local-mtime": localMTime,
-			}
+			Bucket:   aws.String(bucketName),
+			Key:      aws.String(s3Key),
+			Body:     reader,
+			Metadata: withPasswordID(withHMAC(markEncrypted(withOriginalPath(uploadMetadata(localMD5, localMTime), filePath), info.Size()), objectHMAC), passwordID),
 		}
+		applyUploadOptions(putInput)
 
 		_, uploadErr := uploader.UploadObject(ctx, putInput)
 
+		if ifNoneMatch && isPreconditionFailed(uploadErr) {
+			_ = pipeReader.CloseWithError(uploadErr)
+			<-errChan
+			logInfo("Skipping %s (object already exists on S3)\n", filePath)
+			return nil
+		}
+
 		if uploadErr != nil {
 			_ = pipeReader.CloseWithError(uploadErr)
 			<-errChan
@@ -263,26 +854,460 @@ func uploadFileWithParams(ctx context.Context, uploader *manager.Client, bucketN
 		if encErr := <-errChan; encErr != nil {
 			return fmt.Errorf("encryption failed: %w", encErr)
 		}
+		report.addFile(filePath)
+		indexRecorder.record(bucketName, s3Key, filePath)
 	} else {
 		uploadInput := &manager.UploadObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(s3Key),
-			Body:   reader,
-		}
-		if localMD5 != "" || localMTime != "" {
-			uploadInput.Metadata = map[string]string{}
-			if localMD5 != "" {
-				uploadInput.Metadata["local-md5"] = localMD5
+			Bucket:   aws.String(bucketName),
+			Key:      aws.String(s3Key),
+			Body:     reader,
+			Metadata: withOriginalPath(uploadMetadata(localMD5, localMTime), filePath),
+		}
+		applyUploadOptions(uploadInput)
+
+		if fileInfo, statErr := file.Stat(); statErr == nil && shouldResumeUpload(fileInfo.Size()) {
+			err = uploadResumable(ctx, uploader, file, fileInfo.Size(), uploadInput)
+		} else {
+			_, err = uploader.UploadObject(ctx, uploadInput)
+		}
+		if ifNoneMatch && isPreconditionFailed(err) {
+			logInfo("Skipping %s (object already exists on S3)\n", filePath)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if spotCheck > 0 {
+			if err := spotCheckObject(ctx, bucketName, s3Key, filePath); err != nil {
+				return err
+			}
+		}
+		report.addFile(filePath)
+	}
+
+	return nil
+}
+
+// localUploadMetadata returns the MD5 (skipped when the file is encrypted)
+// and the modification time of a local file for the upload metadata
+func localUploadMetadata(filePath string) (localMD5, localMTime string) {
+	if !shouldEncryptFile(filePath) {
+		if md5Hash, err := calculateFileMD5(filePath); err == nil {
+			localMD5 = md5Hash
+		} else {
+			logVerbose("Warning: Could not calculate MD5 for %s: %v\n", filePath, err)
+		}
+	}
+
+	if fileInfo, statErr := os.Stat(filePath); statErr == nil {
+		localMTime = strconv.FormatInt(fileInfo.ModTime().Unix(), 10)
+	} else {
+		logVerbose("Warning: Could not stat %s for mtime metadata: %v\n", filePath, statErr)
+	}
+
+	return localMD5, localMTime
+}
+
+// uploadMetadata builds the object metadata stored with an upload
+func uploadMetadata(localMD5, localMTime string) map[string]string {
+	if localMD5 == "" && localMTime == "" {
+		return nil
+	}
+
+	metadata := map[string]string{}
+	if localMD5 != "" {
+		metadata["local-md5"] = localMD5
+	}
+	if localMTime != "" {
+		metadata["local-mtime"] = localMTime
+	}
+	return metadata
+}
+
+// withHMAC adds the ciphertext HMAC to the upload metadata when one was computed
+func withHMAC(metadata map[string]string, objectHMAC string) map[string]string {
+	if objectHMAC == "" {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[hmacMetadataKey] = objectHMAC
+	return metadata
+}
+
+// applyUploadOptions sets the optional object headers configured by flags on an upload input
+func applyUploadOptions(input *manager.UploadObjectInput) {
+	if !expiresAt.IsZero() {
+		input.Expires = aws.Time(expiresAt)
+	}
+	if expireTag != "" {
+		tagKey, tagValue, _ := strings.Cut(expireTag, "=")
+		input.Tagging = aws.String(url.Values{tagKey: []string{tagValue}}.Encode())
+	}
+	if grantFullControlHeader != "" {
+		input.GrantFullControl = aws.String(grantFullControlHeader)
+	}
+	if grantReadHeader != "" {
+		input.GrantRead = aws.String(grantReadHeader)
+	}
+	if wantType := contentTypeFor(aws.ToString(input.Key)); wantType != "" {
+		input.ContentType = aws.String(wantType)
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if contentLanguage != "" {
+		input.ContentLanguage = aws.String(contentLanguage)
+	}
+	if contentDisposition != "" {
+		input.ContentDisposition = aws.String(contentDisposition)
+	}
+	if runID != "" {
+		if input.Metadata == nil {
+			input.Metadata = map[string]string{}
+		}
+		input.Metadata[runIDMetadataKey] = runID
+	}
+}
+
+// contentTypeFor returns the Content-Type configured with --content-type for
+// an object key. With auto the type is derived from the key's extension, and
+// keys with an unknown extension get no explicit type.
+func contentTypeFor(key string) string {
+	if contentType != "auto" {
+		return contentType
+	}
+	return mime.TypeByExtension(path.Ext(key))
+}
+
+// parseContentLanguage checks the comma-separated language tags of
+// --content-language against the basic BCP 47 syntax: a primary language of
+// 2 to 8 letters, or x or i for private and grandfathered tags, followed by
+// subtags of 1 to 8 letters and digits. The tags are returned in the format
+// of the Content-Language header.
+func parseContentLanguage(value string) (string, error) {
+	var tags []string
+	for tag := range strings.SplitSeq(value, ",") {
+		tag = strings.TrimSpace(tag)
+		subtags := strings.Split(tag, "-")
+		primary := strings.ToLower(subtags[0])
+		valid := (len(primary) >= 2 && len(primary) <= 8 && isAlphanumeric(primary, false)) ||
+			((primary == "x" || primary == "i") && len(subtags) > 1)
+		for _, subtag := range subtags[1:] {
+			valid = valid && len(subtag) >= 1 && len(subtag) <= 8 && isAlphanumeric(subtag, true)
+		}
+		if !valid {
+			return "", fmt.Errorf("%q is not a valid language tag", tag)
+		}
+		tags = append(tags, tag)
+	}
+	return strings.Join(tags, ", "), nil
+}
+
+// isAlphanumeric reports whether value consists of ASCII letters, and with
+// digits also of ASCII digits
+func isAlphanumeric(value string, digits bool) bool {
+	for _, r := range value {
+		isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !isLetter && !(digits && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateContentDisposition checks that --content-disposition starts with
+// the inline or attachment disposition type, optionally followed by
+// parameters such as filename
+func validateContentDisposition(value string) error {
+	dispositionType, _, _ := strings.Cut(value, ";")
+	switch strings.ToLower(strings.TrimSpace(dispositionType)) {
+	case "inline", "attachment":
+		return nil
+	}
+	return fmt.Errorf("%q must start with inline or attachment", value)
+}
+
+// runIDMetadataKey is the user metadata entry that holds --run-id
+const runIDMetadataKey = "run-id"
+
+// validateRunID checks that --run-id can be sent as a metadata header and
+// matched with --if-metadata: up to 128 letters, digits and . _ : -
+func validateRunID(value string) error {
+	if len(value) > 128 {
+		return fmt.Errorf("%q is longer than 128 characters", value)
+	}
+	for _, r := range value {
+		if !isAlphanumeric(string(r), true) && !strings.ContainsRune("._:-", r) {
+			return fmt.Errorf("%q may only contain letters, digits and . _ : -", value)
+		}
+	}
+	return nil
+}
+
+// parseGrantees converts a comma-separated list of grantees such as
+// "id=<canonical user id>,uri=<group uri>" into the x-amz-grant-* header
+// format id="...", uri="..."
+func parseGrantees(value string) (string, error) {
+	var grantees []string
+	for grantee := range strings.SplitSeq(value, ",") {
+		grantee = strings.TrimSpace(grantee)
+		if grantee == "" {
+			continue
+		}
+
+		granteeType, granteeValue, found := strings.Cut(grantee, "=")
+		granteeValue = strings.Trim(strings.TrimSpace(granteeValue), "\"")
+		if !found || granteeValue == "" {
+			return "", fmt.Errorf("invalid grantee %q, use id=<canonical user id>, uri=<group uri> or emailAddress=<email>", grantee)
+		}
+
+		switch granteeType = strings.TrimSpace(granteeType); granteeType {
+		case "id", "emailAddress":
+		case "uri":
+			if !strings.HasPrefix(granteeValue, "http://acs.amazonaws.com/groups/") {
+				return "", fmt.Errorf("invalid grantee uri %q, expected a predefined group such as http://acs.amazonaws.com/groups/global/AllUsers", granteeValue)
 			}
-			if localMTime != "" {
-				uploadInput.Metadata["local-mtime"] = localMTime
+		default:
+			return "", fmt.Errorf("invalid grantee type %q, use id, uri or emailAddress", granteeType)
+		}
+
+		grantees = append(grantees, fmt.Sprintf("%s=\"%s\"", granteeType, granteeValue))
+	}
+
+	if len(grantees) == 0 {
+		return "", fmt.Errorf("no grantees given")
+	}
+	return strings.Join(grantees, ", "), nil
+}
+
+// parseExpires parses an --expires value, either an RFC 3339 timestamp or a
+// duration relative to now such as "72h" or "7d"
+func parseExpires(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("expiration %s is in the past", value)
+		}
+		return t, nil
+	}
+
+	duration, err := parseDayDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration %q, use an RFC 3339 timestamp or a duration like 72h or 7d", value)
+	}
+
+	if duration <= 0 {
+		return time.Time{}, fmt.Errorf("expiration duration must be positive")
+	}
+	return now.Add(duration).UTC().Truncate(time.Second), nil
+}
+
+var errAllDestinationsFailed = errors.New("all destinations failed")
+
+// fanOutWriter writes to several writers and drops a writer once it fails, so
+// one failing destination does not stop the others. It only returns an error
+// when every writer has failed.
+type fanOutWriter struct {
+	writers []io.Writer
+	failed  []bool
+}
+
+func newFanOutWriter(writers ...io.Writer) *fanOutWriter {
+	return &fanOutWriter{writers: writers, failed: make([]bool, len(writers))}
+}
+
+func (f *fanOutWriter) Write(p []byte) (int, error) {
+	live := 0
+	for i, writer := range f.writers {
+		if f.failed[i] {
+			continue
+		}
+		if _, err := writer.Write(p); err != nil {
+			f.failed[i] = true
+			continue
+		}
+		live++
+	}
+
+	if live == 0 {
+		return 0, errAllDestinationsFailed
+	}
+	return len(p), nil
+}
+
+// performS3UploadToTargets reads a local file once and streams it to several
+// destinations in parallel. With encryption the file is encrypted once and
+// the ciphertext is shared. Failures are reported per destination.
+func performS3UploadToTargets(ctx context.Context, uploader *uploadManager, filePath string, targets []uploadTarget) error {
+	for _, target := range targets {
+		logInfo("Uploading %s to %s\n", filePath, target)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	encryptFile := shouldEncryptFile(filePath)
+	if skipSameSize && !encryptFile {
+		var changed []uploadTarget
+		for _, target := range targets {
+			if sameSizeOnS3(ctx, target.bucket, target.key, filePath) {
+				logInfo("Skipping %s for %s (object already exists on S3 with the same size)\n", filePath, target)
+				continue
+			}
+			changed = append(changed, target)
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		targets = changed
+	}
+
+	localMD5, localMTime := localUploadMetadata(filePath)
+	uploadedChecksums.recordMD5(filePath, localMD5)
+
+	if !forceOverwrite && !encryptFile && !ifNoneMatch && localMD5 != "" {
+		s3Client, err := getS3Client(ctx)
+		if err != nil {
+			logVerbose("Warning: Could not get S3 client for checksum check: %v\n", err)
+		} else {
+			var changed []uploadTarget
+			for _, target := range targets {
+				skip, err := compareFileChecksums(ctx, s3Client, target.bucket, target.key, filePath, localMD5)
+				if err != nil {
+					logVerbose("Warning: %v\n", err)
+				} else if skip {
+					logInfo("Skipping %s for %s (file already exists on S3 with same checksum)\n", filePath, target)
+					continue
+				}
+				changed = append(changed, target)
 			}
+			targets = changed
 		}
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer closeWithLog(file, filePath)
 
-		_, err = uploader.UploadObject(ctx, uploadInput)
+	var reader io.Reader = file
+	var encReader *io.PipeReader
+	var objectHMAC string
+	encErrChan := make(chan error, 1)
+	metadata := withOriginalPath(uploadMetadata(localMD5, localMTime), filePath)
+	if encryptFile {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat file %s: %w", filePath, err)
+		}
+		// all targets share one ciphertext, so the first key picks the password
+		secret, passwordID, err := uploadPassword(targets[0].key)
+		if err != nil {
+			return err
+		}
+		var header *encryptionHeader
+		header, objectHMAC, err = prepareEncryption(file, secret)
 		if err != nil {
 			return err
 		}
+		metadata = withPasswordID(withHMAC(markEncrypted(metadata, info.Size()), objectHMAC), passwordID)
+
+		var encWriter *io.PipeWriter
+		encReader, encWriter = io.Pipe()
+		reader = encReader
+		go func() {
+			defer closeWithLog(encWriter, "pipe writer")
+			encErrChan <- encryptWithHeader(encWriter, file, header, cryptoWorkers())
+		}()
+	}
+
+	uploadErrs := make([]error, len(targets))
+	pipeWriters := make([]io.Writer, len(targets))
+	closers := make([]*io.PipeWriter, len(targets))
+	// the file is counted once, however many destinations it was written to
+	var uploaded atomic.Bool
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		pipeReader, pipeWriter := io.Pipe()
+		pipeWriters[i] = pipeWriter
+		closers[i] = pipeWriter
+
+		wg.Go(func() {
+			// applyUploadOptions adds to the metadata, so every target
+			// gets its own copy
+			uploadInput := &manager.UploadObjectInput{
+				Bucket:   aws.String(target.bucket),
+				Key:      aws.String(target.key),
+				Body:     pipeReader,
+				Metadata: maps.Clone(metadata),
+			}
+			applyUploadOptions(uploadInput)
+
+			_, uploadErr := uploader.UploadObject(ctx, uploadInput)
+			if ifNoneMatch && isPreconditionFailed(uploadErr) {
+				_ = pipeReader.CloseWithError(uploadErr)
+				logInfo("Skipping %s for %s (object already exists on S3)\n", filePath, target)
+				return
+			}
+			if uploadErr != nil {
+				_ = pipeReader.CloseWithError(uploadErr)
+				uploadErrs[i] = uploadErr
+				return
+			}
+			closeWithLog(pipeReader, "pipe reader")
+			uploaded.Store(true)
+			if encryptFile {
+				indexRecorder.record(target.bucket, target.key, filePath)
+			}
+		})
+	}
+
+	_, copyErr := io.Copy(newFanOutWriter(pipeWriters...), reader)
+	for _, pipeWriter := range closers {
+		if copyErr != nil {
+			_ = pipeWriter.CloseWithError(copyErr)
+		} else {
+			closeWithLog(pipeWriter, "pipe writer")
+		}
+	}
+	wg.Wait()
+
+	var encErr error
+	if encryptFile {
+		_ = encReader.CloseWithError(copyErr)
+		encErr = <-encErrChan
+	}
+
+	var failures []error
+	for i, uploadErr := range uploadErrs {
+		if uploadErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to upload %s to %s: %v\n", filePath, targets[i], uploadErr)
+			failures = append(failures, fmt.Errorf("%s: %w", targets[i], uploadErr))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("upload failed for %d of %d destinations: %w", len(failures), len(targets), errors.Join(failures...))
+	}
+	if errors.Is(copyErr, errAllDestinationsFailed) {
+		// every destination was skipped because the object already exists
+		return nil
+	}
+	if copyErr != nil {
+		return fmt.Errorf("failed to read %s: %w", filePath, copyErr)
+	}
+	if encErr != nil {
+		return fmt.Errorf("encryption failed: %w", encErr)
+	}
+	if uploaded.Load() {
+		report.addFile(filePath)
 	}
 
 	return nil
@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	mtypes "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -207,6 +211,39 @@ func TestUploadDirectoryWithIgnore(t *testing.T) {
 	})
 }
 
+func TestUploadDirectoryWithNegatedIgnore(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-negated-ignore-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "debug.log"), []byte("debug"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "important.log"), []byte("important"), 0644))
+
+	setTestConfig(tempDir, fmt.Sprintf("s3://%s/logs/", bucketName), bucketName, false, true, true, false)
+	ignorePatterns = "*.log,!important.log"
+	require.NoError(t, initializeIgnoreMatcher())
+
+	require.NoError(t, uploadToS3(ctx))
+
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("logs/important.log"),
+	})
+	assert.NoError(t, err)
+
+	_, err = s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("logs/debug.log"),
+	})
+	assert.Error(t, err)
+}
+
 func TestUploadFileWithParams(t *testing.T) {
 	ctx := context.Background()
 	bucketName := "test-upload-params-bucket"
@@ -221,7 +258,7 @@ func TestUploadFileWithParams(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("upload with checkSkipExisting false", func(t *testing.T) {
-		uploader := manager.New(s3Client)
+		uploader := newUploader(s3Client)
 		s3Key := "params-test-file.txt"
 
 		err := uploadFileWithParams(ctx, uploader, bucketName, s3Key, testFile, false)
@@ -280,3 +317,426 @@ func TestUploadDirectoryNested(t *testing.T) {
 		}
 	})
 }
+
+func TestUploadDirectoryExcludeExisting(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-exclude-existing-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	tempDir := t.TempDir()
+	for _, name := range []string{"existing.txt", "new.txt"} {
+		err := os.WriteFile(filepath.Join(tempDir, name), []byte("local content"), 0644)
+		require.NoError(t, err)
+	}
+
+	remoteContent := []byte("remote content")
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("exclude/existing.txt"),
+		Body:   bytes.NewReader(remoteContent),
+	})
+	require.NoError(t, err)
+
+	t.Run("skips keys that already exist regardless of content", func(t *testing.T) {
+		setTestConfig(tempDir, fmt.Sprintf("s3://%s/exclude/", bucketName), bucketName, false, true, true, false)
+		excludeExisting = true
+		defer func() { excludeExisting = false }()
+
+		err := uploadToS3(ctx)
+		assert.NoError(t, err)
+
+		obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("exclude/existing.txt"),
+		})
+		require.NoError(t, err)
+		defer closeWithLog(obj.Body, "response body")
+
+		buf := new(bytes.Buffer)
+		_, err = buf.ReadFrom(obj.Body)
+		require.NoError(t, err)
+		assert.Equal(t, remoteContent, buf.Bytes())
+
+		_, err = s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("exclude/new.txt"),
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestUploadDirectoryLowercaseKeys(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-lowercase-keys-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	tempDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(tempDir, "SubDir"), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "README.md"), []byte("readme"), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "SubDir", "Photo.JPG"), []byte("photo"), 0644)
+	require.NoError(t, err)
+
+	t.Run("upload directory with lowercased keys", func(t *testing.T) {
+		setTestConfig(tempDir, fmt.Sprintf("s3://%s/Mixed/", bucketName), bucketName, false, true, true, false)
+		lowercaseKeys = true
+		defer func() { lowercaseKeys = false }()
+
+		err := uploadToS3(ctx)
+		assert.NoError(t, err)
+
+		for _, key := range []string{"mixed/readme.md", "mixed/subdir/photo.jpg"} {
+			_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(bucketName),
+				Key:    aws.String(key),
+			})
+			assert.NoError(t, err, "File %s should exist in S3", key)
+		}
+	})
+}
+
+func TestUploadWithMultipartThreshold(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-multipart-threshold-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "large.bin")
+	err := os.WriteFile(testFile, bytes.Repeat([]byte("a"), 20*1024*1024), 0644)
+	require.NoError(t, err)
+
+	localMD5, err := calculateFileMD5(testFile)
+	require.NoError(t, err)
+
+	t.Run("file below threshold keeps MD5 ETag", func(t *testing.T) {
+		setTestConfig(testFile, fmt.Sprintf("s3://%s/single-part.bin", bucketName), bucketName, false, false, true, false)
+		multipartThresholdBytes = 64 * 1024 * 1024
+		defer func() { multipartThresholdBytes = 0 }()
+
+		err := uploadToS3(ctx)
+		require.NoError(t, err)
+
+		exists, etag, _, err := checkS3ObjectExists(ctx, s3Client, bucketName, "single-part.bin")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, localMD5, etag)
+	})
+}
+
+func TestUploadWithChunkedChecksum(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-checksum-chunked-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	testFile := filepath.Join(t.TempDir(), "large.bin")
+	require.NoError(t, os.WriteFile(testFile, bytes.Repeat([]byte("b"), 12*1024*1024), 0644))
+
+	setTestConfig(testFile, fmt.Sprintf("s3://%s/checksummed.bin", bucketName), bucketName, false, false, true, false)
+	multipartThresholdBytes = 5 * 1024 * 1024
+	uploadChecksumAlgorithm = mtypes.ChecksumAlgorithmSha256
+
+	require.NoError(t, uploadToS3(ctx))
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String("checksummed.bin"),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, aws.ToString(head.ChecksumSHA256))
+	assert.Empty(t, aws.ToString(head.ChecksumCRC32))
+}
+
+func TestUploadToMultipleDestinations(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-fanout-primary-bucket"
+	secondBucket := "test-fanout-secondary-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	_, err := s3Client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(secondBucket),
+	})
+	require.NoError(t, err)
+
+	tempDir := t.TempDir()
+	testContent := bytes.Repeat([]byte("fan-out content "), 1024)
+	err = os.WriteFile(filepath.Join(tempDir, "a.txt"), testContent, 0644)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(tempDir, "sub"), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "sub", "b.txt"), testContent, 0644)
+	require.NoError(t, err)
+
+	readObject := func(bucketName, key string) []byte {
+		obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		})
+		require.NoError(t, err)
+		defer closeWithLog(obj.Body, "response body")
+
+		buf := new(bytes.Buffer)
+		_, err = buf.ReadFrom(obj.Body)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	t.Run("directory to two buckets", func(t *testing.T) {
+		setTestConfig(tempDir, fmt.Sprintf("s3://%s/primary/", bucketName), "", false, true, true, false)
+		destinations = []string{
+			fmt.Sprintf("s3://%s/primary/", bucketName),
+			fmt.Sprintf("s3://%s/secondary/", secondBucket),
+		}
+		defer func() { destinations = nil }()
+
+		err := uploadToS3(ctx)
+		require.NoError(t, err)
+
+		assert.Equal(t, testContent, readObject(bucketName, "primary/a.txt"))
+		assert.Equal(t, testContent, readObject(bucketName, "primary/sub/b.txt"))
+		assert.Equal(t, testContent, readObject(secondBucket, "secondary/a.txt"))
+		assert.Equal(t, testContent, readObject(secondBucket, "secondary/sub/b.txt"))
+	})
+
+	t.Run("encrypted file to two buckets", func(t *testing.T) {
+		password = "fan-out-password"
+		sourceFile := filepath.Join(tempDir, "a.txt")
+		setTestConfig(sourceFile, fmt.Sprintf("s3://%s/enc/a.txt", bucketName), "", true, false, true, false)
+		destinations = []string{
+			fmt.Sprintf("s3://%s/enc/a.txt", bucketName),
+			fmt.Sprintf("s3://%s/enc/a.txt", secondBucket),
+		}
+		defer func() { destinations = nil }()
+
+		err := uploadToS3(ctx)
+		require.NoError(t, err)
+
+		primary := readObject(bucketName, "enc/a.txt")
+		assert.Equal(t, primary, readObject(secondBucket, "enc/a.txt"))
+
+		var decrypted bytes.Buffer
+		require.NoError(t, decryptStreamFromReader(&decrypted, bytes.NewReader(primary)))
+		assert.Equal(t, testContent, decrypted.Bytes())
+	})
+
+	t.Run("failure is reported per destination", func(t *testing.T) {
+		sourceFile := filepath.Join(tempDir, "a.txt")
+		setTestConfig(sourceFile, fmt.Sprintf("s3://%s/partial/a.txt", bucketName), "", false, false, true, false)
+		forceOverwrite = true
+		destinations = []string{
+			fmt.Sprintf("s3://%s/partial/a.txt", bucketName),
+			"s3://missing-fanout-bucket/partial/a.txt",
+		}
+		defer func() { destinations = nil }()
+
+		err := uploadToS3(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 of 2 destinations")
+		assert.Contains(t, err.Error(), "s3://missing-fanout-bucket/partial/a.txt")
+
+		assert.Equal(t, testContent, readObject(bucketName, "partial/a.txt"))
+	})
+}
+
+func TestUploadWithExpires(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-expires-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "scratch.txt")
+	err := os.WriteFile(testFile, []byte("scratch data"), 0644)
+	require.NoError(t, err)
+
+	setTestConfig(testFile, fmt.Sprintf("s3://%s/scratch.txt", bucketName), bucketName, false, false, false, false)
+	expiresAt = time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	expireTag = "lifecycle=scratch"
+
+	err = uploadToS3(ctx)
+	require.NoError(t, err)
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("scratch.txt"),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, head.ExpiresString)
+	parsed, err := http.ParseTime(*head.ExpiresString)
+	require.NoError(t, err)
+	assert.True(t, expiresAt.Equal(parsed), "expected %v, got %v", expiresAt, parsed)
+
+	tagging, err := s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("scratch.txt"),
+	})
+	require.NoError(t, err)
+	require.Len(t, tagging.TagSet, 1)
+	assert.Equal(t, "lifecycle", aws.ToString(tagging.TagSet[0].Key))
+	assert.Equal(t, "scratch", aws.ToString(tagging.TagSet[0].Value))
+}
+
+func TestUploadWithIfNoneMatch(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-if-none-match-bucket"
+
+	s3Client, cleanup := setupMinIOTest(t, ctx, bucketName)
+	defer cleanup()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "once.txt")
+	require.NoError(t, os.WriteFile(testFile, []byte("first version"), 0644))
+
+	readObject := func() []byte {
+		result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("once.txt"),
+		})
+		require.NoError(t, err)
+		defer closeWithLog(result.Body, "once.txt")
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(result.Body)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	setTestConfig(testFile, fmt.Sprintf("s3://%s/once.txt", bucketName), bucketName, false, false, true, false)
+	ifNoneMatch = true
+
+	require.NoError(t, uploadToS3(ctx))
+	assert.Equal(t, []byte("first version"), readObject())
+
+	require.NoError(t, os.WriteFile(testFile, []byte("second version"), 0644))
+	require.NoError(t, uploadToS3(ctx), "existing key should be skipped, not fail")
+	assert.Equal(t, []byte("first version"), readObject())
+}
+
+func TestUploadDirectoryStreamsWalk(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	localDir := t.TempDir()
+	for i := range 50 {
+		dir := filepath.Join(localDir, fmt.Sprintf("d%02d", i))
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644))
+	}
+	lateDir := filepath.Join(localDir, "zz")
+	require.NoError(t, os.MkdirAll(lateDir, 0755))
+
+	// the first PUT is held until release is closed
+	fake := newFakeS3(nil)
+	firstPut := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			once.Do(func() {
+				close(firstPut)
+				<-release
+			})
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	setTestConfig(localDir, "s3://stream-bucket/tree/", "", false, true, true, false)
+	maxWorkers = 1
+
+	ctx := context.Background()
+	s3Client, err := getS3Client(ctx)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- uploadDirectory(ctx, newUploader(s3Client), localDir, []uploadTarget{{bucket: "stream-bucket", key: "tree/"}}, newUploadRun())
+	}()
+
+	select {
+	case <-firstPut:
+	case <-time.After(10 * time.Second):
+		t.Fatal("first upload did not start")
+	}
+
+	// The walk is blocked on the bounded task channel while the first upload
+	// is in flight, so a file created now in the last directory is still found
+	require.NoError(t, os.WriteFile(filepath.Join(lateDir, "late.txt"), []byte("late"), 0644))
+	close(release)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("upload did not finish")
+	}
+
+	assert.Len(t, fake.contents(), 101)
+	assert.Contains(t, fake.contents(), "stream-bucket/tree/zz/late.txt")
+}
+
+func TestUploadAssumeExistsOn403(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	localFile := filepath.Join(t.TempDir(), "report.csv")
+	require.NoError(t, os.WriteFile(localFile, []byte("a,b\n"), 0644))
+
+	// the bucket policy denies HeadObject but allows PutObject
+	upload := func(t *testing.T, configure func()) (*fakeS3, string, error) {
+		fake := newFakeS3(nil)
+		fake.denied = map[string]bool{"HeadObject": true}
+		useFakeS3(t, fake)
+
+		setTestConfig(localFile, "s3://locked-bucket/reports/", "", false, false, false, true)
+		configure()
+
+		var err error
+		output := captureStdout(func() {
+			err = uploadToS3(context.Background())
+		})
+		return fake, output, err
+	}
+
+	t.Run("403 is a warning by default", func(t *testing.T) {
+		fake, output, err := upload(t, func() {})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"locked-bucket/reports/report.csv"}, fake.paths("PutObject"))
+		assert.Contains(t, output, "Warning: could not check S3 object")
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		fake, output, err := upload(t, func() { assumeExistsOn403 = true })
+		require.NoError(t, err)
+		assert.Empty(t, fake.paths("PutObject"))
+		assert.Contains(t, output, "Skipping reports/report.csv (existence check denied with 403, assuming it exists)")
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		fake, output, err := upload(t, func() {
+			assumeExistsOn403 = true
+			assumeExistsAction = "upload"
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"locked-bucket/reports/report.csv"}, fake.paths("PutObject"))
+		assert.Contains(t, output, "uploading without comparing")
+		assert.NotContains(t, output, "Warning")
+	})
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	manager "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	mtypes "github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -130,3 +139,691 @@ func TestUploadToS3MultipleFiles(t *testing.T) {
 		}
 	})
 }
+
+func TestUploadRunNormalizeKey(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("paths unchanged without lowercase-keys", func(t *testing.T) {
+		lowercaseKeys = false
+		assert.Equal(t, "Sub/File.txt", normalizeKeyPath("Sub/File.txt"))
+		assert.NoError(t, newUploadRun().claimTargets([]uploadTarget{{bucket: "b", key: "File.txt"}}, "/tmp/File.txt"))
+	})
+
+	t.Run("paths are lowercased", func(t *testing.T) {
+		lowercaseKeys = true
+		assert.Equal(t, "sub/file.txt", normalizeKeyPath("Sub/File.TXT"))
+	})
+
+	t.Run("collision is detected", func(t *testing.T) {
+		lowercaseKeys = true
+		run := newUploadRun()
+
+		require.NoError(t, run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/file.txt"}}, "/tmp/File.txt"))
+		err := run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/file.txt"}}, "/tmp/file.txt")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "key collision")
+		assert.Contains(t, err.Error(), "s3://b/docs/file.txt")
+	})
+
+	t.Run("same key in another bucket is not a collision", func(t *testing.T) {
+		lowercaseKeys = true
+		run := newUploadRun()
+
+		require.NoError(t, run.claimTargets([]uploadTarget{{bucket: "a", key: "docs/file.txt"}}, "/tmp/File.txt"))
+		assert.NoError(t, run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/file.txt"}}, "/tmp/file.txt"))
+	})
+
+	t.Run("same local file is not a collision", func(t *testing.T) {
+		lowercaseKeys = true
+		run := newUploadRun()
+
+		require.NoError(t, run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/file.txt"}}, "/tmp/File.txt"))
+		assert.NoError(t, run.claimTargets([]uploadTarget{{bucket: "b", key: "docs/file.txt"}}, "/tmp/File.txt"))
+	})
+}
+
+func TestUploadLowercaseKeys(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	setup := func(t *testing.T, src, dst string) *fakeS3 {
+		fake := newFakeS3(nil)
+		useFakeS3(t, fake)
+		setTestConfig(src, dst, "", false, true, true, false)
+		lowercaseKeys = true
+		return fake
+	}
+
+	t.Run("only the computed part is lowercased", func(t *testing.T) {
+		srcDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "Sub"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "Sub", "File.TXT"), []byte("content"), 0644))
+
+		fake := setup(t, srcDir, "s3://bucket/Data/Mixed/")
+		require.NoError(t, uploadToS3(context.Background()))
+		assert.Equal(t, map[string]string{"bucket/Data/Mixed/sub/file.txt": "content"}, fake.contents())
+	})
+
+	t.Run("collision fails before the first upload", func(t *testing.T) {
+		srcDir := t.TempDir()
+		for _, name := range []string{"File.txt", "file.txt"} {
+			require.NoError(t, os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644))
+		}
+		// these sort between the colliding names, so a streaming walk would
+		// upload them before it reaches the collision
+		for i := range 50 {
+			name := fmt.Sprintf("between-%02d.txt", i)
+			require.NoError(t, os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644))
+		}
+
+		fake := setup(t, srcDir, "s3://bucket/docs/")
+		err := uploadToS3(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "key collision")
+		assert.Zero(t, fake.count("PutObject"))
+	})
+
+	t.Run("collision across glob matches fails before the first upload", func(t *testing.T) {
+		srcDir := t.TempDir()
+		for _, dir := range []string{"A", "a"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "in", dir), 0755))
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "in", "A", "x.txt"), []byte("upper"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "in", "a", "x.txt"), []byte("lower"), 0644))
+
+		fake := setup(t, filepath.Join(srcDir, "in", "*"), "s3://bucket/docs/")
+		err := uploadToS3(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "key collision")
+		assert.Zero(t, fake.count("PutObject"))
+	})
+}
+
+func TestUploadToS3AllIgnored(t *testing.T) {
+	ctx := context.Background()
+
+	restore := preserveGlobalVars()
+	defer restore()
+
+	config = Config{
+		AccessKey: "dummy",
+		SecretKey: "dummy",
+		Region:    "us-east-1",
+	}
+	resetS3Client()
+	defer resetS3Client()
+
+	tempDir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log"} {
+		err := os.WriteFile(filepath.Join(tempDir, name), []byte("log"), 0644)
+		require.NoError(t, err)
+	}
+
+	t.Run("glob with all matches ignored warns", func(t *testing.T) {
+		setTestConfig(filepath.Join(tempDir, "*.log"), "s3://bucket/logs/", "bucket", false, false, true, false)
+		ignorePatterns = "*.log"
+		require.NoError(t, initializeIgnoreMatcher())
+
+		err := uploadToS3(ctx)
+		assert.NoError(t, err)
+	})
+
+	t.Run("glob with all matches ignored fails in strict mode", func(t *testing.T) {
+		setTestConfig(filepath.Join(tempDir, "*.log"), "s3://bucket/logs/", "bucket", false, false, true, false)
+		ignorePatterns = "*.log"
+		require.NoError(t, initializeIgnoreMatcher())
+		strict = true
+
+		err := uploadToS3(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "all matched files were ignored by patterns")
+	})
+
+	t.Run("directory with all files ignored fails in strict mode", func(t *testing.T) {
+		setTestConfig(tempDir, "s3://bucket/logs/", "bucket", false, true, true, false)
+		ignorePatterns = "*.log"
+		require.NoError(t, initializeIgnoreMatcher())
+		strict = true
+
+		err := uploadToS3(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "all matched files were ignored by patterns")
+	})
+
+	t.Run("empty directory is not reported", func(t *testing.T) {
+		setTestConfig(t.TempDir(), "s3://bucket/empty/", "bucket", false, true, true, false)
+		ignorePatterns = "*.log"
+		require.NoError(t, initializeIgnoreMatcher())
+		strict = true
+
+		err := uploadToS3(ctx)
+		assert.NoError(t, err)
+	})
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestFanOutWriter(t *testing.T) {
+	t.Run("writes to all writers", func(t *testing.T) {
+		var a, b bytes.Buffer
+		writer := newFanOutWriter(&a, &b)
+
+		n, err := writer.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Equal(t, "hello", a.String())
+		assert.Equal(t, "hello", b.String())
+	})
+
+	t.Run("failing writer is dropped", func(t *testing.T) {
+		var a bytes.Buffer
+		writer := newFanOutWriter(errWriter{}, &a)
+
+		_, err := writer.Write([]byte("one"))
+		assert.NoError(t, err)
+		_, err = writer.Write([]byte("two"))
+		assert.NoError(t, err)
+		assert.Equal(t, "onetwo", a.String())
+		assert.Equal(t, []bool{true, false}, writer.failed)
+	})
+
+	t.Run("error when all writers failed", func(t *testing.T) {
+		writer := newFanOutWriter(errWriter{}, errWriter{})
+
+		_, err := writer.Write([]byte("data"))
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveUploadTargets(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("single destination", func(t *testing.T) {
+		setTestConfig("file.txt", "s3://bucket-a/docs/", "", false, false, true, false)
+
+		targets, err := resolveUploadTargets(false, "file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []uploadTarget{{bucket: "bucket-a", key: "docs/file.txt"}}, targets)
+		assert.Equal(t, "bucket-a", bucket)
+	})
+
+	t.Run("multiple destinations", func(t *testing.T) {
+		setTestConfig("dir", "s3://bucket-a/backup", "", false, true, true, false)
+		destinations = []string{"s3://bucket-a/backup", "s3://bucket-b/mirror/"}
+
+		targets, err := resolveUploadTargets(true, "dir")
+		require.NoError(t, err)
+		assert.Equal(t, []uploadTarget{
+			{bucket: "bucket-a", key: "backup"},
+			{bucket: "bucket-b", key: "mirror/"},
+		}, targets)
+
+		joined := joinUploadTargets(targets, "sub/file.txt")
+		assert.Equal(t, "s3://bucket-a/backup/sub/file.txt", joined[0].String())
+		assert.Equal(t, "s3://bucket-b/mirror/sub/file.txt", joined[1].String())
+	})
+}
+
+func TestParseExpires(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"rfc3339 timestamp", "2025-07-01T00:00:00Z", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), false},
+		{"hours", "72h", now.Add(72 * time.Hour), false},
+		{"days", "7d", now.Add(7 * 24 * time.Hour), false},
+		{"timestamp in the past", "2025-01-01T00:00:00Z", time.Time{}, true},
+		{"zero duration", "0h", time.Time{}, true},
+		{"negative days", "-1d", time.Time{}, true},
+		{"garbage", "tomorrow", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpires(tt.value, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(got), "expected %v, got %v", tt.expected, got)
+		})
+	}
+}
+
+func TestUploadDirectoryDryRunMappings(t *testing.T) {
+	ctx := context.Background()
+
+	restore := preserveGlobalVars()
+	defer restore()
+	resetS3Client()
+	defer resetS3Client()
+
+	config = Config{
+		AccessKey: "dummy",
+		SecretKey: "dummy",
+		Region:    "us-east-1",
+	}
+
+	tempDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "b", "c"), 0755))
+	for _, file := range []string{"z.txt", filepath.Join("b", "c", "deep.txt"), filepath.Join("b", "a.txt")} {
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, file), []byte("content"), 0644))
+	}
+
+	setTestConfig(tempDir, "s3://bucket/prefix/", "", false, true, false, false)
+	dryRun = true
+
+	var err error
+	output := captureStdout(func() {
+		err = uploadToS3(ctx)
+	})
+	require.NoError(t, err)
+
+	expected := fmt.Sprintf("%s -> s3://bucket/prefix/b/a.txt\n%s -> s3://bucket/prefix/b/c/deep.txt\n%s -> s3://bucket/prefix/z.txt\nDry run: 3 files would be uploaded\n",
+		filepath.Join(tempDir, "b", "a.txt"),
+		filepath.Join(tempDir, "b", "c", "deep.txt"),
+		filepath.Join(tempDir, "z.txt"))
+	assert.Equal(t, expected, output)
+}
+
+func TestUploadSourceRoot(t *testing.T) {
+	ctx := context.Background()
+
+	restore := preserveGlobalVars()
+	defer restore()
+	resetS3Client()
+	defer resetS3Client()
+
+	config = Config{
+		AccessKey: "dummy",
+		SecretKey: "dummy",
+		Region:    "us-east-1",
+	}
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "logs", "app"), 0755))
+	for _, file := range []string{filepath.Join("logs", "app", "a.log"), filepath.Join("logs", "app", "b.log"), filepath.Join("logs", "sys.log")} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, file), []byte("log"), 0644))
+	}
+
+	keyPattern := regexp.MustCompile(`s3://bucket/(\S+)`)
+	uploadKeys := func(t *testing.T, src, dst, override string) []string {
+		setTestConfig(src, dst, "", false, true, false, false)
+		dryRun = true
+		sourceRoot = override
+
+		var err error
+		output := captureStdout(func() {
+			err = uploadToS3(ctx)
+		})
+		require.NoError(t, err)
+
+		var keys []string
+		for _, match := range keyPattern.FindAllStringSubmatch(output, -1) {
+			keys = append(keys, match[1])
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	t.Run("glob", func(t *testing.T) {
+		glob := filepath.Join(root, "logs", "app", "*.log")
+		assert.Equal(t, []string{"backup/a.log", "backup/b.log"}, uploadKeys(t, glob, "s3://bucket/backup/", ""))
+		assert.Equal(t, []string{"backup/logs/app/a.log", "backup/logs/app/b.log"}, uploadKeys(t, glob, "s3://bucket/backup/", root))
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		dir := filepath.Join(root, "logs")
+		assert.Equal(t, []string{"backup/app/a.log", "backup/app/b.log", "backup/sys.log"}, uploadKeys(t, dir, "s3://bucket/backup/", ""))
+		assert.Equal(t, []string{"backup/logs/app/a.log", "backup/logs/app/b.log", "backup/logs/sys.log"}, uploadKeys(t, dir, "s3://bucket/backup/", root))
+	})
+
+	t.Run("single file", func(t *testing.T) {
+		file := filepath.Join(root, "logs", "sys.log")
+		assert.Equal(t, []string{"backup/sys.log"}, uploadKeys(t, file, "s3://bucket/backup/", ""))
+		assert.Equal(t, []string{"backup/logs/sys.log"}, uploadKeys(t, file, "s3://bucket/backup/", root))
+	})
+
+	t.Run("relative source gives the same keys", func(t *testing.T) {
+		t.Chdir(filepath.Join(root, "logs"))
+		assert.Equal(t, []string{"backup/logs/app/a.log", "backup/logs/app/b.log"}, uploadKeys(t, filepath.Join("app", "*.log"), "s3://bucket/backup/", root))
+		assert.Equal(t, []string{"backup/logs/app/a.log", "backup/logs/app/b.log"}, uploadKeys(t, "app", "s3://bucket/backup", root))
+	})
+
+	t.Run("source outside the root", func(t *testing.T) {
+		setTestConfig(filepath.Join(root, "logs", "sys.log"), "s3://bucket/backup/", "", false, true, true, false)
+		dryRun = true
+		sourceRoot = filepath.Join(root, "logs", "app")
+
+		err := uploadToS3(ctx)
+		assert.ErrorContains(t, err, "is not inside the source root")
+	})
+}
+
+func TestUploadSymlinkedSource(t *testing.T) {
+	ctx := context.Background()
+
+	restore := preserveGlobalVars()
+	defer restore()
+	resetS3Client()
+	defer resetS3Client()
+
+	config = Config{
+		AccessKey: "dummy",
+		SecretKey: "dummy",
+		Region:    "us-east-1",
+	}
+
+	tree := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tree, "docs", "drafts"), 0755))
+	for _, file := range []string{"a.txt", filepath.Join("docs", "b.txt"), filepath.Join("docs", "drafts", "c.txt")} {
+		require.NoError(t, os.WriteFile(filepath.Join(tree, file), []byte("content"), 0644))
+	}
+	link := filepath.Join(t.TempDir(), "current")
+	require.NoError(t, os.Symlink(tree, link))
+
+	setTestConfig(link, "s3://bucket/backup/", "", false, true, false, false)
+	dryRun = true
+	ignorePatterns = "docs/drafts/"
+	require.NoError(t, initializeIgnoreMatcher())
+
+	var err error
+	output := captureStdout(func() {
+		err = uploadToS3(ctx)
+	})
+	require.NoError(t, err)
+
+	var keys []string
+	for _, match := range regexp.MustCompile(`s3://bucket/(\S+)`).FindAllStringSubmatch(output, -1) {
+		keys = append(keys, match[1])
+	}
+	assert.ElementsMatch(t, []string{"backup/a.txt", "backup/docs/b.txt"}, keys)
+	assert.Equal(t, link, source, "the source flag is not rewritten")
+	assert.Contains(t, output, filepath.Join(link, "docs", "b.txt"), "paths are shown below the source as given")
+
+	t.Run("sync listing", func(t *testing.T) {
+		files, err := listLocalFilesWithOptions(link, false)
+		require.NoError(t, err)
+		var relPaths []string
+		for _, file := range files {
+			relPaths = append(relPaths, file.RelPath)
+		}
+		assert.ElementsMatch(t, []string{"a.txt", "docs/b.txt"}, relPaths)
+	})
+
+	t.Run("symlinked file keeps its name", func(t *testing.T) {
+		fileLink := filepath.Join(t.TempDir(), "latest.txt")
+		require.NoError(t, os.Symlink(filepath.Join(tree, "a.txt"), fileLink))
+		resolved, err := resolveSymlinkedDir(fileLink)
+		require.NoError(t, err)
+		assert.Equal(t, fileLink, resolved)
+	})
+}
+
+func TestIsPreconditionFailed(t *testing.T) {
+	precondition := &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+
+	assert.True(t, isPreconditionFailed(precondition))
+	assert.True(t, isPreconditionFailed(fmt.Errorf("upload failed: %w", precondition)))
+	assert.False(t, isPreconditionFailed(&smithy.GenericAPIError{Code: "AccessDenied"}))
+	assert.False(t, isPreconditionFailed(errors.New("PreconditionFailed")))
+	assert.False(t, isPreconditionFailed(nil))
+}
+
+func TestParseGrantees(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{"canonical user id", "id=79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be", `id="79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be"`, false},
+		{"quoted value", `id="abc123"`, `id="abc123"`, false},
+		{"predefined group", "uri=http://acs.amazonaws.com/groups/global/AllUsers", `uri="http://acs.amazonaws.com/groups/global/AllUsers"`, false},
+		{"multiple grantees", "id=abc123, emailAddress=owner@example.com", `id="abc123", emailAddress="owner@example.com"`, false},
+		{"unknown type", "user=abc123", "", true},
+		{"missing value", "id=", "", true},
+		{"missing separator", "abc123", "", true},
+		{"custom uri", "uri=http://example.com/groups/admins", "", true},
+		{"empty list", " , ", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGrantees(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestApplyUploadOptionsGrants(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("no grants by default", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, true, false)
+		input := &manager.UploadObjectInput{}
+		applyUploadOptions(input)
+
+		assert.Nil(t, input.GrantFullControl)
+		assert.Nil(t, input.GrantRead)
+	})
+
+	t.Run("grants are set on the upload input", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, true, false)
+		grantFullControlHeader = `id="owner-id"`
+		grantReadHeader = `uri="http://acs.amazonaws.com/groups/global/AuthenticatedUsers"`
+
+		input := &manager.UploadObjectInput{}
+		applyUploadOptions(input)
+
+		assert.Equal(t, `id="owner-id"`, aws.ToString(input.GrantFullControl))
+		assert.Equal(t, `uri="http://acs.amazonaws.com/groups/global/AuthenticatedUsers"`, aws.ToString(input.GrantRead))
+	})
+}
+
+func TestApplyUploadOptionsContentHeaders(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("no headers by default", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, true, false)
+		input := &manager.UploadObjectInput{Key: aws.String("index.html")}
+		applyUploadOptions(input)
+
+		assert.Nil(t, input.ContentType)
+		assert.Nil(t, input.CacheControl)
+	})
+
+	t.Run("fixed values", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, true, false)
+		contentType = "application/octet-stream"
+		cacheControl = "max-age=3600"
+
+		input := &manager.UploadObjectInput{Key: aws.String("index.html")}
+		applyUploadOptions(input)
+
+		assert.Equal(t, "application/octet-stream", aws.ToString(input.ContentType))
+		assert.Equal(t, "max-age=3600", aws.ToString(input.CacheControl))
+	})
+
+	t.Run("auto content type", func(t *testing.T) {
+		setTestConfig("", "", "", false, false, true, false)
+		contentType = "auto"
+
+		assert.Equal(t, "text/html; charset=utf-8", contentTypeFor("site/index.html"))
+		assert.Equal(t, "", contentTypeFor("site/no-extension"))
+
+		input := &manager.UploadObjectInput{Key: aws.String("data.unknown-ext")}
+		applyUploadOptions(input)
+		assert.Nil(t, input.ContentType)
+	})
+}
+
+func TestParseContentLanguage(t *testing.T) {
+	for value, expected := range map[string]string{
+		"de":                 "de",
+		"de-CH":              "de-CH",
+		"zh-Hant-TW":         "zh-Hant-TW",
+		"es-419":             "es-419",
+		"en-US, fr ,de-1996": "en-US, fr, de-1996",
+		"x-klingon":          "x-klingon",
+	} {
+		header, err := parseContentLanguage(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, header)
+	}
+
+	for _, value := range []string{"", "e", "en_US", "en-", "de--CH", "en-toolongsubtag", "x", "1a", "en,"} {
+		_, err := parseContentLanguage(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestValidateContentDisposition(t *testing.T) {
+	assert.NoError(t, validateContentDisposition("attachment"))
+	assert.NoError(t, validateContentDisposition(`Inline; filename="report.pdf"`))
+	assert.Error(t, validateContentDisposition(`filename="report.pdf"`))
+	assert.Error(t, validateContentDisposition(""))
+}
+
+func TestContentHeadersRoundTrip(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	useFakeS3(t, newFakeS3(nil))
+
+	localFile := filepath.Join(t.TempDir(), "index.de.html")
+	require.NoError(t, os.WriteFile(localFile, []byte("<p>Hallo</p>"), 0644))
+
+	setTestConfig(localFile, "s3://website/de/index.html", "", false, false, true, false)
+	contentLanguage = "de-CH, de"
+	contentDisposition = "inline"
+	cacheControl = "max-age=60"
+
+	require.NoError(t, uploadToS3(context.Background()))
+
+	s3Client, err := getS3Client(context.Background())
+	require.NoError(t, err)
+	head, err := s3Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("website"),
+		Key:    aws.String("de/index.html"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "de-CH, de", aws.ToString(head.ContentLanguage))
+	assert.Equal(t, "inline", aws.ToString(head.ContentDisposition))
+	assert.Equal(t, "max-age=60", aws.ToString(head.CacheControl))
+}
+
+func TestValidateRunID(t *testing.T) {
+	for _, value := range []string{"nightly-42", "2025-06-01T02:00:00Z", "batch_7.retry"} {
+		assert.NoError(t, validateRunID(value), value)
+	}
+	for _, value := range []string{"run 1", "run/1", "läuft", strings.Repeat("a", 129)} {
+		assert.Error(t, validateRunID(value), value)
+	}
+}
+
+func TestRunIDMetadata(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	localDir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "nested/c.txt"} {
+		localPath := filepath.Join(localDir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(name), 0644))
+	}
+
+	setTestConfig(localDir, "s3://batches/incoming/", "", false, true, true, false)
+	runID = "nightly-2025-06-01"
+
+	require.NoError(t, uploadToS3(context.Background()))
+	runIDs := map[string]string{}
+	for path := range fake.contents() {
+		object, _ := fake.object(path)
+		runIDs[path] = object.metadata("Run-Id")
+	}
+	assert.Equal(t, map[string]string{
+		"batches/incoming/a.txt":        "nightly-2025-06-01",
+		"batches/incoming/b.txt":        "nightly-2025-06-01",
+		"batches/incoming/nested/c.txt": "nightly-2025-06-01",
+	}, runIDs)
+}
+
+func TestRunIDMetadataMultipleDestinations(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	fake := newFakeS3(nil)
+	useFakeS3(t, fake)
+
+	localFile := filepath.Join(t.TempDir(), "report.csv")
+	require.NoError(t, os.WriteFile(localFile, []byte("a,b\n"), 0644))
+
+	setTestConfig(localFile, "s3://first/reports/", "", false, false, true, false)
+	destinations = []string{"s3://first/reports/", "s3://second/reports/", "s3://third/reports/", "s3://fourth/reports/"}
+	runID = "nightly-2025-06-01"
+
+	// the destinations are uploaded concurrently, run with -race
+	require.NoError(t, uploadToS3(context.Background()))
+	require.Len(t, fake.contents(), 4)
+	for path := range fake.contents() {
+		object, _ := fake.object(path)
+		assert.Equal(t, "nightly-2025-06-01", object.metadata("Run-Id"), path)
+		assert.Equal(t, md5Hex("a,b\n"), object.metadata("Local-Md5"), path)
+	}
+}
+
+func TestParseChecksumAlgorithm(t *testing.T) {
+	for value, expected := range map[string]mtypes.ChecksumAlgorithm{
+		"crc32":  mtypes.ChecksumAlgorithmCrc32,
+		"CRC32C": mtypes.ChecksumAlgorithmCrc32c,
+		"sha1":   mtypes.ChecksumAlgorithmSha1,
+		"Sha256": mtypes.ChecksumAlgorithmSha256,
+	} {
+		algorithm, err := parseChecksumAlgorithm(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, algorithm)
+	}
+
+	_, err := parseChecksumAlgorithm("md5")
+	assert.ErrorContains(t, err, "unsupported checksum algorithm")
+}
+
+func TestApplyUploaderOptions(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	var defaults manager.Options
+	applyUploaderOptions(&defaults)
+	assert.Empty(t, defaults.ChecksumAlgorithm)
+	assert.Zero(t, defaults.MultipartUploadThreshold)
+
+	multipartThresholdBytes = 32 * 1024 * 1024
+	uploadChecksumAlgorithm = mtypes.ChecksumAlgorithmSha256
+
+	var options manager.Options
+	applyUploaderOptions(&options)
+	assert.Equal(t, mtypes.ChecksumAlgorithm(mtypes.ChecksumAlgorithmSha256), options.ChecksumAlgorithm)
+	assert.Equal(t, int64(32*1024*1024), options.MultipartUploadThreshold)
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -17,24 +24,45 @@ import (
 const (
 	// DefaultEncryptionChunkSize is the default chunk size for encryption (1MB)
 	DefaultEncryptionChunkSize = 1024 * 1024
+	// DefaultEncryptionWorkers caps the goroutines sealing or opening the chunks of one file
+	DefaultEncryptionWorkers = 4
 	// DefaultWorkerPoolBufferMultiplier determines the buffer size for worker pool
 	DefaultWorkerPoolBufferMultiplier = 2
+	// DefaultMultipartThreshold matches the transfer manager's default multipart threshold (16MB)
+	DefaultMultipartThreshold = 1024 * 1024 * 16
+	// DefaultResumePartSize is the part size of resumable uploads, the transfer manager's default (8MB)
+	DefaultResumePartSize = 1024 * 1024 * 8
+	// DefaultResumePartConcurrency is the number of parts of one file uploaded at once with --resume
+	DefaultResumePartConcurrency = 5
+	// MaxUploadParts is the maximum number of parts of an S3 multipart upload
+	MaxUploadParts = 10000
 )
 
 // calculateFileMD5 calculates the MD5 checksum of a file
 func calculateFileMD5(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+	sum, err := calculateFileChecksum(filePath, md5.New)
 	if err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(sum), nil
+}
+
+// calculateFileChecksum hashes a file with the given hash function
+func calculateFileChecksum(filePath string, newHash func() hash.Hash) ([]byte, error) {
+	defer report.track(phaseHashing)()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
 	defer closeWithLog(file, filePath)
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+	h := newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return h.Sum(nil), nil
 }
 
 // runWorkerPool executes tasks using a worker pool pattern with context support
@@ -191,6 +219,106 @@ func runWorkerPoolStream[T any](ctx context.Context, maxWorkers int, worker func
 	}
 }
 
+// orderBySize reorders tasks according to the --order strategy. "size-desc"
+// schedules the largest files first; "interleave" alternates between the
+// largest and the smallest remaining files so workers mix long and short
+// transfers. Any other order returns tasks unchanged.
+func orderBySize[T any](tasks []T, order string, size func(T) int64) []T {
+	if order != "size-desc" && order != "interleave" {
+		return tasks
+	}
+
+	sorted := slices.Clone(tasks)
+	slices.SortStableFunc(sorted, func(a, b T) int {
+		return cmp.Compare(size(b), size(a))
+	})
+
+	if order == "size-desc" {
+		return sorted
+	}
+
+	interleaved := make([]T, 0, len(sorted))
+	for low, high := 0, len(sorted)-1; low <= high; low++ {
+		interleaved = append(interleaved, sorted[low])
+		if low != high {
+			interleaved = append(interleaved, sorted[high])
+		}
+		high--
+	}
+	return interleaved
+}
+
+// withRunTimeout derives the context of a run with the --timeout deadline.
+// The returned function turns the error of a run that hit the deadline into
+// a runTimeoutError with the file counts at the moment the deadline passed,
+// and releases the context.
+func withRunTimeout(ctx context.Context, timeout time.Duration) (context.Context, func(error) error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	// only the deadline runs the snapshot, the run ends before cancel is called
+	snapshot := make(chan *runTimeoutError, 1)
+	stop := context.AfterFunc(ctx, func() {
+		queued, completed := report.fileCounts()
+		snapshot <- &runTimeoutError{timeout: timeout, queued: queued, completed: completed}
+	})
+
+	return ctx, func(err error) error {
+		deadlinePassed := !stop()
+		cancel()
+		if !deadlinePassed {
+			return err
+		}
+		timedOut := <-snapshot
+		if err == nil {
+			return nil
+		}
+		timedOut.err = err
+		return timedOut
+	}
+}
+
+// fileTimeoutError is returned by runWithFileTimeout for a transfer that
+// exceeded --per-file-timeout. Directory transfers report it as a failure of
+// that file and go on with the others.
+type fileTimeoutError struct {
+	name    string
+	timeout int
+	err     error
+}
+
+func (e *fileTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %ds: %v", e.name, e.timeout, e.err)
+}
+
+func (e *fileTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// isFileTimeout reports whether err is a --per-file-timeout of one transfer
+// rather than a failure of the whole run
+func isFileTimeout(err error) bool {
+	var timedOut *fileTimeoutError
+	return errors.As(err, &timedOut)
+}
+
+// runWithFileTimeout runs a single file transfer with its own deadline when
+// --per-file-timeout is set, so one stuck transfer is cancelled and reported
+// without waiting for the overall --timeout
+func runWithFileTimeout(ctx context.Context, name string, transfer func(context.Context) error) error {
+	if perFileTimeout <= 0 {
+		return transfer(ctx)
+	}
+
+	fileCtx, cancel := context.WithTimeout(ctx, time.Duration(perFileTimeout)*time.Second)
+	defer cancel()
+
+	err := transfer(fileCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(fileCtx.Err(), context.DeadlineExceeded) {
+		return &fileTimeoutError{name: name, timeout: perFileTimeout, err: err}
+	}
+	return err
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -204,6 +332,55 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// parseByteSize parses a human readable size such as "64MB", "512k" or "1GiB"
+// into bytes. Units are binary (1 KB = 1024 bytes) to match formatBytes.
+func parseByteSize(value string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(value))
+	if trimmed == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	numberEnd := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	numberPart, unitPart := trimmed, ""
+	if numberEnd >= 0 {
+		numberPart, unitPart = trimmed[:numberEnd], strings.TrimSpace(trimmed[numberEnd:])
+	}
+
+	number, err := strconv.ParseFloat(numberPart, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	unitPart = strings.TrimSuffix(strings.TrimSuffix(unitPart, "B"), "I")
+	exponent := strings.Index("KMGTPE", unitPart)
+	if unitPart == "" {
+		exponent = -1
+	} else if exponent < 0 || len(unitPart) != 1 {
+		return 0, fmt.Errorf("invalid size unit in %q", value)
+	}
+
+	multiplier := int64(1)
+	for range exponent + 1 {
+		multiplier *= 1024
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// parseDayDuration parses a Go duration such as 72h, or a number of days such as 7d
+func parseDayDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -214,14 +391,24 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen-3] + "..."
 }
 
+// logInfo prints a per-file line, suppressed by --quiet and --summary-only
 func logInfo(format string, args ...any) {
-	if !quiet {
+	if currentOutputMode().perFile() {
 		fmt.Printf(format, args...)
 	}
 }
 
+// logVerbose prints per-file details that only --verbose shows
 func logVerbose(format string, args ...any) {
-	if verbose {
+	if currentOutputMode().details() {
+		fmt.Printf(format, args...)
+	}
+}
+
+// logSummary prints an end-of-run summary line. It is suppressed by --quiet
+// unless --summary-only is set.
+func logSummary(format string, args ...any) {
+	if currentOutputMode().summary() {
 		fmt.Printf(format, args...)
 	}
 }
@@ -233,31 +420,51 @@ func closeWithLog(closer io.Closer, resourceName string) {
 	}
 }
 
-// compareFileChecksums compares local file checksum with S3 object checksum
-func compareFileChecksums(ctx context.Context, s3Client *s3.Client, bucket, s3Key, localMD5 string) (bool, error) {
-	exists, etag, metadata, err := checkS3ObjectExists(ctx, s3Client, bucket, s3Key)
-	if err != nil {
-		return false, fmt.Errorf("could not check S3 object: %v", err)
+// removeTempFile removes a temporary file and logs any error other than the
+// file already being gone. The warning goes through logVerbose so it never
+// appears under --quiet.
+func removeTempFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logVerbose("Warning: failed to remove temp file %s: %v\n", path, err)
 	}
+}
 
-	if !exists {
+// compareFileChecksums compares a local file with an S3 object under
+// --integrity-policy and reports whether the object has the same content
+func compareFileChecksums(ctx context.Context, s3Client *s3.Client, bucket, s3Key, localPath, localMD5 string) (bool, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil && isNotFound(err) {
 		return false, nil
 	}
-
-	if etag == localMD5 {
-		logInfo("Skipping %s (already exists with same checksum via ETag)\n", s3Key)
+	if err != nil && assumeExistsOn403 && isForbidden(err) {
+		if assumeExistsAction == "upload" {
+			logVerbose("Existence check for %s denied (403), uploading without comparing\n", s3Key)
+			return false, nil
+		}
+		logInfo("Skipping %s (existence check denied with 403, assuming it exists)\n", s3Key)
 		return true, nil
 	}
+	if err != nil {
+		return false, fmt.Errorf("could not check S3 object: %v", err)
+	}
 
-	if storedMD5, exists := metadata["local-md5"]; exists {
-		if storedMD5 == localMD5 {
-			logInfo("Skipping %s (already exists with same checksum via metadata)\n", s3Key)
-			return true, nil
-		}
-		logVerbose("Object exists but checksum differs (local: %s, metadata: %s, etag: %s)\n", localMD5, storedMD5, etag)
-	} else {
-		logVerbose("Object exists but no local MD5 in metadata, will upload (local: %s, etag: %s)\n", localMD5, etag)
+	localSize := int64(-1)
+	if info, statErr := os.Stat(localPath); statErr == nil {
+		localSize = info.Size()
+	}
+	object, err := resolveObjectIntegrity(ctx, s3Client, bucket, s3Key, localSize, headIntegrity(head))
+	if err != nil {
+		return false, fmt.Errorf("could not recompute checksum of %s: %w", s3Key, err)
 	}
 
+	same, evidence := integrityMatch(integrityPolicy, localSize, localMD5, object)
+	if same {
+		logInfo("Skipping %s (already exists with same %s)\n", s3Key, evidence)
+		return true, nil
+	}
+	logVerbose("Object exists but differs by %s, will transfer (local: %s, metadata: %s, etag: %s)\n", evidence, localMD5, object.storedMD5, object.etag)
 	return false, nil
 }
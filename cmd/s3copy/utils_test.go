@@ -3,7 +3,12 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -34,6 +39,199 @@ func TestFormatBytes(t *testing.T) {
 	}
 }
 
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"1KB", 1024},
+		{"1k", 1024},
+		{"1.5KB", 1536},
+		{"64MB", 64 * 1024 * 1024},
+		{"64 MiB", 64 * 1024 * 1024},
+		{"2GB", 2 * 1024 * 1024 * 1024},
+		{"1TB", 1024 * 1024 * 1024 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := parseByteSize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+
+	for _, input := range []string{"", "MB", "-1MB", "10XB", "10MBB", "abc"} {
+		t.Run("invalid "+input, func(t *testing.T) {
+			_, err := parseByteSize(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestOrderBySize(t *testing.T) {
+	sizes := []int64{5, 100, 1, 50, 10}
+	identity := func(size int64) int64 { return size }
+
+	t.Run("walk keeps order", func(t *testing.T) {
+		assert.Equal(t, []int64{5, 100, 1, 50, 10}, orderBySize(sizes, "walk", identity))
+	})
+
+	t.Run("size-desc", func(t *testing.T) {
+		assert.Equal(t, []int64{100, 50, 10, 5, 1}, orderBySize(sizes, "size-desc", identity))
+	})
+
+	t.Run("interleave", func(t *testing.T) {
+		assert.Equal(t, []int64{100, 1, 50, 5, 10}, orderBySize(sizes, "interleave", identity))
+	})
+
+	t.Run("interleave even count", func(t *testing.T) {
+		assert.Equal(t, []int64{4, 1, 3, 2}, orderBySize([]int64{1, 2, 3, 4}, "interleave", identity))
+	})
+
+	t.Run("input is not modified", func(t *testing.T) {
+		input := []int64{1, 3, 2}
+		_ = orderBySize(input, "size-desc", identity)
+		assert.Equal(t, []int64{1, 3, 2}, input)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, orderBySize([]int64{}, "interleave", identity))
+	})
+}
+
+// BenchmarkOrderBySizeThroughput simulates transfers whose duration is
+// proportional to file size on a mix of a few large and many small files,
+// with the large files clustered at the end of the walk order.
+func BenchmarkOrderBySizeThroughput(b *testing.B) {
+	var sizes []int64
+	for range 40 {
+		sizes = append(sizes, 1)
+	}
+	for range 5 {
+		sizes = append(sizes, 20)
+	}
+
+	for _, order := range []string{"walk", "size-desc", "interleave"} {
+		b.Run(order, func(b *testing.B) {
+			for b.Loop() {
+				tasks := orderBySize(sizes, order, func(size int64) int64 { return size })
+				err := runWorkerPool(context.Background(), tasks, 4, func(ctx context.Context, size int64) error {
+					time.Sleep(time.Duration(size) * time.Millisecond)
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithFileTimeout(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("no timeout passes context through", func(t *testing.T) {
+		perFileTimeout = 0
+		ctx := context.Background()
+		err := runWithFileTimeout(ctx, "file", func(fileCtx context.Context) error {
+			_, hasDeadline := fileCtx.Deadline()
+			assert.False(t, hasDeadline)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("one slow file among many", func(t *testing.T) {
+		perFileTimeout = 1
+
+		tasks := make([]int, 10)
+		for i := range tasks {
+			tasks[i] = i
+		}
+
+		var mu sync.Mutex
+		var completed []int
+		var failures []error
+
+		err := runWorkerPool(context.Background(), tasks, 3, func(ctx context.Context, task int) error {
+			err := runWithFileTimeout(ctx, fmt.Sprintf("file-%d", task), func(fileCtx context.Context) error {
+				if task == 4 {
+					<-fileCtx.Done()
+					return fileCtx.Err()
+				}
+				return nil
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, err)
+			} else {
+				completed = append(completed, task)
+			}
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Len(t, completed, 9)
+		assert.NotContains(t, completed, 4)
+		require.Len(t, failures, 1)
+		assert.Contains(t, failures[0].Error(), "file-4 timed out after 1s")
+		assert.ErrorIs(t, failures[0], context.DeadlineExceeded)
+	})
+
+	t.Run("slow file in a directory upload", func(t *testing.T) {
+		fake := newFakeS3(nil)
+		useFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/slow.txt") {
+				// the request context only ends after the body was read
+				_, _ = io.Copy(io.Discard, r.Body)
+				<-r.Context().Done()
+				return
+			}
+			fake.ServeHTTP(w, r)
+		}))
+
+		localDir := t.TempDir()
+		for _, name := range []string{"a.txt", "slow.txt", "z.txt"} {
+			require.NoError(t, os.WriteFile(filepath.Join(localDir, name), []byte(name), 0644))
+		}
+
+		setTestConfig(localDir, "s3://bucket/tree/", "", false, true, true, false)
+		perFileTimeout = 1
+		maxWorkers = 1
+		retries = 1
+		failures.reset()
+		defer failures.reset()
+
+		err := uploadToS3(context.Background())
+		var partial *partialFailureError
+		require.ErrorAs(t, err, &partial)
+		assert.Contains(t, err.Error(), "1 timed out file(s)")
+		assert.Contains(t, fake.contents(), "bucket/tree/a.txt")
+		assert.Contains(t, fake.contents(), "bucket/tree/z.txt", "files after the slow one are still uploaded")
+		require.Len(t, failures.list(), 1)
+		assert.Contains(t, failures.list()[0].Error, "timed out after 1s")
+	})
+
+	t.Run("parent cancellation is not reported as file timeout", func(t *testing.T) {
+		perFileTimeout = 10
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := runWithFileTimeout(ctx, "file", func(fileCtx context.Context) error {
+			return fileCtx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotContains(t, err.Error(), "timed out")
+	})
+}
+
 func TestTruncateString(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -210,3 +408,69 @@ func TestRunWorkerPoolStream(t *testing.T) {
 		assert.Len(t, processed, 5)
 	})
 }
+
+func TestRemoveTempFile(t *testing.T) {
+	originalQuiet := quiet
+	originalVerbose := verbose
+	defer func() {
+		quiet = originalQuiet
+		verbose = originalVerbose
+	}()
+
+	// A non-empty directory cannot be removed with os.Remove, which gives a
+	// cleanup failure that is not os.IsNotExist
+	stuckPath := t.TempDir()
+	require.NoError(t, os.WriteFile(stuckPath+"/child", []byte("x"), 0644))
+
+	t.Run("removes file", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp(t.TempDir(), "s3copy-*")
+		require.NoError(t, err)
+		require.NoError(t, tmpFile.Close())
+
+		removeTempFile(tmpFile.Name())
+		_, err = os.Stat(tmpFile.Name())
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("missing file is silent", func(t *testing.T) {
+		quiet = false
+		verbose = true
+		output := captureStdout(func() {
+			removeTempFile(stuckPath + "/does-not-exist")
+		})
+		assert.Empty(t, output)
+	})
+
+	t.Run("no output when quiet", func(t *testing.T) {
+		quiet = true
+		verbose = false
+		output := captureStdout(func() {
+			removeTempFile(stuckPath)
+		})
+		assert.Empty(t, output)
+	})
+
+	t.Run("warning when verbose", func(t *testing.T) {
+		quiet = false
+		verbose = true
+		output := captureStdout(func() {
+			removeTempFile(stuckPath)
+		})
+		assert.Contains(t, output, "Warning: failed to remove temp file")
+	})
+}
+
+func TestParseDayDuration(t *testing.T) {
+	d, err := parseDayDuration("7d")
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	d, err = parseDayDuration("90m")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, d)
+
+	_, err = parseDayDuration("xd")
+	assert.Error(t, err)
+	_, err = parseDayDuration("soon")
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package main
+
+import "time"
+
+// defaultProgressInterval is the progress line interval of --progress when
+// --progress-interval is not set
+const defaultProgressInterval = 10 * time.Second
+
+// outputMode combines --quiet and --verbose with the --summary-only and
+// --progress modifiers. It is resolved from the flag globals on every call so
+// that all output decisions go through one place. --quiet and --verbose are
+// independent: --quiet drops the per-file lines and --verbose adds the
+// details, so together they print only the details.
+type outputMode struct {
+	quiet            bool
+	verbose          bool
+	summaryOnly      bool
+	progressInterval time.Duration
+}
+
+// currentOutputMode resolves the output mode from the flags
+func currentOutputMode() outputMode {
+	mode := outputMode{
+		quiet:            quiet,
+		verbose:          verbose,
+		summaryOnly:      summaryOnly,
+		progressInterval: progressPeriod,
+	}
+	if showProgress && mode.progressInterval <= 0 {
+		mode.progressInterval = defaultProgressInterval
+	}
+	return mode
+}
+
+// perFile reports whether a line is printed for every transferred, skipped
+// or deleted file
+func (m outputMode) perFile() bool {
+	return !m.quiet && !m.summaryOnly
+}
+
+// details reports whether per-file details such as skip reasons are printed
+func (m outputMode) details() bool {
+	return m.verbose && !m.summaryOnly
+}
+
+// summary reports whether end-of-run summaries are printed. --summary-only
+// brings the summary back under --quiet.
+func (m outputMode) summary() bool {
+	return !m.quiet || m.summaryOnly
+}
+
+// summaryDetails reports whether summaries list the affected files
+func (m outputMode) summaryDetails() bool {
+	return m.verbose
+}
+
+// progress reports whether periodic progress lines are printed. They are
+// independent of --quiet and --verbose, so --quiet --progress prints only
+// progress lines and errors.
+func (m outputMode) progress() bool {
+	return m.progressInterval > 0
+}
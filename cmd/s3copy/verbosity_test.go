@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputModeCombinations(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	result := SyncResult{Uploaded: []string{"a.txt"}}
+	emit := func() string {
+		return captureStdout(func() {
+			logInfo("per-file line\n")
+			logVerbose("detail line\n")
+			printSyncSummary(result)
+		})
+	}
+
+	tests := []struct {
+		name                             string
+		quiet, verbose, summaryOnly      bool
+		perFile, detail, summary, upList bool
+	}{
+		{name: "quiet", quiet: true},
+		{name: "quiet verbose", quiet: true, verbose: true, detail: true},
+		{name: "default", perFile: true, summary: true},
+		{name: "verbose", verbose: true, perFile: true, detail: true, summary: true, upList: true},
+		{name: "summary-only", summaryOnly: true, summary: true},
+		{name: "quiet summary-only", quiet: true, summaryOnly: true, summary: true},
+		{name: "verbose summary-only", verbose: true, summaryOnly: true, summary: true, upList: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiet = tt.quiet
+			verbose = tt.verbose
+			summaryOnly = tt.summaryOnly
+
+			output := emit()
+			assert.Equal(t, tt.perFile, strings.Contains(output, "per-file line"), "per-file line")
+			assert.Equal(t, tt.detail, strings.Contains(output, "detail line"), "detail line")
+			assert.Equal(t, tt.summary, strings.Contains(output, "Uploaded: 1 files"), "summary")
+			assert.Equal(t, tt.upList, strings.Contains(output, "  up a.txt"), "summary file list")
+		})
+	}
+}
+
+func TestOutputModeProgress(t *testing.T) {
+	restore := preserveGlobalVars()
+	defer restore()
+
+	t.Run("disabled by default", func(t *testing.T) {
+		assert.False(t, currentOutputMode().progress())
+	})
+
+	t.Run("progress uses the default interval", func(t *testing.T) {
+		showProgress = true
+		assert.Equal(t, defaultProgressInterval, currentOutputMode().progressInterval)
+	})
+
+	t.Run("progress-interval overrides the default", func(t *testing.T) {
+		showProgress = true
+		progressPeriod = 2 * time.Second
+		assert.Equal(t, 2*time.Second, currentOutputMode().progressInterval)
+	})
+
+	t.Run("quiet progress prints only progress lines", func(t *testing.T) {
+		quiet = true
+		showProgress = true
+		progressPeriod = 5 * time.Millisecond
+		report.reset()
+
+		output := captureStdout(func() {
+			stop := startProgressReporter(currentOutputMode().progressInterval)
+			logInfo("per-file line\n")
+			time.Sleep(20 * time.Millisecond)
+			stop()
+			printSyncSummary(SyncResult{Uploaded: []string{"a.txt"}})
+		})
+		assert.Contains(t, output, "Progress: ")
+		assert.NotContains(t, output, "per-file line")
+		assert.NotContains(t, output, "Sync Summary")
+	})
+}